@@ -0,0 +1,222 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The PCM of room stream to detect speech, mono in 16kHz.
+const (
+	stageListenSampleRate = 16000
+	stageListenChannels   = 1
+)
+
+// StageListener listen to the audio of room stream, segment it to utterances by VAD, and ask the assistant
+// like the user uploads a clip. When the host starts speaking, the answers in progress are interrupted.
+type StageListener struct {
+	// The stage to ask the assistant.
+	stage *Stage
+	// The host of room, the user of utterances.
+	user *StageUser
+	// The stream and VAD config, to restart the listener when changed.
+	stream string
+	config VADConfig
+
+	// To stop the listener.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewStageListener(stage *Stage) *StageListener {
+	return &StageListener{
+		stage: stage,
+		user: &StageUser{
+			UserID: fmt.Sprintf("host-%v", stage.room.UUID), Username: "Host",
+			Language: stage.asrLanguage, update: time.Now(), stage: stage,
+		},
+		stream: stage.room.StreamName,
+		config: *stage.vadConfig,
+	}
+}
+
+func (v *StageListener) String() string {
+	return fmt.Sprintf("sid=%v, room=%v, stream=%v, vad=<%v>",
+		v.stage.sid, v.stage.room.UUID, v.stream, v.config.String())
+}
+
+// Start to pull the room stream, and retry util closed.
+func (v *StageListener) Start(ctx context.Context) {
+	ctx, v.cancel = context.WithCancel(ctx)
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.serve(ctx); err != nil && ctx.Err() == nil {
+				logger.Wf(ctx, "Listen: Ignore %v, err %+v", v.String(), err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+			}
+		}
+	}()
+}
+
+func (v *StageListener) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *StageListener) serve(ctx context.Context) error {
+	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", "localhost", "live", v.stream)
+	args := []string{
+		"-i", inputURL, "-vn", "-ac", fmt.Sprintf("%v", stageListenChannels),
+		"-ar", fmt.Sprintf("%v", stageListenSampleRate), "-f", "s16le", "-",
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe stdout")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "start ffmpeg %v", args)
+	}
+	defer cmd.Wait()
+	logger.Tf(ctx, "Listen: Start %v, input=%v", v.String(), inputURL)
+
+	vad, err := NewVAD(v.config, stageListenSampleRate, stageListenChannels)
+	if err != nil {
+		return errors.Wrapf(err, "create vad")
+	}
+	onEvent := func(event *VADEvent) {
+		if event.Type == VADSpeechStart {
+			v.stage.bargeIn(ctx, nil)
+		} else if err := v.onUtterance(ctx, event.Audio); err != nil {
+			logger.Wf(ctx, "Listen: Ignore utterance of %v, err %+v", v.String(), err)
+		}
+	}
+
+	// Read the PCM in 100ms.
+	buf := make([]byte, stageListenSampleRate/10*stageListenChannels*2)
+	for {
+		n, err := stdout.Read(buf)
+		for _, event := range vad.Write(buf[:n]) {
+			onEvent(event)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "read pcm")
+		}
+	}
+
+	if event := vad.Flush(); event != nil {
+		onEvent(event)
+	}
+	logger.Tf(ctx, "Listen: Stream done %v", v.String())
+	return nil
+}
+
+// onUtterance ask the assistant by the audio of utterance, like the user uploads a clip.
+func (v *StageListener) onUtterance(ctx context.Context, audio []byte) error {
+	stage := v.stage
+	stage.KeepAlive()
+	v.user.KeepAlive()
+
+	sreq := &StageRequest{rid: uuid.NewString(), stage: stage}
+	sreq.lastSentence = time.Now()
+	// TODO: FIMXE: Should cleanup finished requests.
+	stage.addRequest(sreq)
+
+	sreq.inputFile = path.Join(aiTalkWorkDir, fmt.Sprintf("assistant-%v-input.wav", sreq.rid))
+	if err := writeWavFile(sreq.inputFile, audio, stageListenSampleRate, stageListenChannels); err != nil {
+		return errors.Wrapf(err, "write %vB audio to %v", len(audio), sreq.inputFile)
+	}
+	sreq.lastUploadAudio = time.Now()
+	logger.Tf(ctx, "Listen: Utterance rid=%v, audio=%vB, %v", sreq.rid, len(audio), v.String())
+
+	go func() {
+		defer sreq.FastDispose()
+
+		if err := stage.handleRequest(ctx, sreq, v.user, true, "", 0); err != nil {
+			sreq.errs = append(sreq.errs, err)
+			logger.Wf(ctx, "Listen: Ignore request rid=%v, %v, err %+v", sreq.rid, v.String(), err)
+		}
+	}()
+	return nil
+}
+
+// updateListener start or stop the listener of room stream, by the config of room. The listener is
+// restarted when the stream or VAD config changed.
+func (v *Stage) updateListener(ctx context.Context) {
+	// Detach the listener under lock, and close it without lock, because the listener may barge-in.
+	var enabled bool
+	stopped := func() *StageListener {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		enabled = v.aiVadListen && v.aiASREnabled && v.room != nil && v.room.StreamName != ""
+		if v.listener != nil && (!enabled || v.listener.stream != v.room.StreamName ||
+			v.listener.config != *v.vadConfig) {
+			listener := v.listener
+			v.listener = nil
+			return listener
+		}
+		return nil
+	}()
+	if stopped != nil {
+		logger.Tf(ctx, "Listen: Stop %v, enabled=%v", stopped.String(), enabled)
+		stopped.Close()
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	enabled = v.aiVadListen && v.aiASREnabled && v.room != nil && v.room.StreamName != ""
+	if enabled && v.listener == nil {
+		v.listener = NewStageListener(v)
+		v.listener.Start(ctx)
+	}
+}
+
+// detachListener detach the listener from stage, to close it without lock.
+func (v *Stage) detachListener() *StageListener {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	listener := v.listener
+	v.listener = nil
+	return listener
+}
+
+// queryVADConfig copy the VAD config of stage, nil if not configured.
+func (v *Stage) queryVADConfig() *VADConfig {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.vadConfig == nil {
+		return nil
+	}
+	config := *v.vadConfig
+	return &config
+}
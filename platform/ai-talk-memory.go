@@ -0,0 +1,224 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+// The memory strategy for the chat turns out of window.
+const (
+	// Drop the turns out of window.
+	StageMemoryWindow = "window"
+	// Summarize the turns out of window by AI, and keep the summary as a system message.
+	StageMemorySummary = "summary"
+)
+
+// The default max words of summary.
+const defaultStageSummaryWords = 200
+
+// StageMemory is the long-term memory of chat, the turns out of window are summarized into a summary, when
+// the memory strategy is summary.
+type StageMemory struct {
+	// The summary of evicted turns.
+	Summary string `json:"summary,omitempty"`
+	// The evicted turns not summarized yet.
+	Pending []openai.ChatCompletionMessage `json:"pending,omitempty"`
+
+	// The memory strategy, window or summary.
+	strategy string
+	// The model and max words of summary.
+	model string
+	words int
+	// Whether summarizing.
+	running bool
+	// The generation of memory, increased when reset or restored, to ignore the stale summary.
+	generation int
+	// To protect the summary and pending turns.
+	lock sync.Mutex
+}
+
+func NewStageMemory() *StageMemory {
+	return &StageMemory{}
+}
+
+func (v *StageMemory) String() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return fmt.Sprintf("strategy=%v, model=%v, words=%v, summary=%vB, pending=%v",
+		v.strategy, v.model, v.words, len(v.Summary), len(v.Pending))
+}
+
+// update the config of memory, for example, the room is updated.
+func (v *StageMemory) update(strategy, model string, words int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.strategy, v.model, v.words = strategy, model, words
+}
+
+// snapshot copy the summary and pending turns, to persist the memory.
+func (v *StageMemory) snapshot() *StageMemory {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return &StageMemory{
+		Summary: v.Summary, Pending: append([]openai.ChatCompletionMessage{}, v.Pending...),
+	}
+}
+
+// restore the summary and pending turns from snapshot.
+func (v *StageMemory) restore(snapshot *StageMemory) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if snapshot != nil {
+		v.Summary, v.Pending = snapshot.Summary, snapshot.Pending
+		v.generation++
+	}
+}
+
+// reset clear the memory, for example, the conversation is cleared.
+func (v *StageMemory) reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.Summary, v.Pending = "", nil
+	v.generation++
+}
+
+// trim the histories to the max messages, the evicted turns are kept to summarize if strategy is summary.
+func (v *StageMemory) trim(histories []openai.ChatCompletionMessage, max int) []openai.ChatCompletionMessage {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for len(histories) > max {
+		if v.strategy == StageMemorySummary {
+			v.Pending = append(v.Pending, histories[0])
+		}
+		histories = histories[1:]
+	}
+	return histories
+}
+
+// message build the message of summary, to insert after the system prompt, nil if no summary.
+func (v *StageMemory) message(model string) *openai.ChatCompletionMessage {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.Summary == "" || v.strategy != StageMemorySummary {
+		return nil
+	}
+
+	// If not support system message, use User message.
+	role := openai.ChatMessageRoleSystem
+	if !gptModelSupportSystem(model) {
+		role = openai.ChatMessageRoleUser
+	}
+	return &openai.ChatCompletionMessage{
+		Role: role, Content: fmt.Sprintf("Summary of the earlier conversation: %v", v.Summary),
+	}
+}
+
+// summarize the pending turns with the previous summary in background, ignore if already summarizing. The
+// callback is called when the summary is updated, to persist the memory.
+func (v *StageMemory) summarize(ctx context.Context, conf openai.ClientConfig, defaultModel string, onSummary func()) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.strategy != StageMemorySummary || v.running || len(v.Pending) == 0 {
+		return
+	}
+	v.running = true
+
+	summary, pending, generation := v.Summary, v.Pending, v.generation
+	model, words := ChooseNotEmpty(v.model, defaultModel), v.words
+	if words <= 0 {
+		words = defaultStageSummaryWords
+	}
+
+	go func() {
+		defer func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			v.running = false
+		}()
+
+		r0, err := requestStageSummary(ctx, conf, model, words, summary, pending)
+		if err != nil {
+			logger.Wf(ctx, "Memory: Ignore summarize %v turns by %v, err %+v", len(pending), model, err)
+			return
+		}
+
+		// Remove the summarized turns, note that new turns may be evicted during summarizing. Ignore the
+		// summary if memory is reset or restored during summarizing.
+		if ok := func() bool {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+
+			if v.generation != generation {
+				return false
+			}
+			v.Summary, v.Pending = r0, v.Pending[len(pending):]
+			return true
+		}(); !ok {
+			return
+		}
+		logger.Tf(ctx, "Memory: Summarize %v turns by %v, summary is %v", len(pending), model, r0)
+
+		if onSummary != nil {
+			onSummary()
+		}
+	}()
+}
+
+// requestStageSummary request AI to merge the previous summary and the turns into a new summary.
+func requestStageSummary(
+	ctx context.Context, conf openai.ClientConfig, model string, words int,
+	summary string, turns []openai.ChatCompletionMessage,
+) (string, error) {
+	var sb strings.Builder
+	if summary != "" {
+		sb.WriteString(fmt.Sprintf("Previous summary:\n%v\n\n", summary))
+	}
+	sb.WriteString("New conversation:\n")
+	for _, turn := range turns {
+		sb.WriteString(fmt.Sprintf("%v: %v\n", turn.Role, turn.Content))
+	}
+
+	system := fmt.Sprintf("Summarize the conversation, merging the previous summary if any. Keep the facts, "+
+		"names, numbers and preferences of user. Limit the summary to %v words.", words)
+	role := openai.ChatMessageRoleSystem
+	if !gptModelSupportSystem(model) {
+		role = openai.ChatMessageRoleUser
+	}
+
+	client := openai.NewClientWithConfig(conf)
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: role, Content: system},
+			{Role: openai.ChatMessageRoleUser, Content: sb.String()},
+		},
+		// Some model may not support MaxTokens.
+		MaxTokens: gptModelSupportMaxTokens(model, 1024),
+		// Some model may not support temporature.
+		Temperature: gptModelSupportTemperature(model, 0.3),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "create chat")
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.Errorf("no choice")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestStageMemory_Summary(t *testing.T) {
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[len(req.Messages)-1].Content
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "User is Tom."},
+		}}})
+	}))
+	defer server.Close()
+
+	conf := openai.DefaultConfig("xxx")
+	conf.BaseURL = server.URL
+
+	turn := func(user, assistant string) []openai.ChatCompletionMessage {
+		return []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: user},
+			{Role: openai.ChatMessageRoleAssistant, Content: assistant},
+		}
+	}
+	histories := append(turn("I'm Tom", "Hi Tom"), turn("Hello", "Hello")...)
+
+	// The evicted turns are dropped for window strategy.
+	memory := NewStageMemory()
+	if r0 := memory.trim(histories, 2); len(r0) != 2 || len(memory.Pending) != 0 {
+		t.Errorf("invalid trim %v, pending=%v", len(r0), len(memory.Pending))
+	}
+
+	// The evicted turns are summarized for summary strategy.
+	memory.update(StageMemorySummary, "", 0)
+	if r0 := memory.trim(histories, 2); len(r0) != 2 || len(memory.Pending) != 2 {
+		t.Errorf("invalid trim %v, pending=%v", len(r0), len(memory.Pending))
+	}
+
+	done := make(chan bool, 1)
+	memory.summarize(context.Background(), conf, "gpt-4o", func() {
+		done <- true
+	})
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("summarize timeout")
+	}
+
+	if !strings.Contains(prompt, "user: I'm Tom") || !strings.Contains(prompt, "assistant: Hi Tom") {
+		t.Errorf("invalid prompt %v", prompt)
+	}
+	if m := memory.message("gpt-4o"); m == nil || m.Role != openai.ChatMessageRoleSystem ||
+		!strings.Contains(m.Content, "User is Tom.") || len(memory.Pending) != 0 {
+		t.Errorf("invalid summary %v", m)
+	}
+
+	restored := NewStageMemory()
+	restored.restore(memory.snapshot())
+	if restored.Summary != "User is Tom." {
+		t.Errorf("invalid restore %v", restored.Summary)
+	}
+}
+
+func TestStageMemory_ResetWhenSummarizing(t *testing.T) {
+	memory := NewStageMemory()
+	memory.update(StageMemorySummary, "", 0)
+
+	turns := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "I'm Tom"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Hi Tom"},
+		{Role: openai.ChatMessageRoleUser, Content: "Hello"},
+	}
+
+	// The memory is reset and more turns are evicted during summarizing, the stale summary is ignored.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		memory.reset()
+		memory.trim(turns, 0)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "User is Tom."},
+		}}})
+	}))
+	defer server.Close()
+
+	conf := openai.DefaultConfig("xxx")
+	conf.BaseURL = server.URL
+
+	memory.trim(turns, 1)
+	memory.summarize(context.Background(), conf, "gpt-4o", nil)
+
+	for i := 0; ; i++ {
+		memory.lock.Lock()
+		running := memory.running
+		memory.lock.Unlock()
+		if !running {
+			break
+		}
+		if i > 300 {
+			t.Fatalf("summarize timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if memory.Summary != "" || len(memory.Pending) != 3 {
+		t.Errorf("invalid memory %v", memory.String())
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The PCM of assistant speech to mix, mono in 24kHz, and write in frames of 20ms.
+const (
+	stageMixSampleRate    = 24000
+	stageMixFrameDuration = 20 * time.Millisecond
+	stageMixFrameSize     = stageMixSampleRate * 2 * int(stageMixFrameDuration/time.Millisecond) / 1000
+)
+
+// StageMixItem is the speech of an answer segment to mix, in order of segments.
+type StageMixItem struct {
+	// The answer segment UUID.
+	asid string
+	// The text of segment, for subtitle.
+	text string
+	// The PCM of speech, and the offset played.
+	pcm    []byte
+	offset int
+	// Whether the speech is ready to play.
+	ready bool
+}
+
+// StageMixer mix the TTS of assistant into the room stream, and publish a new stream for viewers. The host
+// audio is ducked when the assistant speaks, and the answer is drawn as subtitle if enabled.
+type StageMixer struct {
+	// The stage of assistant.
+	stage *Stage
+	// The input and output stream, and the config to restart the mixer when changed.
+	stream, output string
+	config         SrsAssistantMix
+	// The file of subtitle, reloaded by FFmpeg.
+	subtitleFile string
+
+	// The speech to play, in order of segments.
+	queue []*StageMixItem
+	// The lock to protect the queue.
+	lock sync.Mutex
+
+	// To stop the mixer.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewStageMixer(stage *Stage) *StageMixer {
+	config := stage.room.SrsAssistantMix
+	return &StageMixer{
+		stage: stage, stream: stage.room.StreamName, config: config,
+		output:       ChooseNotEmpty(config.AIMixStream, fmt.Sprintf("%v_ai", stage.room.StreamName)),
+		subtitleFile: path.Join(aiTalkWorkDir, fmt.Sprintf("assistant-%v-subtitle.txt", stage.sid)),
+	}
+}
+
+func (v *StageMixer) String() string {
+	return fmt.Sprintf("sid=%v, room=%v, stream=%v, output=%v, mix=<%v>",
+		v.stage.sid, v.stage.room.UUID, v.stream, v.output, v.config.String())
+}
+
+// Start to mix the room stream, and retry util closed.
+func (v *StageMixer) Start(ctx context.Context) {
+	ctx, v.cancel = context.WithCancel(ctx)
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		defer os.Remove(v.subtitleFile)
+
+		for ctx.Err() == nil {
+			if err := v.serve(ctx); err != nil && ctx.Err() == nil {
+				logger.Wf(ctx, "Mix: Ignore %v, err %+v", v.String(), err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+			}
+		}
+	}()
+}
+
+func (v *StageMixer) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *StageMixer) serve(ctx context.Context) error {
+	// The secret to publish the output stream, which is not the room stream.
+	secret, err := rdb.HGet(ctx, SRS_AUTH_SECRET, "pubSecret").Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v pubSecret", SRS_AUTH_SECRET)
+	}
+
+	if err := v.writeSubtitle(""); err != nil {
+		return errors.Wrapf(err, "write subtitle")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", v.buildArgs(secret)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe stdin")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "start ffmpeg")
+	}
+	logger.Tf(ctx, "Mix: Start %v", v.String())
+
+	// Feed the speech in realtime, stop FFmpeg if failed.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		if err := v.feed(ctx, stdin); err != nil && ctx.Err() == nil {
+			logger.Wf(ctx, "Mix: Ignore feed %v, err %+v", v.String(), err)
+		}
+	}()
+
+	err = cmd.Wait()
+	logger.Tf(ctx, "Mix: Stream done %v, err %v", v.String(), err)
+	return nil
+}
+
+// buildArgs build the FFmpeg args, the room stream is the input 0, and the speech in stdin is the input 1,
+// which is also the sidechain to duck the host audio.
+func (v *StageMixer) buildArgs(secret string) []string {
+	ducking := v.config.AIMixDucking
+	if ducking <= 0 {
+		ducking = 0.3
+	}
+	// The ratio of compressor, to reduce the host volume to about the ducking.
+	ratio := 1 / ducking
+	if ratio > 20 {
+		ratio = 20
+	}
+
+	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", "localhost", "live", v.stream)
+	outputURL := fmt.Sprintf("rtmp://%v/%v/%v", "localhost", "live", v.output)
+	if secret != "" {
+		outputURL = fmt.Sprintf("%v?secret=%v", outputURL, secret)
+	}
+
+	filters := []string{
+		"[1:a]asplit=2[sc][tts]",
+		fmt.Sprintf("[0:a][sc]sidechaincompress=threshold=0.01:ratio=%.2f:attack=20:release=400[host]", ratio),
+		"[host][tts]amix=inputs=2:duration=first:dropout_transition=0:normalize=0[aout]",
+	}
+	if v.config.AIMixSubtitle {
+		filters = append(filters, fmt.Sprintf("[0:v]drawtext=textfile=%v:reload=1:expansion=none:"+
+			"fontsize=h/20:fontcolor=white:box=1:boxcolor=black@0.5:boxborderw=10:"+
+			"x=(w-text_w)/2:y=h-text_h-h/10[vout]", v.subtitleFile))
+	}
+
+	args := []string{
+		"-i", inputURL,
+		"-thread_queue_size", "1024", "-f", "s16le", "-ar", fmt.Sprintf("%v", stageMixSampleRate), "-ac", "1",
+		"-i", "pipe:0",
+		"-filter_complex", strings.Join(filters, ";"),
+	}
+	if v.config.AIMixSubtitle {
+		args = append(args, "-map", "[vout]",
+			"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-bf", "0", "-g", "50",
+		)
+	} else {
+		args = append(args, "-map", "0:v?", "-c:v", "copy")
+	}
+	args = append(args, "-map", "[aout]", "-c:a", "aac", "-b:a", "128k", "-f", "flv", outputURL)
+	return args
+}
+
+// feed write the speech or silence to FFmpeg in realtime, a frame in every 20ms.
+func (v *StageMixer) feed(ctx context.Context, w io.Writer) error {
+	var subtitle string
+	var frames int64
+	starttime := time.Now()
+
+	ticker := time.NewTicker(stageMixFrameDuration)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Write all frames util now, to avoid drift of ticker.
+		for ; frames < int64(time.Since(starttime)/stageMixFrameDuration); frames++ {
+			frame, text := v.next()
+			if _, err := w.Write(frame); err != nil {
+				return errors.Wrapf(err, "write frame")
+			}
+
+			if v.config.AIMixSubtitle && text != subtitle {
+				if err := v.writeSubtitle(text); err != nil {
+					logger.Wf(ctx, "Mix: Ignore subtitle %v, err %+v", text, err)
+				}
+				subtitle = text
+			}
+		}
+	}
+	return nil
+}
+
+// next pick a frame of speech in order, or silence if no speech or the first is not ready. Return the text
+// of speech playing, for subtitle.
+func (v *StageMixer) next() (frame []byte, text string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	frame = make([]byte, stageMixFrameSize)
+	for len(v.queue) > 0 {
+		item := v.queue[0]
+		if !item.ready {
+			return
+		}
+
+		if item.offset >= len(item.pcm) {
+			v.queue = v.queue[1:]
+			continue
+		}
+
+		n := copy(frame, item.pcm[item.offset:])
+		item.offset += n
+		return frame, item.text
+	}
+	return
+}
+
+// reserve a slot for segment, to keep the order of speech same as segments, because the TTS of segments
+// are done in parallel.
+func (v *StageMixer) reserve(segment *AnswerSegment) *StageMixItem {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	item := &StageMixItem{asid: segment.asid, text: segment.text}
+	v.queue = append(v.queue, item)
+	return item
+}
+
+// complete the slot with the PCM of speech, empty to skip the slot.
+func (v *StageMixer) complete(item *StageMixItem, pcm []byte) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	item.pcm, item.ready = pcm, true
+}
+
+// onSegment decode the TTS audio of segment to PCM, and complete the slot.
+func (v *StageMixer) onSegment(ctx context.Context, item *StageMixItem, segment *AnswerSegment) {
+	var pcm []byte
+	if segment.err == nil && !segment.noTTS && segment.ttsFile != "" {
+		b, err := exec.CommandContext(ctx, "ffmpeg", "-i", segment.ttsFile,
+			"-f", "s16le", "-ar", fmt.Sprintf("%v", stageMixSampleRate), "-ac", "1", "-",
+		).Output()
+		if err != nil {
+			logger.Wf(ctx, "Mix: Ignore decode %v, err %+v", segment.ttsFile, err)
+		} else {
+			pcm = b
+		}
+	}
+
+	v.complete(item, pcm)
+	logger.Tf(ctx, "Mix: Segment asid=%v, pcm=%vB, %v", segment.asid, len(pcm), segment.text)
+}
+
+// reset drop all speech, for example, user starts speaking.
+func (v *StageMixer) reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.queue = nil
+}
+
+// writeSubtitle write the subtitle file atomically, as FFmpeg reloads it for each frame.
+func (v *StageMixer) writeSubtitle(text string) error {
+	// Note that an empty file fails the drawtext filter.
+	content := " "
+	if text != "" {
+		content = wrapSubtitle(text, 40)
+	}
+
+	tmpFile := fmt.Sprintf("%v.tmp", v.subtitleFile)
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		return errors.Wrapf(err, "write %v", tmpFile)
+	}
+	if err := os.Rename(tmpFile, v.subtitleFile); err != nil {
+		return errors.Wrapf(err, "rename %v to %v", tmpFile, v.subtitleFile)
+	}
+	return nil
+}
+
+// wrapSubtitle wrap the text to lines not longer than width, by words, or by characters for the text
+// without space such as Chinese.
+func wrapSubtitle(text string, width int) string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		// Split the long word, such as Chinese, to pieces not longer than width.
+		for runes := []rune(word); len(runes) > 0; {
+			n := width
+			if n > len(runes) {
+				n = len(runes)
+			}
+			piece := runes[:n]
+			runes = runes[n:]
+
+			if len(line) == 0 {
+				line = piece
+			} else if len(line)+1+len(piece) <= width {
+				line = append(append(line, ' '), piece...)
+			} else {
+				lines, line = append(lines, string(line)), piece
+			}
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// updateMixer start or stop the mixer of room stream, by the config of room. The mixer is restarted when
+// the stream or mix config changed.
+func (v *Stage) updateMixer(ctx context.Context) {
+	// Detach the mixer under lock, and close it without lock, to not block the TTS and barge-in.
+	var enabled bool
+	stopped := func() *StageMixer {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		enabled = v.aiTtsEnabled && v.room != nil && v.room.AIMixEnabled && v.room.StreamName != ""
+		if v.mixer != nil && (!enabled || v.mixer.stream != v.room.StreamName ||
+			v.mixer.config != v.room.SrsAssistantMix) {
+			mixer := v.mixer
+			v.mixer = nil
+			return mixer
+		}
+		return nil
+	}()
+	if stopped != nil {
+		logger.Tf(ctx, "Mix: Stop %v, enabled=%v", stopped.String(), enabled)
+		stopped.Close()
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	enabled = v.aiTtsEnabled && v.room != nil && v.room.AIMixEnabled && v.room.StreamName != ""
+	if enabled && v.mixer == nil {
+		v.mixer = NewStageMixer(v)
+		v.mixer.Start(ctx)
+	}
+}
+
+// queryMixer return the mixer of stage, nil if not mixing.
+func (v *Stage) queryMixer() *StageMixer {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.mixer
+}
+
+// detachMixer detach the mixer from stage, to close it without lock.
+func (v *Stage) detachMixer() *StageMixer {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	mixer := v.mixer
+	v.mixer = nil
+	return mixer
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStageMixer_Queue(t *testing.T) {
+	mixer := &StageMixer{}
+
+	// The speech is played in order of segments, even the second is ready first.
+	first := mixer.reserve(&AnswerSegment{asid: "s0", text: "Hello"})
+	second := mixer.reserve(&AnswerSegment{asid: "s1", text: "World"})
+	mixer.complete(second, make([]byte, stageMixFrameSize))
+	if frame, text := mixer.next(); len(frame) != stageMixFrameSize || text != "" {
+		t.Errorf("should be silence, text=%v", text)
+	}
+
+	// The failed segment is skipped.
+	mixer.complete(first, nil)
+	if _, text := mixer.next(); text != "World" {
+		t.Errorf("invalid text %v", text)
+	}
+	if _, text := mixer.next(); text != "" || len(mixer.queue) != 0 {
+		t.Errorf("should be empty, text=%v, queue=%v", text, len(mixer.queue))
+	}
+
+	// Drop all speech for barge-in.
+	mixer.complete(mixer.reserve(&AnswerSegment{asid: "s2"}), make([]byte, stageMixFrameSize))
+	if mixer.reset(); len(mixer.queue) != 0 {
+		t.Errorf("should be reset, queue=%v", len(mixer.queue))
+	}
+
+	if r0 := wrapSubtitle("Hello world, this is the assistant", 12); r0 != "Hello world,\nthis is the\nassistant" {
+		t.Errorf("invalid subtitle %v", r0)
+	}
+	if r0 := wrapSubtitle("你好我是你的助手", 3); r0 != "你好我\n是你的\n助手" {
+		t.Errorf("invalid subtitle %v", r0)
+	}
+}
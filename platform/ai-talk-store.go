@@ -0,0 +1,423 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// StageSnapshot is the state of a stage, persisted in SRS_AI_TALK_STAGE keyed by the stage UUID, to restore
+// the stage with its chat context when platform restarts or upgrades. Note that the requests and messages
+// are not persisted, because the audio files are temporary.
+type StageSnapshot struct {
+	// The stage UUID.
+	StageUUID string `json:"sid"`
+	// The room UUID of stage.
+	RoomUUID string `json:"room"`
+	// The last update time, in RFC3339.
+	Update string `json:"update"`
+
+	// The chat context, previous text and histories.
+	PreviousUser      string                         `json:"previousUser,omitempty"`
+	PreviousAssistant string                         `json:"previousAssistant,omitempty"`
+	Histories         []openai.ChatCompletionMessage `json:"histories,omitempty"`
+	// The post-processing context, previous text and histories.
+	PostPreviousUser      string                         `json:"postPreviousUser,omitempty"`
+	PostPreviousAssistant string                         `json:"postPreviousAssistant,omitempty"`
+	PostHistories         []openai.ChatCompletionMessage `json:"postHistories,omitempty"`
+	// The summary of histories out of window, for chat and post-processing.
+	Memory     *StageMemory `json:"memory,omitempty"`
+	PostMemory *StageMemory `json:"postMemory,omitempty"`
+
+	// The users on the stage.
+	Users []*StageUserSnapshot `json:"users,omitempty"`
+	// The subscriber UUIDs of stage.
+	Subscribers []string `json:"subscribers,omitempty"`
+}
+
+// StageUserSnapshot is the user on stage, with the ASR prompt.
+type StageUserSnapshot struct {
+	*StageUser
+	// Previous ASR text, to use as prompt for next ASR.
+	PreviousAsrText string `json:"previousAsrText,omitempty"`
+}
+
+func (v StageSnapshot) String() string {
+	return fmt.Sprintf("sid=%v, room=%v, update=%v, histories=%v, postHistories=%v, users=%v, subscribers=%v",
+		v.StageUUID, v.RoomUUID, v.Update, len(v.Histories), len(v.PostHistories), len(v.Users), len(v.Subscribers))
+}
+
+func (v *Stage) snapshot() *StageSnapshot {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	snapshot := &StageSnapshot{
+		StageUUID: v.sid, Update: v.update.Format(time.RFC3339),
+		PreviousUser: v.previousUser, PreviousAssistant: v.previousAssitant,
+		Histories:        append([]openai.ChatCompletionMessage{}, v.histories...),
+		PostPreviousUser: v.postPreviousUser, PostPreviousAssistant: v.postPreviousAssitant,
+		PostHistories: append([]openai.ChatCompletionMessage{}, v.postHistories...),
+		Memory:        v.memory.snapshot(), PostMemory: v.postMemory.snapshot(),
+	}
+	if v.room != nil {
+		snapshot.RoomUUID = v.room.UUID
+	}
+
+	for _, user := range v.users {
+		u := *user
+		snapshot.Users = append(snapshot.Users, &StageUserSnapshot{
+			StageUser: &u, PreviousAsrText: user.previousAsrText,
+		})
+	}
+	for _, subscriber := range v.subscribers {
+		snapshot.Subscribers = append(snapshot.Subscribers, subscriber.spid)
+	}
+	return snapshot
+}
+
+// restore the chat context, users and subscribers of stage, from the snapshot. The users and subscribers
+// are alive, to allow clients to reconnect to the stage.
+func (v *Stage) restore(snapshot *StageSnapshot) {
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+
+		v.sid = snapshot.StageUUID
+		v.previousUser, v.previousAssitant = snapshot.PreviousUser, snapshot.PreviousAssistant
+		v.histories = snapshot.Histories
+		v.postPreviousUser, v.postPreviousAssitant = snapshot.PostPreviousUser, snapshot.PostPreviousAssistant
+		v.postHistories = snapshot.PostHistories
+	}()
+	v.memory.restore(snapshot.Memory)
+	v.postMemory.restore(snapshot.PostMemory)
+
+	for _, u := range snapshot.Users {
+		if u.StageUser == nil {
+			continue
+		}
+
+		user := u.StageUser
+		user.previousAsrText, user.stage = u.PreviousAsrText, v
+		user.KeepAlive()
+		v.addUser(user)
+	}
+
+	for _, spid := range snapshot.Subscribers {
+		subscriber := NewStageSubscriber(func(subscriber *StageSubscriber) {
+			subscriber.spid, subscriber.loggingCtx = spid, v.loggingCtx
+			subscriber.room, subscriber.stage = v.room, v
+		})
+		subscriber.KeepAlive()
+		v.addSubscriber(subscriber)
+	}
+}
+
+// Save the state of stage to redis.
+func (v *Stage) Save(ctx context.Context) error {
+	snapshot := v.snapshot()
+	if b, err := json.Marshal(snapshot); err != nil {
+		return errors.Wrapf(err, "marshal %v", snapshot.String())
+	} else if err := rdb.HSet(ctx, SRS_AI_TALK_STAGE, v.sid, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_AI_TALK_STAGE, v.sid)
+	}
+	return nil
+}
+
+// onMemorySummary save the stage when the summary of memory is updated.
+func (v *Stage) onMemorySummary(ctx context.Context) func() {
+	return func() {
+		if err := v.Save(ctx); err != nil {
+			logger.Wf(ctx, "Stage: Ignore save stage sid=%v err %+v", v.sid, err)
+		}
+	}
+}
+
+// resetHistories clear the chat context of stage, for example, the conversation log is cleared.
+func (v *Stage) resetHistories() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.previousUser, v.previousAssitant, v.histories = "", "", nil
+	v.postPreviousUser, v.postPreviousAssitant, v.postHistories = "", "", nil
+	v.memory.reset()
+	v.postMemory.reset()
+	for _, user := range v.users {
+		user.previousAsrText = ""
+	}
+}
+
+// The max messages of the conversation log of a room, the oldest messages are trimmed.
+const maxStageConversationMessages = 1000
+
+// StageConversation is the conversation log of a live room, persisted in a redis list per room, see
+// stageConversationKey. It's not truncated by the chat window, and kept after the stage expired, but only
+// the last maxStageConversationMessages messages are kept.
+type StageConversation struct {
+	// The room UUID.
+	RoomUUID string `json:"room"`
+	// The last update time, in RFC3339.
+	Update string `json:"update"`
+	// The messages of all stages of room.
+	Messages []*StageConversationMessage `json:"messages,omitempty"`
+}
+
+// StageConversationMessage is a message of user, assistant or post-processing.
+type StageConversationMessage struct {
+	// The stage UUID.
+	StageUUID string `json:"sid"`
+	// The request UUID.
+	RequestUUID string `json:"rid"`
+	// The message role, user, assistant, post or tool.
+	Role string `json:"role"`
+	// The username who send this message.
+	Username string `json:"username,omitempty"`
+	// The message content.
+	Message string `json:"msg"`
+	// The create time, in RFC3339.
+	Created string `json:"created"`
+}
+
+func (v StageConversation) String() string {
+	return fmt.Sprintf("room=%v, update=%v, messages=%v", v.RoomUUID, v.Update, len(v.Messages))
+}
+
+// stageConversationKey is the redis list of conversation log of the room, each element is a message.
+func stageConversationKey(roomUUID string) string {
+	return fmt.Sprintf("%v:%v", SRS_AI_TALK_CONVERSATION, roomUUID)
+}
+
+func queryStageConversation(ctx context.Context, roomUUID string) (*StageConversation, error) {
+	key := stageConversationKey(roomUUID)
+	messages, err := rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "lrange %v", key)
+	}
+
+	conversation := &StageConversation{RoomUUID: roomUUID}
+	for _, b := range messages {
+		var message StageConversationMessage
+		if err := json.Unmarshal([]byte(b), &message); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", b)
+		}
+		conversation.Messages = append(conversation.Messages, &message)
+		conversation.Update = message.Created
+	}
+	return conversation, nil
+}
+
+// AppendConversation append the message to the conversation log of the room of stage.
+func (v *TalkServer) AppendConversation(ctx context.Context, stage *Stage, message *StageConversationMessage) error {
+	if strings.TrimSpace(message.Message) == "" {
+		return nil
+	}
+
+	message.StageUUID = stage.sid
+	message.Created = time.Now().Format(time.RFC3339)
+
+	b, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrapf(err, "marshal message")
+	}
+
+	// Append the message and trim the oldest ones, in one transaction.
+	key := stageConversationKey(stage.room.UUID)
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, string(b))
+		pipe.LTrim(ctx, key, -maxStageConversationMessages, -1)
+		return nil
+	}); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "rpush %v %v", key, string(b))
+	}
+	return nil
+}
+
+// onChatFinished log the response of chat or post-processing, and save the chat context of stage.
+func (v *TalkServer) onChatFinished(ctx context.Context, stage *Stage, sreq *StageRequest, role, text string) {
+	if err := v.AppendConversation(ctx, stage, &StageConversationMessage{
+		RequestUUID: sreq.rid, Role: role, Username: stage.room.AIName, Message: text,
+	}); err != nil {
+		logger.Wf(ctx, "Stage: Ignore append conversation sid=%v, rid=%v err %+v", stage.sid, sreq.rid, err)
+	}
+
+	if err := stage.Save(ctx); err != nil {
+		logger.Wf(ctx, "Stage: Ignore save stage sid=%v err %+v", stage.sid, err)
+	}
+}
+
+// Start restore the stages from redis, which is bound to the room. The stage is discarded if room is
+// removed, assistant is disabled, or room binds to another stage.
+func (v *TalkServer) Start(ctx context.Context) error {
+	snapshots, err := rdb.HGetAll(ctx, SRS_AI_TALK_STAGE).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_AI_TALK_STAGE)
+	}
+
+	for sid, b := range snapshots {
+		if err := func() error {
+			var snapshot StageSnapshot
+			if err := json.Unmarshal([]byte(b), &snapshot); err != nil {
+				return errors.Wrapf(err, "unmarshal %v", b)
+			}
+
+			var room SrsLiveRoom
+			if r0, err := rdb.HGet(ctx, SRS_LIVE_ROOM, snapshot.RoomUUID).Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v %v", SRS_LIVE_ROOM, snapshot.RoomUUID)
+			} else if r0 != "" {
+				if err = json.Unmarshal([]byte(r0), &room); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", snapshot.RoomUUID, r0)
+				}
+			}
+
+			if room.UUID == "" || !room.Assistant || room.StageUUID != sid {
+				logger.Tf(ctx, "Stage: Discard %v, room=%v, assistant=%v, roomStage=%v",
+					snapshot.String(), room.UUID, room.Assistant, room.StageUUID)
+				if err := rdb.HDel(ctx, SRS_AI_TALK_STAGE, sid).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_AI_TALK_STAGE, sid)
+				}
+				return nil
+			}
+
+			stageCtx := logger.WithContext(ctx)
+			stage := NewStage(func(stage *Stage) {
+				stage.sid, stage.loggingCtx = sid, stageCtx
+				stage.UpdateFromRoom(&room)
+				stage.restore(&snapshot)
+			})
+
+			v.AddStage(stage)
+			v.serveStage(stageCtx, stage)
+			for _, user := range stage.copyUsers() {
+				stage.serveUser(stageCtx, user)
+			}
+			for _, subscriber := range stage.copySubscribers() {
+				stage.serveSubscriber(stageCtx, subscriber)
+			}
+
+			logger.Tf(stageCtx, "Stage: Restore %v", snapshot.String())
+			return nil
+		}(); err != nil {
+			logger.Wf(ctx, "Stage: Ignore restore sid=%v err %+v", sid, err)
+		}
+	}
+
+	logger.Tf(ctx, "talk server start ok, stages=%v", v.CountStage())
+	return nil
+}
+
+func (v *TalkServer) handleConversationService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai-talk/conversation/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, roomUUID, roomToken string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token     *string `json:"token"`
+				RoomUUID  *string `json:"room"`
+				RoomToken *string `json:"roomToken"`
+			}{
+				Token: &token, RoomUUID: &roomUUID, RoomToken: &roomToken,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			// Authenticate by bearer token if no room token
+			if roomToken == "" {
+				apiSecret := envApiSecret()
+				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+			}
+
+			if roomUUID == "" {
+				return errors.Errorf("empty room id")
+			}
+
+			// Authenticate by room token if got one.
+			if roomToken != "" {
+				var room SrsLiveRoom
+				if r0, err := rdb.HGet(ctx, SRS_LIVE_ROOM, roomUUID).Result(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hget %v %v", SRS_LIVE_ROOM, roomUUID)
+				} else if r0 == "" {
+					return errors.Errorf("live room %v not exists", roomUUID)
+				} else if err = json.Unmarshal([]byte(r0), &room); err != nil {
+					return errors.Wrapf(err, "unmarshal %v %v", roomUUID, r0)
+				}
+
+				if room.RoomToken != roomToken {
+					return errors.Errorf("invalid room token %v", roomToken)
+				}
+			}
+
+			conversation, err := queryStageConversation(ctx, roomUUID)
+			if err != nil {
+				return errors.Wrapf(err, "query conversation of room %v", roomUUID)
+			}
+
+			ohttp.WriteData(ctx, w, r, conversation)
+			logger.Tf(ctx, "ai-talk conversation query ok, %v, token=%vB", conversation.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai-talk/conversation/clear"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, roomUUID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token    *string `json:"token"`
+				RoomUUID *string `json:"room"`
+			}{
+				Token: &token, RoomUUID: &roomUUID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if roomUUID == "" {
+				return errors.Errorf("empty room id")
+			}
+
+			key := stageConversationKey(roomUUID)
+			if err := rdb.Del(ctx, key).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "del %v", key)
+			}
+
+			// Also forget the chat context of the active stage, so the assistant starts a new conversation.
+			if stage := v.QueryStageOfRoom(roomUUID); stage != nil {
+				stage.resetHistories()
+				if err := stage.Save(ctx); err != nil {
+					return errors.Wrapf(err, "save stage %v", stage.sid)
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ai-talk conversation clear ok, room=%v, token=%vB", roomUUID, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestStageStore_SnapshotRestore(t *testing.T) {
+	room := &SrsLiveRoom{UUID: "room-1"}
+	stage := NewStage(func(stage *Stage) {
+		stage.room = room
+		stage.previousUser, stage.previousAssitant = "How are you?", "Fine."
+		stage.histories = []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "Hi"},
+			{Role: openai.ChatMessageRoleAssistant, Content: "Hello"},
+		}
+		stage.postHistories = []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "Hello"},
+		}
+	})
+	stage.addUser(&StageUser{UserID: "user-1", Username: "Alice", Language: "en", previousAsrText: "Hi", stage: stage})
+	stage.addSubscriber(NewStageSubscriber(func(s *StageSubscriber) {
+		s.room, s.stage = room, stage
+	}))
+
+	b, err := json.Marshal(stage.snapshot())
+	if err != nil {
+		t.Fatalf("marshal err %+v", err)
+	}
+
+	var snapshot StageSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		t.Fatalf("unmarshal err %+v", err)
+	}
+	if snapshot.RoomUUID != room.UUID || snapshot.StageUUID != stage.sid {
+		t.Errorf("invalid snapshot %v", snapshot.String())
+	}
+
+	restored := NewStage(func(v *Stage) {
+		v.room = room
+		v.restore(&snapshot)
+	})
+	if restored.sid != stage.sid || restored.previousUser != stage.previousUser || restored.previousAssitant != stage.previousAssitant {
+		t.Errorf("invalid stage sid=%v, previous=%v/%v", restored.sid, restored.previousUser, restored.previousAssitant)
+	}
+	if len(restored.histories) != 2 || restored.histories[1].Content != "Hello" || len(restored.postHistories) != 1 {
+		t.Errorf("invalid histories %v, post %v", restored.histories, restored.postHistories)
+	}
+	if user := restored.queryUser("user-1"); user == nil || user.Username != "Alice" || user.previousAsrText != "Hi" || user.stage != restored {
+		t.Errorf("invalid user %v", user)
+	}
+	if len(restored.subscribers) != 1 || restored.querySubscriber(stage.subscribers[0].spid) == nil {
+		t.Errorf("invalid subscribers %v", len(restored.subscribers))
+	}
+
+	restored.resetHistories()
+	if len(restored.histories) != 0 || restored.previousUser != "" || restored.users[0].previousAsrText != "" {
+		t.Errorf("invalid reset histories %v", restored.histories)
+	}
+}
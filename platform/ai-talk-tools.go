@@ -0,0 +1,360 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+// The builtin tools of assistant, which should be permitted by room.
+const (
+	// Query the viewers of room stream, and the publish and play counters.
+	StageToolViewers = "viewers"
+	// Start or stop the recording of room stream.
+	StageToolRecord = "record"
+	// Read the latest OCR text of room stream.
+	StageToolOCR = "ocr"
+	// Read the latest transcript text of room stream.
+	StageToolTranscript = "transcript"
+)
+
+var stageBuiltinTools = []string{StageToolViewers, StageToolRecord, StageToolOCR, StageToolTranscript}
+
+// The name of tool for AI, see https://platform.openai.com/docs/api-reference/chat/create#chat-create-tools
+var stageToolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// The max rounds of tool calls for a request, to avoid endless calls.
+const maxStageToolRounds = 3
+
+// The max size of tool result, to limit the tokens.
+const maxStageToolResult = 4096
+
+// The parameters of tool without arguments.
+var stageToolNoParameters = json.RawMessage(`{"type":"object","properties":{}}`)
+
+// StageTool is a tool for AI to call, the result is a text for AI.
+type StageTool struct {
+	// The definition for AI.
+	definition openai.FunctionDefinition
+	// Call the tool with arguments in JSON.
+	call func(ctx context.Context, stage *Stage, args string) (string, error)
+}
+
+// stageBuiltinToolName whether the name is used by a builtin tool.
+func stageBuiltinToolName(name string) bool {
+	for _, tool := range buildStageBuiltinTools(stageBuiltinTools) {
+		if tool.definition.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildStageTools build the tools permitted by room, the builtin tools and user defined HTTP tools.
+func buildStageTools(room *SrsLiveRoom) []*StageTool {
+	if room == nil {
+		return nil
+	}
+
+	tools := buildStageBuiltinTools(room.AITools)
+	for _, t := range room.AIHTTPTools {
+		tool := t
+		parameters := stageToolNoParameters
+		if len(tool.Parameters) > 0 {
+			parameters = tool.Parameters
+		}
+
+		tools = append(tools, &StageTool{
+			definition: openai.FunctionDefinition{
+				Name: tool.Name, Description: tool.Description, Parameters: parameters,
+			},
+			call: func(ctx context.Context, stage *Stage, args string) (string, error) {
+				return callStageHTTPTool(ctx, tool, args)
+			},
+		})
+	}
+	return tools
+}
+
+func buildStageBuiltinTools(permissions []string) []*StageTool {
+	var tools []*StageTool
+	if slicesContains(permissions, StageToolViewers) {
+		tools = append(tools, &StageTool{
+			definition: openai.FunctionDefinition{
+				Name:        "get_viewers",
+				Description: "Get the current viewers of the live room, and the total publish and play counters.",
+				Parameters:  stageToolNoParameters,
+			},
+			call: func(ctx context.Context, stage *Stage, args string) (string, error) {
+				return queryStageViewers(ctx, stage.room.StreamName)
+			},
+		})
+	}
+	if slicesContains(permissions, StageToolRecord) {
+		for _, t := range []struct {
+			name, description string
+			enabled           bool
+		}{
+			{"start_recording", "Start recording the live stream of the room.", true},
+			{"stop_recording", "Stop recording the live stream of the room.", false},
+		} {
+			enabled := t.enabled
+			tools = append(tools, &StageTool{
+				definition: openai.FunctionDefinition{
+					Name: t.name, Description: t.description, Parameters: stageToolNoParameters,
+				},
+				call: func(ctx context.Context, stage *Stage, args string) (string, error) {
+					// Only change the recording of room stream, never the global switch of all streams.
+					field := recordStreamField("live", stage.room.StreamName)
+					if enabled {
+						if err := rdb.HSet(ctx, SRS_RECORD_PATTERNS, field, "true").Err(); err != nil && err != redis.Nil {
+							return "", errors.Wrapf(err, "hset %v %v", SRS_RECORD_PATTERNS, field)
+						}
+					} else if err := rdb.HDel(ctx, SRS_RECORD_PATTERNS, field).Err(); err != nil && err != redis.Nil {
+						return "", errors.Wrapf(err, "hdel %v %v", SRS_RECORD_PATTERNS, field)
+					}
+
+					all, recording, err := queryRecordEnabled(ctx, "live", stage.room.StreamName)
+					if err != nil {
+						return "", errors.Wrapf(err, "query record")
+					}
+					return fmt.Sprintf(`{"recording":%v,"all":%v}`, recording, all), nil
+				},
+			})
+		}
+	}
+	if slicesContains(permissions, StageToolOCR) {
+		tools = append(tools, &StageTool{
+			definition: openai.FunctionDefinition{
+				Name:        "get_ocr_text",
+				Description: "Get the latest text recognized from the video frames of the live room.",
+				Parameters:  stageToolNoParameters,
+			},
+			call: func(ctx context.Context, stage *Stage, args string) (string, error) {
+				histories, err := queryOCRHistories(ctx, &OCRHistoryFilter{App: "live", Stream: stage.room.StreamName})
+				if err != nil {
+					return "", errors.Wrapf(err, "query ocr")
+				}
+				if len(histories) == 0 {
+					return "No OCR text.", nil
+				}
+				return fmt.Sprintf("%v %v", histories[0].Time, histories[0].Text), nil
+			},
+		})
+	}
+	if slicesContains(permissions, StageToolTranscript) {
+		tools = append(tools, &StageTool{
+			definition: openai.FunctionDefinition{
+				Name:        "get_transcript_text",
+				Description: "Get the latest transcript text of the speech in the live room.",
+				Parameters:  stageToolNoParameters,
+			},
+			call: func(ctx context.Context, stage *Stage, args string) (string, error) {
+				if transcriptWorker == nil {
+					return "No transcript text.", nil
+				}
+
+				// Only use the task of room stream, never the text of other streams.
+				task, err := transcriptWorker.queryTask("", "live", stage.room.StreamName)
+				if err != nil {
+					return "No transcript text.", nil
+				}
+
+				if text := task.overlayText(); text != "" {
+					return text, nil
+				}
+				return "No transcript text.", nil
+			},
+		})
+	}
+	return tools
+}
+
+// queryStageViewers query the clients of stream from SRS, and the counters of platform.
+func queryStageViewers(ctx context.Context, stream string) (string, error) {
+	counters, err := rdb.HGetAll(ctx, SRS_STAT_COUNTER).Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hgetall %v", SRS_STAT_COUNTER)
+	}
+
+	res := struct {
+		Online  bool   `json:"online"`
+		Viewers int    `json:"viewers"`
+		Publish string `json:"publish,omitempty"`
+		Play    string `json:"play,omitempty"`
+	}{
+		Publish: counters["publish"], Play: counters["play"],
+	}
+
+	// Ignore the error of SRS API, for example, SRS is restarting.
+	if err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:1985/api/v1/streams/?count=1000", nil)
+		if err != nil {
+			return errors.Wrapf(err, "new request")
+		}
+
+		resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+		if err != nil {
+			return errors.Wrapf(err, "query streams")
+		}
+		defer resp.Body.Close()
+
+		var streams struct {
+			Streams []struct {
+				App     string `json:"app"`
+				Name    string `json:"name"`
+				Clients int    `json:"clients"`
+				Publish struct {
+					Active bool `json:"active"`
+				} `json:"publish"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&streams); err != nil {
+			return errors.Wrapf(err, "decode streams")
+		}
+
+		for _, s := range streams.Streams {
+			if s.App == "live" && s.Name == stream {
+				res.Online, res.Viewers = s.Publish.Active, s.Clients
+				// The publisher is also a client.
+				if s.Publish.Active && res.Viewers > 0 {
+					res.Viewers--
+				}
+			}
+		}
+		return nil
+	}(); err != nil {
+		logger.Wf(ctx, "Tool: Ignore query viewers of %v, err %+v", stream, err)
+	}
+
+	b, err := json.Marshal(&res)
+	if err != nil {
+		return "", errors.Wrapf(err, "marshal %v", res)
+	}
+	return string(b), nil
+}
+
+// callStageHTTPTool POST the arguments to the URL of tool, the response body is the result.
+func callStageHTTPTool(ctx context.Context, tool *SrsAssistantHTTPTool, args string) (string, error) {
+	if args == "" {
+		args = "{}"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tool.URL, bytes.NewReader([]byte(args)))
+	if err != nil {
+		return "", errors.Wrapf(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if tool.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", tool.Token))
+	}
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "request %v", tool.URL)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStageToolResult))
+	if err != nil {
+		return "", errors.Wrapf(err, "read body")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("status %v, body %v", resp.StatusCode, string(b))
+	}
+	return string(b), nil
+}
+
+// callTool call the tool for AI, the call and result are shipped as stage messages, and the error is
+// returned to AI as result.
+func (v *Stage) callTool(ctx context.Context, sreq *StageRequest, tools []*StageTool, call openai.ToolCall) string {
+	name, args := call.Function.Name, call.Function.Arguments
+
+	result, err := func() (string, error) {
+		for _, tool := range tools {
+			if tool.definition.Name == name {
+				return tool.call(ctx, v, args)
+			}
+		}
+		return "", errors.Errorf("tool %v not permitted", name)
+	}()
+	if err != nil {
+		logger.Wf(ctx, "Tool: Ignore call %v(%v) of rid=%v, err %+v", name, args, sreq.rid, err)
+		result = fmt.Sprintf("Error: %v", err.Error())
+	}
+	if len(result) > maxStageToolResult {
+		result = result[:maxStageToolResult]
+	}
+	logger.Tf(ctx, "Tool: Call %v(%v) of rid=%v, result is %v", name, args, sreq.rid, result)
+
+	message := fmt.Sprintf("%v(%v): %v", name, args, result)
+	for _, subscriber := range v.copySubscribers() {
+		subscriber.addToolMessage(sreq.rid, name, message)
+	}
+	if sreq.onTool != nil {
+		sreq.onTool(name, args, result)
+	}
+	if err := talkServer.AppendConversation(ctx, v, &StageConversationMessage{
+		RequestUUID: sreq.rid, Role: "tool", Username: name, Message: message,
+	}); err != nil {
+		logger.Wf(ctx, "Tool: Ignore append conversation rid=%v, err %+v", sreq.rid, err)
+	}
+	return result
+}
+
+// requestTools request AI with tools, and call the tools util AI answers without tool calls, return the
+// messages with tool calls and results, and the answer of AI, which is empty if exceed the max rounds.
+func (v *openaiChatService) requestTools(
+	ctx context.Context, stage *Stage, sreq *StageRequest, gptReq openai.ChatCompletionRequest, tools []*StageTool,
+) ([]openai.ChatCompletionMessage, string, error) {
+	gptReq.Stream = false
+	gptReq.Tools = stageToolDefinitions(tools)
+
+	client := openai.NewClientWithConfig(v.conf)
+	for i := 0; i < maxStageToolRounds; i++ {
+		gptChat, err := client.CreateChatCompletion(ctx, gptReq)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "create chat with tools")
+		}
+		if len(gptChat.Choices) == 0 {
+			return gptReq.Messages, "", nil
+		}
+
+		message := gptChat.Choices[0].Message
+		if len(message.ToolCalls) == 0 {
+			return gptReq.Messages, message.Content, nil
+		}
+
+		gptReq.Messages = append(gptReq.Messages, message)
+		for _, call := range message.ToolCalls {
+			gptReq.Messages = append(gptReq.Messages, openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleTool, ToolCallID: call.ID, Name: call.Function.Name,
+				Content: stage.callTool(ctx, sreq, tools, call),
+			})
+		}
+	}
+	return gptReq.Messages, "", nil
+}
+
+func stageToolDefinitions(tools []*StageTool) []openai.Tool {
+	var definitions []openai.Tool
+	for _, tool := range tools {
+		definition := tool.definition
+		definitions = append(definitions, openai.Tool{Type: openai.ToolTypeFunction, Function: &definition})
+	}
+	return definitions
+}
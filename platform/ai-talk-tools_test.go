@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStageTools_Build(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer xxx" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"echo":%v}`, string(b))))
+	}))
+	defer server.Close()
+
+	room := &SrsLiveRoom{}
+	room.AITools = []string{StageToolViewers, StageToolRecord, StageToolOCR}
+	room.AIHTTPTools = []*SrsAssistantHTTPTool{{Name: "weather", URL: server.URL, Token: "xxx"}}
+	if err := room.SrsAssistantTools.Validate(); err != nil {
+		t.Fatalf("validate tools err %+v", err)
+	}
+
+	var names []string
+	for _, tool := range buildStageTools(room) {
+		names = append(names, tool.definition.Name)
+	}
+	if r0 := strings.Join(names, ","); r0 != "get_viewers,start_recording,stop_recording,get_ocr_text,weather" {
+		t.Errorf("invalid tools %v", r0)
+	}
+
+	if r0, err := callStageHTTPTool(context.Background(), room.AIHTTPTools[0], `{"city":"Paris"}`); err != nil {
+		t.Errorf("call tool err %+v", err)
+	} else if r0 != `{"echo":{"city":"Paris"}}` {
+		t.Errorf("invalid result %v", r0)
+	}
+
+	// The tool names should be valid and unique.
+	for _, tools := range [][]*SrsAssistantHTTPTool{
+		{{Name: "get weather", URL: server.URL}},
+		{{Name: "get_viewers", URL: server.URL}},
+		{{Name: "weather", URL: "ftp://localhost"}},
+	} {
+		if err := (&SrsAssistantTools{AIHTTPTools: tools}).Validate(); err == nil {
+			t.Errorf("should fail for %v", tools[0].Name)
+		}
+	}
+	if err := (&SrsAssistantTools{AITools: []string{"shell"}}).Validate(); err == nil {
+		t.Errorf("should fail for invalid builtin tool")
+	}
+}
@@ -0,0 +1,656 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+)
+
+// The audio format of realtime session. The pcm is signed 16 bits little-endian samples, while the webm
+// and ogg are the opus in container, for example, by MediaRecorder of browser.
+const (
+	StageAudioFormatPCM  = "pcm"
+	StageAudioFormatWebM = "webm"
+	StageAudioFormatOgg  = "ogg"
+)
+
+// The max bitrate of opus in bps, to estimate the max bytes of webm and ogg in duration.
+const stageSessionOpusMaxBitrate = 510000
+
+// The trailing window of audio for partial ASR, to not send the whole utterance each time.
+const stageSessionPartialWindow = 10 * time.Second
+
+// stageSessionAudioBytes is the bytes of audio in duration, the pcm is signed 16 bits, while the webm and
+// ogg is estimated by the max bitrate of opus.
+func stageSessionAudioBytes(format string, duration time.Duration, sampleRate, channels int) int {
+	if format == StageAudioFormatPCM {
+		return int(duration.Milliseconds()) * sampleRate / 1000 * channels * 2
+	}
+	return int(duration.Milliseconds()) * stageSessionOpusMaxBitrate / 8 / 1000
+}
+
+// StageSessionCommand is the command from client, in text message. The audio of utterance is sent in
+// binary messages, between the start and stop commands.
+type StageSessionCommand struct {
+	// The command type, start, stop, cancel, text or ping.
+	Type string `json:"type"`
+	// For start, the audio format, pcm, webm or ogg. Default to pcm.
+	Format string `json:"format,omitempty"`
+	// For start, the sample rate and channels of pcm. Default to 16000 and 1.
+	SampleRate int `json:"sampleRate,omitempty"`
+	Channels   int `json:"channels,omitempty"`
+	// For start, the interval in seconds to push partial ASR text. Zero to disable.
+	Partial float64 `json:"partial,omitempty"`
+	// For start, enable the continuous mode for pcm, to segment the audio to utterances by server side VAD,
+	// until stop. The fields not set use the VAD config of room.
+	VAD *VADConfig `json:"vad,omitempty"`
+	// For text, the text message of user.
+	Text string `json:"text,omitempty"`
+	// For stop and text, merge the messages of conversations, see stage upload.
+	MergeMessages int `json:"mergeMessages,omitempty"`
+}
+
+// StageSessionEvent is the event to client, in text message. For segment with audio, the audio file is
+// sent in a binary message immediately after the event.
+type StageSessionEvent struct {
+	// The event type, ready, started, barge-in, asr, token, answer, segment, tool, error or pong. The barge-in
+	// is sent when user starts speaking in continuous mode, the client should stop playing the answers.
+	Type string `json:"type"`
+	// The stage and user UUID, for ready.
+	StageUUID string `json:"sid,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+	// The request UUID.
+	RequestUUID string `json:"rid,omitempty"`
+	// The ASR text, chat tokens, whole answer, segment text, tool result or error message.
+	Text string `json:"text,omitempty"`
+	// For asr, whether it's the partial text of utterance.
+	Partial bool `json:"partial,omitempty"`
+	// For answer, the role, assistant or post.
+	Role string `json:"role,omitempty"`
+	// For segment, the answer segment UUID, whether it's the first segment, and the audio format.
+	SegmentUUID string `json:"asid,omitempty"`
+	First       bool   `json:"first,omitempty"`
+	HasAudio    bool   `json:"hasAudio,omitempty"`
+	Format      string `json:"format,omitempty"`
+	// For ready, the hello voice url.
+	Voice string `json:"voice,omitempty"`
+	// For tool, the tool name and arguments in JSON.
+	Tool      string `json:"tool,omitempty"`
+	Arguments string `json:"args,omitempty"`
+}
+
+// StageSession is a realtime session of a user on stage, over WebSocket. The client streams the audio
+// of utterance up, and the ASR text, chat tokens and TTS audio are pushed down once produced. It uses
+// the same stage, request and segment as the HTTP API.
+type StageSession struct {
+	// The WebSocket connection.
+	conn *WebSocketConn
+	// The stage and user of session.
+	stage *Stage
+	user  *StageUser
+
+	// The current utterance, nil if not started.
+	sreq *StageRequest
+	// The audio format, sample rate and channels of utterance.
+	format     string
+	sampleRate int
+	channels   int
+	// The audio data of utterance, at most maxAudio bytes by the max speech of VAD config, the audio
+	// exceeds is dropped.
+	audio    []byte
+	maxAudio int
+	overflow bool
+	// The VAD for continuous mode, nil if not enabled.
+	vad *VAD
+	// The interval to push partial ASR, and the state of partial ASR.
+	partialInterval time.Duration
+	partialAt       time.Time
+	partialRunning  bool
+	// The lock to protect the utterance.
+	lock sync.Mutex
+}
+
+func NewStageSession(conn *WebSocketConn, stage *Stage, user *StageUser) *StageSession {
+	return &StageSession{conn: conn, stage: stage, user: user}
+}
+
+// Serve read the commands and audio of client, until the client closes the connection.
+func (v *StageSession) Serve(ctx context.Context) error {
+	if err := v.conn.WriteJSON(&StageSessionEvent{
+		Type: "ready", StageUUID: v.stage.sid, UserID: v.user.UserID, Voice: v.user.Voice,
+	}); err != nil {
+		return errors.Wrapf(err, "write ready")
+	}
+
+	for ctx.Err() == nil {
+		opcode, data, err := v.conn.ReadMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "read message")
+		}
+
+		// Any message from client keeps the stage and user alive.
+		v.stage.KeepAlive()
+		v.user.KeepAlive()
+
+		if opcode == WebSocketBinary {
+			v.onAudio(ctx, data)
+			continue
+		}
+
+		var cmd StageSessionCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", string(data))
+		}
+
+		if err := v.onCommand(ctx, &cmd); err != nil {
+			logger.Wf(ctx, "Session: Ignore command %v of sid=%v, user=%v, err %+v",
+				cmd.Type, v.stage.sid, v.user.UserID, err)
+			v.writeEvent(ctx, &StageSessionEvent{Type: "error", Text: err.Error()})
+		}
+	}
+	return nil
+}
+
+func (v *StageSession) onCommand(ctx context.Context, cmd *StageSessionCommand) error {
+	switch cmd.Type {
+	case "ping":
+		v.writeEvent(ctx, &StageSessionEvent{Type: "pong"})
+	case "start":
+		format := ChooseNotEmpty(cmd.Format, StageAudioFormatPCM)
+		if !slicesContains([]string{StageAudioFormatPCM, StageAudioFormatWebM, StageAudioFormatOgg}, format) {
+			return errors.Errorf("invalid format %v", format)
+		}
+
+		if cmd.VAD != nil && format != StageAudioFormatPCM {
+			return errors.Errorf("vad requires %v, got %v", StageAudioFormatPCM, format)
+		}
+
+		sampleRate, channels := cmd.SampleRate, cmd.Channels
+		if sampleRate <= 0 {
+			sampleRate = 16000
+		}
+		if channels <= 0 {
+			channels = 1
+		}
+		if sampleRate < 8000 || sampleRate > 48000 {
+			return errors.Errorf("invalid sample rate %v, should in [8000, 48000]", sampleRate)
+		}
+		if channels > 2 {
+			return errors.Errorf("invalid channels %v, should be 1 or 2", channels)
+		}
+
+		var vadConfig *VADConfig
+		if cmd.VAD != nil {
+			vadConfig = &VADConfig{}
+			if config := v.stage.queryVADConfig(); config != nil {
+				*vadConfig = *config
+			}
+			if cmd.VAD.Threshold != 0 {
+				vadConfig.Threshold = cmd.VAD.Threshold
+			}
+			if cmd.VAD.Silence != 0 {
+				vadConfig.Silence = cmd.VAD.Silence
+			}
+			if cmd.VAD.MinSpeech != 0 {
+				vadConfig.MinSpeech = cmd.VAD.MinSpeech
+			}
+			if cmd.VAD.MaxSpeech != 0 {
+				vadConfig.MaxSpeech = cmd.VAD.MaxSpeech
+			}
+			if cmd.VAD.Padding != 0 {
+				vadConfig.Padding = cmd.VAD.Padding
+			}
+			if err := vadConfig.Validate(); err != nil {
+				return errors.Wrapf(err, "validate vad")
+			}
+		}
+
+		// The max duration of utterance, by the VAD config of session or room.
+		maxSpeech := VADConfig{}.withDefaults().MaxSpeech
+		if vadConfig != nil {
+			maxSpeech = vadConfig.withDefaults().MaxSpeech
+		} else if config := v.stage.queryVADConfig(); config != nil {
+			maxSpeech = config.withDefaults().MaxSpeech
+		}
+
+		// In continuous mode, the request is created when user starts speaking.
+		var sreq *StageRequest
+		if vadConfig == nil {
+			sreq = v.newRequest()
+		}
+
+		var vad *VAD
+		if vadConfig != nil {
+			var err error
+			if vad, err = NewVAD(*vadConfig, sampleRate, channels); err != nil {
+				return errors.Wrapf(err, "create vad")
+			}
+		}
+
+		v.lock.Lock()
+		v.sreq, v.audio, v.vad, v.overflow = sreq, nil, vad, false
+		v.format, v.sampleRate, v.channels = format, sampleRate, channels
+		v.maxAudio = stageSessionAudioBytes(format, time.Duration(maxSpeech)*time.Millisecond, v.sampleRate, v.channels)
+		v.partialInterval = time.Duration(cmd.Partial * float64(time.Second))
+		v.partialAt = time.Now()
+		v.lock.Unlock()
+
+		if sreq != nil {
+			v.writeEvent(ctx, &StageSessionEvent{Type: "started", RequestUUID: sreq.rid, Format: format})
+			logger.Tf(ctx, "Session: Start utterance sid=%v, user=%v, rid=%v, format=%v, rate=%v, channels=%v, partial=%v",
+				v.stage.sid, v.user.UserID, sreq.rid, format, v.sampleRate, v.channels, v.partialInterval)
+		} else {
+			logger.Tf(ctx, "Session: Start continuous sid=%v, user=%v, rate=%v, channels=%v, partial=%v, vad=<%v>",
+				v.stage.sid, v.user.UserID, v.sampleRate, v.channels, v.partialInterval, vadConfig.String())
+		}
+	case "cancel":
+		v.lock.Lock()
+		v.sreq, v.audio, v.vad = nil, nil, nil
+		v.lock.Unlock()
+	case "stop":
+		// For continuous mode, end the utterance in progress and stop the VAD.
+		v.lock.Lock()
+		vad := v.vad
+		v.vad = nil
+		v.lock.Unlock()
+		if vad != nil {
+			if event := vad.Flush(); event != nil {
+				v.onSpeech(ctx, event)
+			}
+			return nil
+		}
+
+		v.lock.Lock()
+		sreq, audio := v.sreq, v.audio
+		v.sreq, v.audio = nil, nil
+		v.lock.Unlock()
+
+		if sreq == nil {
+			return errors.Errorf("no utterance")
+		}
+		if len(audio) == 0 {
+			return errors.Errorf("empty audio of rid %v", sreq.rid)
+		}
+
+		sreq.inputFile = v.buildAudioFile(sreq.rid, "input")
+		if err := v.writeAudioFile(sreq.inputFile, audio); err != nil {
+			return errors.Wrapf(err, "write %vB audio to %v", len(audio), sreq.inputFile)
+		}
+		sreq.lastUploadAudio = time.Now()
+
+		go v.handleRequest(sreq, true, "", cmd.MergeMessages)
+	case "text":
+		if cmd.Text == "" {
+			return errors.Errorf("empty text")
+		}
+
+		sreq := v.newRequest()
+		go v.handleRequest(sreq, false, cmd.Text, cmd.MergeMessages)
+	default:
+		return errors.Errorf("invalid command %v", cmd.Type)
+	}
+	return nil
+}
+
+// newRequest create a request of stage, with the callbacks to push events to client.
+func (v *StageSession) newRequest() *StageRequest {
+	ctx := v.stage.loggingCtx
+
+	sreq := &StageRequest{rid: uuid.NewString(), stage: v.stage}
+	sreq.lastSentence = time.Now()
+	// TODO: FIMXE: Should cleanup finished requests.
+	v.stage.addRequest(sreq)
+
+	sreq.onASR = func(text string) {
+		v.writeEvent(ctx, &StageSessionEvent{Type: "asr", RequestUUID: sreq.rid, Text: text})
+	}
+	sreq.onToken = func(text string) {
+		v.writeEvent(ctx, &StageSessionEvent{Type: "token", RequestUUID: sreq.rid, Text: text})
+	}
+	sreq.onAnswer = func(role, text string) {
+		v.writeEvent(ctx, &StageSessionEvent{Type: "answer", RequestUUID: sreq.rid, Role: role, Text: text})
+	}
+	sreq.onSegment = func(segment *AnswerSegment) {
+		v.writeSegment(ctx, sreq, segment)
+	}
+	sreq.onTool = func(name, args, result string) {
+		v.writeEvent(ctx, &StageSessionEvent{
+			Type: "tool", RequestUUID: sreq.rid, Tool: name, Arguments: args, Text: result,
+		})
+	}
+	return sreq
+}
+
+// handleRequest process the request in the context of stage, like the upload of stage.
+func (v *StageSession) handleRequest(sreq *StageRequest, hasAudio bool, text string, mergeMessages int) {
+	ctx := v.stage.loggingCtx
+	defer sreq.FastDispose()
+
+	if err := v.stage.handleRequest(ctx, sreq, v.user, hasAudio, text, mergeMessages); err != nil {
+		sreq.errs = append(sreq.errs, err)
+		logger.Wf(ctx, "Session: Ignore request sid=%v, rid=%v, user=%v, err %+v",
+			v.stage.sid, sreq.rid, v.user.UserID, err)
+		v.writeEvent(ctx, &StageSessionEvent{Type: "error", RequestUUID: sreq.rid, Text: err.Error()})
+	}
+}
+
+// onSpeech handle the speech event of VAD in continuous mode. When user starts speaking, create a request and
+// interrupt the answers in progress, then ask the assistant when user stops speaking.
+func (v *StageSession) onSpeech(ctx context.Context, event *VADEvent) {
+	if event.Type == VADSpeechStart {
+		sreq := v.newRequest()
+		v.stage.bargeIn(ctx, sreq)
+
+		v.lock.Lock()
+		v.sreq, v.audio, v.overflow, v.partialAt = sreq, nil, false, time.Now()
+		v.lock.Unlock()
+
+		v.writeEvent(ctx, &StageSessionEvent{Type: "barge-in", RequestUUID: sreq.rid})
+		v.writeEvent(ctx, &StageSessionEvent{Type: "started", RequestUUID: sreq.rid, Format: StageAudioFormatPCM})
+		logger.Tf(ctx, "Session: Speech start sid=%v, user=%v, rid=%v", v.stage.sid, v.user.UserID, sreq.rid)
+		return
+	}
+
+	v.lock.Lock()
+	sreq := v.sreq
+	v.sreq, v.audio = nil, nil
+	v.lock.Unlock()
+
+	if sreq == nil {
+		return
+	}
+
+	sreq.inputFile = v.buildAudioFile(sreq.rid, "input")
+	if err := v.writeAudioFile(sreq.inputFile, event.Audio); err != nil {
+		logger.Wf(ctx, "Session: Ignore speech rid=%v, audio=%vB, err %+v", sreq.rid, len(event.Audio), err)
+		v.writeEvent(ctx, &StageSessionEvent{Type: "error", RequestUUID: sreq.rid, Text: err.Error()})
+		return
+	}
+	sreq.lastUploadAudio = time.Now()
+	logger.Tf(ctx, "Session: Speech end sid=%v, user=%v, rid=%v, audio=%vB",
+		v.stage.sid, v.user.UserID, sreq.rid, len(event.Audio))
+
+	go v.handleRequest(sreq, true, "", 0)
+}
+
+// onAudio append the audio to utterance, and start the partial ASR if reach the interval. For continuous
+// mode, the audio is segmented to utterances by VAD.
+func (v *StageSession) onAudio(ctx context.Context, data []byte) {
+	var events []*VADEvent
+	v.lock.Lock()
+	if v.vad != nil {
+		events = v.vad.Write(data)
+	}
+	v.lock.Unlock()
+
+	for _, event := range events {
+		v.onSpeech(ctx, event)
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.sreq == nil {
+		return
+	}
+	if len(v.audio)+len(data) > v.maxAudio {
+		if !v.overflow {
+			v.overflow = true
+			logger.Wf(ctx, "Session: Drop audio of rid=%v, exceed max %vB", v.sreq.rid, v.maxAudio)
+		}
+		return
+	}
+	v.audio = append(v.audio, data...)
+
+	if v.partialInterval <= 0 || v.partialRunning || time.Since(v.partialAt) < v.partialInterval {
+		return
+	}
+	v.partialRunning, v.partialAt = true, time.Now()
+
+	// For pcm, only the trailing window of audio is used for partial ASR. The webm and ogg can't be cut
+	// without the header, so the whole utterance is used, which is limited by max speech.
+	sreq, audio := v.sreq, v.audio
+	if v.format == StageAudioFormatPCM {
+		window := stageSessionAudioBytes(v.format, stageSessionPartialWindow, v.sampleRate, v.channels)
+		if len(audio) > window {
+			audio = audio[len(audio)-window:]
+		}
+	}
+	audio = append([]byte{}, audio...)
+
+	// Copy the ASR context, which is updated by the requests of stage.
+	asrConfig, asrLanguage, previousAsrText := v.stage.queryASRContext(v.user)
+	go func() {
+		defer func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			v.partialRunning = false
+		}()
+
+		text, err := v.partialASR(ctx, sreq, audio, asrConfig, asrLanguage, previousAsrText)
+		if err != nil {
+			logger.Wf(ctx, "Session: Ignore partial asr rid=%v, audio=%vB, err %+v", sreq.rid, len(audio), err)
+			return
+		}
+
+		// Ignore if utterance is stopped or cancelled.
+		v.lock.Lock()
+		current := v.sreq
+		v.lock.Unlock()
+		if current == sreq && text != "" {
+			v.writeEvent(ctx, &StageSessionEvent{Type: "asr", RequestUUID: sreq.rid, Text: text, Partial: true})
+		}
+	}()
+}
+
+func (v *StageSession) partialASR(ctx context.Context, sreq *StageRequest, audio []byte, asrConfig *ASRProviderConfig, asrLanguage, previousAsrText string) (string, error) {
+	partialFile := v.buildAudioFile(sreq.rid, "partial")
+	defer os.Remove(partialFile)
+
+	if err := v.writeAudioFile(partialFile, audio); err != nil {
+		return "", errors.Wrapf(err, "write %vB audio to %v", len(audio), partialFile)
+	}
+
+	resp, err := NewOpenAIASRService(asrConfig).RequestASR(ctx, partialFile, asrLanguage, previousAsrText)
+	if err != nil {
+		return "", errors.Wrapf(err, "asr %v", partialFile)
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+func (v *StageSession) buildAudioFile(rid, name string) string {
+	v.lock.Lock()
+	format := v.format
+	v.lock.Unlock()
+
+	ext := format
+	if format == "" || format == StageAudioFormatPCM {
+		ext = "wav"
+	}
+	return path.Join(aiTalkWorkDir, fmt.Sprintf("assistant-%v-%v.%v", rid, name, ext))
+}
+
+// writeAudioFile write the audio to file, the pcm is written as wav.
+func (v *StageSession) writeAudioFile(filename string, audio []byte) error {
+	if strings.HasSuffix(filename, ".wav") {
+		v.lock.Lock()
+		sampleRate, channels := v.sampleRate, v.channels
+		v.lock.Unlock()
+
+		return writeWavFile(filename, audio, sampleRate, channels)
+	}
+
+	if err := os.WriteFile(filename, audio, 0644); err != nil {
+		return errors.Wrapf(err, "write %v", filename)
+	}
+	return nil
+}
+
+// writeSegment push the segment event, and the audio file in binary message if has audio.
+func (v *StageSession) writeSegment(ctx context.Context, sreq *StageRequest, segment *AnswerSegment) {
+	event := &StageSessionEvent{
+		Type: "segment", RequestUUID: sreq.rid, SegmentUUID: segment.asid, Text: segment.text,
+		First: segment.first,
+	}
+	if segment.err != nil {
+		event.Type, event.Text = "error", segment.err.Error()
+		v.writeEvent(ctx, event)
+		return
+	}
+
+	messages := []WebSocketMessage{}
+	if !segment.noTTS && segment.ttsFile != "" {
+		data, err := os.ReadFile(segment.ttsFile)
+		if err != nil {
+			logger.Wf(ctx, "Session: Ignore read %v, err %+v", segment.ttsFile, err)
+		} else {
+			event.HasAudio, event.Format = true, strings.Trim(path.Ext(segment.ttsFile), ".")
+			messages = append(messages, WebSocketMessage{Opcode: WebSocketBinary, Data: data})
+		}
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		logger.Wf(ctx, "Session: Ignore marshal %v, err %+v", event, err)
+		return
+	}
+	messages = append([]WebSocketMessage{{Opcode: WebSocketText, Data: b}}, messages...)
+
+	if err := v.conn.WriteMessages(messages...); err != nil {
+		logger.Wf(ctx, "Session: Ignore write segment rid=%v, asid=%v, err %+v", sreq.rid, segment.asid, err)
+		return
+	}
+	logger.Tf(ctx, "Session: Push segment rid=%v, asid=%v, audio=%v, text=%v",
+		sreq.rid, segment.asid, event.HasAudio, segment.text)
+}
+
+func (v *StageSession) writeEvent(ctx context.Context, event *StageSessionEvent) {
+	if err := v.conn.WriteJSON(event); err != nil {
+		logger.Wf(ctx, "Session: Ignore write %v event of sid=%v, err %+v", event.Type, v.stage.sid, err)
+	}
+}
+
+// writeWavFile write the signed 16 bits little-endian PCM as wav file.
+func writeWavFile(filename string, pcm []byte, sampleRate, channels int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "create %v", filename)
+	}
+	defer f.Close()
+
+	buf := &audio.IntBuffer{
+		Data:           make([]int, len(pcm)/2),
+		Format:         &audio.Format{SampleRate: sampleRate, NumChannels: channels},
+		SourceBitDepth: 16,
+	}
+	for i := range buf.Data {
+		buf.Data[i] = int(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+
+	enc := wav.NewEncoder(f, sampleRate, 16, channels, 1)
+	if err := enc.Write(buf); err != nil {
+		return errors.Wrapf(err, "write audio")
+	}
+	if err := enc.Close(); err != nil {
+		return errors.Wrapf(err, "close encoder")
+	}
+	return nil
+}
+
+func handleAITalkSessionService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai-talk/stage/ws"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		var conn *WebSocketConn
+		if err := func() error {
+			// The WebSocket of browser is not able to set headers, so the params are in query.
+			q := r.URL.Query()
+			token, roomUUID, roomToken := q.Get("token"), q.Get("room"), q.Get("roomToken")
+			sid, userID := q.Get("sid"), q.Get("userId")
+
+			// Authenticate by bearer token if no room token
+			if roomToken == "" {
+				apiSecret := envApiSecret()
+				if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+					return errors.Wrapf(err, "authenticate")
+				}
+			}
+
+			if sid == "" {
+				return errors.Errorf("empty sid")
+			}
+			if userID == "" {
+				return errors.Errorf("empty userId")
+			}
+
+			stage := talkServer.QueryStage(sid)
+			if stage == nil {
+				return errors.Errorf("invalid sid %v", sid)
+			}
+
+			// Authenticate by room token if got one.
+			if roomToken != "" && stage.room.RoomToken != roomToken {
+				return errors.Errorf("invalid room token %v", roomToken)
+			}
+			if roomUUID != "" && stage.room.UUID != roomUUID {
+				return errors.Errorf("invalid room %v", roomUUID)
+			}
+
+			user := stage.queryUser(userID)
+			if user == nil {
+				return errors.Errorf("invalid user %v of sid %v", userID, sid)
+			}
+
+			// Keep alive the stage.
+			stage.KeepAlive()
+			user.KeepAlive()
+
+			var err error
+			if conn, err = UpgradeWebSocket(w, r); err != nil {
+				return errors.Wrapf(err, "upgrade")
+			}
+			defer conn.Close()
+
+			// Switch to the context of stage.
+			ctx := logger.WithContext(stage.loggingCtx)
+			logger.Tf(ctx, "Session: Start sid=%v, user=%v, room=%v", sid, userID, stage.room.UUID)
+
+			session := NewStageSession(conn, stage, user)
+			if err := session.Serve(ctx); err != nil {
+				return errors.Wrapf(err, "serve")
+			}
+
+			logger.Tf(ctx, "srs ai-talk session done, sid=%v, user=%v", sid, userID)
+			return nil
+		}(); err != nil {
+			// Response error in HTTP, if not upgraded to WebSocket.
+			if conn == nil {
+				ohttp.WriteError(ctx, w, r, err)
+			} else {
+				logger.Wf(ctx, "Session: Ignore err %+v", err)
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-audio/wav"
+)
+
+func TestStageSession_WavFile(t *testing.T) {
+	pcm := make([]byte, 32000)
+	pcm[0], pcm[1], pcm[2], pcm[3] = 0x01, 0x00, 0xff, 0xff
+
+	filename := path.Join(t.TempDir(), "input.wav")
+	if err := writeWavFile(filename, pcm, 16000, 1); err != nil {
+		t.Fatalf("write wav failed, %v", err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("open wav failed, %v", err)
+	}
+	defer f.Close()
+
+	buf, err := wav.NewDecoder(f).FullPCMBuffer()
+	if err != nil {
+		t.Fatalf("decode wav failed, %v", err)
+	}
+	if buf.Format.SampleRate != 16000 || buf.Format.NumChannels != 1 || len(buf.Data) != 16000 {
+		t.Errorf("invalid wav rate=%v, channels=%v, samples=%v", buf.Format.SampleRate, buf.Format.NumChannels, len(buf.Data))
+	}
+	if buf.Data[0] != 1 || buf.Data[1] != -1 {
+		t.Errorf("invalid samples %v", buf.Data[:2])
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -38,13 +39,14 @@ type ASRResult struct {
 }
 
 type openaiASRService struct {
-	conf openai.ClientConfig
+	// The ASR provider, OpenAI or self-hosted whisper server.
+	provider ASRProvider
 	// The callback before start ASR request.
 	onBeforeRequest func()
 }
 
-func NewOpenAIASRService(conf openai.ClientConfig, opts ...func(service *openaiASRService)) *openaiASRService {
-	v := &openaiASRService{conf: conf}
+func NewOpenAIASRService(conf *ASRProviderConfig, opts ...func(service *openaiASRService)) *openaiASRService {
+	v := &openaiASRService{provider: NewASRProvider(conf)}
 	for _, opt := range opts {
 		opt(v)
 	}
@@ -60,11 +62,13 @@ func (v *openaiASRService) RequestASR(ctx context.Context, inputFile, language,
 	// Transcode input audio in opus or aac, to aac in m4a/mp4 format.
 	// If need to encode to aac, use:
 	//		"-c:a", "aac", "-ac", "1", "-ar", "16000", "-ab", "30k",
-	if err := exec.CommandContext(ctx, "ffmpeg",
-		"-i", inputFile,
-		"-vn", "-c:a", "copy",
-		outputFile,
-	).Run(); err != nil {
+	// The PCM in wav from realtime session is not allowed in mp4, so we encode it to aac.
+	codec := []string{"-c:a", "copy"}
+	if strings.HasSuffix(inputFile, ".wav") {
+		codec = []string{"-c:a", "aac", "-ac", "1", "-ar", "16000", "-ab", "30k"}
+	}
+	args := append([]string{"-i", inputFile, "-vn"}, codec...)
+	if err := exec.CommandContext(ctx, "ffmpeg", append(args, outputFile)...).Run(); err != nil {
 		return nil, errors.Errorf("Error converting the file")
 	}
 	logger.Tf(ctx, "Convert audio %v to %v ok", inputFile, outputFile)
@@ -74,18 +78,7 @@ func (v *openaiASRService) RequestASR(ctx context.Context, inputFile, language,
 	}
 
 	// Request ASR.
-	client := openai.NewClientWithConfig(v.conf)
-	resp, err := client.CreateTranscription(
-		ctx,
-		openai.AudioRequest{
-			Model:    openai.Whisper1,
-			FilePath: outputFile,
-			// Note that must use verbose JSON, to get the duration of file.
-			Format:   openai.AudioResponseFormatVerboseJSON,
-			Language: language,
-			Prompt:   prompt,
-		},
-	)
+	resp, err := v.provider.Transcribe(ctx, outputFile, language, prompt)
 	if err != nil {
 		return nil, errors.Wrapf(err, "asr")
 	}
@@ -111,25 +104,12 @@ type openaiChatService struct {
 	conf openai.ClientConfig
 	// The callback for the first response.
 	onFirstResponse func(ctx context.Context, text string)
+	// The callback when the whole response is done.
+	onFinished func(ctx context.Context, text string)
 }
 
 func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest, stage *Stage, user *StageUser, taskCancel context.CancelFunc) error {
-	if stage.previousUser != "" && stage.previousAssitant != "" {
-		stage.histories = append(stage.histories, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: stage.previousUser,
-		}, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: stage.previousAssitant,
-		})
-
-		for len(stage.histories) > stage.chatWindow*2 {
-			stage.histories = stage.histories[1:]
-		}
-	}
-
-	stage.previousUser = user.previousAsrText
-	stage.previousAssitant = ""
+	histories := stage.startChat(ctx, v.conf, user.previousAsrText)
 
 	system := stage.prompt
 	system += fmt.Sprintf(" Keep your reply neat, limiting the reply to %v words.", stage.replyLimit)
@@ -147,7 +127,12 @@ func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest,
 		})
 	}
 
-	messages = append(messages, stage.histories...)
+	// The summary of turns out of window.
+	if summary := stage.memory.message(model); summary != nil {
+		messages = append(messages, *summary)
+	}
+
+	messages = append(messages, histories...)
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
 		Content: user.previousAsrText,
@@ -156,7 +141,7 @@ func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest,
 	maxTokens := 1024
 	temperature := float32(0.9)
 	logger.Tf(ctx, "AIChat is baseURL=%v, org=%v, model=%v, maxTokens=%v, temperature=%v, window=%v, histories=%v, system is %v",
-		v.conf.BaseURL, v.conf.OrgID, model, maxTokens, temperature, stage.chatWindow, len(stage.histories), system)
+		v.conf.BaseURL, v.conf.OrgID, model, maxTokens, temperature, stage.chatWindow, len(histories), system)
 
 	gptReq := openai.ChatCompletionRequest{
 		Model:    model,
@@ -169,26 +154,47 @@ func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest,
 		Temperature: gptModelSupportTemperature(model, temperature),
 	}
 
+	// Call the tools permitted by room before answering, and use the answer if AI doesn't call more tools.
+	var answer string
+	if tools := buildStageTools(stage.room); len(tools) > 0 {
+		var err error
+		if gptReq.Messages, answer, err = v.requestTools(ctx, stage, sreq, gptReq, tools); err != nil {
+			return errors.Wrapf(err, "request tools")
+		}
+
+		// Never call tools when exceed the max rounds.
+		gptReq.Tools, gptReq.ToolChoice = stageToolDefinitions(tools), "none"
+	}
+
 	// For OpenAI chat completion, without stream.
-	if !gptModelSupportStream(model) {
-		client := openai.NewClientWithConfig(v.conf)
-		gptChat, err := client.CreateChatCompletion(ctx, gptReq)
-		if err != nil {
-			return errors.Wrapf(err, "create chat")
+	if !gptModelSupportStream(model) || answer != "" {
+		if answer == "" {
+			client := openai.NewClientWithConfig(v.conf)
+			gptChat, err := client.CreateChatCompletion(ctx, gptReq)
+			if err != nil {
+				return errors.Wrapf(err, "create chat")
+			}
+			answer = gptChat.Choices[0].Message.Content
 		}
 
 		// For sync request, complete the task when finished.
 		defer taskCancel()
 
+		if sreq.onToken != nil {
+			sreq.onToken(answer)
+		}
 		if err := v.handleSentence(ctx,
-			stage, sreq, gptChat.Choices[0].Message.Content, true, nil,
+			stage, sreq, answer, true, nil,
 			func(sentence string) {
-				stage.previousAssitant += sentence + " "
+				stage.appendChatAnswer(sentence)
 			},
 		); err != nil {
 			return errors.Wrapf(err, "handle chat")
 		}
 
+		if v.onFinished != nil {
+			v.onFinished(ctx, answer)
+		}
 		return nil
 	}
 
@@ -207,7 +213,7 @@ func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest,
 		if err := v.handle(ctx,
 			stage, user, sreq, gptChatStream, aiFirstResponseCancel, taskCancel,
 			func(sentence string) {
-				stage.previousAssitant += sentence + " "
+				stage.appendChatAnswer(sentence)
 			},
 		); err != nil {
 			logger.Ef(ctx, "Handle stream failed, err %+v", err)
@@ -227,22 +233,7 @@ func (v *openaiChatService) RequestChat(ctx context.Context, sreq *StageRequest,
 }
 
 func (v *openaiChatService) RequestPostProcess(ctx context.Context, sreq *StageRequest, stage *Stage, user *StageUser) error {
-	if stage.postPreviousUser != "" && stage.postPreviousAssitant != "" {
-		stage.postHistories = append(stage.postHistories, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: stage.postPreviousUser,
-		}, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: stage.postPreviousAssitant,
-		})
-
-		for len(stage.postHistories) > stage.postChatWindow*2 {
-			stage.postHistories = stage.postHistories[1:]
-		}
-	}
-
-	stage.postPreviousUser = stage.previousAssitant
-	stage.postPreviousAssitant = ""
+	histories, chatAnswer := stage.startPost(ctx, v.conf)
 
 	system := stage.postPrompt
 	system += fmt.Sprintf(" Keep your reply neat, limiting the reply to %v words.", stage.postReplyLimit)
@@ -260,16 +251,21 @@ func (v *openaiChatService) RequestPostProcess(ctx context.Context, sreq *StageR
 		})
 	}
 
-	messages = append(messages, stage.postHistories...)
+	// The summary of turns out of window.
+	if summary := stage.postMemory.message(model); summary != nil {
+		messages = append(messages, *summary)
+	}
+
+	messages = append(messages, histories...)
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
-		Content: stage.previousAssitant,
+		Content: chatAnswer,
 	})
 
 	maxTokens := 1024
 	temperature := float32(0.9)
 	logger.Tf(ctx, "AIPostProcess is baseURL=%v, org=%v, model=%v, maxTokens=%v, temperature=%v, window=%v, histories=%v, system is %v",
-		v.conf.BaseURL, v.conf.OrgID, model, maxTokens, temperature, stage.postChatWindow, len(stage.postHistories), system)
+		v.conf.BaseURL, v.conf.OrgID, model, maxTokens, temperature, stage.postChatWindow, len(histories), system)
 
 	gptReq := openai.ChatCompletionRequest{
 		Model:    model,
@@ -290,15 +286,21 @@ func (v *openaiChatService) RequestPostProcess(ctx context.Context, sreq *StageR
 			return errors.Wrapf(err, "create post-process")
 		}
 
+		if sreq.onToken != nil {
+			sreq.onToken(gptChat.Choices[0].Message.Content)
+		}
 		if err := v.handleSentence(ctx,
 			stage, sreq, gptChat.Choices[0].Message.Content, true, nil,
 			func(sentence string) {
-				stage.postPreviousAssitant += sentence + " "
+				stage.appendPostAnswer(sentence)
 			},
 		); err != nil {
 			return errors.Wrapf(err, "handle post-process")
 		}
 
+		if v.onFinished != nil {
+			v.onFinished(ctx, gptChat.Choices[0].Message.Content)
+		}
 		return nil
 	}
 
@@ -317,7 +319,7 @@ func (v *openaiChatService) RequestPostProcess(ctx context.Context, sreq *StageR
 		if err := v.handle(ctx,
 			stage, user, sreq, gptChatStream, aiFirstResponseCancel, nil,
 			func(sentence string) {
-				stage.postPreviousAssitant += sentence + " "
+				stage.appendPostAnswer(sentence)
 			},
 		); err != nil {
 			logger.Ef(ctx, "Handle post-process stream failed, err %+v", err)
@@ -474,7 +476,11 @@ func (v *openaiChatService) handle(
 		return newSentence
 	}
 
+	var reply []string
 	commitAISentence := func(sentence string, firstSentense bool) error {
+		if sentence != "" {
+			reply = append(reply, sentence)
+		}
 		return v.handleSentence(ctx, stage, sreq, sentence, firstSentense, aiFirstResponseCancel, onSentence)
 	}
 
@@ -487,6 +493,9 @@ func (v *openaiChatService) handle(
 		} else {
 			isFinished, sentence, lastWords = finished, sentence+words, words
 		}
+		if sreq.onToken != nil && lastWords != "" {
+			sreq.onToken(lastWords)
+		}
 		//logger.Tf(ctx, "AI response: text=%v plus %v", lastWords, sentence)
 
 		newSentence := gotNewSentence(sentence, lastWords, firstSentense)
@@ -503,42 +512,9 @@ func (v *openaiChatService) handle(
 		sentence, firstSentense = "", false
 	}
 
-	return nil
-}
-
-type openaiTTSService struct {
-	conf openai.ClientConfig
-}
-
-func NewOpenAITTSService(conf openai.ClientConfig) *openaiTTSService {
-	return &openaiTTSService{conf: conf}
-}
-
-func (v *openaiTTSService) RequestTTS(ctx context.Context, buildFilepath func(ext string) string, text string) error {
-	ttsFile := buildFilepath("aac")
-
-	client := openai.NewClientWithConfig(v.conf)
-	resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
-		Model:          openai.TTSModel1,
-		Input:          text,
-		Voice:          openai.VoiceNova,
-		ResponseFormat: openai.SpeechResponseFormatAac,
-	})
-	if err != nil {
-		return errors.Wrapf(err, "create speech")
-	}
-	defer resp.Close()
-
-	out, err := os.Create(ttsFile)
-	if err != nil {
-		return errors.Errorf("Unable to create the file %v for writing", ttsFile)
-	}
-	defer out.Close()
-
-	if _, err = io.Copy(out, resp); err != nil {
-		return errors.Errorf("Error writing the file")
+	if isFinished && v.onFinished != nil {
+		v.onFinished(ctx, strings.Join(reply, " "))
 	}
-
 	return nil
 }
 
@@ -555,6 +531,9 @@ type StageRequest struct {
 	finished bool
 	// Whether merged to the next request.
 	merged bool
+	// Whether interrupted by user speaking, the TTS of answer is skipped, see barge-in. It's set by the
+	// goroutine of another request, so it's atomic, see interrupt and isInterrupted.
+	interrupted int32
 	// Processing errors of this request.
 	errs []error
 
@@ -583,6 +562,14 @@ type StageRequest struct {
 	segments []*AnswerSegment
 	// The owner stage.
 	stage *Stage
+
+	// The callbacks for realtime session such as WebSocket, to push the ASR text, the chat tokens, the
+	// whole answer of chat or post-processing, the TTS segments, and the tool calls once produced.
+	onASR     func(text string)
+	onToken   func(text string)
+	onAnswer  func(role, text string)
+	onSegment func(segment *AnswerSegment)
+	onTool    func(name, args, result string)
 }
 
 func (v *StageRequest) onSegmentReady(segment *AnswerSegment) {
@@ -602,6 +589,15 @@ func (v *StageRequest) Close() error {
 	return v.FastDispose()
 }
 
+// interrupt the request for barge-in, return false if already interrupted.
+func (v *StageRequest) interrupt() bool {
+	return atomic.CompareAndSwapInt32(&v.interrupted, 0, 1)
+}
+
+func (v *StageRequest) isInterrupted() bool {
+	return atomic.LoadInt32(&v.interrupted) != 0
+}
+
 // Fast cleanup the files of request, after converted to text by ASR service.
 func (v *StageRequest) FastDispose() error {
 	if v.inputFile != "" {
@@ -613,11 +609,11 @@ func (v *StageRequest) FastDispose() error {
 	return nil
 }
 
-func (v *StageRequest) asrAudioToText(ctx context.Context, aiConfig openai.ClientConfig, asrLanguage, previousAsrText string) error {
+func (v *StageRequest) asrAudioToText(ctx context.Context, asrConfig *ASRProviderConfig, asrLanguage, previousAsrText string) error {
 	var asrText string
 	var asrDuration time.Duration
 
-	asrService := NewOpenAIASRService(aiConfig, func(*openaiASRService) {
+	asrService := NewOpenAIASRService(asrConfig, func(*openaiASRService) {
 		v.lastExtractAudio = time.Now()
 	})
 
@@ -828,6 +824,13 @@ func (v *StageSubscriber) addUserTextMessage(rid, name, msg string) {
 	})
 }
 
+func (v *StageSubscriber) addToolMessage(rid, name, msg string) {
+	v.messages = append(v.messages, &StageMessage{
+		finished: true, MessageUUID: uuid.NewString(), subscriber: v,
+		RequestUUID: rid, Role: "tool", Message: msg, Username: name,
+	})
+}
+
 // Create a robot empty message, to keep the order of messages.
 func (v *StageSubscriber) createRobotEmptyMessage() *StageMessage {
 	message := &StageMessage{
@@ -1006,6 +1009,8 @@ type Stage struct {
 	postPreviousUser, postPreviousAssitant string
 	// The chat history, to use as prompt for next chat.
 	postHistories []openai.ChatCompletionMessage
+	// The memory of histories out of window.
+	postMemory *StageMemory
 	// Reply words limit.
 	postReplyLimit int
 	// AI Chat model.
@@ -1025,6 +1030,8 @@ type Stage struct {
 	previousUser, previousAssitant string
 	// The chat history, to use as prompt for next chat.
 	histories []openai.ChatCompletionMessage
+	// The memory of histories out of window.
+	memory *StageMemory
 	// The AI prompt.
 	prompt string
 	// Reply words limit.
@@ -1047,6 +1054,17 @@ type Stage struct {
 
 	// The AI configuration.
 	aiConfig openai.ClientConfig
+	// The ASR provider configuration.
+	asrConfig *ASRProviderConfig
+	// The TTS provider configuration.
+	ttsConfig *TTSProviderConfig
+	// Whether listen to the room stream, and the VAD configuration.
+	aiVadListen bool
+	vadConfig   *VADConfig
+	// The listener of room stream, nil if not listening.
+	listener *StageListener
+	// The mixer of assistant speech into room stream, nil if not mixing.
+	mixer *StageMixer
 	// The room it belongs to. Note that it's a caching object, update when updating the room. The room object
 	// is not the same one, even the uuid is the same. The room is always available when stage is not expired.
 	room *SrsLiveRoom
@@ -1056,6 +1074,9 @@ type Stage struct {
 	subscribers []*StageSubscriber
 	// All the users binding to this stage.
 	users []*StageUser
+
+	// To protect the users, subscribers and histories.
+	lock sync.Mutex
 }
 
 func NewStage(opts ...func(*Stage)) *Stage {
@@ -1066,6 +1087,9 @@ func NewStage(opts ...func(*Stage)) *Stage {
 		update: time.Now(),
 		// The TTS worker.
 		ttsWorker: NewTTSWorker(),
+		// The memory of chat and post processing.
+		memory:     NewStageMemory(),
+		postMemory: NewStageMemory(),
 	}
 
 	for _, opt := range opts {
@@ -1074,7 +1098,7 @@ func NewStage(opts ...func(*Stage)) *Stage {
 	return v
 }
 
-func (v Stage) String() string {
+func (v *Stage) String() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("enabled(asr:%v,chat:%v,post:%v,tts:%v)",
 		v.aiASREnabled, v.aiChatEnabled, v.aiPostEnabled, v.aiTtsEnabled))
@@ -1096,12 +1120,18 @@ func (v Stage) String() string {
 }
 
 func (v *Stage) Close() error {
-	for _, subscriber := range v.subscribers {
+	for _, subscriber := range v.copySubscribers() {
 		subscriber.Close()
 	}
-	for _, request := range v.requests {
+	for _, request := range v.copyRequests() {
 		request.Close()
 	}
+	if listener := v.detachListener(); listener != nil {
+		listener.Close()
+	}
+	if mixer := v.detachMixer(); mixer != nil {
+		mixer.Close()
+	}
 	return v.ttsWorker.Close()
 }
 
@@ -1113,6 +1143,9 @@ func helloVoiceFromLanguage(language string) string {
 }
 
 func (v *Stage) UpdateFromRoom(room *SrsLiveRoom) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	// Whether enabled.
 	v.aiASREnabled = room.AIASREnabled
 	v.aiChatEnabled = room.AIChatEnabled
@@ -1134,16 +1167,39 @@ func (v *Stage) UpdateFromRoom(room *SrsLiveRoom) {
 	v.postChatWindow = room.AIPostMaxWindow
 	v.postPrompt = room.AIPostPrompt
 
+	// Setup the memory of histories out of window.
+	v.memory.update(room.AIChatMemory, room.AIChatSummaryModel, room.AIChatSummaryWords)
+	v.postMemory.update(room.AIPostMemory, room.AIPostSummaryModel, room.AIPostSummaryWords)
+
 	// Initialize the AI services.
 	v.aiConfig = openai.DefaultConfig(room.AISecretKey)
 	v.aiConfig.OrgID = room.AIOrganization
 	v.aiConfig.BaseURL = room.AIBaseURL
+	v.asrConfig = room.SrsAssistant.ASRProviderConfig()
+	v.ttsConfig = room.SrsAssistant.TTSProviderConfig()
+	v.aiVadListen = room.AIVADListen
+	v.vadConfig = room.SrsAssistant.VADConfig()
 
 	// Bind stage to room.
 	room.StageUUID = v.sid
 	v.room = room
 }
 
+// helloVoice is the hello voice of language, generated by the voice of stage if customized, or the example
+// files of default voice.
+func (v *Stage) helloVoice(ctx context.Context, language string) string {
+	if !v.aiTtsEnabled || v.ttsConfig == nil || !v.ttsConfig.Customized() {
+		return helloVoiceFromLanguage(language)
+	}
+
+	filename, err := generateHelloVoice(ctx, aiTalkWorkDir, v.ttsConfig, language)
+	if err != nil {
+		logger.Wf(ctx, "Stage: Ignore hello voice of sid=%v, lang=%v, err %+v", v.sid, language, err)
+		return helloVoiceFromLanguage(language)
+	}
+	return filename
+}
+
 func (v *Stage) Expired() bool {
 	return time.Since(v.update) > 600*time.Second
 }
@@ -1153,10 +1209,16 @@ func (v *Stage) KeepAlive() {
 }
 
 func (v *Stage) addUser(user *StageUser) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	v.users = append(v.users, user)
 }
 
 func (v *Stage) removeUser(user *StageUser) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	for i, u := range v.users {
 		if u.UserID == user.UserID {
 			v.users = append(v.users[:i], v.users[i+1:]...)
@@ -1170,6 +1232,9 @@ func (v *Stage) queryUser(userID string) *StageUser {
 		return nil
 	}
 
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	for _, user := range v.users {
 		if user.UserID == userID {
 			return user
@@ -1179,6 +1244,9 @@ func (v *Stage) queryUser(userID string) *StageUser {
 }
 
 func (v *Stage) addRequest(request *StageRequest) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	v.requests = append(v.requests, request)
 }
 
@@ -1187,6 +1255,9 @@ func (v *Stage) queryRequest(rid string) *StageRequest {
 		return nil
 	}
 
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	for _, request := range v.requests {
 		if request.rid == rid {
 			return request
@@ -1195,7 +1266,23 @@ func (v *Stage) queryRequest(rid string) *StageRequest {
 	return nil
 }
 
+// bargeIn interrupt the requests in progress when user starts speaking, so the TTS of answers is skipped.
+func (v *Stage) bargeIn(ctx context.Context, from *StageRequest) {
+	if mixer := v.queryMixer(); mixer != nil {
+		mixer.reset()
+	}
+
+	for _, request := range v.copyRequests() {
+		if request != from && !request.finished && request.interrupt() {
+			logger.Tf(ctx, "Stage: Barge-in rid=%v of sid=%v", request.rid, v.sid)
+		}
+	}
+}
+
 func (v *Stage) queryPreviousNotMergedRequests(from *StageRequest) []*StageRequest {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	var requests []*StageRequest
 	var matched bool
 	for i := len(v.requests) - 1; i >= 0; i-- {
@@ -1221,6 +1308,9 @@ func (v *Stage) queryPreviousNotMergedRequests(from *StageRequest) []*StageReque
 }
 
 func (v *Stage) addSubscriber(subscriber *StageSubscriber) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	v.subscribers = append(v.subscribers, subscriber)
 }
 
@@ -1229,6 +1319,9 @@ func (v *Stage) querySubscriber(spid string) *StageSubscriber {
 		return nil
 	}
 
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	for _, subscriber := range v.subscribers {
 		if subscriber.spid == spid {
 			return subscriber
@@ -1238,6 +1331,9 @@ func (v *Stage) querySubscriber(spid string) *StageSubscriber {
 }
 
 func (v *Stage) removeSubscriber(subscriber *StageSubscriber) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
 	for i, s := range v.subscribers {
 		if s.spid == subscriber.spid {
 			v.subscribers = append(v.subscribers[:i], v.subscribers[i+1:]...)
@@ -1246,6 +1342,269 @@ func (v *Stage) removeSubscriber(subscriber *StageSubscriber) {
 	}
 }
 
+// copyUsers copy the users, to iterate without lock.
+func (v *Stage) copyUsers() []*StageUser {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return append([]*StageUser{}, v.users...)
+}
+
+// copyRequests copy the requests, to iterate without lock.
+func (v *Stage) copyRequests() []*StageRequest {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return append([]*StageRequest{}, v.requests...)
+}
+
+// copySubscribers copy the subscribers, to iterate without lock.
+func (v *Stage) copySubscribers() []*StageSubscriber {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return append([]*StageSubscriber{}, v.subscribers...)
+}
+
+// startChat move the previous turn to histories, and start a new turn of user text. Return a copy of
+// histories as the prompt of chat.
+func (v *Stage) startChat(ctx context.Context, conf openai.ClientConfig, userText string) []openai.ChatCompletionMessage {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.previousUser != "" && v.previousAssitant != "" {
+		v.histories = append(v.histories, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: v.previousUser,
+		}, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: v.previousAssitant,
+		})
+
+		v.histories = v.memory.trim(v.histories, v.chatWindow*2)
+		v.memory.summarize(ctx, conf, v.chatModel, v.onMemorySummary(ctx))
+	}
+
+	v.previousUser = userText
+	v.previousAssitant = ""
+	return append([]openai.ChatCompletionMessage{}, v.histories...)
+}
+
+// appendChatAnswer append the sentence to the answer of current chat turn.
+func (v *Stage) appendChatAnswer(sentence string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.previousAssitant += sentence + " "
+}
+
+// startPost move the previous turn to post histories, and start a new turn of the chat answer. Return a copy
+// of post histories and the chat answer, as the prompt of post-processing.
+func (v *Stage) startPost(ctx context.Context, conf openai.ClientConfig) ([]openai.ChatCompletionMessage, string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.postPreviousUser != "" && v.postPreviousAssitant != "" {
+		v.postHistories = append(v.postHistories, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: v.postPreviousUser,
+		}, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: v.postPreviousAssitant,
+		})
+
+		v.postHistories = v.postMemory.trim(v.postHistories, v.postChatWindow*2)
+		v.postMemory.summarize(ctx, conf, ChooseNotEmpty(v.postChatModel, v.chatModel), v.onMemorySummary(ctx))
+	}
+
+	v.postPreviousUser = v.previousAssitant
+	v.postPreviousAssitant = ""
+	return append([]openai.ChatCompletionMessage{}, v.postHistories...), v.previousAssitant
+}
+
+// appendPostAnswer append the sentence to the answer of current post-processing turn.
+func (v *Stage) appendPostAnswer(sentence string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.postPreviousAssitant += sentence + " "
+}
+
+// queryASRContext get the ASR config, language and previous text of user, which are updated by requests.
+func (v *Stage) queryASRContext(user *StageUser) (asrConfig *ASRProviderConfig, asrLanguage, previousAsrText string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.asrConfig, ChooseNotEmpty(user.Language, v.asrLanguage), user.previousAsrText
+}
+
+// updatePreviousAsrText set the previous text of user, which is the prompt of next ASR.
+func (v *Stage) updatePreviousAsrText(user *StageUser, text string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	user.previousAsrText = text
+}
+
+// handleRequest convert the audio of request to text by ASR if has audio, or use the input text, then
+// chat and post-process, the answers are committed to the TTS worker as segments.
+func (v *Stage) handleRequest(ctx context.Context, sreq *StageRequest, user *StageUser, hasAudio bool, textMessage string, mergeMessages int) error {
+	// Whether user input audio.
+	if hasAudio {
+		// Do ASR, convert to text.
+		asrConfig, asrLanguage, previousAsrText := v.queryASRContext(user)
+		if err := sreq.asrAudioToText(ctx, asrConfig, asrLanguage, previousAsrText); err != nil {
+			return errors.Wrapf(err, "asr lang=%v, previous=%v", asrLanguage, previousAsrText)
+		}
+		logger.Tf(ctx, "ASR ok, sid=%v, rid=%v, user=%v, lang=%v, prompt=<%v>, resp is <%v>",
+			v.sid, sreq.rid, user.UserID, asrLanguage, previousAsrText, sreq.asrText)
+	} else {
+		// Directly update the time for stat.
+		sreq.lastUploadAudio = time.Now()
+		sreq.lastExtractAudio = time.Now()
+		sreq.lastRequestASR = time.Now()
+	}
+
+	// Handle user input text.
+	if textMessage != "" {
+		sreq.asrText = textMessage
+		logger.Tf(ctx, "Text ok, sid=%v, rid=%v, user=%v, text=%v",
+			v.sid, sreq.rid, user.UserID, sreq.asrText)
+	}
+
+	// Important trace log.
+	v.updatePreviousAsrText(user, sreq.asrText)
+	logger.Tf(ctx, "You: %v", sreq.asrText)
+	if sreq.onASR != nil {
+		sreq.onASR(sreq.asrText)
+	}
+
+	// Append to the conversation log of room, which is kept across restarts.
+	if err := talkServer.AppendConversation(ctx, v, &StageConversationMessage{
+		RequestUUID: sreq.rid, Role: "user", Username: user.Username, Message: sreq.asrText,
+	}); err != nil {
+		logger.Wf(ctx, "Stage: Ignore append conversation sid=%v, rid=%v err %+v", v.sid, sreq.rid, err)
+	}
+
+	// Notify all subscribers about the ASR text.
+	for _, subscriber := range v.copySubscribers() {
+		subscriber.addUserTextMessage(sreq.rid, user.Username, sreq.asrText)
+	}
+
+	// Keep alive the stage.
+	v.KeepAlive()
+
+	// If merge conversation to next one, we do not submit to chat and post processing.
+	conversations := v.queryPreviousNotMergedRequests(sreq)
+	mergeToNextConversation := mergeMessages > 0 && len(conversations) < mergeMessages
+	if !mergeToNextConversation {
+		sreq.merged = true
+		// Generate the merged text for chat input.
+		var mergedText string
+		for _, conversation := range conversations {
+			// If request has error, such as silent or other error, ignore the text.
+			if conversation.errs != nil {
+				continue
+			}
+			mergedText += conversation.asrText
+		}
+		v.updatePreviousAsrText(user, mergedText)
+	}
+
+	// Do chat, get the response in stream.
+	chatTaskCtx, chatTaskCancel := context.WithCancel(context.Background())
+	if !mergeToNextConversation && v.aiChatEnabled {
+		chatService := &openaiChatService{
+			conf: v.aiConfig,
+			onFirstResponse: func(ctx context.Context, text string) {
+				sreq.lastRequestChat = time.Now()
+				sreq.lastRobotFirstText = text
+			},
+			onFinished: func(ctx context.Context, text string) {
+				talkServer.onChatFinished(ctx, v, sreq, "assistant", text)
+				if sreq.onAnswer != nil {
+					sreq.onAnswer("assistant", text)
+				}
+			},
+		}
+		if err := chatService.RequestChat(ctx, sreq, v, user, chatTaskCancel); err != nil {
+			return errors.Wrapf(err, "chat")
+		}
+	}
+
+	// Do AI post-process ,get the response in stream.
+	// TODO: FIXME: Should use a goroutine to do post-process.
+	if !mergeToNextConversation && v.aiChatEnabled && v.aiPostEnabled {
+		// Wait for chat to be completed.
+		select {
+		case <-ctx.Done():
+		case <-chatTaskCtx.Done():
+		}
+
+		// Start post processing task.
+		chatService := &openaiChatService{
+			conf: v.aiConfig,
+			onFinished: func(ctx context.Context, text string) {
+				talkServer.onChatFinished(ctx, v, sreq, "post", text)
+				if sreq.onAnswer != nil {
+					sreq.onAnswer("post", text)
+				}
+			},
+		}
+		if err := chatService.RequestPostProcess(ctx, sreq, v, user); err != nil {
+			return errors.Wrapf(err, "post-process")
+		}
+	}
+
+	return nil
+}
+
+// Remove the user from stage when expired.
+func (v *Stage) serveUser(ctx context.Context, user *StageUser) {
+	go func() {
+		defer user.Close()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+				if user.Expired() {
+					logger.Tf(ctx, "Stage: Remove user=%v from stage sid=%v for expired, update=%v",
+						user.UserID, v.sid, user.update.Format(time.RFC3339))
+					v.removeUser(user)
+					if err := v.Save(ctx); err != nil {
+						logger.Wf(ctx, "Stage: Ignore save stage sid=%v err %+v", v.sid, err)
+					}
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Remove the subscriber from stage when expired.
+func (v *Stage) serveSubscriber(ctx context.Context, subscriber *StageSubscriber) {
+	go func() {
+		defer subscriber.Close()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+				if subscriber.Expired() {
+					logger.Tf(ctx, "Stage: Remove spid=%v from stage sid=%v for expired, update=%v",
+						subscriber.spid, v.sid, subscriber.update.Format(time.RFC3339))
+					v.removeSubscriber(subscriber)
+					if err := v.Save(ctx); err != nil {
+						logger.Wf(ctx, "Stage: Ignore save stage sid=%v err %+v", v.sid, err)
+					}
+					return
+				}
+			}
+		}
+	}()
+}
+
 // The AnswerSegment is a segment of answer, which is a sentence.
 type AnswerSegment struct {
 	// Request UUID.
@@ -1344,6 +1703,31 @@ func (v *TalkServer) QueryStage(rid string) *Stage {
 	return nil
 }
 
+// Remove the stage and its state in redis when expired. Note that the conversation log of room is kept.
+func (v *TalkServer) serveStage(ctx context.Context, stage *Stage) {
+	go func() {
+		defer stage.Close()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+				stage.updateListener(ctx)
+				stage.updateMixer(ctx)
+				if stage.Expired() {
+					logger.Tf(ctx, "Stage: Remove %v for expired, update=%v",
+						stage.sid, stage.update.Format(time.RFC3339))
+					v.RemoveStage(stage)
+					if err := rdb.HDel(ctx, SRS_AI_TALK_STAGE, stage.sid).Err(); err != nil && err != redis.Nil {
+						logger.Wf(ctx, "Stage: Ignore hdel %v %v err %+v", SRS_AI_TALK_STAGE, stage.sid, err)
+					}
+					return
+				}
+			}
+		}
+	}()
+}
+
 func (v *TalkServer) QueryStageOfRoom(roomUUID string) *Stage {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -1389,6 +1773,8 @@ func (v *TTSWorker) RemoveSegment(asid string) {
 
 func (v *TTSWorker) SubmitSegment(ctx context.Context, stage *Stage, sreq *StageRequest, segment *AnswerSegment) {
 	var messages []*StageMessage
+	var mixer *StageMixer
+	var mixItem *StageMixItem
 
 	func() {
 		v.lock.Lock()
@@ -1403,10 +1789,15 @@ func (v *TTSWorker) SubmitSegment(ctx context.Context, stage *Stage, sreq *Stage
 
 		// TODO: Should not use the lock of tts worker.
 		// Add message to subscriber, to keep the same order as segments.
-		for _, subscriber := range stage.subscribers {
+		for _, subscriber := range stage.copySubscribers() {
 			message := subscriber.createRobotEmptyMessage()
 			messages = append(messages, message)
 		}
+
+		// Reserve the speech to mix into room stream, to keep the same order as segments.
+		if mixer = stage.queryMixer(); mixer != nil {
+			mixItem = mixer.reserve(segment)
+		}
 	}()
 
 	// Start a goroutine to do TTS task.
@@ -1417,8 +1808,11 @@ func (v *TTSWorker) SubmitSegment(ctx context.Context, stage *Stage, sreq *Stage
 		// Always make the segment ready or error, to update the stage to be ready.
 		defer sreq.onSegmentReady(segment)
 
-		if stage.aiTtsEnabled {
-			ttsService := NewOpenAITTSService(stage.aiConfig)
+		if sreq.isInterrupted() {
+			segment.ready, segment.noTTS = true, true
+			logger.Tf(ctx, "TTS: Skip rid=%v, asid=%v for barge-in, %v", sreq.rid, segment.asid, segment.text)
+		} else if stage.aiTtsEnabled {
+			ttsService := NewTTSProvider(stage.ttsConfig)
 			if err := ttsService.RequestTTS(ctx, func(ext string) string {
 				segment.ttsFile = path.Join(aiTalkWorkDir,
 					fmt.Sprintf("assistant-%v-sentence-%v-tts.%v", sreq.rid, segment.asid, ext),
@@ -1436,10 +1830,22 @@ func (v *TTSWorker) SubmitSegment(ctx context.Context, stage *Stage, sreq *Stage
 			logger.Tf(ctx, "TTS: Skip rid=%v, asid=%v, %v", sreq.rid, segment.asid, segment.text)
 		}
 
+		// Drop the audio if user starts speaking during TTS.
+		if sreq.isInterrupted() && !segment.noTTS {
+			segment.noTTS = true
+			logger.Tf(ctx, "TTS: Drop rid=%v, asid=%v for barge-in", sreq.rid, segment.asid)
+		}
+
 		// Update all messages.
 		for _, m := range messages {
 			m.subscriber.completeRobotAudioMessage(ctx, sreq, segment, m)
 		}
+		if sreq.onSegment != nil {
+			sreq.onSegment(segment)
+		}
+		if mixItem != nil {
+			mixer.onSegment(ctx, mixItem, segment)
+		}
 
 		// Start a goroutine to remove the sentence.
 		v.wg.Add(1)
@@ -1489,26 +1895,15 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 				return nil, errors.Wrapf(err, "hset %v %v %v", SRS_LIVE_ROOM, room.UUID, string(b))
 			}
 
+			// Store the stage, to restore it when platform restarts.
+			if err := stage.Save(ctx); err != nil {
+				return nil, errors.Wrapf(err, "save stage %v", stage.sid)
+			}
+
 			talkServer.AddStage(stage)
 			logger.Tf(ctx, "Stage: Create new stage sid=%v, all=%v", stage.sid, talkServer.CountStage())
 
-			go func() {
-				defer stage.Close()
-
-				for ctx.Err() == nil {
-					select {
-					case <-ctx.Done():
-					case <-time.After(3 * time.Second):
-						if stage.Expired() {
-							logger.Tf(ctx, "Stage: Remove %v for expired, update=%v",
-								stage.sid, stage.update.Format(time.RFC3339))
-							talkServer.RemoveStage(stage)
-							return
-						}
-					}
-				}
-			}()
-
+			talkServer.serveStage(ctx, stage)
 			return stage, nil
 		}
 
@@ -1575,6 +1970,9 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// Keep alive the stage.
 			stage.KeepAlive()
 
+			// Generate the hello voice of stage, by the selected voice of room.
+			stage.voice = stage.helloVoice(ctx, stage.asrLanguage)
+
 			// Create new user bind to this stage.
 			user := &StageUser{
 				UserID: uuid.NewString(), stage: stage,
@@ -1583,23 +1981,11 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			}
 			user.KeepAlive()
 			stage.addUser(user)
+			stage.serveUser(ctx, user)
 
-			go func() {
-				defer user.Close()
-
-				for ctx.Err() == nil {
-					select {
-					case <-ctx.Done():
-					case <-time.After(3 * time.Second):
-						if user.Expired() {
-							logger.Tf(ctx, "Stage: Remove user=%v from stage sid=%v for expired, update=%v",
-								user.UserID, stage.sid, user.update.Format(time.RFC3339))
-							stage.removeUser(user)
-							return
-						}
-					}
-				}
-			}()
+			if err := stage.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save stage %v", stage.sid)
+			}
 
 			type StageResult struct {
 				StageID   string `json:"sid"`
@@ -1618,7 +2004,7 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 			ohttp.WriteData(ctx, w, r, r0)
 			logger.Tf(ctx, "srs ai-talk create stage ok, room=%v, stage=%v, users=%v, subscribers=%v, requests=%v",
-				room.UUID, stage.sid, len(stage.users), len(stage.subscribers), len(stage.requests))
+				room.UUID, stage.sid, len(stage.copyUsers()), len(stage.copySubscribers()), len(stage.copyRequests()))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -1783,92 +2169,15 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			logger.Tf(ctx, "Stage: Got question sid=%v, rid=%v, user=%v, umi=%v, input=%v",
 				sid, sreq.rid, userID, userMayInput, sreq.inputFile)
 
-			// Whether user input audio.
+			// Save audio input to file.
 			if audioBase64Data != "" {
-				// Save audio input to file.
 				if err := sreq.receiveInputFile(ctx, audioBase64Data); err != nil {
 					return errors.Wrapf(err, "save %vB audio to file %v", len(audioBase64Data), sreq.inputFile)
 				}
-
-				// Do ASR, convert to text.
-				asrLanguage := ChooseNotEmpty(user.Language, stage.asrLanguage)
-				if err := sreq.asrAudioToText(ctx, stage.aiConfig, asrLanguage, user.previousAsrText); err != nil {
-					return errors.Wrapf(err, "asr lang=%v, previous=%v", asrLanguage, user.previousAsrText)
-				}
-				logger.Tf(ctx, "ASR ok, sid=%v, rid=%v, user=%v, lang=%v, prompt=<%v>, resp is <%v>",
-					sid, sreq.rid, userID, asrLanguage, user.previousAsrText, sreq.asrText)
-			} else {
-				// Directly update the time for stat.
-				sreq.lastUploadAudio = time.Now()
-				sreq.lastExtractAudio = time.Now()
-				sreq.lastRequestASR = time.Now()
 			}
 
-			// Handle user input text.
-			if textMessage != "" {
-				sreq.asrText = textMessage
-				logger.Tf(ctx, "Text ok, sid=%v, rid=%v, user=%v, text=%v",
-					sid, sreq.rid, userID, sreq.asrText)
-			}
-
-			// Important trace log.
-			user.previousAsrText = sreq.asrText
-			logger.Tf(ctx, "You: %v", sreq.asrText)
-
-			// Notify all subscribers about the ASR text.
-			for _, subscriber := range stage.subscribers {
-				subscriber.addUserTextMessage(sreq.rid, user.Username, sreq.asrText)
-			}
-
-			// Keep alive the stage.
-			stage.KeepAlive()
-
-			// If merge conversation to next one, we do not submit to chat and post processing.
-			conversations := stage.queryPreviousNotMergedRequests(sreq)
-			mergeToNextConversation := mergeMessages > 0 && len(conversations) < mergeMessages
-			if !mergeToNextConversation {
-				sreq.merged, user.previousAsrText = true, ""
-				// Generate the merged text for chat input.
-				for _, conversation := range conversations {
-					// If request has error, such as silent or other error, ignore the text.
-					if conversation.errs != nil {
-						continue
-					}
-					user.previousAsrText += conversation.asrText
-				}
-			}
-
-			// Do chat, get the response in stream.
-			chatTaskCtx, chatTaskCancel := context.WithCancel(context.Background())
-			if !mergeToNextConversation && stage.aiChatEnabled {
-				chatService := &openaiChatService{
-					conf: stage.aiConfig,
-					onFirstResponse: func(ctx context.Context, text string) {
-						sreq.lastRequestChat = time.Now()
-						sreq.lastRobotFirstText = text
-					},
-				}
-				if err := chatService.RequestChat(ctx, sreq, stage, user, chatTaskCancel); err != nil {
-					return errors.Wrapf(err, "chat")
-				}
-			}
-
-			// Do AI post-process ,get the response in stream.
-			// TODO: FIXME: Should use a goroutine to do post-process.
-			if !mergeToNextConversation && stage.aiChatEnabled && stage.aiPostEnabled {
-				// Wait for chat to be completed.
-				select {
-				case <-ctx.Done():
-				case <-chatTaskCtx.Done():
-				}
-
-				// Start post processing task.
-				chatService := &openaiChatService{
-					conf: stage.aiConfig,
-				}
-				if err := chatService.RequestPostProcess(ctx, sreq, stage, user); err != nil {
-					return errors.Wrapf(err, "post-process")
-				}
+			if err := stage.handleRequest(ctx, sreq, user, audioBase64Data != "", textMessage, mergeMessages); err != nil {
+				return errors.Wrapf(err, "handle request")
 			}
 
 			// Response the request UUID and pulling the response.
@@ -1979,12 +2288,18 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 				}
 			}
 
+			// The hello voice generated by the selected voice is in the work dir, others are examples.
+			filepath := path.Join(aiTalkExampleDir, filename)
+			if strings.HasPrefix(filename, "hello-voice-") {
+				filepath = path.Join(aiTalkWorkDir, path.Base(filename))
+			}
+
 			ext := strings.Trim(path.Ext(filename), ".")
-			contentType := fmt.Sprintf("audio/%v", ext)
-			logger.Tf(ctx, "Serve example file=%v, ext=%v, contentType=%v", filename, ext, contentType)
+			contentType := ttsContentType(filename)
+			logger.Tf(ctx, "Serve example file=%v, ext=%v, contentType=%v", filepath, ext, contentType)
 
 			w.Header().Set("Content-Type", contentType)
-			http.ServeFile(w, r, path.Join(aiTalkExampleDir, filename))
+			http.ServeFile(w, r, filepath)
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -2076,28 +2391,16 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 				subscriber.stage = stage
 				stage.addSubscriber(subscriber)
 			})
-
-			go func() {
-				defer subscriber.Close()
-
-				for ctx.Err() == nil {
-					select {
-					case <-ctx.Done():
-					case <-time.After(3 * time.Second):
-						if subscriber.Expired() {
-							logger.Tf(ctx, "Stage: Remove spid=%v from stage sid=%v for expired, update=%v",
-								subscriber.spid, stage.sid, subscriber.update.Format(time.RFC3339))
-							stage.removeSubscriber(subscriber)
-							return
-						}
-					}
-				}
-			}()
+			stage.serveSubscriber(ctx, subscriber)
 
 			// Keep alive the stage.
 			stage.KeepAlive()
 			subscriber.KeepAlive()
 
+			if err := stage.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save stage %v", stage.sid)
+			}
+
 			type SubscribeResult struct {
 				StageID      string `json:"sid"`
 				SubscriberID string `json:"spid"`
@@ -2296,12 +2599,8 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 			// When the first subscriber got the segment, we log the elapsed time.
 			finishAudioSegment(answer.segment)
 
-			// Read the ttsFile and response it as opus audio.
-			if strings.HasSuffix(answer.audioFile, ".wav") {
-				w.Header().Set("Content-Type", "audio/wav")
-			} else {
-				w.Header().Set("Content-Type", "audio/aac")
-			}
+			// Read the ttsFile and response it by the format of TTS provider.
+			w.Header().Set("Content-Type", ttsContentType(answer.audioFile))
 			http.ServeFile(w, r, answer.audioFile)
 
 			logger.Tf(ctx, "srs ai-talk play tts subscriber stage ok")
@@ -2525,7 +2824,11 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 
 			user.Username = username
 			user.Language = userLanguage
-			user.Voice = helloVoiceFromLanguage(userLanguage)
+			user.Voice = stage.helloVoice(ctx, userLanguage)
+
+			if err := stage.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save stage %v", stage.sid)
+			}
 
 			ohttp.WriteData(ctx, w, r, user)
 			logger.Tf(ctx, "srs ai-talk update user ok, sid=%v, user=%v", sid, userID)
@@ -2535,5 +2838,13 @@ func handleAITalkService(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	if err := talkServer.handleConversationService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle conversation")
+	}
+
+	if err := handleAITalkSessionService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle session")
+	}
+
 	return nil
 }
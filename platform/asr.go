@@ -0,0 +1,179 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// The OpenAI or OpenAI-compatible endpoint, for example, faster-whisper server with OpenAI API.
+	ASRProviderOpenAI = "openai"
+	// The self-hosted whisper.cpp server, or other whisper server with the same inference API.
+	ASRProviderWhisper = "whisper"
+	// The generic HTTP ASR, POST audio file and get the segments in JSON.
+	ASRProviderHTTP = "http"
+)
+
+// ASRProviderConfig is the config to create an ASR provider, built from the config of transcript task, live
+// room assistant or dubbing project.
+type ASRProviderConfig struct {
+	// The ASR provider, openai, whisper or http. Default to openai.
+	Provider string
+	// The model name, default to whisper-1 for OpenAI. Ignore if empty for other providers.
+	Model string
+	// The sampling temperature, between 0 and 1. Zero for provider default.
+	Temperature float32
+	// The prompt hints, for example, the vocabulary or context, prepend to the prompt of each request.
+	Hints string
+	// For whisper and http provider, the URL to POST the audio file.
+	URL string
+	// For whisper and http provider, the optional bearer token for authorization.
+	Token string
+	// For OpenAI provider, the AI service config.
+	AI openai.ClientConfig
+}
+
+func (v ASRProviderConfig) String() string {
+	return fmt.Sprintf("provider=%v, model=%v, temperature=%v, hints=%vB, url=%v, token=%vB",
+		v.Provider, v.Model, v.Temperature, len(v.Hints), v.URL, len(v.Token))
+}
+
+// ASRProvider is the service to convert audio to text.
+type ASRProvider interface {
+	// Transcribe the audio file, with the language and prompt which is generally the previous text. Return
+	// the text and segments, with the same format of OpenAI verbose JSON.
+	Transcribe(ctx context.Context, inputFile, language, prompt string) (*openai.AudioResponse, error)
+}
+
+// NewASRProvider create the ASR provider by config, fallback to OpenAI.
+func NewASRProvider(config *ASRProviderConfig) ASRProvider {
+	switch config.Provider {
+	case ASRProviderWhisper:
+		// See https://github.com/ggerganov/whisper.cpp/tree/master/examples/server
+		return &httpASRProvider{config: *config, fields: map[string]string{
+			"response_format": string(openai.AudioResponseFormatVerboseJSON),
+		}}
+	case ASRProviderHTTP:
+		return &httpASRProvider{config: *config}
+	}
+	return &openaiASRProvider{config: *config}
+}
+
+// buildASRPrompt build the prompt of ASR request, with hints and the prompt of request.
+func buildASRPrompt(hints, prompt string) string {
+	return strings.TrimSpace(fmt.Sprintf("%v %v", strings.TrimSpace(hints), strings.TrimSpace(prompt)))
+}
+
+type openaiASRProvider struct {
+	config ASRProviderConfig
+}
+
+func (v *openaiASRProvider) Transcribe(ctx context.Context, inputFile, language, prompt string) (*openai.AudioResponse, error) {
+	model := v.config.Model
+	if model == "" {
+		model = openai.Whisper1
+	}
+
+	client := openai.NewClientWithConfig(v.config.AI)
+	resp, err := client.CreateTranscription(
+		ctx,
+		openai.AudioRequest{
+			Model:    model,
+			FilePath: inputFile,
+			// Note that must use verbose JSON, to get the duration and segments.
+			Format:      openai.AudioResponseFormatVerboseJSON,
+			Language:    language,
+			Prompt:      buildASRPrompt(v.config.Hints, prompt),
+			Temperature: v.config.Temperature,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "transcription %v by %v", inputFile, model)
+	}
+	return &resp, nil
+}
+
+// httpASRProvider POST the audio file in multipart form, with fields file, language, prompt, model and
+// temperature, and the response is a JSON object with text, duration and segments of start, end and text.
+type httpASRProvider struct {
+	config ASRProviderConfig
+	// The extra form fields, for example, the response format of whisper server.
+	fields map[string]string
+}
+
+func (v *httpASRProvider) Transcribe(ctx context.Context, inputFile, language, prompt string) (*openai.AudioResponse, error) {
+	if v.config.URL == "" {
+		return nil, errors.Errorf("no url for %v asr", v.config.Provider)
+	}
+
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %v", inputFile)
+	}
+	defer f.Close()
+
+	fields := map[string]string{
+		"language": language, "prompt": buildASRPrompt(v.config.Hints, prompt), "model": v.config.Model,
+	}
+	if v.config.Temperature > 0 {
+		fields["temperature"] = fmt.Sprintf("%v", v.config.Temperature)
+	}
+	for k, v := range v.fields {
+		fields[k] = v
+	}
+
+	req, err := httpNewMultipartRequest(ctx, v.config.URL, fields, path.Base(inputFile), f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request")
+	}
+	if v.config.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.config.Token))
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "request %v", v.config.URL)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read body")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("status %v, body %v", resp.StatusCode, string(b))
+	}
+
+	var res openai.AudioResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+
+	// Build the text and duration from segments, if not specified by server.
+	if res.Text == "" {
+		var texts []string
+		for _, segment := range res.Segments {
+			texts = append(texts, strings.TrimSpace(segment.Text))
+		}
+		res.Text = strings.Join(texts, " ")
+	}
+	if res.Duration == 0 && len(res.Segments) > 0 {
+		res.Duration = res.Segments[len(res.Segments)-1].End
+	}
+	return &res, nil
+}
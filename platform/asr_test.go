@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestASR_HttpProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("language") != "en" || r.FormValue("prompt") != "Oryx SRS. Hello" {
+			t.Errorf("invalid form %v", r.Form)
+		}
+		if r.FormValue("response_format") != "verbose_json" {
+			t.Errorf("invalid format %v", r.FormValue("response_format"))
+		}
+		fmt.Fprintf(w, `{"segments":[{"start":0,"end":1.5,"text":" Hello"},{"start":1.5,"end":3,"text":" world"}]}`)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "asr-*.m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	provider := NewASRProvider(&ASRProviderConfig{
+		Provider: ASRProviderWhisper, URL: server.URL, Hints: "Oryx SRS.",
+	})
+	resp, err := provider.Transcribe(context.Background(), f.Name(), "en", "Hello")
+	if err != nil {
+		t.Fatalf("transcribe failed, %v", err)
+	}
+	if resp.Text != "Hello world" || resp.Duration != 3 || len(resp.Segments) != 2 {
+		t.Errorf("invalid response %v", resp)
+	}
+}
@@ -0,0 +1,556 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// Cea608Caption is a caption to display, the start and end time is in seconds of PTS. Note that only the
+// CEA-608 captions of channel 1 are carried in the cc_data, there is no CEA-708 service(DTVCC packet), so
+// the players or platforms which only decode the 708 services don't show the captions.
+type Cea608Caption struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// The max characters of a row, and the max rows of a caption.
+const cea608MaxColumns, cea608MaxRows = 32, 4
+
+// The PAC(Preamble Address Code) of channel 1 for rows 12 to 15, white text at column 0.
+var cea608RowPACs = [][2]byte{{0x13, 0x40}, {0x13, 0x60}, {0x14, 0x40}, {0x14, 0x60}}
+
+// The misc control codes of channel 1.
+var (
+	// Resume caption loading, for pop-on caption.
+	cea608RCL = [2]byte{0x14, 0x20}
+	// Erase displayed memory.
+	cea608EDM = [2]byte{0x14, 0x2C}
+	// Erase non-displayed memory.
+	cea608ENM = [2]byte{0x14, 0x2E}
+	// End of caption, flip the memories to display the loaded caption.
+	cea608EOC = [2]byte{0x14, 0x2F}
+)
+
+// cea608Char converts the rune to the standard character of CEA-608, which is mostly ASCII, except some
+// characters are replaced by Latin ones. Return false if not supported, for example, CJK characters.
+func cea608Char(r rune) (byte, bool) {
+	switch r {
+	case 'á':
+		return 0x2A, true
+	case 'é':
+		return 0x5C, true
+	case 'í':
+		return 0x5E, true
+	case 'ó':
+		return 0x5F, true
+	case 'ú':
+		return 0x60, true
+	case 'ç':
+		return 0x7B, true
+	case 'Ñ':
+		return 0x7D, true
+	case 'ñ':
+		return 0x7E, true
+	case '*', '\\', '^', '_', '`', '{', '|', '}', '~':
+		return 0, false
+	}
+	if r >= 0x20 && r < 0x7F {
+		return byte(r), true
+	}
+	return 0, false
+}
+
+// cea608Rows wraps the text to rows of CEA-608 characters, drop the unsupported characters and truncate
+// the rows exceeds the max rows.
+func cea608Rows(text string) []string {
+	var words []string
+	for _, word := range strings.Fields(text) {
+		var sb strings.Builder
+		for _, r := range word {
+			if c, ok := cea608Char(r); ok {
+				sb.WriteByte(c)
+			}
+		}
+		if sb.Len() > 0 {
+			words = append(words, sb.String())
+		}
+	}
+
+	var rows []string
+	var row string
+	for _, word := range words {
+		if len(word) > cea608MaxColumns {
+			word = word[:cea608MaxColumns]
+		}
+		if row != "" && len(row)+1+len(word) > cea608MaxColumns {
+			rows, row = append(rows, row), ""
+		}
+		if row == "" {
+			row = word
+		} else {
+			row = row + " " + word
+		}
+	}
+	if row != "" {
+		rows = append(rows, row)
+	}
+
+	if len(rows) > cea608MaxRows {
+		rows = rows[:cea608MaxRows]
+	}
+	return rows
+}
+
+// buildCea608Pairs builds the byte pairs of a pop-on caption. Note that the control codes are sent twice,
+// to be robust for transmission errors, and the parity bit is not set.
+func buildCea608Pairs(text string) [][2]byte {
+	rows := cea608Rows(text)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var pairs [][2]byte
+	control := func(code [2]byte) {
+		pairs = append(pairs, code, code)
+	}
+
+	control(cea608RCL)
+	control(cea608ENM)
+	for i, row := range rows {
+		control(cea608RowPACs[len(cea608RowPACs)-len(rows)+i])
+		for j := 0; j < len(row); j += 2 {
+			pair := [2]byte{row[j], 0}
+			if j+1 < len(row) {
+				pair[1] = row[j+1]
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	control(cea608EOC)
+	return pairs
+}
+
+// buildCea608Schedule assigns the byte pairs of captions to the frames, one pair each frame in display
+// order. The pts is the time in seconds of frames in decode order, and the result is the pair of each
+// frame, nil if no data.
+func buildCea608Schedule(captions []Cea608Caption, pts []float64) [][]byte {
+	if len(pts) == 0 {
+		return nil
+	}
+
+	// The captions data is displayed in the order of PTS, not DTS.
+	order := make([]int, len(pts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return pts[order[i]] < pts[order[j]]
+	})
+
+	type event struct {
+		time  float64
+		pairs [][2]byte
+	}
+	var events []*event
+
+	sort.SliceStable(captions, func(i, j int) bool {
+		return captions[i].Start < captions[j].Start
+	})
+	for i, caption := range captions {
+		pairs := buildCea608Pairs(caption.Text)
+		if len(pairs) == 0 {
+			continue
+		}
+
+		// Load the caption earlier, if not enough frames to send all pairs before the end of segment.
+		start := caption.Start
+		if latest := len(order) - len(pairs); latest < 0 {
+			start = pts[order[0]]
+		} else if t := pts[order[latest]]; t < start {
+			start = t
+		}
+		events = append(events, &event{time: start, pairs: pairs})
+
+		// Erase the caption when it ends, if not replaced by the next one.
+		if i == len(captions)-1 || captions[i+1].Start > caption.End {
+			events = append(events, &event{time: caption.End, pairs: [][2]byte{cea608EDM, cea608EDM}})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time < events[j].time
+	})
+
+	schedule := make([][]byte, len(pts))
+	var queue [][2]byte
+	var next int
+	for _, index := range order {
+		for ; next < len(events) && events[next].time <= pts[index]; next++ {
+			queue = append(queue, events[next].pairs...)
+		}
+		if len(queue) > 0 {
+			schedule[index] = []byte{queue[0][0], queue[0][1]}
+			queue = queue[1:]
+		}
+	}
+	return schedule
+}
+
+// cea608Parity sets the odd parity bit of CEA-608 byte.
+func cea608Parity(b byte) byte {
+	b &= 0x7F
+	var ones int
+	for v := b; v > 0; v >>= 1 {
+		ones += int(v & 0x01)
+	}
+	if ones%2 == 0 {
+		b |= 0x80
+	}
+	return b
+}
+
+// buildCea608SEI builds the H.264 SEI NALU with start code, which carries the CEA-608 pair in the cc_data
+// of ATSC A/53, that is, the user_data_registered_itu_t_t35 SEI, which is also the transport of CEA-708. If
+// no pair, send the null pair to keep the caption channel.
+func buildCea608SEI(pair []byte) []byte {
+	b1, b2 := byte(0), byte(0)
+	if len(pair) == 2 {
+		b1, b2 = pair[0], pair[1]
+	}
+
+	payload := []byte{
+		// The country code of USA, and the provider code of ATSC.
+		0xB5, 0x00, 0x31,
+		// The user identifier, GA94 for ATSC A/53.
+		'G', 'A', '9', '4',
+		// The user data type code, 3 for cc_data.
+		0x03,
+		// The process_cc_data_flag and cc_count, and the reserved em_data.
+		0x40 | 0x01, 0xFF,
+		// The cc_data of NTSC field 1, with marker bits and cc_valid set.
+		0xFC, cea608Parity(b1), cea608Parity(b2),
+		// The marker bits.
+		0xFF,
+	}
+
+	// The SEI message of user_data_registered_itu_t_t35, and the rbsp trailing bits.
+	rbsp := append([]byte{0x04, byte(len(payload))}, payload...)
+	rbsp = append(rbsp, 0x80)
+
+	// Insert emulation prevention bytes.
+	nalu := []byte{0x00, 0x00, 0x00, 0x01, 0x06}
+	var zeros int
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 0x03 {
+			nalu, zeros = append(nalu, 0x03), 0
+		}
+		nalu = append(nalu, b)
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nalu
+}
+
+// insertH264SEI inserts the SEI NALU before the first VCL NALU of access unit in Annex B format. Return the
+// original data if no VCL NALU.
+func insertH264SEI(es, sei []byte) []byte {
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0x00 || es[i+1] != 0x00 || es[i+2] != 0x01 {
+			continue
+		}
+
+		// The non-IDR or IDR slice.
+		if nalType := es[i+3] & 0x1F; nalType == 1 || nalType == 5 {
+			if i > 0 && es[i-1] == 0x00 {
+				i--
+			}
+
+			data := make([]byte, 0, len(es)+len(sei))
+			data = append(data, es[:i]...)
+			data = append(data, sei...)
+			return append(data, es[i:]...)
+		}
+	}
+	return es
+}
+
+// tsVideoPES is a PES of video in TS, with the adaptation field of the first TS packet.
+type tsVideoPES struct {
+	// The adaptation field of the first packet, without the length and stuffing bytes, nil if no field.
+	af []byte
+	// The PES packet, with header and payload.
+	data []byte
+}
+
+// pts parse the PTS in seconds of PES, return false if no PTS.
+func (v *tsVideoPES) pts() (float64, bool) {
+	b := v.data
+	if len(b) < 14 || b[0] != 0x00 || b[1] != 0x00 || b[2] != 0x01 || b[7]&0x80 == 0 {
+		return 0, false
+	}
+
+	pts := uint64(b[9]>>1&0x07)<<30 | uint64(b[10])<<22 | uint64(b[11]>>1)<<15 | uint64(b[12])<<7 | uint64(b[13]>>1)
+	return float64(pts) / 90000, true
+}
+
+// insertSEI inserts the SEI to the payload of PES, and update the length of PES.
+func (v *tsVideoPES) insertSEI(sei []byte) {
+	b := v.data
+	if len(b) < 9 || b[0] != 0x00 || b[1] != 0x00 || b[2] != 0x01 {
+		return
+	}
+
+	headerSize := 9 + int(b[8])
+	if headerSize > len(b) {
+		return
+	}
+
+	data := append([]byte{}, b[:headerSize]...)
+	data = append(data, insertH264SEI(b[headerSize:], sei)...)
+
+	// Update the PES packet length, zero means unbounded, which is allowed for video.
+	if size := len(data) - 6; b[4] != 0 || b[5] != 0 {
+		if size > 0xFFFF {
+			size = 0
+		}
+		data[4], data[5] = byte(size>>8), byte(size)
+	}
+	v.data = data
+}
+
+// packets builds the TS packets of PES, the continuity counter is not set.
+func (v *tsVideoPES) packets(pid uint16) [][]byte {
+	var packets [][]byte
+	data := v.data
+	for first := true; len(data) > 0; first = false {
+		var af []byte
+		if first && v.af != nil {
+			af = append([]byte{}, v.af...)
+		}
+
+		overhead := 0
+		if af != nil {
+			overhead = 1 + len(af)
+		}
+
+		// Stuffing by adaptation field, if not enough payload.
+		capacity := 184 - overhead
+		if stuffing := capacity - len(data); stuffing > 0 {
+			switch {
+			case af == nil && stuffing == 1:
+				af = []byte{}
+			case af == nil:
+				af = append([]byte{0x00}, bytes.Repeat([]byte{0xFF}, stuffing-2)...)
+			case len(af) == 0:
+				af = append([]byte{0x00}, bytes.Repeat([]byte{0xFF}, stuffing-1)...)
+			default:
+				af = append(af, bytes.Repeat([]byte{0xFF}, stuffing)...)
+			}
+			capacity = len(data)
+		}
+
+		p := make([]byte, 0, 188)
+		pusi := byte(0x00)
+		if first {
+			pusi = 0x40
+		}
+		p = append(p, 0x47, pusi|byte(pid>>8&0x1F), byte(pid))
+		if af != nil {
+			p = append(p, 0x30, byte(len(af)))
+			p = append(p, af...)
+		} else {
+			p = append(p, 0x10)
+		}
+		p = append(p, data[:capacity]...)
+		data = data[capacity:]
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// parseTsAdaptationField parse the adaptation field of TS packet, return the field without the length and
+// stuffing bytes, and the offset of payload.
+func parseTsAdaptationField(p []byte) (af []byte, offset int) {
+	if p[3]&0x20 == 0 {
+		return nil, 4
+	}
+
+	size := int(p[4])
+	if size == 0 || 5+size > len(p) {
+		return []byte{}, 5 + size
+	}
+
+	body := p[5 : 5+size]
+	flags, pos := body[0], 1
+	if flags&0x10 != 0 {
+		pos += 6
+	}
+	if flags&0x08 != 0 {
+		pos += 6
+	}
+	if flags&0x04 != 0 {
+		pos += 1
+	}
+	if flags&0x02 != 0 && pos < len(body) {
+		pos += 1 + int(body[pos])
+	}
+	if flags&0x01 != 0 && pos < len(body) {
+		pos += 1 + int(body[pos])
+	}
+	if pos > len(body) {
+		pos = len(body)
+	}
+	return append([]byte{}, body[:pos]...), 5 + size
+}
+
+// parseTsSectionPIDs parse the PAT or PMT section, return the PIDs of PMT in PAT, or the H.264 PID in PMT.
+func parseTsSectionPIDs(payload []byte, isPMT bool) []uint16 {
+	if len(payload) < 1 || 1+int(payload[0]) >= len(payload) {
+		return nil
+	}
+
+	section := payload[1+int(payload[0]):]
+	if len(section) < 3 {
+		return nil
+	}
+	end := 3 + (int(section[1]&0x0F)<<8 | int(section[2])) - 4
+	if end > len(section) {
+		end = len(section)
+	}
+
+	var pids []uint16
+	if !isPMT {
+		for pos := 8; pos+4 <= end; pos += 4 {
+			if program := int(section[pos])<<8 | int(section[pos+1]); program != 0 {
+				pids = append(pids, uint16(section[pos+2]&0x1F)<<8|uint16(section[pos+3]))
+			}
+		}
+		return pids
+	}
+
+	if end < 12 {
+		return nil
+	}
+	pos := 12 + (int(section[10]&0x0F)<<8 | int(section[11]))
+	for pos+5 <= end {
+		streamType, pid := section[pos], uint16(section[pos+1]&0x1F)<<8|uint16(section[pos+2])
+		if streamType == 0x1B {
+			pids = append(pids, pid)
+		}
+		pos += 5 + (int(section[pos+3]&0x0F)<<8 | int(section[pos+4]))
+	}
+	return pids
+}
+
+// injectCea608Captions injects the captions to the H.264 SEI of TS file, without transcoding. Note that
+// CEA-608 only supports Latin characters, others are dropped.
+func injectCea608Captions(input, output string, captions []Cea608Caption) error {
+	b, err := ioutil.ReadFile(input)
+	if err != nil {
+		return errors.Wrapf(err, "read %v", input)
+	}
+	if len(b)%188 != 0 {
+		return errors.Errorf("invalid ts size %v of %v", len(b), input)
+	}
+
+	// The TS packets, or the video PES which is the index of pes.
+	type tsItem struct {
+		packet []byte
+		pes    int
+	}
+	var items []*tsItem
+	var pes []*tsVideoPES
+
+	pmtPIDs := map[uint16]bool{}
+	var videoPID uint16
+	var hasVideo bool
+	for pos := 0; pos < len(b); pos += 188 {
+		p := b[pos : pos+188]
+		if p[0] != 0x47 {
+			return errors.Errorf("invalid sync byte at %v of %v", pos, input)
+		}
+
+		pid := uint16(p[1]&0x1F)<<8 | uint16(p[2])
+		pusi := p[1]&0x40 != 0
+		af, offset := parseTsAdaptationField(p)
+		var payload []byte
+		if p[3]&0x10 != 0 && offset < 188 {
+			payload = p[offset:]
+		}
+
+		if pid == 0 && pusi {
+			for _, pmtPID := range parseTsSectionPIDs(payload, false) {
+				pmtPIDs[pmtPID] = true
+			}
+		} else if pmtPIDs[pid] && pusi && !hasVideo {
+			if pids := parseTsSectionPIDs(payload, true); len(pids) > 0 {
+				videoPID, hasVideo = pids[0], true
+			}
+		}
+
+		// Merge the video packets to PES, except the packet without payload, for example, PCR only.
+		if hasVideo && pid == videoPID && payload != nil {
+			if pusi {
+				pes = append(pes, &tsVideoPES{af: af})
+				items = append(items, &tsItem{pes: len(pes) - 1})
+			}
+			if len(pes) > 0 {
+				pes[len(pes)-1].data = append(pes[len(pes)-1].data, payload...)
+				continue
+			}
+		}
+
+		items = append(items, &tsItem{packet: p, pes: -1})
+	}
+
+	if !hasVideo || len(pes) == 0 {
+		return errors.Errorf("no h.264 video in %v", input)
+	}
+
+	// Schedule the caption data to frames.
+	pts := make([]float64, len(pes))
+	for i, frame := range pes {
+		pts[i], _ = frame.pts()
+	}
+	schedule := buildCea608Schedule(captions, pts)
+	for i, frame := range pes {
+		frame.insertSEI(buildCea608SEI(schedule[i]))
+	}
+
+	// Generate the TS packets, and rebuild the continuity counter of video.
+	cc := byte(0x0F)
+	data := make([]byte, 0, len(b)+len(pes)*188)
+	for _, item := range items {
+		packets := [][]byte{item.packet}
+		if item.pes >= 0 {
+			packets = pes[item.pes].packets(videoPID)
+		}
+
+		for _, packet := range packets {
+			packet = append([]byte{}, packet...)
+			if pid := uint16(packet[1]&0x1F)<<8 | uint16(packet[2]); pid == videoPID {
+				if packet[3]&0x10 != 0 {
+					cc++
+				}
+				packet[3] = packet[3]&0xF0 | cc&0x0F
+			}
+			data = append(data, packet...)
+		}
+	}
+
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		return errors.Wrapf(err, "write %v", output)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCea608_Captions(t *testing.T) {
+	var pts []float64
+	for i := 0; i < 30; i++ {
+		pts = append(pts, 10+float64(i)*0.04)
+	}
+	schedule := buildCea608Schedule([]Cea608Caption{{Start: 10, End: 10.8, Text: "Hi, 你好 Oryx"}}, pts)
+	if len(schedule) != len(pts) || schedule[0][0] != 0x14 || schedule[0][1] != 0x20 {
+		t.Fatalf("invalid schedule %v", schedule)
+	}
+	if p := schedule[6]; p[0] != 'H' || p[1] != 'i' || schedule[8][0] != 'O' {
+		t.Errorf("invalid chars %v", p)
+	}
+	if p := schedule[20]; p[0] != 0x14 || p[1] != 0x2C {
+		t.Errorf("invalid erase %v", p)
+	}
+	if rows := cea608Rows("the quick brown fox jumps over the lazy dog"); len(rows) != 2 || len(rows[0]) > 32 {
+		t.Errorf("invalid rows %v", rows)
+	}
+
+	// Build a TS file with PAT, PMT and two H.264 frames.
+	psi := func(pid uint16, section []byte) []byte {
+		p := append([]byte{0x47, 0x40 | byte(pid>>8), byte(pid), 0x10, 0x00}, section...)
+		for len(p) < 188 {
+			p = append(p, 0xFF)
+		}
+		return p
+	}
+	ts := psi(0, []byte{0x00, 0xB0, 13, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00, 0, 0, 0, 0})
+	ts = append(ts, psi(0x1000, []byte{0x02, 0xB0, 18, 0x00, 0x01, 0xC1, 0x00, 0x00, 0xE1, 0x00, 0xF0, 0x00,
+		0x1B, 0xE1, 0x00, 0xF0, 0x00, 0, 0, 0, 0})...)
+	for _, v := range []uint64{900000, 903600} {
+		pes := &tsVideoPES{data: []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x80, 0x05,
+			0x21 | byte(v>>29&0x0E), byte(v >> 22), byte(v>>14&0xFE) | 1, byte(v >> 7), byte(v<<1&0xFE) | 1,
+			0x00, 0x00, 0x00, 0x01, 0x09, 0xF0, 0x00, 0x00, 0x00, 0x01, 0x65,
+		}}
+		pes.data = append(pes.data, bytes.Repeat([]byte{0x11}, 300)...)
+		for _, p := range pes.packets(0x100) {
+			ts = append(ts, p...)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "cea608")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input, output := path.Join(dir, "input.ts"), path.Join(dir, "output.ts")
+	if err := ioutil.WriteFile(input, ts, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := injectCea608Captions(input, output, []Cea608Caption{{Start: 10, End: 11, Text: "Hello"}}); err != nil {
+		t.Fatalf("inject failed, %v", err)
+	}
+
+	b, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b)%188 != 0 || bytes.Count(b, []byte("GA94")) != 2 {
+		t.Errorf("invalid output, size=%v, sei=%v", len(b), bytes.Count(b, []byte("GA94")))
+	}
+	if sei := bytes.Index(b, []byte{0x00, 0x00, 0x00, 0x01, 0x06, 0x04}); sei < 0 || sei > bytes.Index(b, []byte{0x01, 0x65}) {
+		t.Errorf("invalid sei position %v", sei)
+	}
+}
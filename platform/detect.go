@@ -0,0 +1,814 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The type of detected event.
+type DetectEventType string
+
+const (
+	// The scene is changed, by FFmpeg scdet filter.
+	DetectEventScene DetectEventType = "scene"
+	// The black frames, by FFmpeg blackdetect filter.
+	DetectEventBlack DetectEventType = "black"
+	// The frozen frames, by FFmpeg freezedetect filter.
+	DetectEventFreeze DetectEventType = "freeze"
+	// The audio silence, by FFmpeg silencedetect filter.
+	DetectEventSilence DetectEventType = "silence"
+)
+
+// The max number of recent events in the status of stream.
+const maxDetectEvents = 100
+
+// The tolerance in seconds, to join the black, freeze or silence event which spans TS segments.
+const detectEventTolerance = 0.2
+
+// The min duration in seconds of black, freeze or silence runs reported by FFmpeg filters. It's much less
+// than the duration of config, because the run may span TS segments, and the length of run is carried
+// across segments, see DetectStreamStatus.track.
+const detectRunDuration = 0.1
+
+var detectWorker *DetectWorker
+
+// DetectWorker analyze the TS segments of live streams by FFmpeg filters, without any AI service, to
+// detect the scene change, black, frozen frames and audio silence.
+type DetectWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// The config for detection.
+	config DetectConfig
+	// The status of streams, only accessed by the goroutine to detect segments.
+	streams map[string]*DetectStreamStatus
+
+	// Use async goroutine to process on_hls messages.
+	msgs chan *SrsOnHlsMessage
+	// Got message from SRS, a new TS segment file is generated.
+	tsfiles chan *SrsOnHlsObject
+
+	// To protect the common fields.
+	lock sync.Mutex
+}
+
+func NewDetectWorker() *DetectWorker {
+	return &DetectWorker{
+		config:  *NewDetectConfig(),
+		streams: make(map[string]*DetectStreamStatus),
+		msgs:    make(chan *SrsOnHlsMessage, 1024),
+		tsfiles: make(chan *SrsOnHlsObject, 1024),
+	}
+}
+
+func (v *DetectWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/detect/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config := NewDetectConfig()
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "detect query ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/detect/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			config := NewDetectConfig()
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*DetectConfig
+			}{
+				Token: &token, DetectConfig: config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := config.validate(); err != nil {
+				return errors.Wrapf(err, "invalid config")
+			}
+
+			if err := config.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save config")
+			}
+
+			func() {
+				v.lock.Lock()
+				defer v.lock.Unlock()
+				v.config = *config
+			}()
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "detect apply ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/detect/status"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+			}{
+				Token: &token, App: &app, Stream: &stream,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			statuses, err := queryDetectStatuses(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query status")
+			}
+
+			res := []*DetectStreamStatus{}
+			for _, status := range statuses {
+				if (app != "" && status.App != app) || (stream != "" && status.Stream != stream) {
+					continue
+				}
+				res = append(res, status)
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "detect status ok, app=%v, stream=%v, streams=%v, token=%vB",
+				app, stream, len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/detect/image/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is /image/:uuid.jpg
+			filename := path.Base(r.URL.Path)
+			eventUUID := strings.TrimSuffix(filename, path.Ext(filename))
+			if _, err := uuid.Parse(eventUUID); err != nil {
+				return errors.Wrapf(err, "invalid uuid %v of %v", eventUUID, r.URL.Path)
+			}
+
+			event := &DetectEvent{UUID: eventUUID}
+			if f, err := os.Open(event.thumbnailFile()); err != nil {
+				return errors.Wrapf(err, "open file %v", event.thumbnailFile())
+			} else {
+				defer f.Close()
+				w.Header().Set("Content-Type", "image/jpeg")
+				io.Copy(w, f)
+			}
+
+			logger.Tf(ctx, "detect thumbnail ok, uuid=%v", eventUUID)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *DetectWorker) Enabled() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.config.All
+}
+
+func (v *DetectWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
+	select {
+	case <-ctx.Done():
+	case v.msgs <- msg:
+	}
+
+	return nil
+}
+
+func (v *DetectWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage) error {
+	// Copy the ts file to temporary cache dir.
+	tsid := fmt.Sprintf("%v-org-%v", msg.SeqNo, uuid.NewString())
+	tsfile := path.Join("detect", fmt.Sprintf("%v.ts", tsid))
+
+	// Always use execFile when params contains user inputs, see https://auth0.com/blog/preventing-command-injection-attacks-in-node-js-apps/
+	// Note that should never use fs.copyFileSync(file, tsfile, fs.constants.COPYFILE_FICLONE_FORCE) which fails in macOS.
+	if err := exec.CommandContext(ctx, "cp", "-f", msg.File, tsfile).Run(); err != nil {
+		return errors.Wrapf(err, "copy file %v to %v", msg.File, tsfile)
+	}
+
+	// Create a local ts file object, estimate the start time of ts by its duration.
+	starttime := time.Now().Add(-1 * time.Duration(msg.Duration*float64(time.Second)))
+	tsFile := &TsFile{
+		TsID:     tsid,
+		URL:      msg.URL,
+		SeqNo:    msg.SeqNo,
+		Duration: msg.Duration,
+		File:     tsfile,
+		// The wallclock time of ts, for the time of events.
+		ProgramDateTime: starttime.Format(programDateTimeLayout),
+	}
+
+	select {
+	case <-ctx.Done():
+		os.Remove(tsfile)
+	case v.tsfiles <- &SrsOnHlsObject{Msg: msg, TsFile: tsFile}:
+	}
+	return nil
+}
+
+func (v *DetectWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+
+	v.wg.Wait()
+	return nil
+}
+
+func (v *DetectWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "detect start a worker")
+
+	if err := v.config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
+
+	// Load the status of streams, to continue the events.
+	if statuses, err := queryDetectStatuses(ctx); err != nil {
+		return errors.Wrapf(err, "query status")
+	} else {
+		for _, status := range statuses {
+			v.streams[status.key()] = status
+		}
+	}
+
+	// Consume all on_hls messages.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case msg := <-v.msgs:
+				if err := v.OnHlsTsMessageImpl(ctx, msg); err != nil {
+					logger.Wf(ctx, "detect: handle on hls message %v err %+v", msg.String(), err)
+				}
+			}
+		}
+	}()
+
+	// Detect all ts files.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case obj := <-v.tsfiles:
+				if err := v.detect(ctx, obj); err != nil {
+					logger.Wf(ctx, "detect: handle ts %v err %+v", obj.TsFile.String(), err)
+				}
+				os.Remove(obj.TsFile.File)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// detect the events of ts file, update the status of stream and notify the new events.
+func (v *DetectWorker) detect(ctx context.Context, obj *SrsOnHlsObject) error {
+	var config DetectConfig
+	func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		config = v.config
+	}()
+
+	vf, af := config.filters()
+	if vf == "" && af == "" {
+		return nil
+	}
+
+	starttime := time.Now()
+	msg, tsFile := obj.Msg, obj.TsFile
+
+	// The filters write events to the log of level info.
+	args := []string{"-hide_banner", "-nostats", "-i", tsFile.File}
+	if vf != "" {
+		args = append(args, "-vf", vf)
+	} else {
+		args = append(args, "-vn")
+	}
+	if af != "" {
+		args = append(args, "-af", af)
+	} else {
+		args = append(args, "-an")
+	}
+	args = append(args, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "detect %v, stderr %v", args, stderr.String())
+	}
+
+	events := parseDetectEvents(stderr.String(), tsFile.Duration)
+
+	status, ok := v.streams[detectStreamKey(msg.App, msg.Stream)]
+	if !ok {
+		status = &DetectStreamStatus{
+			Vhost: msg.Vhost, App: msg.App, Stream: msg.Stream,
+			Counts: make(map[DetectEventType]int), Events: []*DetectEvent{},
+		}
+		v.streams[status.key()] = status
+	}
+
+	// Build the wallclock time of events, which is the start of run if continues in next segments.
+	pdt, err := time.Parse(programDateTimeLayout, tsFile.ProgramDateTime)
+	if err != nil {
+		pdt = time.Now()
+	}
+	for _, event := range events {
+		event.Vhost, event.App, event.Stream = msg.Vhost, msg.App, msg.Stream
+		event.Time = pdt.Add(time.Duration(event.Offset * float64(time.Second))).Format(programDateTimeLayout)
+		event.TsURL = msg.URL
+	}
+
+	// Carry the runs across segments, and report the runs which last for the min duration.
+	created := status.track(events, tsFile.Duration, config.minDuration)
+	for _, event := range created {
+		event.UUID, event.CreatedAt = uuid.NewString(), time.Now().Format(time.RFC3339)
+
+		// Extract the thumbnail of event, ignore if failed, for example, audio only stream. For the run
+		// starts in previous segment, use the first frame of this segment.
+		offset := event.Offset
+		if event.TsURL != msg.URL {
+			offset = 0
+		}
+		if err := event.extractThumbnail(ctx, tsFile.File, offset); err != nil {
+			logger.Wf(ctx, "detect: ignore thumbnail of %v err %+v", event.String(), err)
+		}
+	}
+
+	for _, event := range status.update(created) {
+		event.dispose()
+	}
+	if err := status.Save(ctx); err != nil {
+		return errors.Wrapf(err, "save status %v", status.String())
+	}
+
+	for _, event := range created {
+		if err := callbackWorker.OnDetect(ctx, SrsActionOnDetect, event); err != nil {
+			logger.Wf(ctx, "detect: ignore callback %v err %+v", event.String(), err)
+		}
+		logger.Tf(ctx, "detect: event %v", event.String())
+	}
+
+	logger.Tf(ctx, "detect: ts %v, events=%v, created=%v, active=%v, cost=%v",
+		tsFile.String(), len(events), len(created), status.Active, time.Since(starttime))
+	return nil
+}
+
+// DetectConfig is the config for detection, saved in SRS_DETECT_CONFIG.
+type DetectConfig struct {
+	// Whether detect all streams.
+	All bool `json:"all"`
+	// Whether detect scene change, and the threshold of scene score, from 0 to 100.
+	Scene          bool    `json:"scene"`
+	SceneThreshold float64 `json:"sceneThreshold"`
+	// Whether detect black frames, the min duration in seconds, and the threshold of black pixel from 0 to 1.
+	Black          bool    `json:"black"`
+	BlackDuration  float64 `json:"blackDuration"`
+	BlackThreshold float64 `json:"blackThreshold"`
+	// Whether detect frozen frames, the min duration in seconds, and the noise tolerance in dB.
+	Freeze         bool    `json:"freeze"`
+	FreezeDuration float64 `json:"freezeDuration"`
+	FreezeNoise    float64 `json:"freezeNoise"`
+	// Whether detect audio silence, the min duration in seconds, and the noise tolerance in dB.
+	Silence         bool    `json:"silence"`
+	SilenceDuration float64 `json:"silenceDuration"`
+	SilenceNoise    float64 `json:"silenceNoise"`
+}
+
+func NewDetectConfig() *DetectConfig {
+	return &DetectConfig{
+		All: false, Scene: true, SceneThreshold: 10, Black: true, BlackDuration: 2, BlackThreshold: 0.1,
+		Freeze: true, FreezeDuration: 5, FreezeNoise: -60, Silence: true, SilenceDuration: 5, SilenceNoise: -50,
+	}
+}
+
+func (v DetectConfig) String() string {
+	return fmt.Sprintf("all=%v, scene=%v/%v, black=%v/%v/%v, freeze=%v/%v/%v, silence=%v/%v/%v",
+		v.All, v.Scene, v.SceneThreshold, v.Black, v.BlackDuration, v.BlackThreshold, v.Freeze,
+		v.FreezeDuration, v.FreezeNoise, v.Silence, v.SilenceDuration, v.SilenceNoise)
+}
+
+func (v *DetectConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_DETECT_CONFIG, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_DETECT_CONFIG)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+func (v *DetectConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal conf %v", v)
+	} else if err := rdb.HSet(ctx, SRS_DETECT_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v global %v", SRS_DETECT_CONFIG, string(b))
+	}
+	return nil
+}
+
+func (v *DetectConfig) validate() error {
+	if v.SceneThreshold < 0 || v.SceneThreshold > 100 {
+		return errors.Errorf("invalid scene threshold %v", v.SceneThreshold)
+	}
+	if v.BlackThreshold < 0 || v.BlackThreshold > 1 {
+		return errors.Errorf("invalid black threshold %v", v.BlackThreshold)
+	}
+	if v.BlackDuration <= 0 || v.FreezeDuration <= 0 || v.SilenceDuration <= 0 {
+		return errors.Errorf("invalid duration black=%v, freeze=%v, silence=%v",
+			v.BlackDuration, v.FreezeDuration, v.SilenceDuration)
+	}
+	if v.FreezeNoise >= 0 || v.SilenceNoise >= 0 {
+		return errors.Errorf("invalid noise freeze=%vdB, silence=%vdB", v.FreezeNoise, v.SilenceNoise)
+	}
+	return nil
+}
+
+// filters build the FFmpeg video and audio filters, empty if not enabled. The filters report the short
+// runs, and the min duration of config is checked by minDuration, after the runs are joined.
+func (v *DetectConfig) filters() (vf, af string) {
+	var vfs []string
+	if v.Scene {
+		vfs = append(vfs, fmt.Sprintf("scdet=threshold=%v", v.SceneThreshold))
+	}
+	if v.Black {
+		vfs = append(vfs, fmt.Sprintf("blackdetect=d=%v:pix_th=%v", detectRunDuration, v.BlackThreshold))
+	}
+	if v.Freeze {
+		vfs = append(vfs, fmt.Sprintf("freezedetect=n=%vdB:d=%v", v.FreezeNoise, detectRunDuration))
+	}
+	if v.Silence {
+		af = fmt.Sprintf("silencedetect=n=%vdB:d=%v", v.SilenceNoise, detectRunDuration)
+	}
+	return strings.Join(vfs, ","), af
+}
+
+// minDuration is the min duration in seconds of run to report, zero for scene change.
+func (v *DetectConfig) minDuration(t DetectEventType) float64 {
+	switch t {
+	case DetectEventBlack:
+		return v.BlackDuration
+	case DetectEventFreeze:
+		return v.FreezeDuration
+	case DetectEventSilence:
+		return v.SilenceDuration
+	}
+	return 0
+}
+
+// DetectEvent is an event detected in the TS segment of stream.
+type DetectEvent struct {
+	// The event UUID.
+	UUID string `json:"uuid"`
+	// The event type, scene, black, freeze or silence.
+	Type DetectEventType `json:"type"`
+	// The stream of event.
+	Vhost  string `json:"vhost,omitempty"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The wallclock time of event, in ISO 8601 format.
+	Time string `json:"time"`
+	// The TS url of SRS, and the offset in seconds of event in TS.
+	TsURL  string  `json:"ts"`
+	Offset float64 `json:"offset"`
+	// The duration in seconds of black, freeze or silence event, which may span TS segments.
+	Duration float64 `json:"duration,omitempty"`
+	// The score of scene change, from 0 to 100.
+	Score float64 `json:"score,omitempty"`
+	// The URL of thumbnail, empty if no video.
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// The create time, in RFC3339.
+	CreatedAt string `json:"created"`
+
+	// Whether the run is reported as an event.
+	reported bool
+}
+
+func (v DetectEvent) String() string {
+	return fmt.Sprintf("uuid=%v, type=%v, app=%v, stream=%v, time=%v, ts=%v, offset=%v, duration=%v, score=%v",
+		v.UUID, v.Type, v.App, v.Stream, v.Time, v.TsURL, v.Offset, v.Duration, v.Score)
+}
+
+func (v *DetectEvent) thumbnailFile() string {
+	return path.Join("detect", fmt.Sprintf("%v.jpg", v.UUID))
+}
+
+// extractThumbnail extract the frame at the offset of ts file, as the thumbnail.
+func (v *DetectEvent) extractThumbnail(ctx context.Context, tsfile string, offset float64) error {
+	args := []string{
+		"-ss", fmt.Sprintf("%.3f", offset), "-i", tsfile,
+		"-frames:v", "1", "-vf", "scale=320:-2", "-q:v", "10",
+		"-y", v.thumbnailFile(),
+	}
+	if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
+		return errors.Wrapf(err, "transcode %v", args)
+	}
+
+	v.Thumbnail = fmt.Sprintf("/terraform/v1/ai/detect/image/%v.jpg", v.UUID)
+	return nil
+}
+
+// dispose remove the thumbnail of event.
+func (v *DetectEvent) dispose() {
+	if _, err := os.Stat(v.thumbnailFile()); err == nil {
+		os.Remove(v.thumbnailFile())
+	}
+}
+
+// The log of FFmpeg filters, for example:
+//
+//	[scdet @ 0x7f8] lavfi.scd.score: 35.237, lavfi.scd.time: 4.12
+//	[blackdetect @ 0x7f8] black_start:0 black_end:2.04 black_duration:2.04
+//	[freezedetect @ 0x7f8] lavfi.freezedetect.freeze_start: 1.2
+//	[freezedetect @ 0x7f8] lavfi.freezedetect.freeze_duration: 5.2
+//	[silencedetect @ 0x7f8] silence_start: 1.5
+//	[silencedetect @ 0x7f8] silence_end: 7.2 | silence_duration: 5.7
+var (
+	detectSceneRegexp   = regexp.MustCompile(`lavfi\.scd\.score:\s*([\d.]+),\s*lavfi\.scd\.time:\s*([\d.]+)`)
+	detectBlackRegexp   = regexp.MustCompile(`black_start:\s*([\d.]+)\s+black_end:\s*([\d.]+)`)
+	detectFreezeRegexp  = regexp.MustCompile(`lavfi\.freezedetect\.freeze_(start|end):\s*([\d.]+)`)
+	detectSilenceRegexp = regexp.MustCompile(`silence_(start|end):\s*(-?[\d.]+)`)
+)
+
+// parseDetectEvents parse the events from the log of FFmpeg filters. The black, freeze or silence event
+// which is not ended, lasts to the end of segment.
+func parseDetectEvents(stderr string, duration float64) []*DetectEvent {
+	var events []*DetectEvent
+	ongoing := make(map[DetectEventType]*DetectEvent)
+
+	start := func(t DetectEventType, offset float64) {
+		if offset < 0 {
+			offset = 0
+		}
+		event := &DetectEvent{Type: t, Offset: offset, Duration: duration - offset}
+		ongoing[t] = event
+		events = append(events, event)
+	}
+	end := func(t DetectEventType, offset float64) {
+		if event := ongoing[t]; event != nil {
+			event.Duration = offset - event.Offset
+			delete(ongoing, t)
+		}
+	}
+	parse := func(s string) float64 {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := detectSceneRegexp.FindStringSubmatch(line); m != nil {
+			events = append(events, &DetectEvent{Type: DetectEventScene, Score: parse(m[1]), Offset: parse(m[2])})
+		} else if m := detectBlackRegexp.FindStringSubmatch(line); m != nil {
+			start(DetectEventBlack, parse(m[1]))
+			end(DetectEventBlack, parse(m[2]))
+		} else if m := detectFreezeRegexp.FindStringSubmatch(line); m != nil {
+			if m[1] == "start" {
+				start(DetectEventFreeze, parse(m[2]))
+			} else {
+				end(DetectEventFreeze, parse(m[2]))
+			}
+		} else if m := detectSilenceRegexp.FindStringSubmatch(line); m != nil {
+			if m[1] == "start" {
+				start(DetectEventSilence, parse(m[2]))
+			} else {
+				end(DetectEventSilence, parse(m[2]))
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Offset < events[j].Offset
+	})
+	return events
+}
+
+// DetectStreamStatus is the summary of detection of a stream, saved in SRS_DETECT_STATUS.
+type DetectStreamStatus struct {
+	// The stream.
+	Vhost  string `json:"vhost,omitempty"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The last update time, in RFC3339.
+	Update string `json:"update"`
+	// The number of analyzed segments.
+	Segments int `json:"segments"`
+	// The number of events of each type.
+	Counts map[DetectEventType]int `json:"counts"`
+	// The black, freeze or silence events which last to the end of the latest segment, for example, the
+	// stream is black now.
+	Active []DetectEventType `json:"active"`
+	// The recent events, latest first.
+	Events []*DetectEvent `json:"events"`
+
+	// The black, freeze or silence runs which last to the end of the latest segment, to carry the length of
+	// run to next segment, which may not be reported yet.
+	runs map[DetectEventType]*DetectEvent
+}
+
+func (v DetectStreamStatus) String() string {
+	return fmt.Sprintf("app=%v, stream=%v, update=%v, segments=%v, counts=%v, active=%v, events=%v",
+		v.App, v.Stream, v.Update, v.Segments, v.Counts, v.Active, len(v.Events))
+}
+
+func (v *DetectStreamStatus) key() string {
+	return detectStreamKey(v.App, v.Stream)
+}
+
+func (v *DetectStreamStatus) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	} else if err := rdb.HSet(ctx, SRS_DETECT_STATUS, v.key(), string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_DETECT_STATUS, v.key())
+	}
+	return nil
+}
+
+// track the black, freeze or silence runs across segments, return the events to report, that is, the scene
+// changes, and the runs which last for the min duration. The run starts at the beginning of segment
+// continues the run of previous segment, so the event spans segments is not missed. Note that the reported
+// run is updated when it continues, for example, the duration.
+func (v *DetectStreamStatus) track(events []*DetectEvent, duration float64, minDuration func(t DetectEventType) float64) []*DetectEvent {
+	runs := make(map[DetectEventType]*DetectEvent)
+
+	var reported []*DetectEvent
+	for _, event := range events {
+		if event.Type == DetectEventScene {
+			reported = append(reported, event)
+			continue
+		}
+
+		// Continue the run of previous segment, or start a new run.
+		run := event
+		if previous := v.runs[event.Type]; previous != nil && event.Offset <= detectEventTolerance {
+			previous.Duration += event.Offset + event.Duration
+			run = previous
+		}
+
+		// The run may continue in next segment, if it lasts to the end of segment.
+		if event.Offset+event.Duration >= duration-detectEventTolerance {
+			runs[event.Type] = run
+		}
+
+		if !run.reported && run.Duration >= minDuration(run.Type) {
+			run.reported = true
+			reported = append(reported, run)
+		}
+	}
+	v.runs = runs
+
+	// The event is active if the reported run lasts to the end of segment.
+	v.Active = []DetectEventType{}
+	for _, t := range []DetectEventType{DetectEventBlack, DetectEventFreeze, DetectEventSilence} {
+		if run := runs[t]; run != nil && run.reported {
+			v.Active = append(v.Active, t)
+		}
+	}
+	return reported
+}
+
+// update the status by the created events of segment, return the events which are removed.
+func (v *DetectStreamStatus) update(created []*DetectEvent) []*DetectEvent {
+	// The created events are sorted by offset, so the last one is the latest.
+	for _, event := range created {
+		v.Events = append([]*DetectEvent{event}, v.Events...)
+		v.Counts[event.Type]++
+	}
+
+	v.Segments++
+	v.Update = time.Now().Format(time.RFC3339)
+
+	var removed []*DetectEvent
+	if len(v.Events) > maxDetectEvents {
+		removed = v.Events[maxDetectEvents:]
+		v.Events = v.Events[:maxDetectEvents]
+	}
+	return removed
+}
+
+// detectStreamKey is the key of stream status.
+func detectStreamKey(app, stream string) string {
+	return fmt.Sprintf("%v/%v", app, stream)
+}
+
+func detectEventTypesContains(types []DetectEventType, t DetectEventType) bool {
+	for _, e := range types {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// queryDetectStatuses load the status of all streams, sorted by update time, latest first.
+func queryDetectStatuses(ctx context.Context) ([]*DetectStreamStatus, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_DETECT_STATUS).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_DETECT_STATUS)
+	}
+
+	var statuses []*DetectStreamStatus
+	for key, b := range objs {
+		var status DetectStreamStatus
+		if err := json.Unmarshal([]byte(b), &status); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", key, b)
+		}
+		if status.Counts == nil {
+			status.Counts = make(map[DetectEventType]int)
+		}
+		statuses = append(statuses, &status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Update > statuses[j].Update
+	})
+	return statuses, nil
+}
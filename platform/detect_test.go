@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect_Events(t *testing.T) {
+	stderr := strings.Join([]string{
+		"[scdet @ 0x7f8] lavfi.scd.score: 35.237, lavfi.scd.time: 4.12",
+		"[blackdetect @ 0x7f8] black_start:0 black_end:2.04 black_duration:2.04",
+		"[silencedetect @ 0x7f8] silence_start: 7.5",
+		"[freezedetect @ 0x7f8] lavfi.freezedetect.freeze_start: 3",
+		"[freezedetect @ 0x7f8] lavfi.freezedetect.freeze_duration: 5",
+		"[freezedetect @ 0x7f8] lavfi.freezedetect.freeze_end: 8",
+	}, "\n")
+	events := parseDetectEvents(stderr, 10)
+	if len(events) != 4 {
+		t.Fatalf("invalid events %v", len(events))
+	}
+	if e := events[0]; e.Type != DetectEventBlack || e.Offset != 0 || e.Duration != 2.04 {
+		t.Errorf("invalid event %v", e.String())
+	}
+	if e := events[1]; e.Type != DetectEventFreeze || e.Offset != 3 || e.Duration != 5 {
+		t.Errorf("invalid event %v", e.String())
+	}
+	if e := events[2]; e.Type != DetectEventScene || e.Offset != 4.12 || e.Score != 35.237 {
+		t.Errorf("invalid event %v", e.String())
+	}
+	if e := events[3]; e.Type != DetectEventSilence || e.Offset != 7.5 || e.Duration != 2.5 {
+		t.Errorf("invalid event %v", e.String())
+	}
+
+	// The silence lasts to the end of segment, but it's not reported until it lasts for 5s, while the black
+	// lasts for 2s is reported.
+	minDuration := NewDetectConfig().minDuration
+	status := &DetectStreamStatus{Counts: make(map[DetectEventType]int)}
+	created := status.track(events, 10, minDuration)
+	status.update(created)
+	if len(created) != 3 || created[0].Type != DetectEventBlack || created[1].Type != DetectEventFreeze {
+		t.Fatalf("invalid created %v", len(created))
+	}
+	if len(status.Active) != 0 || status.Counts[DetectEventSilence] != 0 {
+		t.Errorf("invalid status %v", status.String())
+	}
+
+	// The silence continues in next segment, it's reported when the length across segments reaches 5s.
+	next := parseDetectEvents("[silencedetect @ 0x7f8] silence_start: 0", 2)
+	created = status.track(next, 2, minDuration)
+	status.update(created)
+	if len(created) != 0 || len(status.Active) != 0 {
+		t.Fatalf("should not report %v", status.String())
+	}
+
+	next = parseDetectEvents("[silencedetect @ 0x7f8] silence_start: 0.1", 2)
+	created = status.track(next, 2, minDuration)
+	status.update(created)
+	if len(created) != 1 || created[0].Offset != 7.5 || created[0].Duration != 6.5 {
+		t.Fatalf("invalid created %v", created)
+	}
+	if len(status.Active) != 1 || status.Active[0] != DetectEventSilence || status.Counts[DetectEventSilence] != 1 {
+		t.Errorf("invalid status %v", status.String())
+	}
+
+	// The reported silence is updated, but not reported again.
+	next = parseDetectEvents("[silencedetect @ 0x7f8] silence_start: 0\n[silencedetect @ 0x7f8] silence_end: 1 | silence_duration: 1", 2)
+	created = status.track(next, 2, minDuration)
+	status.update(created)
+	if len(created) != 0 || len(status.Active) != 0 || status.Events[0].Duration != 7.5 {
+		t.Errorf("invalid status %v", status.String())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -286,6 +285,12 @@ func handleDubbingService(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if dubbing.TTS != nil && dubbing.TTS.AITTSEnabled {
+				if err := dubbing.TTS.TTSProviderConfig().Validate(); err != nil {
+					return errors.Wrapf(err, "validate tts")
+				}
+			}
+
 			// TODO: FIXME: Should load dubbing from redis and merge the fields.
 			if b, err := json.Marshal(dubbing); err != nil {
 				return errors.Wrapf(err, "marshal dubbing")
@@ -1332,38 +1337,20 @@ func (v *AudioGroup) Translate(ctx context.Context, trans *SrsAssistant, previou
 
 func (v *AudioGroup) GenerateTTS(ctx context.Context, tts *SrsAssistant, projectUUID string) error {
 	if err := func() error {
-		// Initialize the AI services.
-		aiConfig := openai.DefaultConfig(tts.AISecretKey)
-		aiConfig.OrgID = tts.AIOrganization
-		aiConfig.BaseURL = tts.AIBaseURL
-
-		client := openai.NewClientWithConfig(aiConfig)
-		resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
-			Model:          openai.TTSModel1,
-			Input:          v.SourceTextForTTS(),
-			Voice:          openai.VoiceNova,
-			ResponseFormat: openai.SpeechResponseFormatAac,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "create speech")
-		}
-		defer resp.Close()
-
-		ttsFilename := path.Join(projectUUID, fmt.Sprintf("tts-%v.aac", v.UUID))
-		ttsFile := path.Join(conf.Pwd, aiDubbingWorkDir, ttsFilename)
-		out, err := os.Create(ttsFile)
-		if err != nil {
-			return errors.Errorf("Unable to create the file %v for writing", ttsFile)
-		}
-		defer out.Close()
-
-		if _, err = io.Copy(out, resp); err != nil {
-			return errors.Errorf("Error writing the file")
+		// Initialize the TTS provider, by the voice of project.
+		config := tts.TTSProviderConfig()
+
+		var ttsFilename string
+		if err := NewTTSProvider(config).RequestTTS(ctx, func(ext string) string {
+			ttsFilename = path.Join(projectUUID, fmt.Sprintf("tts-%v.%v", v.UUID, ext))
+			return path.Join(conf.Pwd, aiDubbingWorkDir, ttsFilename)
+		}, v.SourceTextForTTS()); err != nil {
+			return errors.Wrapf(err, "request tts, %v", config.String())
 		}
 
 		v.TTS = ttsFilename
 		v.TTSAt = AITime(time.Now())
-		logger.Tf(ctx, "dubbing generate TTS for group %v to %v", v.SourceTextForTTS(), v.TTS)
+		logger.Tf(ctx, "dubbing generate TTS for group %v to %v, %v", v.SourceTextForTTS(), v.TTS, config.String())
 		return nil
 	}(); err != nil {
 		return errors.Wrapf(err, "generate tts")
@@ -1836,22 +1823,9 @@ func (v *SrsDubbingTask) Start(ctx context.Context) error {
 				}
 				logger.Tf(ctx, "Convert %v to segment %v ok, starttime=%v", absAsrInputAudio, tmpAsrInputAudio, starttime)
 
-				// Initialize the AI services.
-				aiConfig := openai.DefaultConfig(v.project.ASR.AISecretKey)
-				aiConfig.OrgID = v.project.ASR.AIOrganization
-				aiConfig.BaseURL = v.project.ASR.AIBaseURL
-
-				// Do ASR, convert to text.
-				client := openai.NewClientWithConfig(aiConfig)
-				resp, err := client.CreateTranscription(
-					ctx,
-					openai.AudioRequest{
-						Model:    openai.Whisper1,
-						FilePath: tmpAsrInputAudio,
-						Format:   openai.AudioResponseFormatVerboseJSON,
-						Language: v.project.ASR.AIASRLanguage,
-					},
-				)
+				// Do ASR by the provider of project, convert to text.
+				provider := NewASRProvider(v.project.ASR.ASRProviderConfig())
+				resp, err := provider.Transcribe(ctx, tmpAsrInputAudio, v.project.ASR.AIASRLanguage, "")
 				if err != nil {
 					return errors.Wrapf(err, "transcription")
 				}
@@ -1859,7 +1833,7 @@ func (v *SrsDubbingTask) Start(ctx context.Context) error {
 					v.project.UUID, len(resp.Text), len(v.AsrResponse.Groups))
 
 				// Append the segment to ASR output object.
-				v.AsrResponse.AppendSegment(resp, starttime)
+				v.AsrResponse.AppendSegment(*resp, starttime)
 				logger.Tf(ctx, "Save ASR output ok")
 
 				return nil
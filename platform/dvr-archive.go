@@ -0,0 +1,524 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// ArchivePolicy is the policy for the original file, when archive is done.
+type ArchivePolicy string
+
+const (
+	// Keep the original index.mp4, and save the archive as archive.mp4 in the same directory.
+	ArchivePolicyKeep ArchivePolicy = "keep"
+	// Replace the original index.mp4 by the archive.
+	ArchivePolicyReplace ArchivePolicy = "replace"
+)
+
+// ArchiveStatus is the status of archive job for a record artifact.
+type ArchiveStatus string
+
+const (
+	ArchiveStatusProcessing ArchiveStatus = "processing"
+	ArchiveStatusDone       ArchiveStatus = "done"
+	ArchiveStatusFailed     ArchiveStatus = "failed"
+)
+
+var archiveWorker *ArchiveWorker
+
+// ArchiveWorker re-encode the finished record artifacts to a smaller profile in background, during the
+// configured off-peak hours, to save disk for long-term archives.
+type ArchiveWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewArchiveWorker() *ArchiveWorker {
+	return &ArchiveWorker{}
+}
+
+func (v *ArchiveWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/record/archive/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config := NewArchiveConfig()
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "archive query ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/archive/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			config := NewArchiveConfig()
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*ArchiveConfig
+			}{
+				Token: &token, ArchiveConfig: config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if config.Policy != ArchivePolicyKeep && config.Policy != ArchivePolicyReplace {
+				return errors.Errorf("invalid policy %v", config.Policy)
+			}
+			if config.StartHour < 0 || config.StartHour > 23 || config.EndHour < 0 || config.EndHour > 23 {
+				return errors.Errorf("invalid hours %v-%v", config.StartHour, config.EndHour)
+			}
+			if config.VideoCodec == "" {
+				return errors.New("no video codec")
+			}
+
+			if err := config.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save config")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "archive apply ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *ArchiveWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *ArchiveWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "archive start a worker")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			var duration time.Duration
+			if archived, err := v.archiveOne(ctx); err != nil {
+				logger.Wf(ctx, "archive: ignore err %+v", err)
+				duration = 30 * time.Second
+			} else if archived {
+				duration = 3 * time.Second
+			} else {
+				duration = 60 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// archiveOne pick a finished artifact and archive it, return true if an artifact is archived.
+func (v *ArchiveWorker) archiveOne(ctx context.Context) (bool, error) {
+	config := NewArchiveConfig()
+	if err := config.Load(ctx); err != nil {
+		return false, errors.Wrapf(err, "load config")
+	}
+
+	if !config.All || !config.inWindow(time.Now()) {
+		return false, nil
+	}
+
+	artifacts, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result()
+	if err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+	}
+
+	// Note that the processing status means the job is interrupted by restart, so we start it over.
+	var artifact *M3u8VoDArtifact
+	for _, value := range artifacts {
+		var metadata M3u8VoDArtifact
+		if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+			return false, errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		if metadata.Processing {
+			continue
+		}
+		if metadata.Archive != nil && metadata.Archive.Status != ArchiveStatusProcessing {
+			continue
+		}
+		// Never archive the artifact in transcribing, because the file is being read.
+		if metadata.Transcript != nil && metadata.Transcript.Status == RecordTranscriptStatusProcessing {
+			continue
+		}
+
+		artifact = &metadata
+		break
+	}
+
+	if artifact == nil {
+		return false, nil
+	}
+
+	if err := v.archive(ctx, config, artifact); err != nil {
+		// Retry later if the artifact is transcribing.
+		if errors.Cause(err) == errRecordArtifactBusy {
+			return false, nil
+		}
+
+		if archive := artifact.Archive; archive != nil {
+			archive.Status, archive.Error = ArchiveStatusFailed, err.Error()
+			archive.Update = time.Now().Format(time.RFC3339)
+			if r0 := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+				a.Archive = archive
+				return nil
+			}); r0 != nil {
+				logger.Wf(ctx, "archive: ignore save %v err %+v", artifact.UUID, r0)
+			}
+		}
+		return true, errors.Wrapf(err, "archive %v", artifact.String())
+	}
+
+	return true, nil
+}
+
+func (v *ArchiveWorker) archive(ctx context.Context, config *ArchiveConfig, artifact *M3u8VoDArtifact) error {
+	starttime := time.Now()
+	archive := &M3u8VoDArchive{
+		Status: ArchiveStatusProcessing, Policy: config.Policy, VideoCodec: config.VideoCodec,
+		CRF: config.CRF, Height: config.Height, Update: starttime.Format(time.RFC3339),
+	}
+	// Only update the archive of artifact, never overwrite the fields of other workers.
+	saveArchive := func() error {
+		return updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+			a.Archive = archive
+			return nil
+		})
+	}
+	// Start the job only if the artifact is not transcribing, to serialize with the transcript worker.
+	if err := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+		if a.Transcript != nil && a.Transcript.Status == RecordTranscriptStatusProcessing {
+			return errRecordArtifactBusy
+		}
+		a.Archive = archive
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "save artifact")
+	}
+	artifact.Archive = archive
+
+	mp4File := path.Join("record", artifact.UUID, "index.mp4")
+	stats, err := os.Stat(mp4File)
+	if err != nil {
+		return errors.Wrapf(err, "stat %v", mp4File)
+	}
+	archive.OriginalSize = uint64(stats.Size())
+
+	format, _, _, err := FFprobeFileFormat(ctx, mp4File)
+	if err != nil {
+		return errors.Wrapf(err, "probe %v", mp4File)
+	}
+
+	archiveFile := path.Join("record", artifact.UUID, "archive.mp4")
+	tmpFile := path.Join("record", artifact.UUID, "archive.tmp.mp4")
+	args := []string{"-i", mp4File, "-c:v", config.VideoCodec}
+	if config.CRF > 0 {
+		args = append(args, "-crf", fmt.Sprintf("%v", config.CRF))
+	}
+	if config.Preset != "" {
+		args = append(args, "-preset", config.Preset)
+	}
+	if config.Height > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:%v", config.Height))
+	}
+	// Use hvc1 tag for HEVC, to make it playable in Safari.
+	if config.VideoCodec == "libx265" {
+		args = append(args, "-tag:v", "hvc1")
+	}
+	args = append(args, "-c:a", "aac", "-b:a", config.AudioBitrate)
+	args = append(args, "-movflags", "+faststart", "-progress", "pipe:1", "-nostats", "-y", tmpFile)
+
+	// Parse the progress of FFmpeg, to update the progress of artifact.
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrapf(err, "pipe stdout")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "start ffmpeg %v", args)
+	}
+	logger.Tf(ctx, "archive: start %v, args=%v", artifact.UUID, strings.Join(args, " "))
+
+	update := time.Now()
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 || kv[0] != "out_time_us" {
+			continue
+		}
+
+		us, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || format.Duration <= 0 {
+			continue
+		}
+
+		archive.Progress = float64(us) / 1000.0 / 1000.0 / format.Duration * 100
+		if time.Since(update) > 10*time.Second {
+			update = time.Now()
+			archive.Update = update.Format(time.RFC3339)
+			if err := saveArchive(); err != nil {
+				logger.Wf(ctx, "archive: ignore save %v err %+v", artifact.UUID, err)
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		os.Remove(tmpFile)
+		return errors.Wrapf(err, "ffmpeg %v, stderr %v", args, stderr.String())
+	}
+
+	if stats, err := os.Stat(tmpFile); err != nil {
+		return errors.Wrapf(err, "stat %v", tmpFile)
+	} else {
+		archive.ArchiveSize = uint64(stats.Size())
+	}
+
+	// Apply the policy, note that we never replace the original file by a larger one.
+	archive.File = "archive.mp4"
+	if config.Policy == ArchivePolicyReplace && archive.ArchiveSize < archive.OriginalSize {
+		if err := os.Rename(tmpFile, mp4File); err != nil {
+			return errors.Wrapf(err, "rename %v to %v", tmpFile, mp4File)
+		}
+		archive.File = "index.mp4"
+		archive.SavedSize = archive.OriginalSize - archive.ArchiveSize
+	} else if err := os.Rename(tmpFile, archiveFile); err != nil {
+		return errors.Wrapf(err, "rename %v to %v", tmpFile, archiveFile)
+	}
+
+	archive.Status, archive.Progress = ArchiveStatusDone, 100
+	archive.Cost = time.Since(starttime).Seconds()
+	archive.Update = time.Now().Format(time.RFC3339)
+	if err := saveArchive(); err != nil {
+		return errors.Wrapf(err, "save artifact")
+	}
+
+	logger.Tf(ctx, "archive: done %v, archive=%v", artifact.UUID, archive.String())
+	return nil
+}
+
+// errRecordArtifactBusy means the artifact is processing by another worker, for example, the archive and
+// transcript worker never process the same artifact at the same time.
+var errRecordArtifactBusy = errors.New("artifact busy")
+
+// recordArtifactLock serialize the writes of record artifacts, because the workers like archive, merge
+// and transcript update the same artifact in background.
+var recordArtifactLock sync.Mutex
+
+// saveRecordArtifact save the whole record artifact to redis, only for a new artifact, or the artifact
+// owned by the caller. Use updateRecordArtifact to update some fields of an existing artifact.
+func saveRecordArtifact(ctx context.Context, artifact *M3u8VoDArtifact) error {
+	recordArtifactLock.Lock()
+	defer recordArtifactLock.Unlock()
+
+	if b, err := json.Marshal(artifact); err != nil {
+		return errors.Wrapf(err, "marshal %v", artifact.String())
+	} else if err = rdb.HSet(ctx, SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b))
+	}
+	return nil
+}
+
+// updateRecordArtifact load the latest record artifact, update it by the callback and save it to redis.
+// Because the worker only modify its own fields of the latest artifact, it never overwrite the fields
+// updated by other workers, and never restore a removed artifact.
+func updateRecordArtifact(ctx context.Context, uuid string, update func(artifact *M3u8VoDArtifact) error) error {
+	recordArtifactLock.Lock()
+	defer recordArtifactLock.Unlock()
+
+	artifact, err := queryRecordArtifact(ctx, uuid)
+	if err != nil {
+		return errors.Wrapf(err, "query artifact")
+	}
+
+	if err := update(artifact); err != nil {
+		return errors.Wrapf(err, "update %v", uuid)
+	}
+
+	if b, err := json.Marshal(artifact); err != nil {
+		return errors.Wrapf(err, "marshal %v", artifact.String())
+	} else if err = rdb.HSet(ctx, SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_M3U8_ARTIFACT, artifact.UUID, string(b))
+	}
+	return nil
+}
+
+// removeRecordArtifact remove the record artifact from redis, under the lock of artifacts.
+func removeRecordArtifact(ctx context.Context, uuid string) error {
+	recordArtifactLock.Lock()
+	defer recordArtifactLock.Unlock()
+
+	if err := rdb.HDel(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+	}
+	return nil
+}
+
+// ArchiveConfig is the config for archive transcoding of record.
+type ArchiveConfig struct {
+	// Whether enable the archive job.
+	All bool `json:"all"`
+	// The policy for the original file, keep or replace.
+	Policy ArchivePolicy `json:"policy"`
+	// The video encoder of FFmpeg, for example, libx265 or libsvtav1.
+	VideoCodec string `json:"videoCodec"`
+	// The CRF of video encoder, the target quality, 0 to use the default of encoder.
+	CRF int `json:"crf"`
+	// The preset of video encoder, for example, medium or slow.
+	Preset string `json:"preset"`
+	// The height to downscale the video, 0 to keep the resolution.
+	Height int `json:"height"`
+	// The bitrate of AAC audio, for example, 64k.
+	AudioBitrate string `json:"audioBitrate"`
+	// The off-peak hours in local time, from start to end, for example, 1 to 6. Note that the window might
+	// cross the midnight, for example, 22 to 6. The job runs all day if start equals to end.
+	StartHour int `json:"start"`
+	EndHour   int `json:"end"`
+}
+
+func NewArchiveConfig() *ArchiveConfig {
+	return &ArchiveConfig{
+		Policy: ArchivePolicyKeep, VideoCodec: "libx265", CRF: 28, Preset: "medium", AudioBitrate: "64k",
+		StartHour: 1, EndHour: 6,
+	}
+}
+
+func (v ArchiveConfig) String() string {
+	return fmt.Sprintf("all=%v, policy=%v, codec=%v, crf=%v, preset=%v, height=%v, abitrate=%v, hours=%v-%v",
+		v.All, v.Policy, v.VideoCodec, v.CRF, v.Preset, v.Height, v.AudioBitrate, v.StartHour, v.EndHour)
+}
+
+func (v *ArchiveConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_RECORD_ARCHIVE, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_RECORD_ARCHIVE)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+func (v *ArchiveConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal conf %v", v)
+	} else if err := rdb.HSet(ctx, SRS_RECORD_ARCHIVE, "global", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v global %v", SRS_RECORD_ARCHIVE, string(b))
+	}
+	return nil
+}
+
+// inWindow whether the time is in the off-peak hours.
+func (v *ArchiveConfig) inWindow(now time.Time) bool {
+	if v.StartHour == v.EndHour {
+		return true
+	}
+
+	hour := now.Hour()
+	if v.StartHour < v.EndHour {
+		return hour >= v.StartHour && hour < v.EndHour
+	}
+	return hour >= v.StartHour || hour < v.EndHour
+}
+
+// M3u8VoDArchive is the archive job and result of a record artifact.
+type M3u8VoDArchive struct {
+	// The status of archive job.
+	Status ArchiveStatus `json:"status"`
+	// The progress in percent.
+	Progress float64 `json:"progress"`
+	// The policy and profile to archive the file.
+	Policy     ArchivePolicy `json:"policy"`
+	VideoCodec string        `json:"videoCodec"`
+	CRF        int           `json:"crf"`
+	Height     int           `json:"height"`
+	// The archived file in the directory of artifact, archive.mp4 or index.mp4 if replaced.
+	File string `json:"file,omitempty"`
+	// The size in bytes of the original and archived file.
+	OriginalSize uint64 `json:"originalSize"`
+	ArchiveSize  uint64 `json:"archiveSize"`
+	// The disk space in bytes saved by replacing the original file.
+	SavedSize uint64 `json:"savedSize"`
+	// The cost in seconds to archive the file.
+	Cost float64 `json:"cost"`
+	// The error message if failed.
+	Error string `json:"error,omitempty"`
+	// The last update time.
+	Update string `json:"update"`
+}
+
+func (v *M3u8VoDArchive) String() string {
+	return fmt.Sprintf("status=%v, progress=%.1f, policy=%v, codec=%v, crf=%v, height=%v, file=%v, size=%v/%v, saved=%v, cost=%.1f",
+		v.Status, v.Progress, v.Policy, v.VideoCodec, v.CRF, v.Height, v.File, v.ArchiveSize, v.OriginalSize,
+		v.SavedSize, v.Cost)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchive_Window(t *testing.T) {
+	for _, e := range []struct {
+		start, end, hour int
+		expect           bool
+	}{
+		{start: 1, end: 6, hour: 0, expect: false},
+		{start: 1, end: 6, hour: 1, expect: true},
+		{start: 1, end: 6, hour: 5, expect: true},
+		{start: 1, end: 6, hour: 6, expect: false},
+		{start: 22, end: 6, hour: 23, expect: true},
+		{start: 22, end: 6, hour: 3, expect: true},
+		{start: 22, end: 6, hour: 12, expect: false},
+		{start: 0, end: 0, hour: 12, expect: true},
+	} {
+		config := &ArchiveConfig{StartHour: e.start, EndHour: e.end}
+		now := time.Date(2024, 1, 1, e.hour, 30, 0, 0, time.Local)
+		if v := config.inWindow(now); v != e.expect {
+			t.Errorf("archive window %v-%v at %v, expect %v, actual %v", e.start, e.end, e.hour, e.expect, v)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -80,6 +81,10 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				return errors.Wrapf(err, "hget %v globs", SRS_RECORD_PATTERNS)
 			} else if processCpDir, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, string(RecordPostProcessCpFile)).Result(); err != nil && err != redis.Nil {
 				return errors.Wrapf(err, "hget %v %v", SRS_RECORD_PATTERNS, string(RecordPostProcessCpFile))
+			} else if timeshift, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "timeshift").Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v timeshift", SRS_RECORD_PATTERNS)
+			} else if stitch, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "stitch").Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v stitch", SRS_RECORD_PATTERNS)
 			} else {
 				globFilters := []string{}
 				if globs != "" {
@@ -97,11 +102,17 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 					Globs []string `json:"globs"`
 					// The post process to copy file to dir for record.
 					ProcessCpDir string `json:"processCpDir"`
+					// The timeshift window in seconds, 0 means the whole recording.
+					Timeshift int `json:"timeshift"`
+					// The window in seconds to stitch the reconnected session to previous one, 0 to disable.
+					Stitch int `json:"stitch"`
 				}
 
+				timeshiftWindow, _ := strconv.Atoi(timeshift)
+				stitchWindow, _ := strconv.Atoi(stitch)
 				ohttp.WriteData(ctx, w, r, &RecordQueryResult{
 					All: all == "true", Home: "/data/record", Globs: globFilters,
-					ProcessCpDir: processCpDir,
+					ProcessCpDir: processCpDir, Timeshift: timeshiftWindow, Stitch: stitchWindow,
 				})
 			}
 
@@ -228,6 +239,78 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		}
 	})
 
+	ep = "/terraform/v1/hooks/record/timeshift"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var window int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Window *int    `json:"window"`
+			}{
+				Token: &token, Window: &window,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if window < 0 {
+				return errors.Errorf("invalid window %v", window)
+			}
+
+			if err := rdb.HSet(ctx, SRS_RECORD_PATTERNS, "timeshift", fmt.Sprintf("%v", window)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v timeshift %v", SRS_RECORD_PATTERNS, window)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "record update timeshift ok, window=%v, token=%vB", window, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/stitch"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var window int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				Window *int    `json:"window"`
+			}{
+				Token: &token, Window: &window,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if window < 0 {
+				return errors.Errorf("invalid window %v", window)
+			}
+
+			if err := rdb.HSet(ctx, SRS_RECORD_PATTERNS, "stitch", fmt.Sprintf("%v", window)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v stitch %v", SRS_RECORD_PATTERNS, window)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "record update stitch ok, window=%v, token=%vB", window, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/hooks/record/remove"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
@@ -286,8 +369,8 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			}
 
 			// Remove HLS from list.
-			if err := rdb.HDel(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Err(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hdel %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+			if err := removeRecordArtifact(ctx, uuid); err != nil {
+				return errors.Wrapf(err, "remove %v", uuid)
 			}
 
 			ohttp.WriteData(ctx, w, r, nil)
@@ -375,15 +458,19 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 				}
 
 				files = append(files, map[string]interface{}{
-					"uuid":     metadata.UUID,
-					"vhost":    metadata.Vhost,
-					"app":      metadata.App,
-					"stream":   metadata.Stream,
-					"progress": metadata.Processing,
-					"update":   metadata.Update,
-					"nn":       len(metadata.Files),
-					"duration": duration,
-					"size":     size,
+					"uuid":       metadata.UUID,
+					"vhost":      metadata.Vhost,
+					"app":        metadata.App,
+					"stream":     metadata.Stream,
+					"progress":   metadata.Processing,
+					"update":     metadata.Update,
+					"nn":         len(metadata.Files),
+					"duration":   duration,
+					"size":       size,
+					"preview":    metadata.Preview,
+					"archive":    metadata.Archive,
+					"merge":      metadata.Merge,
+					"transcript": metadata.Transcript,
 				})
 			}
 
@@ -460,12 +547,15 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 	}
 
 	mp4Handler := func(w http.ResponseWriter, r *http.Request) error {
-		// Format is :uuid/index.mp4
+		// Format is :uuid/index.mp4 or :uuid/archive.mp4
 		filename := r.URL.Path[len("/terraform/v1/hooks/record/hls/"):]
-		uuid := path.Dir(filename)
+		uuid, mp4Name := path.Dir(filename), path.Base(filename)
 		if len(uuid) == 0 {
 			return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 		}
+		if mp4Name != "index.mp4" && mp4Name != "archive.mp4" {
+			mp4Name = "index.mp4"
+		}
 
 		var metadata M3u8VoDArtifact
 		if m3u8Metadata, err := rdb.HGet(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Result(); err != nil && err != redis.Nil {
@@ -476,7 +566,7 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 			return errors.Wrapf(err, "parse %v", m3u8Metadata)
 		}
 
-		mp4FilePath := path.Join("record", uuid, "index.mp4")
+		mp4FilePath := path.Join("record", uuid, mp4Name)
 		stats, err := os.Stat(mp4FilePath)
 		if err != nil {
 			return errors.Wrapf(err, "no mp4 file %v", mp4FilePath)
@@ -520,16 +610,124 @@ func (v *RecordWorker) Handle(ctx context.Context, handler *http.ServeMux) error
 		return nil
 	}
 
+	previewHandler := func(w http.ResponseWriter, r *http.Request) error {
+		// Format is :uuid/poster.jpg or :uuid/sprite.jpg or :uuid/thumbnails.vtt
+		filename := r.URL.Path[len("/terraform/v1/hooks/record/hls/"):]
+		uuid, fileBase := path.Dir(filename), path.Base(filename)
+		if len(uuid) == 0 || uuid == "." {
+			return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
+		}
+
+		var metadata M3u8VoDArtifact
+		if m3u8Metadata, err := rdb.HGet(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Result(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hget %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+		} else if m3u8Metadata == "" {
+			return errors.Errorf("no m3u8 of uuid=%v", uuid)
+		} else if err = json.Unmarshal([]byte(m3u8Metadata), &metadata); err != nil {
+			return errors.Wrapf(err, "parse %v", m3u8Metadata)
+		}
+
+		preview := metadata.Preview
+		if preview == nil {
+			return errors.Errorf("no preview of uuid=%v", uuid)
+		}
+
+		var contentType string
+		switch fileBase {
+		case preview.Poster, preview.Sprite:
+			contentType = "image/jpeg"
+		case preview.Thumbnails:
+			contentType = "text/vtt"
+		default:
+			return errors.Errorf("invalid preview %v of uuid=%v", fileBase, uuid)
+		}
+
+		previewFilePath := path.Join("record", uuid, fileBase)
+		if previewFile, err := os.Open(previewFilePath); err != nil {
+			return errors.Wrapf(err, "open file %v", previewFilePath)
+		} else {
+			defer previewFile.Close()
+			w.Header().Set("Content-Type", contentType)
+			io.Copy(w, previewFile)
+		}
+
+		logger.Tf(ctx, "record serve preview ok, uuid=%v, file=%v", uuid, previewFilePath)
+		return nil
+	}
+
+	ep = "/terraform/v1/hooks/record/timeshift/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is :app/:stream.m3u8
+			filename := r.URL.Path[len("/terraform/v1/hooks/record/timeshift/"):]
+			if !strings.HasSuffix(filename, ".m3u8") {
+				return errors.Errorf("invalid handler for %v", r.URL.Path)
+			}
+			app, stream := path.Dir(filename), strings.TrimSuffix(path.Base(filename), ".m3u8")
+			if len(app) == 0 || app == "." || len(stream) == 0 {
+				return errors.Errorf("invalid app %v or stream %v of %v", app, stream, r.URL.Path)
+			}
+
+			task := recordWorker.QueryTaskByStream(app, stream)
+			if task == nil {
+				return errors.Errorf("no live record for app=%v, stream=%v", app, stream)
+			}
+
+			var window int
+			if timeshift, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "timeshift").Result(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hget %v timeshift", SRS_RECORD_PATTERNS)
+			} else if timeshift != "" {
+				if window, err = strconv.Atoi(timeshift); err != nil {
+					return errors.Wrapf(err, "parse timeshift %v", timeshift)
+				}
+			}
+
+			// Keep the newest files in window, drop the old files from head of list.
+			tsFiles := task.copyArtifactFiles()
+			var sequence int
+			if window > 0 {
+				var duration float64
+				sequence = len(tsFiles)
+				for sequence > 0 && duration < float64(window) {
+					sequence--
+					duration += tsFiles[sequence].Duration
+				}
+			}
+
+			prefix := "/terraform/v1/hooks/record/hls/"
+			contentType, m3u8Body, duration, err := buildTimeshiftM3u8ForLocal(
+				ctx, tsFiles[sequence:], sequence, window == 0, prefix,
+			)
+			if err != nil {
+				return errors.Wrapf(err, "build timeshift m3u8 of %v with prefix=%v", task.String(), prefix)
+			}
+
+			w.Header().Set("Cache-Control", "no-cache, max-age=0")
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(m3u8Body))
+			logger.Tf(ctx, "record generate timeshift m3u8 ok, uuid=%v, window=%v, sequence=%v, duration=%v",
+				task.UUID, window, sequence, duration)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/hooks/record/hls/"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
-			if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			if isRecordTranscriptFile(r.URL.Path) {
+				return serveRecordTranscript(ctx, w, r)
+			} else if strings.HasSuffix(r.URL.Path, ".m3u8") {
 				return m3u8Handler(w, r)
 			} else if strings.HasSuffix(r.URL.Path, ".ts") {
 				return tsHandler(w, r)
 			} else if strings.HasSuffix(r.URL.Path, ".mp4") {
 				return mp4Handler(w, r)
+			} else if strings.HasSuffix(r.URL.Path, ".jpg") || strings.HasSuffix(r.URL.Path, ".vtt") {
+				return previewHandler(w, r)
 			}
 
 			return errors.Errorf("invalid handler for %v", r.URL.Path)
@@ -558,7 +756,9 @@ func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage)
 		return errors.Wrapf(err, "stat file %v", msg.File)
 	}
 
-	// Create a local ts file object.
+	// Create a local ts file object. Because SRS generates the callback once the ts file is done, we
+	// estimate the start time of ts by its duration, for timeshift to play at wallclock time.
+	starttime := time.Now().Add(-1 * time.Duration(msg.Duration*float64(time.Second)))
 	tsFile := &TsFile{
 		TsID:     tsid,
 		URL:      msg.URL,
@@ -566,6 +766,8 @@ func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage)
 		Duration: msg.Duration,
 		Size:     uint64(stats.Size()),
 		File:     tsfile,
+		// The wallclock time of ts.
+		ProgramDateTime: starttime.Format(programDateTimeLayout),
 	}
 
 	// Notify worker asynchronously.
@@ -578,6 +780,28 @@ func (v *RecordWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage)
 	return nil
 }
 
+// recordStreamField is the field in SRS_RECORD_PATTERNS to record a specified stream, even if the record is
+// not enabled for all streams, for example, the stream of live room.
+func recordStreamField(app, stream string) string {
+	return fmt.Sprintf("stream:/%v/%v", app, stream)
+}
+
+// queryRecordEnabled whether record the stream, enabled for all streams or the specified stream.
+func queryRecordEnabled(ctx context.Context, app, stream string) (all, enabled bool, err error) {
+	if r0, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err != nil && err != redis.Nil {
+		return false, false, errors.Wrapf(err, "hget %v all", SRS_RECORD_PATTERNS)
+	} else if r0 == "true" {
+		return true, true, nil
+	}
+
+	field := recordStreamField(app, stream)
+	if r0, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, field).Result(); err != nil && err != redis.Nil {
+		return false, false, errors.Wrapf(err, "hget %v %v", SRS_RECORD_PATTERNS, field)
+	} else {
+		return false, r0 == "true", nil
+	}
+}
+
 func (v *RecordWorker) Close() error {
 	if v.cancel != nil {
 		v.cancel()
@@ -598,6 +822,68 @@ func (v *RecordWorker) QueryTask(uuid string) *RecordM3u8Stream {
 	return target
 }
 
+// QueryTaskByStream find the working record task of the stream, for timeshift.
+func (v *RecordWorker) QueryTaskByStream(app, stream string) *RecordM3u8Stream {
+	var target *RecordM3u8Stream
+	v.streams.Range(func(key, value interface{}) bool {
+		if task := value.(*RecordM3u8Stream); task.matchStream(app, stream) {
+			target = task
+			return false
+		}
+		return true
+	})
+	return target
+}
+
+// queryStitchArtifact find the finished artifact of the stream, which ends in the stitch window, to append the
+// reconnected session to it. Return empty string if not found.
+func (v *RecordWorker) queryStitchArtifact(ctx context.Context, msg *SrsOnHlsMessage) (string, error) {
+	var window int
+	if stitch, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "stitch").Result(); err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v stitch", SRS_RECORD_PATTERNS)
+	} else if stitch != "" {
+		window, _ = strconv.Atoi(stitch)
+	}
+
+	if window <= 0 {
+		return "", nil
+	}
+
+	artifacts, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+	}
+
+	var target string
+	var latest time.Time
+	for _, value := range artifacts {
+		var artifact M3u8VoDArtifact
+		if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+			return "", errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		// Never stitch to the artifact which is being processed, merged, archived or transcribed.
+		if artifact.Processing || artifact.Merge != nil || artifact.Archive != nil || artifact.Transcript != nil {
+			continue
+		}
+		if artifact.Vhost != msg.Vhost || artifact.App != msg.App || artifact.Stream != msg.Stream {
+			continue
+		}
+		if v.QueryTask(artifact.UUID) != nil {
+			continue
+		}
+
+		if _, end, ok := artifactTimeRange(&artifact); ok && end.After(latest) {
+			target, latest = artifact.UUID, end
+		}
+	}
+
+	if target == "" || time.Since(latest) > time.Duration(window)*time.Second {
+		return "", nil
+	}
+	return target, nil
+}
+
 // Start 是 RecordWorker 的核心方法，用于启动记录工作器。
 // 该方法的主要功能包括：
 // 1. 从 Redis 中加载所有未完成的 HLS 记录任务；
@@ -658,6 +944,12 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 	buildM3u8Object := func(ctx context.Context, msg *SrsOnHlsObject) error {
 		logger.Tf(ctx, "Record: Got message %v", msg.String())
 
+		// Ignore the glob filters, if the stream is recorded for all streams.
+		all, _, err := queryRecordEnabled(ctx, msg.Msg.App, msg.Msg.Stream)
+		if err != nil {
+			return errors.Wrapf(err, "query record")
+		}
+
 		// Filter the stream by glob filters.
 		// 根据 glob 过滤规则筛选流。
 		var globFilters []string
@@ -671,7 +963,7 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 
 		// If glob filters are empty, ignore it, and record all streams.
 		// 如果没有设置 glob 过滤规则，则记录所有流。
-		if len(globFilters) > 0 {
+		if all && len(globFilters) > 0 {
 			var globMatched bool
 			streamURL := fmt.Sprintf("/%v/%v", msg.Msg.App, msg.Msg.Stream)
 			for _, globFilter := range globFilters {
@@ -693,8 +985,25 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 		// 加载或创建本地流对象。
 		var m3u8LocalObj *RecordM3u8Stream
 		var freshObject bool
+
+		// Stitch to the previous session if the stream reconnects in the window, only for new stream.
+		// 如果流在窗口内重连，则拼接到上一个录制会话。
+		var stitchUUID string
+		if _, ok := v.streams.Load(msg.Msg.M3u8URL); !ok {
+			if r0, err := v.queryStitchArtifact(ctx, msg.Msg); err != nil {
+				logger.Wf(ctx, "ignore stitch for %v err %+v", msg.Msg.String(), err)
+			} else {
+				stitchUUID = r0
+			}
+		}
+
+		objUUID := stitchUUID
+		if objUUID == "" {
+			objUUID = uuid.NewString()
+		}
+
 		if obj, loaded := v.streams.LoadOrStore(msg.Msg.M3u8URL, &RecordM3u8Stream{
-			M3u8URL: msg.Msg.M3u8URL, UUID: uuid.NewString(), recordWorker: v,
+			M3u8URL: msg.Msg.M3u8URL, UUID: objUUID, recordWorker: v,
 		}); true {
 			m3u8LocalObj, freshObject = obj.(*RecordM3u8Stream), !loaded
 		}
@@ -705,6 +1014,13 @@ func (v *RecordWorker) Start(ctx context.Context) error {
 			if err := m3u8LocalObj.Initialize(ctx, v); err != nil {
 				return errors.Wrapf(err, "init %v", m3u8LocalObj.String())
 			}
+
+			if stitchUUID != "" {
+				if err := m3u8LocalObj.reopenArtifact(ctx); err != nil {
+					return errors.Wrapf(err, "reopen %v", m3u8LocalObj.String())
+				}
+				logger.Tf(ctx, "record stitch %v to previous session", m3u8LocalObj.String())
+			}
 		}
 
 		// Append new ts file to object.
@@ -777,6 +1093,8 @@ type RecordM3u8Stream struct {
 	recordWorker *RecordWorker
 	// The artifact we're working for.
 	artifact *M3u8VoDArtifact
+	// Whether the next ts is discontinuous, because the session is stitched to previous one.
+	discontinuity bool
 	// To protect the fields.
 	lock sync.Mutex
 }
@@ -810,16 +1128,25 @@ func (v *RecordM3u8Stream) saveObject(ctx context.Context) error {
 	return nil
 }
 
+// saveArtifact save the fields of artifact owned by the record stream, such as the files and state, by the
+// locked read-modify-write of updateRecordArtifact, so never overwrite the fields updated by other workers,
+// for example, the archive, transcript and merge. The whole artifact is saved only if it's new.
 func (v *RecordM3u8Stream) saveArtifact(ctx context.Context, artifact *M3u8VoDArtifact) error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
-	if b, err := json.Marshal(artifact); err != nil {
-		return errors.Wrapf(err, "marshal %v", artifact.String())
-	} else if err = rdb.HSet(ctx, SRS_RECORD_M3U8_ARTIFACT, v.UUID, string(b)).Err(); err != nil && err != redis.Nil {
-		return errors.Wrapf(err, "hset %v %v %v", SRS_RECORD_M3U8_ARTIFACT, v.UUID, string(b))
+	if exists, err := rdb.HExists(ctx, SRS_RECORD_M3U8_ARTIFACT, v.UUID).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hexists %v %v", SRS_RECORD_M3U8_ARTIFACT, v.UUID)
+	} else if !exists {
+		return saveRecordArtifact(ctx, artifact)
 	}
-	return nil
+
+	return updateRecordArtifact(ctx, v.UUID, func(latest *M3u8VoDArtifact) error {
+		latest.NN, latest.Update, latest.M3u8URL = artifact.NN, artifact.Update, artifact.M3u8URL
+		latest.Vhost, latest.App, latest.Stream = artifact.Vhost, artifact.App, artifact.Stream
+		latest.Processing, latest.Files, latest.Preview = artifact.Processing, artifact.Files, artifact.Preview
+		return nil
+	})
 }
 
 func (v *RecordM3u8Stream) updateArtifact(ctx context.Context, artifact *M3u8VoDArtifact, msg *SrsOnHlsObject) {
@@ -830,6 +1157,10 @@ func (v *RecordM3u8Stream) updateArtifact(ctx context.Context, artifact *M3u8VoD
 	artifact.App = msg.Msg.App
 	artifact.Stream = msg.Msg.Stream
 
+	if v.discontinuity {
+		msg.TsFile.Discontinuity, v.discontinuity = true, false
+	}
+
 	artifact.Files = append(artifact.Files, msg.TsFile)
 	artifact.NN = len(artifact.Files)
 
@@ -844,6 +1175,37 @@ func (v *RecordM3u8Stream) finishArtifact(ctx context.Context, artifact *M3u8VoD
 	artifact.Update = time.Now().Format(time.RFC3339)
 }
 
+// reopenArtifact reopen the finished artifact, to append the ts files of a reconnected session.
+func (v *RecordM3u8Stream) reopenArtifact(ctx context.Context) error {
+	v.lock.Lock()
+	v.artifact.Processing, v.discontinuity = true, true
+	v.artifact.Update = time.Now().Format(time.RFC3339)
+	v.lock.Unlock()
+
+	// The mp4 and preview will be regenerated when done.
+	if err := v.saveArtifact(ctx, v.artifact); err != nil {
+		return errors.Wrapf(err, "save artifact %v", v.artifact.String())
+	}
+	return nil
+}
+
+func (v *RecordM3u8Stream) matchStream(app, stream string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.artifact != nil && v.artifact.App == app && v.artifact.Stream == stream
+}
+
+func (v *RecordM3u8Stream) copyArtifactFiles() []*TsFile {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.artifact == nil {
+		return nil
+	}
+	return append([]*TsFile{}, v.artifact.Files...)
+}
+
 func (v *RecordM3u8Stream) addMessage(ctx context.Context, msg *SrsOnHlsObject) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -889,8 +1251,9 @@ func (v *RecordM3u8Stream) expired(ctx context.Context) bool {
 	}
 
 	var enabled bool
-	if all, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err == nil {
-		enabled = all == "true"
+	if len(v.Messages) > 0 {
+		msg := v.Messages[0].Msg
+		_, enabled, _ = queryRecordEnabled(ctx, msg.App, msg.Stream)
 	}
 
 	duration := 30 * time.Second
@@ -1079,6 +1442,16 @@ func (v *RecordM3u8Stream) finishM3u8(ctx context.Context) error {
 	}
 	logger.Tf(ctx, "record to %v ok", mp4)
 
+	// Generate the poster and thumbnails for previewing, ignore any error because it's optional.
+	if preview, err := generateVoDPreview(ctx, mp4, path.Join("record", v.UUID)); err != nil {
+		logger.Wf(ctx, "ignore record preview of %v err %+v", mp4, err)
+	} else {
+		v.lock.Lock()
+		v.artifact.Preview = preview
+		v.lock.Unlock()
+		logger.Tf(ctx, "record preview of %v ok, %v", mp4, preview.String())
+	}
+
 	// Remove object from worker.
 	v.recordWorker.streams.Delete(v.M3u8URL)
 
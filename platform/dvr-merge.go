@@ -0,0 +1,487 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var mergeWorker *MergeWorker
+
+// MergeWorker merge multiple record artifacts, for example, the sessions of a stream which reconnects
+// several times, into a new artifact with a continuous HLS and MP4.
+type MergeWorker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewMergeWorker() *MergeWorker {
+	return &MergeWorker{}
+}
+
+func (v *MergeWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/record/merge"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var uuids []string
+			var app, stream, start, end string
+			var filler bool
+			var maxGap float64
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// Merge the specified artifacts, in the order of start time.
+				UUIDs *[]string `json:"uuids"`
+				// Or merge all artifacts of the stream, which starts in the time range.
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+				Start  *string `json:"start"`
+				End    *string `json:"end"`
+				// Whether insert black and silent filler for the gap between sessions.
+				Filler *bool `json:"filler"`
+				// The max duration in seconds of filler, the gap longer than it will be truncated.
+				MaxGap *float64 `json:"maxGap"`
+			}{
+				Token: &token, UUIDs: &uuids, App: &app, Stream: &stream, Start: &start, End: &end,
+				Filler: &filler, MaxGap: &maxGap,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if len(uuids) == 0 && (app == "" || stream == "") {
+				return errors.New("no uuids or stream")
+			}
+			if maxGap < 0 {
+				return errors.Errorf("invalid maxGap %v", maxGap)
+			} else if maxGap == 0 {
+				maxGap = 30
+			}
+
+			var startTime, endTime time.Time
+			if start != "" {
+				if t, err := time.Parse(time.RFC3339, start); err != nil {
+					return errors.Wrapf(err, "parse start %v", start)
+				} else {
+					startTime = t
+				}
+			}
+			if end != "" {
+				if t, err := time.Parse(time.RFC3339, end); err != nil {
+					return errors.Wrapf(err, "parse end %v", end)
+				} else {
+					endTime = t
+				}
+			}
+
+			sources, err := queryMergeSources(ctx, uuids, app, stream, startTime, endTime)
+			if err != nil {
+				return errors.Wrapf(err, "query sources")
+			}
+			if len(sources) < 2 {
+				return errors.Errorf("at least 2 artifacts to merge, got %v", len(sources))
+			}
+
+			artifact, err := v.merge(ctx, sources, filler, maxGap)
+			if err != nil {
+				return errors.Wrapf(err, "merge")
+			}
+
+			ohttp.WriteData(ctx, w, r, &struct {
+				UUID string `json:"uuid"`
+			}{
+				UUID: artifact.UUID,
+			})
+			logger.Tf(ctx, "record merge ok, uuid=%v, sources=%v, filler=%v, maxGap=%v, token=%vB",
+				artifact.UUID, len(sources), filler, maxGap, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *MergeWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *MergeWorker) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	v.ctx, v.cancel = ctx, cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "merge start a worker")
+
+	// Restart the merge jobs which are interrupted by restart.
+	artifacts, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+	}
+
+	for _, value := range artifacts {
+		artifact := &M3u8VoDArtifact{}
+		if err := json.Unmarshal([]byte(value), artifact); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		if artifact.Merge == nil || !artifact.Processing {
+			continue
+		}
+
+		logger.Tf(ctx, "merge: restart %v", artifact.String())
+		v.run(artifact)
+	}
+
+	return nil
+}
+
+// merge create a new artifact for the sources, and start a job to merge them.
+func (v *MergeWorker) merge(ctx context.Context, sources []*M3u8VoDArtifact, filler bool, maxGap float64) (*M3u8VoDArtifact, error) {
+	first := sources[0]
+	artifact := &M3u8VoDArtifact{
+		UUID: uuid.NewString(), M3u8URL: first.M3u8URL,
+		Vhost: first.Vhost, App: first.App, Stream: first.Stream,
+		Processing: true, Update: time.Now().Format(time.RFC3339),
+		Merge: &M3u8VoDMerge{Filler: filler, MaxGap: maxGap},
+	}
+	for _, source := range sources {
+		artifact.Merge.Sources = append(artifact.Merge.Sources, source.UUID)
+	}
+
+	if err := saveRecordArtifact(ctx, artifact); err != nil {
+		return nil, errors.Wrapf(err, "save artifact")
+	}
+
+	v.run(artifact)
+	return artifact, nil
+}
+
+func (v *MergeWorker) run(artifact *M3u8VoDArtifact) {
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		ctx := logger.WithContext(v.ctx)
+		if err := v.doMerge(ctx, artifact); err != nil {
+			logger.Wf(ctx, "merge: ignore %v err %+v", artifact.UUID, err)
+
+			// Never update the artifact if quit, because we will restart it.
+			if ctx.Err() != nil {
+				return
+			}
+
+			artifact.Processing, artifact.Merge.Error = false, err.Error()
+			artifact.Update = time.Now().Format(time.RFC3339)
+			if err := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+				a.Processing, a.Merge, a.Update = artifact.Processing, artifact.Merge, artifact.Update
+				return nil
+			}); err != nil {
+				logger.Wf(ctx, "merge: ignore save %v err %+v", artifact.UUID, err)
+			}
+		}
+	}()
+}
+
+func (v *MergeWorker) doMerge(ctx context.Context, artifact *M3u8VoDArtifact) error {
+	starttime := time.Now()
+	logger.Tf(ctx, "merge: start %v, sources=%v", artifact.UUID, artifact.Merge.Sources)
+
+	var sources []*M3u8VoDArtifact
+	for _, sourceUUID := range artifact.Merge.Sources {
+		source, err := queryRecordArtifact(ctx, sourceUUID)
+		if err != nil {
+			return errors.Wrapf(err, "query %v", sourceUUID)
+		}
+		if len(source.Files) == 0 {
+			continue
+		}
+		sources = append(sources, source)
+	}
+
+	mergeDir := path.Join("record", artifact.UUID)
+	if err := os.MkdirAll(mergeDir, 0755); err != nil {
+		return errors.Wrapf(err, "mkdir %v", mergeDir)
+	}
+
+	// Link the ts files of sources to the merged directory, so that the merged artifact is still available
+	// after the source removed. Insert filler between sessions if required.
+	var files []*TsFile
+	for index, source := range sources {
+		if index > 0 && artifact.Merge.Filler {
+			gap := mergeGapDuration(sources[index-1], source, artifact.Merge.MaxGap)
+			if gap >= 1 {
+				filler, err := generateMergeFiller(ctx, mergeDir, source, gap)
+				if err != nil {
+					return errors.Wrapf(err, "generate filler for %v", source.UUID)
+				}
+				files = append(files, filler)
+			}
+		}
+
+		for i, file := range source.Files {
+			key := path.Join(mergeDir, fmt.Sprintf("%v.ts", file.TsID))
+			if err := os.Link(file.Key, key); err != nil && !os.IsExist(err) {
+				if err := exec.CommandContext(ctx, "cp", "-f", file.Key, key).Run(); err != nil {
+					return errors.Wrapf(err, "copy %v to %v", file.Key, key)
+				}
+			}
+
+			tsFile := *file
+			tsFile.Key = key
+			tsFile.Discontinuity = file.Discontinuity || (index > 0 && i == 0)
+			files = append(files, &tsFile)
+		}
+	}
+
+	contentType, m3u8Body, duration, err := buildVodM3u8ForLocal(ctx, files, false, "")
+	if err != nil {
+		return errors.Wrapf(err, "build vod")
+	}
+
+	hls := path.Join(mergeDir, "index.m3u8")
+	if err := ioutil.WriteFile(hls, []byte(m3u8Body), 0644); err != nil {
+		return errors.Wrapf(err, "write hls %v", hls)
+	}
+	logger.Tf(ctx, "merge: hls %v ok, type=%v, duration=%v", hls, contentType, duration)
+
+	// Use concat demuxer, which rebase the timestamp of each ts file, so the output is continuous. We must
+	// transcode when there is filler, because the encoding parameters of filler might not match the stream.
+	concatFile := path.Join(mergeDir, "concat.txt")
+	var concat []string
+	for _, file := range files {
+		concat = append(concat, fmt.Sprintf("file '%v.ts'", file.TsID))
+	}
+	if err := ioutil.WriteFile(concatFile, []byte(strings.Join(concat, "\n")), 0644); err != nil {
+		return errors.Wrapf(err, "write concat %v", concatFile)
+	}
+	defer os.Remove(concatFile)
+
+	mp4 := path.Join(mergeDir, "index.mp4")
+	args := []string{"-f", "concat", "-safe", "0", "-i", concatFile}
+	if artifact.Merge.Filler {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, "-movflags", "+faststart", "-y", mp4)
+	if b, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "merge to mp4 %v err %v", mp4, string(b))
+	}
+	logger.Tf(ctx, "merge: mp4 %v ok", mp4)
+
+	// Generate the poster and thumbnails for previewing, ignore any error because it's optional.
+	if preview, err := generateVoDPreview(ctx, mp4, mergeDir); err != nil {
+		logger.Wf(ctx, "merge: ignore preview of %v err %+v", mp4, err)
+	} else {
+		artifact.Preview = preview
+	}
+
+	artifact.Files, artifact.NN = files, len(files)
+	artifact.Processing, artifact.Merge.Cost = false, time.Since(starttime).Seconds()
+	artifact.Update = time.Now().Format(time.RFC3339)
+	if err := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+		a.Files, a.NN, a.Preview = artifact.Files, artifact.NN, artifact.Preview
+		a.Processing, a.Merge, a.Update = artifact.Processing, artifact.Merge, artifact.Update
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "save artifact")
+	}
+
+	logger.Tf(ctx, "merge: done %v, files=%v, duration=%v, cost=%.1f",
+		artifact.UUID, len(files), duration, artifact.Merge.Cost)
+	return nil
+}
+
+// generateMergeFiller generate a black and silent ts file in dir, with the resolution of source.
+func generateMergeFiller(ctx context.Context, dir string, source *M3u8VoDArtifact, gap float64) (*TsFile, error) {
+	width, height := 1280, 720
+	if _, video, _, err := FFprobeFileFormat(ctx, source.Files[0].Key); err != nil {
+		logger.Wf(ctx, "merge: ignore probe %v err %+v", source.Files[0].Key, err)
+	} else if video != nil && video.Width > 0 && video.Height > 0 {
+		width, height = int(video.Width), int(video.Height)
+	}
+
+	tsid := uuid.NewString()
+	key := path.Join(dir, fmt.Sprintf("%v.ts", tsid))
+	args := []string{
+		"-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%vx%v:r=25", width, height),
+		"-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo",
+		"-t", fmt.Sprintf("%.3f", gap), "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-f", "mpegts", "-y", key,
+	}
+	if b, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "generate filler %v err %v", key, string(b))
+	}
+
+	stats, err := os.Stat(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat %v", key)
+	}
+
+	return &TsFile{
+		Key: key, TsID: tsid, Duration: gap, Size: uint64(stats.Size()), Discontinuity: true,
+	}, nil
+}
+
+// queryRecordArtifact load the record artifact by uuid.
+func queryRecordArtifact(ctx context.Context, uuid string) (*M3u8VoDArtifact, error) {
+	var artifact M3u8VoDArtifact
+	if value, err := rdb.HGet(ctx, SRS_RECORD_M3U8_ARTIFACT, uuid).Result(); err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_RECORD_M3U8_ARTIFACT, uuid)
+	} else if value == "" {
+		return nil, errors.Errorf("no record for uuid=%v", uuid)
+	} else if err = json.Unmarshal([]byte(value), &artifact); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", value)
+	}
+	return &artifact, nil
+}
+
+// queryMergeSources query the finished artifacts by uuids, or by stream and time range, sorted by the
+// start time.
+func queryMergeSources(
+	ctx context.Context, uuids []string, app, stream string, start, end time.Time,
+) ([]*M3u8VoDArtifact, error) {
+	var sources []*M3u8VoDArtifact
+	if len(uuids) > 0 {
+		for _, uuid := range uuids {
+			artifact, err := queryRecordArtifact(ctx, uuid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "query %v", uuid)
+			}
+			if artifact.Processing {
+				return nil, errors.Errorf("record %v is processing", uuid)
+			}
+			sources = append(sources, artifact)
+		}
+	} else {
+		artifacts, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+		}
+
+		for _, value := range artifacts {
+			var artifact M3u8VoDArtifact
+			if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %v", value)
+			}
+
+			// Ignore the merged artifacts, to avoid duplicated content.
+			if artifact.Processing || artifact.Merge != nil {
+				continue
+			}
+			if artifact.App != app || artifact.Stream != stream {
+				continue
+			}
+
+			startTime, _, ok := artifactTimeRange(&artifact)
+			if !ok {
+				continue
+			}
+			if !start.IsZero() && startTime.Before(start) {
+				continue
+			}
+			if !end.IsZero() && startTime.After(end) {
+				continue
+			}
+
+			sources = append(sources, &artifact)
+		}
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		si, _, oki := artifactTimeRange(sources[i])
+		sj, _, okj := artifactTimeRange(sources[j])
+		return oki && okj && si.Before(sj)
+	})
+	return sources, nil
+}
+
+// artifactTimeRange get the wallclock time range of artifact, by the program date time of ts files.
+func artifactTimeRange(artifact *M3u8VoDArtifact) (start, end time.Time, ok bool) {
+	if len(artifact.Files) == 0 {
+		return
+	}
+
+	first, last := artifact.Files[0], artifact.Files[len(artifact.Files)-1]
+	if first.ProgramDateTime == "" || last.ProgramDateTime == "" {
+		return
+	}
+
+	var err error
+	if start, err = time.Parse(programDateTimeLayout, first.ProgramDateTime); err != nil {
+		return
+	}
+	if end, err = time.Parse(programDateTimeLayout, last.ProgramDateTime); err != nil {
+		return
+	}
+
+	end = end.Add(time.Duration(last.Duration * float64(time.Second)))
+	return start, end, true
+}
+
+// mergeGapDuration get the gap in seconds between two sessions, limited by maxGap.
+func mergeGapDuration(prev, next *M3u8VoDArtifact, maxGap float64) float64 {
+	_, prevEnd, ok := artifactTimeRange(prev)
+	if !ok {
+		return 0
+	}
+
+	nextStart, _, ok := artifactTimeRange(next)
+	if !ok {
+		return 0
+	}
+
+	gap := nextStart.Sub(prevEnd).Seconds()
+	return math.Max(0, math.Min(gap, maxGap))
+}
+
+// M3u8VoDMerge is the merge job of a record artifact, which is merged from other artifacts.
+type M3u8VoDMerge struct {
+	// The uuid of source artifacts, in the order of start time.
+	Sources []string `json:"sources"`
+	// Whether insert filler for the gap between sessions.
+	Filler bool `json:"filler"`
+	// The max duration in seconds of filler.
+	MaxGap float64 `json:"maxGap"`
+	// The cost in seconds to merge.
+	Cost float64 `json:"cost"`
+	// The error message if failed.
+	Error string `json:"error,omitempty"`
+}
+
+func (v *M3u8VoDMerge) String() string {
+	return fmt.Sprintf("sources=%v, filler=%v, maxGap=%v, cost=%.1f, error=%v",
+		v.Sources, v.Filler, v.MaxGap, v.Cost, v.Error)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMerge_GapDuration(t *testing.T) {
+	prev := &M3u8VoDArtifact{Files: []*TsFile{
+		{Duration: 10, ProgramDateTime: "2024-04-23T01:00:00.000+08:00"},
+		{Duration: 10, ProgramDateTime: "2024-04-23T01:00:10.000+08:00"},
+	}}
+	next := &M3u8VoDArtifact{Files: []*TsFile{
+		{Duration: 10, ProgramDateTime: "2024-04-23T01:00:25.000+08:00"},
+	}}
+	if gap := mergeGapDuration(prev, next, 30); gap != 5 {
+		t.Errorf("merge gap failed, expect 5, actual %v", gap)
+	}
+	if gap := mergeGapDuration(prev, next, 3); gap != 3 {
+		t.Errorf("merge gap failed, expect 3, actual %v", gap)
+	}
+	if gap := mergeGapDuration(next, prev, 30); gap != 0 {
+		t.Errorf("merge gap failed, expect 0, actual %v", gap)
+	}
+	if gap := mergeGapDuration(&M3u8VoDArtifact{}, next, 30); gap != 0 {
+		t.Errorf("merge gap failed, expect 0, actual %v", gap)
+	}
+}
@@ -41,6 +41,12 @@ type VodWorker struct {
 	secretKey string
 	vodAppID  uint64
 	vodClient *vod.Client
+	// The Tencent Cloud VoD, which is the default provider.
+	tencent *TencentVodProvider
+	// The config of VoD provider, for new streams, and to create the HTTP provider.
+	providerConfig *VodProviderConfig
+	// To protect the config of provider.
+	providerLock sync.Mutex
 
 	// Got message from SRS, a new TS segment file is generated.
 	msgs chan *SrsOnHlsObject
@@ -56,9 +62,12 @@ type VodWorker struct {
 func NewVodWorker() *VodWorker {
 	// 创建一个带有缓冲区的 channel，用于接收 SrsOnHlsObject 类型的消息。
 	// 缓冲区大小为 1024，这意味着它可以存储 1024 个消息而不会阻塞。
-	return &VodWorker{
-		msgs: make(chan *SrsOnHlsObject, 1024),
+	v := &VodWorker{
+		msgs:           make(chan *SrsOnHlsObject, 1024),
+		providerConfig: NewVodProviderConfig(),
 	}
+	v.tencent = &TencentVodProvider{worker: v}
+	return v
 }
 
 func (v *VodWorker) ready() bool {
@@ -97,17 +106,25 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			service, _ := rdb.HGet(ctx, SRS_TENCENT_VOD, "service").Result()
 			storage, _ := rdb.HGet(ctx, SRS_TENCENT_VOD, "storage").Result()
 
+			config := NewVodProviderConfig()
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load provider")
+			}
+
 			ohttp.WriteData(ctx, w, r, &struct {
 				All     bool `json:"all"`
 				Secret  bool `json:"secret"`
 				Service bool `json:"service"`
 				Storage bool `json:"storage"`
+				// The VoD provider config.
+				Provider *VodProviderConfig `json:"provider"`
 			}{
 				All:    all == "true",
 				Secret: appId != "" && secretId != "" && secretKey != "",
 				// For previous platform, the service is set to string ok, so we also create an application.
-				Service: service != "" && service != "ok",
-				Storage: storage != "",
+				Service:  service != "" && service != "ok",
+				Storage:  storage != "",
+				Provider: config,
 			})
 
 			logger.Tf(ctx, "vod query ok, token=%vB", len(token))
@@ -149,6 +166,48 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	ep = "/terraform/v1/hooks/vod/provider"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			config := NewVodProviderConfig()
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*VodProviderConfig
+			}{
+				Token: &token, VodProviderConfig: config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if config.Provider != VodProviderTencent && config.Provider != VodProviderHTTP {
+				return errors.Errorf("invalid provider %v", config.Provider)
+			}
+			if config.Provider == VodProviderHTTP && config.UploadURL == "" {
+				return errors.New("no upload url")
+			}
+
+			if err := config.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save provider")
+			}
+
+			// Apply the provider for new streams.
+			v.setProviderConfig(config)
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "vod update provider ok, %v, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
 	ep = "/terraform/v1/hooks/vod/files"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
@@ -173,86 +232,17 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 
 			// Unmarshal artifacts from redis.
-			artifacts, pendingArtifacts := []*M3u8VoDArtifact{}, []*M3u8VoDArtifact{}
+			artifacts := []*M3u8VoDArtifact{}
 			for i := 0; i < len(keys); i += 2 {
 				var metadata M3u8VoDArtifact
 				if err := json.Unmarshal([]byte(keys[i+1]), &metadata); err != nil {
 					return errors.Wrapf(err, "json parse %v", keys[i+1])
 				}
 
-				if metadata.Definition != 0 && metadata.TaskID != "" && metadata.Task == nil {
-					pendingArtifacts = append(pendingArtifacts, &metadata)
-				}
-
 				artifacts = append(artifacts, &metadata)
 			}
 
-			// TODO: FIXME: Query in worker thread.
-			// Query media information for artifacts.
-			// See https://cloud.tencent.com/document/product/266/31763
-			if len(pendingArtifacts) > 0 {
-				var fileIDs []string
-				fileIDKVs := make(map[string]*M3u8VoDArtifact)
-				for _, artifact := range pendingArtifacts {
-					fileIDs = append(fileIDs, artifact.FileID)
-					fileIDKVs[artifact.FileID] = artifact
-				}
-
-				request := vod.NewDescribeMediaInfosRequest()
-				request.FileIds = common.StringPtrs(fileIDs)
-				request.SubAppId = common.Uint64Ptr(v.vodAppID)
-				request.Filters = common.StringPtrs([]string{"transcodeInfo"})
-
-				var mediaInfoSet []*vod.MediaInfo
-				if response, err := v.vodClient.DescribeMediaInfosWithContext(ctx, request); err != nil {
-					return errors.Wrapf(err, "describe media info")
-				} else if len(response.Response.MediaInfoSet) > 0 {
-					mediaInfoSet = response.Response.MediaInfoSet
-				}
-
-				for _, mis := range mediaInfoSet {
-					if mis.FileId == nil || mis == nil || mis.TranscodeInfo == nil || len(mis.TranscodeInfo.TranscodeSet) == 0 {
-						continue
-					}
-
-					artifact, ok := fileIDKVs[*mis.FileId]
-					if !ok {
-						continue
-					}
-
-					var matched *vod.MediaTranscodeItem
-					for _, ts := range mis.TranscodeInfo.TranscodeSet {
-						if ts.Definition == nil || uint64(*ts.Definition) != artifact.Definition {
-							continue
-						}
-
-						matched = ts
-						break
-					}
-					if matched == nil {
-						continue
-					}
-
-					artifact.Task = &VodTaskArtifact{
-						URL:      *matched.Url,
-						Bitrate:  *matched.Bitrate,
-						Height:   int32(*matched.Height),
-						Width:    int32(*matched.Width),
-						Size:     *matched.Size,
-						Duration: *matched.Duration,
-						MD5:      *matched.Md5,
-					}
-
-					if b, err := json.Marshal(artifact); err != nil {
-						return errors.Wrapf(err, "marshal %v", artifact.String())
-					} else if err = rdb.HSet(ctx, SRS_VOD_M3U8_ARTIFACT, artifact.UUID, string(b)).Err(); err != nil && err != redis.Nil {
-						return errors.Wrapf(err, "hset %v %v %v", SRS_VOD_M3U8_ARTIFACT, artifact.UUID, string(b))
-					}
-					logger.Tf(ctx, "vod update task %v", artifact.String())
-				}
-			}
-
-			// Build response from artifacts.
+			// Build response from artifacts, note that the tasks are queried by worker.
 			files := []map[string]interface{}{}
 			for _, metadata := range artifacts {
 				var duration float64
@@ -273,9 +263,10 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 					"duration": duration,
 					"size":     size,
 					// For VoD only.
-					"file":  metadata.FileID,
-					"media": metadata.MediaURL,
-					"task":  metadata.Task,
+					"file":     metadata.FileID,
+					"media":    metadata.MediaURL,
+					"task":     metadata.Task,
+					"provider": metadata.Provider,
 				})
 			}
 
@@ -329,8 +320,8 @@ func (v *VodWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 }
 
 func (v *VodWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
-	// Ignore for Tencent Cloud credentials not ready.
-	if !v.ready() {
+	// Ignore if the VoD provider is not ready, for example, no Tencent Cloud credentials.
+	if _, provider := v.currentProvider(); !provider.Ready() {
 		return nil
 	}
 
@@ -398,6 +389,9 @@ func (v *VodWorker) Start(ctx context.Context) error {
 	if err := v.updateCredential(ctx); err != nil {
 		return errors.Wrapf(err, "update credential")
 	}
+	if err := v.updateProvider(ctx); err != nil {
+		return errors.Wrapf(err, "update provider")
+	}
 
 	// Load all objects from redis.
 	// 从 Redis 加载所有对象。
@@ -439,8 +433,9 @@ func (v *VodWorker) Start(ctx context.Context) error {
 		// 加载流本地对象。
 		var m3u8LocalObj *VodM3u8Stream
 		var freshObject bool
+		provider, _ := v.currentProvider()
 		if obj, loaded := v.streams.LoadOrStore(msg.Msg.M3u8URL, &VodM3u8Stream{
-			M3u8URL: msg.Msg.M3u8URL, UUID: uuid.NewString(), vodWorker: v,
+			M3u8URL: msg.Msg.M3u8URL, UUID: uuid.NewString(), Provider: provider, vodWorker: v,
 		}); true {
 			m3u8LocalObj, freshObject = obj.(*VodM3u8Stream), !loaded
 		}
@@ -505,6 +500,9 @@ func (v *VodWorker) Start(ctx context.Context) error {
 			if err := v.updateCredential(ctx); err != nil {
 				logger.Wf(ctx, "ignore err %+v", err)
 				duration = 30 * time.Second
+			} else if err := v.updateProvider(ctx); err != nil {
+				logger.Wf(ctx, "ignore err %+v", err)
+				duration = 30 * time.Second
 			} else if !v.ready() {
 				duration = 1 * time.Second
 			} else {
@@ -518,6 +516,103 @@ func (v *VodWorker) Start(ctx context.Context) error {
 		}
 	}()
 
+	// Query the processing task of VoD provider.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			if err := v.queryProviderTasks(ctx); err != nil {
+				logger.Wf(ctx, "ignore query provider tasks err %+v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (v *VodWorker) updateProvider(ctx context.Context) error {
+	config := NewVodProviderConfig()
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load provider")
+	}
+
+	v.setProviderConfig(config)
+	return nil
+}
+
+func (v *VodWorker) setProviderConfig(config *VodProviderConfig) {
+	v.providerLock.Lock()
+	defer v.providerLock.Unlock()
+	v.providerConfig = config
+}
+
+// currentProvider return the provider for new streams, and its name, which is empty for Tencent Cloud VoD.
+func (v *VodWorker) currentProvider() (string, VodProvider) {
+	v.providerLock.Lock()
+	defer v.providerLock.Unlock()
+
+	if v.providerConfig.Provider == VodProviderHTTP {
+		return VodProviderHTTP, NewHttpVodProvider(v.providerConfig)
+	}
+	return "", v.tencent
+}
+
+// providerOf return the provider by the name of stream or artifact, empty for Tencent Cloud VoD.
+func (v *VodWorker) providerOf(name string) VodProvider {
+	v.providerLock.Lock()
+	defer v.providerLock.Unlock()
+
+	if name == VodProviderHTTP {
+		return NewHttpVodProvider(v.providerConfig)
+	}
+	return v.tencent
+}
+
+// queryProviderTasks query the processing tasks of the artifacts, and save the result.
+func (v *VodWorker) queryProviderTasks(ctx context.Context) error {
+	artifacts, err := rdb.HGetAll(ctx, SRS_VOD_M3U8_ARTIFACT).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hgetall %v", SRS_VOD_M3U8_ARTIFACT)
+	}
+
+	for _, value := range artifacts {
+		var artifact M3u8VoDArtifact
+		if err := json.Unmarshal([]byte(value), &artifact); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		if artifact.TaskID == "" || artifact.Task != nil {
+			continue
+		}
+
+		provider := v.providerOf(artifact.Provider)
+		if !provider.Ready() {
+			continue
+		}
+
+		task, err := provider.Query(ctx, &artifact)
+		if err != nil {
+			logger.Wf(ctx, "ignore query task %v err %+v", artifact.String(), err)
+			continue
+		} else if task == nil {
+			continue
+		}
+
+		artifact.Task = task
+		if b, err := json.Marshal(artifact); err != nil {
+			return errors.Wrapf(err, "marshal %v", artifact.String())
+		} else if err = rdb.HSet(ctx, SRS_VOD_M3U8_ARTIFACT, artifact.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hset %v %v %v", SRS_VOD_M3U8_ARTIFACT, artifact.UUID, string(b))
+		}
+		logger.Tf(ctx, "vod update task %v, task=<%v>", artifact.String(), task.String())
+	}
+
 	return nil
 }
 
@@ -591,6 +686,8 @@ type VodM3u8Stream struct {
 	M3u8URL string `json:"m3u8_url"`
 	// The uuid of M3u8VoDObject, generated by worker, such as 3ECF0239-708C-42E4-96E1-5AE935C6E6A9
 	UUID string `json:"uuid"`
+	// The VoD provider, empty for Tencent Cloud VoD, or http for generic HTTP provider.
+	Provider string `json:"provider,omitempty"`
 
 	// Number of local files.
 	NN int `json:"nn"`
@@ -601,11 +698,16 @@ type VodM3u8Stream struct {
 
 	// The ts files of this m3u8.
 	Messages []*SrsOnHlsObject `json:"msgs"`
+	// The number of failed uploads when finishing, to give up when exceeds.
+	Retries int `json:"retries,omitempty"`
 
 	// The worker which owns this object.
 	vodWorker *VodWorker
 	// The artifact we're working for.
 	artifact *M3u8VoDArtifact
+	// The COS client and token, only for Tencent Cloud VoD.
+	cosClient *cos.Client
+	cosToken  *VodCosToken
 	// To protect the fields.
 	lock sync.Mutex
 }
@@ -705,6 +807,24 @@ func (v *VodM3u8Stream) vodRemux(ctx context.Context, artifact *M3u8VoDArtifact,
 	artifact.TaskID = taskID
 }
 
+// vodRetry increase the number of failed uploads, and return it.
+func (v *VodM3u8Stream) vodRetry(ctx context.Context) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.Retries++
+	v.Update = time.Now().Format(time.RFC3339)
+	return v.Retries
+}
+
+// vodFailed mark the artifact failed, so the task is never queried.
+func (v *VodM3u8Stream) vodFailed(ctx context.Context, artifact *M3u8VoDArtifact, err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	artifact.Task = &VodTaskArtifact{Error: err.Error()}
+}
+
 func (v *VodM3u8Stream) removeMessage(ctx context.Context, msg *SrsOnHlsObject) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -770,6 +890,7 @@ func (v *VodM3u8Stream) Initialize(ctx context.Context, r *VodWorker) error {
 			UUID:       v.UUID,
 			M3u8URL:    v.M3u8URL,
 			Processing: true,
+			Provider:   v.Provider,
 		}
 		if err := v.saveArtifact(ctx, v.artifact); err != nil {
 			return errors.Wrapf(err, "save artifact %v", v.artifact.String())
@@ -784,21 +905,17 @@ func (v *VodM3u8Stream) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(logger.WithContext(ctx))
 	logger.Tf(ctx, "vod run task %v", v.String())
 
-	var cosClient *cos.Client
-	var cosToken *VodCosToken
-
 	pfn := func() error {
-		// Refresh cos client.
-		if tc, tt, err := v.refreshCosClient(ctx, cosClient, cosToken); err != nil {
-			return errors.Wrapf(err, "refresh cos client")
-		} else {
-			cosClient, cosToken = tc, tt
+		// Prepare the stream, for example, refresh cos client for Tencent Cloud VoD.
+		provider := v.vodWorker.providerOf(v.Provider)
+		if err := provider.Prepare(ctx, v); err != nil {
+			return errors.Wrapf(err, "prepare")
 		}
 
 		// Process message and remove it.
 		msgs := v.copyMessages()
 		for _, msg := range msgs {
-			if err := v.serveMessage(ctx, msg, cosClient, cosToken); err != nil {
+			if err := v.serveMessage(ctx, provider, msg); err != nil {
 				logger.Wf(ctx, "ignore %v err %+v", msg.String(), err)
 			}
 		}
@@ -821,8 +938,8 @@ func (v *VodM3u8Stream) Run(ctx context.Context) error {
 		}
 
 		// Try to finish the object.
-		if err := v.finishM3u8(ctx, cosClient, cosToken); err != nil {
-			return errors.Wrapf(err, "finish m3u8")
+		if err := provider.Finish(ctx, v); err != nil {
+			return errors.Wrapf(err, "finish")
 		}
 
 		// Now HLS is done
@@ -966,12 +1083,61 @@ func (v *VodM3u8Stream) refreshCosClient(ctx context.Context, oldClient *cos.Cli
 	return client, cosToken, nil
 }
 
-func (v *VodM3u8Stream) serveMessage(ctx context.Context, msg *SrsOnHlsObject, cosClient *cos.Client, cosToken *VodCosToken) error {
+func (v *VodM3u8Stream) serveMessage(ctx context.Context, provider VodProvider, msg *SrsOnHlsObject) error {
 	// We always remove the msg from current object.
 	defer v.removeMessage(ctx, msg)
 
+	return provider.Serve(ctx, v, msg)
+}
+
+// finish remove the stream from worker, and update the artifact, when the recording is done.
+func (v *VodM3u8Stream) finish(ctx context.Context) {
+	// Remove object from worker.
+	v.vodWorker.streams.Delete(v.M3u8URL)
+
+	// Update artifact after finally.
+	v.finishArtifact(ctx, v.artifact)
+	r0 := v.saveArtifact(ctx, v.artifact)
+	r1 := v.deleteObject(ctx)
+	logger.Tf(ctx, "vod cleanup ok, r0=%v, r1=%v", r0, r1)
+
+	// Do final cleanup, because new messages might arrive while converting to mp4, which takes a long time.
+	files := v.copyMessages()
+	for _, file := range files {
+		r2 := os.Remove(file.TsFile.File)
+		logger.Tf(ctx, "drop %v r2=%v", file.String(), r2)
+	}
+}
+
+// TencentVodProvider is the Tencent Cloud VoD, which uploads each ts file to COS of VoD when recording, then
+// commit the m3u8 and start the remux task when finished.
+type TencentVodProvider struct {
+	worker *VodWorker
+}
+
+func (v *TencentVodProvider) Ready() bool {
+	return v.worker.ready()
+}
+
+// Prepare refresh the COS client and token of stream, to upload the files.
+func (v *TencentVodProvider) Prepare(ctx context.Context, stream *VodM3u8Stream) error {
+	if !v.Ready() {
+		return nil
+	}
+
+	if tc, tt, err := stream.refreshCosClient(ctx, stream.cosClient, stream.cosToken); err != nil {
+		return errors.Wrapf(err, "refresh cos client")
+	} else {
+		stream.cosClient, stream.cosToken = tc, tt
+	}
+	return nil
+}
+
+func (v *TencentVodProvider) Serve(ctx context.Context, stream *VodM3u8Stream, msg *SrsOnHlsObject) error {
+	cosClient, cosToken := stream.cosClient, stream.cosToken
+
 	// Ignore file if credential is not ready.
-	if !v.vodWorker.ready() {
+	if !v.Ready() {
 		return nil
 	}
 
@@ -1003,17 +1169,23 @@ func (v *VodM3u8Stream) serveMessage(ctx context.Context, msg *SrsOnHlsObject, c
 	}
 
 	// Update the metadata for m3u8.
-	v.updateArtifact(ctx, v.artifact, msg)
-	if err := v.saveArtifact(ctx, v.artifact); err != nil {
-		return errors.Wrapf(err, "save artifact %v", v.artifact.String())
+	stream.updateArtifact(ctx, stream.artifact, msg)
+	if err := stream.saveArtifact(ctx, stream.artifact); err != nil {
+		return errors.Wrapf(err, "save artifact %v", stream.artifact.String())
 	}
 
 	logger.Tf(ctx, "vod consume msg %v", msg.String())
 	return nil
 }
 
-func (v *VodM3u8Stream) finishM3u8(ctx context.Context, cosClient *cos.Client, cosToken *VodCosToken) error {
-	contentType, m3u8Body, duration, err := buildVodM3u8(ctx, v.artifact, false, "", false, "")
+// Finish upload the m3u8, then commit it, and start a remux task to covert HLS to MP4 if required.
+func (v *TencentVodProvider) Finish(ctx context.Context, stream *VodM3u8Stream) error {
+	cosClient, cosToken := stream.cosClient, stream.cosToken
+	if !v.Ready() || cosClient == nil || cosToken == nil {
+		return errors.New("vod not ready")
+	}
+
+	contentType, m3u8Body, duration, err := buildVodM3u8(ctx, stream.artifact, false, "", false, "")
 	if err != nil {
 		return errors.Wrapf(err, "build vod")
 	}
@@ -1037,12 +1209,12 @@ func (v *VodM3u8Stream) finishM3u8(ctx context.Context, cosClient *cos.Client, c
 		request := vod.NewCommitUploadRequest()
 
 		request.VodSessionKey = common.StringPtr(cosToken.Session)
-		request.SubAppId = common.Uint64Ptr(v.vodWorker.vodAppID)
+		request.SubAppId = common.Uint64Ptr(v.worker.vodAppID)
 
-		if response, err := v.vodWorker.vodClient.CommitUploadWithContext(ctx, request); err != nil {
+		if response, err := v.worker.vodClient.CommitUploadWithContext(ctx, request); err != nil {
 			return errors.Wrapf(err, "vod commit, key=%v", cosToken.Key)
 		} else {
-			v.vodCommit(ctx, v.artifact, *response.Response.FileId, *response.Response.MediaUrl)
+			stream.vodCommit(ctx, stream.artifact, *response.Response.FileId, *response.Response.MediaUrl)
 		}
 	}
 
@@ -1067,8 +1239,8 @@ func (v *VodM3u8Stream) finishM3u8(ctx context.Context, cosClient *cos.Client, c
 	if definition > 0 {
 		request := vod.NewProcessMediaRequest()
 
-		request.FileId = common.StringPtr(v.artifact.FileID)
-		request.SubAppId = common.Uint64Ptr(v.vodWorker.vodAppID)
+		request.FileId = common.StringPtr(stream.artifact.FileID)
+		request.SubAppId = common.Uint64Ptr(v.worker.vodAppID)
 		request.MediaProcessTask = &vod.MediaProcessTaskInput{
 			TranscodeTaskSet: []*vod.TranscodeTaskInput{
 				&vod.TranscodeTaskInput{
@@ -1077,7 +1249,7 @@ func (v *VodM3u8Stream) finishM3u8(ctx context.Context, cosClient *cos.Client, c
 			},
 		}
 
-		if response, err := v.vodWorker.vodClient.ProcessMediaWithContext(ctx, request); err != nil {
+		if response, err := v.worker.vodClient.ProcessMediaWithContext(ctx, request); err != nil {
 			return errors.Wrapf(err, "vod remux")
 		} else {
 			taskID = *response.Response.TaskId
@@ -1085,25 +1257,51 @@ func (v *VodM3u8Stream) finishM3u8(ctx context.Context, cosClient *cos.Client, c
 	}
 
 	if definition > 0 || taskID != "" {
-		v.vodRemux(ctx, v.artifact, uint64(definition), taskID)
+		stream.vodRemux(ctx, stream.artifact, uint64(definition), taskID)
 		logger.Tf(ctx, "vod remux ok, definition=%v, taskID=%v", definition, taskID)
 	}
 
-	// Remove object from worker.
-	v.vodWorker.streams.Delete(v.M3u8URL)
+	stream.finish(ctx)
+	return nil
+}
 
-	// Update artifact after finally.
-	v.finishArtifact(ctx, v.artifact)
-	r0 := v.saveArtifact(ctx, v.artifact)
-	r1 := v.deleteObject(ctx)
-	logger.Tf(ctx, "vod cleanup ok, r0=%v, r1=%v", r0, r1)
+// Query the media information of artifact, the task is done when the transcode of definition is ready.
+// See https://cloud.tencent.com/document/product/266/31763
+func (v *TencentVodProvider) Query(ctx context.Context, artifact *M3u8VoDArtifact) (*VodTaskArtifact, error) {
+	if artifact.Definition == 0 || artifact.FileID == "" {
+		return nil, nil
+	}
 
-	// Do final cleanup, because new messages might arrive while converting to mp4, which takes a long time.
-	files := v.copyMessages()
-	for _, file := range files {
-		r2 := os.Remove(file.TsFile.File)
-		logger.Tf(ctx, "drop %v r2=%v", file.String(), r2)
+	request := vod.NewDescribeMediaInfosRequest()
+	request.FileIds = common.StringPtrs([]string{artifact.FileID})
+	request.SubAppId = common.Uint64Ptr(v.worker.vodAppID)
+	request.Filters = common.StringPtrs([]string{"transcodeInfo"})
+
+	response, err := v.worker.vodClient.DescribeMediaInfosWithContext(ctx, request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "describe media info")
 	}
 
-	return nil
+	for _, mis := range response.Response.MediaInfoSet {
+		if mis == nil || mis.TranscodeInfo == nil {
+			continue
+		}
+
+		for _, ts := range mis.TranscodeInfo.TranscodeSet {
+			if ts.Definition == nil || uint64(*ts.Definition) != artifact.Definition {
+				continue
+			}
+
+			return &VodTaskArtifact{
+				URL:      *ts.Url,
+				Bitrate:  *ts.Bitrate,
+				Height:   int32(*ts.Height),
+				Width:    int32(*ts.Width),
+				Size:     *ts.Size,
+				Duration: *ts.Duration,
+				MD5:      *ts.Md5,
+			}, nil
+		}
+	}
+	return nil, nil
 }
@@ -0,0 +1,765 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// RecordTranscriptPolicy is the policy for the live transcript, when the record transcript is done.
+type RecordTranscriptPolicy string
+
+const (
+	// Keep the live transcript session, and serve the record transcript as a sidecar.
+	RecordTranscriptPolicySupplement RecordTranscriptPolicy = "supplement"
+	// Replace the segments of live transcript session of the record, by the record transcript.
+	RecordTranscriptPolicyReplace RecordTranscriptPolicy = "replace"
+)
+
+// RecordTranscriptStatus is the status of transcript job for a record artifact.
+type RecordTranscriptStatus string
+
+const (
+	// The job is requested by user, for the artifacts which are not transcribed automatically.
+	RecordTranscriptStatusPending    RecordTranscriptStatus = "pending"
+	RecordTranscriptStatusProcessing RecordTranscriptStatus = "processing"
+	RecordTranscriptStatusDone       RecordTranscriptStatus = "done"
+	RecordTranscriptStatusFailed     RecordTranscriptStatus = "failed"
+)
+
+// The files of record transcript, in the directory of artifact.
+const (
+	// The transcript segments in JSON.
+	recordTranscriptJSON = "transcript.json"
+	// The sidecar WebVTT of index.mp4, the cue time starts from 0.
+	recordTranscriptVTT = "transcript.vtt"
+	// The HLS master playlist with subtitles, and the subtitles playlist and WebVTT. The cue time of the
+	// WebVTT is the timestamp of ts files, like the live transcript.
+	recordTranscriptMaster       = "master.m3u8"
+	recordTranscriptSubtitles    = "subtitles.m3u8"
+	recordTranscriptSubtitlesVTT = "subtitles.vtt"
+)
+
+var recordTranscriptWorker *RecordTranscriptWorker
+
+// RecordTranscriptWorker re-transcribe the finished record artifacts in background, by a high accuracy model,
+// because the live transcript trades accuracy for latency.
+type RecordTranscriptWorker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRecordTranscriptWorker() *RecordTranscriptWorker {
+	return &RecordTranscriptWorker{}
+}
+
+func (v *RecordTranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/hooks/record/transcript/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config := NewRecordTranscriptConfig()
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "record transcript query ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/transcript/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			config := NewRecordTranscriptConfig()
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*RecordTranscriptConfig
+			}{
+				Token: &token, RecordTranscriptConfig: config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if config.Policy != RecordTranscriptPolicySupplement && config.Policy != RecordTranscriptPolicyReplace {
+				return errors.Errorf("invalid policy %v", config.Policy)
+			}
+			if config.ChunkDuration < 30 {
+				return errors.Errorf("invalid chunk duration %v", config.ChunkDuration)
+			}
+			if config.Overlap < 0 || config.Overlap*2 >= config.ChunkDuration {
+				return errors.Errorf("invalid overlap %v", config.Overlap)
+			}
+
+			if err := config.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save config")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "record transcript apply ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/hooks/record/transcript/start"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			// Mark the artifact as pending, the worker will transcribe it, even if the job is not enabled for
+			// all artifacts.
+			if err := updateRecordArtifact(ctx, uuid, func(artifact *M3u8VoDArtifact) error {
+				if artifact.Processing {
+					return errors.Errorf("artifact %v is processing", uuid)
+				}
+				if artifact.Transcript != nil && artifact.Transcript.Status == RecordTranscriptStatusProcessing {
+					return errors.Errorf("transcript of %v is processing", uuid)
+				}
+
+				artifact.Transcript = &M3u8VoDTranscript{
+					Status: RecordTranscriptStatusPending, Update: time.Now().Format(time.RFC3339),
+				}
+				return nil
+			}); err != nil {
+				return errors.Wrapf(err, "save artifact %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "record transcript start ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
+
+func (v *RecordTranscriptWorker) Close() error {
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.wg.Wait()
+	return nil
+}
+
+func (v *RecordTranscriptWorker) Start(ctx context.Context) error {
+	wg := &v.wg
+
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancel = cancel
+
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "record transcript start a worker")
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for ctx.Err() == nil {
+			var duration time.Duration
+			if transcribed, err := v.transcribeOne(ctx); err != nil {
+				logger.Wf(ctx, "record transcript: ignore err %+v", err)
+				duration = 30 * time.Second
+			} else if transcribed {
+				duration = 3 * time.Second
+			} else {
+				duration = 60 * time.Second
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(duration):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// transcribeOne pick a finished artifact and transcribe it, return true if an artifact is transcribed.
+func (v *RecordTranscriptWorker) transcribeOne(ctx context.Context) (bool, error) {
+	config := NewRecordTranscriptConfig()
+	if err := config.Load(ctx); err != nil {
+		return false, errors.Wrapf(err, "load config")
+	}
+
+	artifacts, err := rdb.HGetAll(ctx, SRS_RECORD_M3U8_ARTIFACT).Result()
+	if err != nil && err != redis.Nil {
+		return false, errors.Wrapf(err, "hgetall %v", SRS_RECORD_M3U8_ARTIFACT)
+	}
+
+	// Note that the processing status means the job is interrupted by restart, so we start it over.
+	var artifact *M3u8VoDArtifact
+	for _, value := range artifacts {
+		var metadata M3u8VoDArtifact
+		if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+			return false, errors.Wrapf(err, "unmarshal %v", value)
+		}
+
+		if metadata.Processing {
+			continue
+		}
+		// Never transcribe the artifact in archiving, because the file might be replaced.
+		if metadata.Archive != nil && metadata.Archive.Status == ArchiveStatusProcessing {
+			continue
+		}
+		if metadata.Transcript == nil && !config.All {
+			continue
+		}
+		if metadata.Transcript != nil && metadata.Transcript.Status != RecordTranscriptStatusPending &&
+			metadata.Transcript.Status != RecordTranscriptStatusProcessing {
+			continue
+		}
+
+		artifact = &metadata
+		break
+	}
+
+	if artifact == nil {
+		return false, nil
+	}
+
+	if err := v.transcribe(ctx, config, artifact); err != nil {
+		// Retry later if the artifact is archiving.
+		if errors.Cause(err) == errRecordArtifactBusy {
+			return false, nil
+		}
+
+		if transcript := artifact.Transcript; transcript != nil {
+			transcript.Status, transcript.Error = RecordTranscriptStatusFailed, err.Error()
+			transcript.Update = time.Now().Format(time.RFC3339)
+			if r0 := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+				a.Transcript = transcript
+				return nil
+			}); r0 != nil {
+				logger.Wf(ctx, "record transcript: ignore save %v err %+v", artifact.UUID, r0)
+			}
+		}
+		return true, errors.Wrapf(err, "transcribe %v", artifact.String())
+	}
+
+	return true, nil
+}
+
+func (v *RecordTranscriptWorker) transcribe(ctx context.Context, config *RecordTranscriptConfig, artifact *M3u8VoDArtifact) error {
+	starttime := time.Now()
+	asrConfig := config.asrProviderConfig()
+	transcript := &M3u8VoDTranscript{
+		Status: RecordTranscriptStatusProcessing, Policy: config.Policy, Provider: asrConfig.Provider,
+		Model: asrConfig.Model, Language: config.Language, Update: starttime.Format(time.RFC3339),
+	}
+	// Only update the transcript of artifact, never overwrite the fields of other workers.
+	saveTranscript := func() error {
+		return updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+			a.Transcript = transcript
+			return nil
+		})
+	}
+
+	// Start the job only if the artifact is not archiving, to serialize with the archive worker.
+	if err := updateRecordArtifact(ctx, artifact.UUID, func(a *M3u8VoDArtifact) error {
+		if a.Archive != nil && a.Archive.Status == ArchiveStatusProcessing {
+			return errRecordArtifactBusy
+		}
+		a.Transcript = transcript
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "save artifact")
+	}
+	artifact.Transcript = transcript
+
+	dir := path.Join("record", artifact.UUID)
+	mp4File := path.Join(dir, "index.mp4")
+	format, _, _, err := FFprobeFileFormat(ctx, mp4File)
+	if err != nil {
+		return errors.Wrapf(err, "probe %v", mp4File)
+	}
+
+	// Detect the silences, to cut the chunks at silence, so that we never cut a word.
+	args := []string{
+		"-i", mp4File, "-vn", "-af", fmt.Sprintf("silencedetect=noise=%v:d=%v", config.SilenceNoise, config.SilenceDuration),
+		"-f", "null", "-",
+	}
+	b, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "detect silence %v", args)
+	}
+	silences := parseSilenceDetect(string(b))
+
+	chunks := buildRecordTranscriptChunks(format.Duration, silences, config.ChunkDuration, config.Overlap)
+	artifact.Transcript.Chunks = len(chunks)
+	logger.Tf(ctx, "record transcript: start %v, duration=%v, silences=%v, chunks=%v, asr=<%v>",
+		artifact.UUID, format.Duration, len(silences), len(chunks), asrConfig)
+
+	var segments []*TranscriptSessionSegment
+	var previousText string
+	provider := NewASRProvider(asrConfig)
+	for index, chunk := range chunks {
+		// Transcode to audio only mp4, mono, 16000HZ, 32kbps, the same to live transcript.
+		audioFile := path.Join(dir, fmt.Sprintf("transcript-%v.m4a", index))
+		args := []string{
+			"-ss", fmt.Sprintf("%.3f", chunk.From), "-t", fmt.Sprintf("%.3f", chunk.To-chunk.From),
+			"-i", mp4File,
+			"-vn", "-acodec", "aac", "-ac", "1", "-ar", "16000", "-ab", "30k",
+			"-y", audioFile,
+		}
+		if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
+			return errors.Wrapf(err, "transcode %v", args)
+		}
+
+		// Use the tail of previous text as prompt, to keep the context between chunks.
+		resp, err := provider.Transcribe(ctx, audioFile, config.Language, recordTranscriptPromptTail(previousText))
+		os.Remove(audioFile)
+		if err != nil {
+			return errors.Wrapf(err, "transcribe chunk %v of %v", index, artifact.UUID)
+		}
+		previousText = resp.Text
+
+		var asrSegments []TranscriptAsrSegment
+		for _, s := range resp.Segments {
+			asrSegments = append(asrSegments, TranscriptAsrSegment{Start: s.Start, End: s.End, Text: s.Text})
+		}
+		segments = append(segments, mergeRecordTranscriptChunk(chunk, asrSegments)...)
+
+		artifact.Transcript.Progress = chunk.End / math.Max(format.Duration, 1) * 100
+		artifact.Transcript.Update = time.Now().Format(time.RFC3339)
+		if err := saveTranscript(); err != nil {
+			logger.Wf(ctx, "record transcript: ignore save %v err %+v", artifact.UUID, err)
+		}
+	}
+
+	// Fill the wallclock time of segments, by the program date time of ts files.
+	if start, _, ok := artifactTimeRange(artifact); ok {
+		for _, s := range segments {
+			s.Time = start.Add(time.Duration(s.Start * float64(time.Second))).Format(programDateTimeLayout)
+		}
+	}
+
+	// The start time of the first ts file, to align the WebVTT of HLS with the ts files.
+	if len(artifact.Files) > 0 {
+		if format, _, _, err := FFprobeFileFormat(ctx, artifact.Files[0].Key); err != nil {
+			logger.Wf(ctx, "record transcript: ignore probe %v err %+v", artifact.Files[0].Key, err)
+		} else if stv, err := strconv.ParseFloat(format.Starttime, 64); err == nil {
+			artifact.Transcript.StreamStarttime = stv
+		}
+	}
+
+	if b, err := json.Marshal(segments); err != nil {
+		return errors.Wrapf(err, "marshal segments")
+	} else if err := ioutil.WriteFile(path.Join(dir, recordTranscriptJSON), b, 0644); err != nil {
+		return errors.Wrapf(err, "write %v", recordTranscriptJSON)
+	}
+
+	_, vtt, err := (&TranscriptSession{Segments: segments}).exportAll("vtt")
+	if err != nil {
+		return errors.Wrapf(err, "export vtt")
+	}
+	if err := ioutil.WriteFile(path.Join(dir, recordTranscriptVTT), []byte(vtt), 0644); err != nil {
+		return errors.Wrapf(err, "write %v", recordTranscriptVTT)
+	}
+
+	if config.Policy == RecordTranscriptPolicyReplace {
+		if err := replaceTranscriptSessionsOfRecord(ctx, artifact, segments); err != nil {
+			return errors.Wrapf(err, "replace sessions")
+		}
+	}
+
+	artifact.Transcript.Status, artifact.Transcript.Progress = RecordTranscriptStatusDone, 100
+	artifact.Transcript.File, artifact.Transcript.Segments = recordTranscriptVTT, len(segments)
+	artifact.Transcript.Cost = time.Since(starttime).Seconds()
+	artifact.Transcript.Update = time.Now().Format(time.RFC3339)
+	if err := saveTranscript(); err != nil {
+		return errors.Wrapf(err, "save artifact")
+	}
+
+	logger.Tf(ctx, "record transcript: done %v, transcript=%v", artifact.UUID, artifact.Transcript.String())
+	return nil
+}
+
+// replaceTranscriptSessionsOfRecord replace the segments of live transcript sessions of the record.
+func replaceTranscriptSessionsOfRecord(ctx context.Context, artifact *M3u8VoDArtifact, segments []*TranscriptSessionSegment) error {
+	sessions, err := queryTranscriptSessions(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "query sessions")
+	}
+
+	recordStart, _, ok := artifactTimeRange(artifact)
+	for _, session := range sessions {
+		if session.RecordUUID != artifact.UUID {
+			continue
+		}
+
+		// The offset of session is relative to the start of session, which might not be the start of record.
+		var shift float64
+		if sessionStart, err := time.Parse(programDateTimeLayout, session.StartedAt); ok && err == nil {
+			shift = recordStart.Sub(sessionStart).Seconds()
+		}
+
+		var shifted []*TranscriptSessionSegment
+		for _, s := range segments {
+			shifted = append(shifted, &TranscriptSessionSegment{
+				Start: s.Start + shift, End: s.End + shift, Time: s.Time, Text: s.Text,
+			})
+		}
+		if len(shifted) > 0 {
+			session.Duration = shifted[len(shifted)-1].End
+		}
+		session.Update = time.Now().Format(time.RFC3339)
+
+		if err := session.replaceSegments(ctx, shifted); err != nil {
+			return errors.Wrapf(err, "save session %v", session.UUID)
+		}
+		logger.Tf(ctx, "record transcript: replace session %v of %v, segments=%v",
+			session.UUID, artifact.UUID, len(shifted))
+	}
+	return nil
+}
+
+// recordTranscriptPromptTail get the tail of text as the prompt of next chunk, because the prompt of
+// whisper is limited to 224 tokens.
+func recordTranscriptPromptTail(text string) string {
+	words := strings.Fields(text)
+	if len(words) > 64 {
+		words = words[len(words)-64:]
+	}
+	return strings.Join(words, " ")
+}
+
+// RecordTranscriptChunk is a chunk of record to transcribe.
+type RecordTranscriptChunk struct {
+	// The range of audio to transcribe in seconds, with overlap.
+	From float64
+	To   float64
+	// The range owned by this chunk in seconds, without overlap. The segments in the overlap are owned by
+	// the neighbour chunk.
+	Start float64
+	End   float64
+}
+
+// parseSilenceDetect parse the output of FFmpeg silencedetect filter, return the silence ranges in seconds.
+func parseSilenceDetect(output string) [][2]float64 {
+	reStart := regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	reEnd := regexp.MustCompile(`silence_end: (-?[0-9.]+)`)
+
+	var silences [][2]float64
+	start := -1.0
+	for _, line := range strings.Split(output, "\n") {
+		if m := reStart.FindStringSubmatch(line); len(m) == 2 {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				start = math.Max(v, 0)
+			}
+		} else if m := reEnd.FindStringSubmatch(line); len(m) == 2 && start >= 0 {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				silences = append(silences, [2]float64{start, v})
+			}
+			start = -1
+		}
+	}
+	return silences
+}
+
+// buildRecordTranscriptChunks split the duration to chunks of about chunkDuration seconds, cut at the middle
+// of the silence nearest to the end of chunk, and extend each chunk by overlap seconds.
+func buildRecordTranscriptChunks(duration float64, silences [][2]float64, chunkDuration, overlap float64) []*RecordTranscriptChunk {
+	var cuts []float64
+	for pos := 0.0; duration-pos > chunkDuration; {
+		// Cut at the target if no silence in the second half of chunk.
+		target, cut := pos+chunkDuration, pos+chunkDuration
+		var found bool
+		for _, silence := range silences {
+			middle := (silence[0] + silence[1]) / 2
+			if middle > pos+chunkDuration/2 && middle <= target && (!found || middle > cut) {
+				cut, found = middle, true
+			}
+		}
+		cuts = append(cuts, cut)
+		pos = cut
+	}
+
+	var chunks []*RecordTranscriptChunk
+	start := 0.0
+	for _, end := range append(cuts, duration) {
+		chunks = append(chunks, &RecordTranscriptChunk{
+			From: math.Max(start-overlap, 0), To: math.Min(end+overlap, duration), Start: start, End: end,
+		})
+		start = end
+	}
+	return chunks
+}
+
+// mergeRecordTranscriptChunk convert the ASR segments of chunk to the segments of record, drop the segments
+// which are not owned by the chunk, that is the middle of segment is in the overlap.
+func mergeRecordTranscriptChunk(chunk *RecordTranscriptChunk, segments []TranscriptAsrSegment) []*TranscriptSessionSegment {
+	var r []*TranscriptSessionSegment
+	for _, s := range segments {
+		start, end := chunk.From+s.Start, chunk.From+s.End
+		if middle := (start + end) / 2; middle < chunk.Start || middle >= chunk.End {
+			continue
+		}
+		if text := strings.TrimSpace(s.Text); text != "" {
+			r = append(r, &TranscriptSessionSegment{Start: start, End: end, Text: text})
+		}
+	}
+	return r
+}
+
+// isRecordTranscriptFile whether the file is served by the record transcript.
+func isRecordTranscriptFile(filename string) bool {
+	return slicesContains([]string{
+		recordTranscriptVTT, recordTranscriptMaster, recordTranscriptSubtitles, recordTranscriptSubtitlesVTT,
+	}, path.Base(filename))
+}
+
+// serveRecordTranscript serve the sidecar WebVTT, or the HLS with subtitles of record.
+func serveRecordTranscript(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	// Format is :uuid/transcript.vtt or :uuid/master.m3u8 or :uuid/subtitles.m3u8 or :uuid/subtitles.vtt
+	filename := r.URL.Path[len("/terraform/v1/hooks/record/hls/"):]
+	uuid, fileBase := path.Dir(filename), path.Base(filename)
+	if len(uuid) == 0 || uuid == "." {
+		return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
+	}
+
+	artifact, err := queryRecordArtifact(ctx, uuid)
+	if err != nil {
+		return errors.Wrapf(err, "query artifact %v", uuid)
+	}
+
+	transcript := artifact.Transcript
+	if transcript == nil || transcript.Status != RecordTranscriptStatusDone {
+		return errors.Errorf("no transcript of uuid=%v", uuid)
+	}
+
+	var contentType, body string
+	switch fileBase {
+	case recordTranscriptVTT:
+		f, err := os.Open(path.Join("record", uuid, recordTranscriptVTT))
+		if err != nil {
+			return errors.Wrapf(err, "open %v of %v", recordTranscriptVTT, uuid)
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "text/vtt")
+		io.Copy(w, f)
+		logger.Tf(ctx, "record serve transcript ok, uuid=%v", uuid)
+		return nil
+	case recordTranscriptMaster:
+		var duration float64
+		var size uint64
+		for _, file := range artifact.Files {
+			duration, size = duration+file.Duration, size+file.Size
+		}
+		bitrate := int64(float64(size*8) / math.Max(duration, 1))
+
+		subtitles := []*SubtitleRendition{{Language: ChooseNotEmpty(transcript.Language, "und"), URI: recordTranscriptSubtitles}}
+		if contentType, body, err = buildLiveM3u8ForVariantCC(ctx, bitrate, "index.m3u8", subtitles); err != nil {
+			return errors.Wrapf(err, "build master of %v", uuid)
+		}
+	case recordTranscriptSubtitles:
+		var duration float64
+		for _, file := range artifact.Files {
+			duration += file.Duration
+		}
+		contentType, body = "application/vnd.apple.mpegurl", strings.Join([]string{
+			"#EXTM3U",
+			"#EXT-X-VERSION:3",
+			"#EXT-X-PLAYLIST-TYPE:VOD",
+			fmt.Sprintf("#EXT-X-TARGETDURATION:%v", math.Ceil(duration)),
+			"#EXT-X-MEDIA-SEQUENCE:0",
+			fmt.Sprintf("#EXTINF:%.3f,", duration),
+			recordTranscriptSubtitlesVTT,
+			"#EXT-X-ENDLIST",
+		}, "\n")
+	case recordTranscriptSubtitlesVTT:
+		b, err := ioutil.ReadFile(path.Join("record", uuid, recordTranscriptJSON))
+		if err != nil {
+			return errors.Wrapf(err, "read %v of %v", recordTranscriptJSON, uuid)
+		}
+
+		var segments []*TranscriptSessionSegment
+		if err := json.Unmarshal(b, &segments); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", string(b))
+		}
+
+		// Shift the cue time to the timestamp of ts files, like the live transcript.
+		for _, s := range segments {
+			s.Start, s.End = s.Start+transcript.StreamStarttime, s.End+transcript.StreamStarttime
+		}
+		if contentType, body, err = (&TranscriptSession{Segments: segments}).exportAll("vtt"); err != nil {
+			return errors.Wrapf(err, "export vtt")
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(body))
+	logger.Tf(ctx, "record serve transcript %v ok, uuid=%v", fileBase, uuid)
+	return nil
+}
+
+// RecordTranscriptConfig is the config for the transcript of record.
+type RecordTranscriptConfig struct {
+	// Whether transcribe all the finished record artifacts.
+	All bool `json:"all"`
+	// The policy for the live transcript, supplement or replace.
+	Policy RecordTranscriptPolicy `json:"policy"`
+	// The AI service for OpenAI provider.
+	SecretKey    string `json:"secretKey"`
+	BaseURL      string `json:"baseURL"`
+	Organization string `json:"organization"`
+	// The ASR provider, model, temperature and URL, see ASRProviderConfig.
+	ASRProvider    string  `json:"asrProvider"`
+	ASRModel       string  `json:"asrModel"`
+	ASRTemperature float32 `json:"asrTemperature"`
+	ASRURL         string  `json:"asrURL"`
+	// The optional bearer token of whisper or http ASR provider, never use the secret key of AI service.
+	ASRToken string `json:"asrToken"`
+	// The context prompt, for example, the topic or vocabulary of the stream.
+	Prompt string `json:"prompt"`
+	// The language of record.
+	Language string `json:"lang"`
+	// The duration of chunk and the overlap between chunks, in seconds.
+	ChunkDuration float64 `json:"chunk"`
+	Overlap       float64 `json:"overlap"`
+	// The noise level and minimum duration in seconds to detect silence, for example, -30dB and 0.5.
+	SilenceNoise    string  `json:"silenceNoise"`
+	SilenceDuration float64 `json:"silenceDuration"`
+}
+
+func NewRecordTranscriptConfig() *RecordTranscriptConfig {
+	return &RecordTranscriptConfig{
+		Policy: RecordTranscriptPolicySupplement, ASRProvider: ASRProviderOpenAI, Language: "en",
+		ChunkDuration: 600, Overlap: 2, SilenceNoise: "-30dB", SilenceDuration: 0.5,
+	}
+}
+
+func (v RecordTranscriptConfig) String() string {
+	return fmt.Sprintf("all=%v, policy=%v, key=%vB, organization=%v, base=%v, asr=%v, model=%v, temperature=%v, url=%v, token=%vB, prompt=%vB, lang=%v, chunk=%v, overlap=%v, silence=%v/%v",
+		v.All, v.Policy, len(v.SecretKey), v.Organization, v.BaseURL, v.ASRProvider, v.ASRModel, v.ASRTemperature,
+		v.ASRURL, len(v.ASRToken), len(v.Prompt), v.Language, v.ChunkDuration, v.Overlap, v.SilenceNoise, v.SilenceDuration)
+}
+
+// Build the config of ASR provider, the secret key is only used for OpenAI provider.
+func (v *RecordTranscriptConfig) asrProviderConfig() *ASRProviderConfig {
+	aiConfig := openai.DefaultConfig(v.SecretKey)
+	aiConfig.BaseURL = v.BaseURL
+	aiConfig.OrgID = v.Organization
+
+	return &ASRProviderConfig{
+		Provider: v.ASRProvider, Model: v.ASRModel, Temperature: v.ASRTemperature,
+		Hints: v.Prompt, URL: v.ASRURL, Token: v.ASRToken, AI: aiConfig,
+	}
+}
+
+func (v *RecordTranscriptConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_RECORD_TRANSCRIPT, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_RECORD_TRANSCRIPT)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+func (v *RecordTranscriptConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal conf %v", v)
+	} else if err := rdb.HSet(ctx, SRS_RECORD_TRANSCRIPT, "global", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v global %v", SRS_RECORD_TRANSCRIPT, string(b))
+	}
+	return nil
+}
+
+// M3u8VoDTranscript is the transcript job and result of a record artifact.
+type M3u8VoDTranscript struct {
+	// The status of transcript job.
+	Status RecordTranscriptStatus `json:"status"`
+	// The progress in percent.
+	Progress float64 `json:"progress"`
+	// The policy and ASR to transcribe the file.
+	Policy   RecordTranscriptPolicy `json:"policy,omitempty"`
+	Provider string                 `json:"provider,omitempty"`
+	Model    string                 `json:"model,omitempty"`
+	Language string                 `json:"lang,omitempty"`
+	// The number of chunks and transcript segments.
+	Chunks   int `json:"chunks"`
+	Segments int `json:"segments"`
+	// The sidecar WebVTT in the directory of artifact.
+	File string `json:"file,omitempty"`
+	// The start time in seconds of the first ts file, to align the WebVTT with the HLS.
+	StreamStarttime float64 `json:"sst,omitempty"`
+	// The cost in seconds to transcribe the file.
+	Cost float64 `json:"cost"`
+	// The error message if failed.
+	Error string `json:"error,omitempty"`
+	// The last update time.
+	Update string `json:"update"`
+}
+
+func (v *M3u8VoDTranscript) String() string {
+	return fmt.Sprintf("status=%v, progress=%.1f, policy=%v, provider=%v, model=%v, lang=%v, chunks=%v, segments=%v, file=%v, sst=%v, cost=%.1f",
+		v.Status, v.Progress, v.Policy, v.Provider, v.Model, v.Language, v.Chunks, v.Segments, v.File,
+		v.StreamStarttime, v.Cost)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecordTranscript_Chunks(t *testing.T) {
+	silences := parseSilenceDetect(strings.Join([]string{
+		"[silencedetect @ 0x7f8] silence_start: 250.5",
+		"[silencedetect @ 0x7f8] silence_end: 251.5 | silence_duration: 1",
+		"[silencedetect @ 0x7f8] silence_start: 520",
+		"[silencedetect @ 0x7f8] silence_end: 522 | silence_duration: 2",
+	}, "\n"))
+	if len(silences) != 2 || silences[0][0] != 250.5 || silences[1][1] != 522 {
+		t.Errorf("invalid silences %v", silences)
+	}
+
+	chunks := buildRecordTranscriptChunks(700, silences, 300, 2)
+	if len(chunks) != 3 {
+		t.Fatalf("invalid chunks %v", len(chunks))
+	}
+	if c := chunks[0]; c.From != 0 || c.Start != 0 || c.End != 251 || c.To != 253 {
+		t.Errorf("invalid chunk %v", *c)
+	}
+	if c := chunks[1]; c.From != 249 || c.Start != 251 || c.End != 521 || c.To != 523 {
+		t.Errorf("invalid chunk %v", *c)
+	}
+	if c := chunks[2]; c.Start != 521 || c.End != 700 || c.To != 700 {
+		t.Errorf("invalid chunk %v", *c)
+	}
+
+	// The segment in the overlap is owned by the previous chunk.
+	segments := mergeRecordTranscriptChunk(chunks[1], []TranscriptAsrSegment{
+		{Start: 0, End: 1.5, Text: "overlap"}, {Start: 3, End: 5, Text: " hello "},
+	})
+	if len(segments) != 1 || segments[0].Text != "hello" || segments[0].Start != 252 {
+		t.Errorf("invalid segments %v", segments)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// The Tencent Cloud VoD, upload each ts file to COS of VoD, then commit and remux it.
+	VodProviderTencent = "tencent"
+	// The generic HTTP VoD, upload the finished recording to an endpoint, then poll the status URL.
+	VodProviderHTTP = "http"
+)
+
+// VodProvider is a VoD platform, to save the ts files of recording, and upload the recording when finished,
+// then track its processing task.
+type VodProvider interface {
+	// Ready whether the provider is ready to record, for example, the credentials are set.
+	Ready() bool
+	// Prepare the recording stream before serving the ts files, for example, refresh the upload token.
+	Prepare(ctx context.Context, stream *VodM3u8Stream) error
+	// Serve a ts file of the recording stream, for example, upload it to VoD or save it locally.
+	Serve(ctx context.Context, stream *VodM3u8Stream, msg *SrsOnHlsObject) error
+	// Finish the recording stream, commit it to the VoD platform and start the processing task if any.
+	Finish(ctx context.Context, stream *VodM3u8Stream) error
+	// Query the processing task of artifact, return nil if the task is not done. Note that the failed task
+	// is returned with the error, so it's never queried again.
+	Query(ctx context.Context, artifact *M3u8VoDArtifact) (*VodTaskArtifact, error)
+}
+
+// VodProviderConfig is the config of VoD provider, saved in SRS_VOD_PATTERNS.
+type VodProviderConfig struct {
+	// The provider, tencent or http. Default to tencent.
+	Provider string `json:"provider"`
+	// For HTTP provider, the URL to upload the file by multipart POST, with fields file, uuid, app, stream,
+	// and the response is a JSON object with fileId, mediaUrl and optional taskId.
+	UploadURL string `json:"uploadUrl"`
+	// For HTTP provider, the URL to poll the status of task, the {taskId} and {fileId} will be replaced. The
+	// response is a JSON object with status(processing, done or failed), url, width, height, bitrate, size,
+	// duration, md5 and error. Empty to disable polling.
+	StatusURL string `json:"statusUrl"`
+	// For HTTP provider, the optional bearer token for authorization.
+	Token string `json:"token"`
+}
+
+func NewVodProviderConfig() *VodProviderConfig {
+	return &VodProviderConfig{Provider: VodProviderTencent}
+}
+
+func (v VodProviderConfig) String() string {
+	return fmt.Sprintf("provider=%v, upload=%v, status=%v, token=%vB",
+		v.Provider, v.UploadURL, v.StatusURL, len(v.Token))
+}
+
+func (v *VodProviderConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_VOD_PATTERNS, "provider").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v provider", SRS_VOD_PATTERNS)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+func (v *VodProviderConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal %v", v)
+	} else if err := rdb.HSet(ctx, SRS_VOD_PATTERNS, "provider", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v provider %v", SRS_VOD_PATTERNS, string(b))
+	}
+	return nil
+}
+
+// The max number of uploads of HTTP VoD provider, the artifact is marked failed if exceeds.
+const maxHttpVodUploads = 10
+
+// HttpVodProvider is a generic HTTP VoD platform, for example, an in-house media library. The ts files are
+// saved locally, and converted to mp4 to upload when finished.
+type HttpVodProvider struct {
+	config VodProviderConfig
+}
+
+func NewHttpVodProvider(config *VodProviderConfig) *HttpVodProvider {
+	return &HttpVodProvider{config: *config}
+}
+
+func (v *HttpVodProvider) Ready() bool {
+	return v.config.UploadURL != ""
+}
+
+func (v *HttpVodProvider) Prepare(ctx context.Context, stream *VodM3u8Stream) error {
+	return nil
+}
+
+func (v *HttpVodProvider) Serve(ctx context.Context, stream *VodM3u8Stream, msg *SrsOnHlsObject) error {
+	// Ignore file if not exists.
+	if _, err := os.Stat(msg.TsFile.File); err != nil {
+		return err
+	}
+
+	tsDir := path.Join("vod", stream.UUID)
+	key := path.Join(tsDir, fmt.Sprintf("%v.ts", msg.TsFile.TsID))
+	msg.TsFile.Key = key
+
+	if err := os.MkdirAll(tsDir, 0755); err != nil {
+		return errors.Wrapf(err, "mkdir %v", tsDir)
+	}
+
+	// Link the file, because the message will remove the original file.
+	if err := os.Link(msg.TsFile.File, key); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "link %v to %v", msg.TsFile.File, key)
+	}
+
+	// Update the metadata for m3u8.
+	stream.updateArtifact(ctx, stream.artifact, msg)
+	if err := stream.saveArtifact(ctx, stream.artifact); err != nil {
+		return errors.Wrapf(err, "save artifact %v", stream.artifact.String())
+	}
+
+	logger.Tf(ctx, "vod consume msg %v", msg.String())
+	return nil
+}
+
+// Finish covert the local ts files to mp4, and upload it. The mp4 is reused when retry to upload, and the
+// artifact is marked failed after maxHttpVodUploads uploads, while the mp4 is kept for manual recovery.
+func (v *HttpVodProvider) Finish(ctx context.Context, stream *VodM3u8Stream) error {
+	if !v.Ready() {
+		return errors.Errorf("no upload url of %v", v.config.String())
+	}
+
+	tsDir := path.Join("vod", stream.UUID)
+	mp4 := path.Join(tsDir, "index.mp4")
+	if _, err := os.Stat(mp4); err != nil {
+		contentType, m3u8Body, duration, err := buildVodM3u8ForLocal(ctx, stream.artifact.Files, false, "")
+		if err != nil {
+			return errors.Wrapf(err, "build vod")
+		}
+
+		hls := path.Join(tsDir, "index.m3u8")
+		if err := ioutil.WriteFile(hls, []byte(m3u8Body), 0644); err != nil {
+			return errors.Wrapf(err, "write hls %v", hls)
+		}
+		logger.Tf(ctx, "vod to %v ok, type=%v, duration=%v", hls, contentType, duration)
+
+		// Covert to a temporary file then rename it, so the mp4 is always complete when exists.
+		tmpFile := path.Join(tsDir, "index.tmp.mp4")
+		if b, err := exec.CommandContext(ctx, "ffmpeg", "-i", hls, "-c", "copy", "-y", tmpFile).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "covert to mp4 %v err %v", tmpFile, string(b))
+		}
+		if err := os.Rename(tmpFile, mp4); err != nil {
+			return errors.Wrapf(err, "rename %v to %v", tmpFile, mp4)
+		}
+	}
+
+	fileID, mediaURL, taskID, err := v.Upload(ctx, stream.artifact, mp4)
+	if err != nil {
+		retries := stream.vodRetry(ctx)
+		if retries < maxHttpVodUploads {
+			if err := stream.saveObject(ctx); err != nil {
+				logger.Wf(ctx, "vod ignore save object %v err %+v", stream.String(), err)
+			}
+			return errors.Wrapf(err, "upload %v, retries=%v", mp4, retries)
+		}
+
+		// Give up and keep the mp4, because the upload always fails.
+		stream.vodFailed(ctx, stream.artifact, err)
+		stream.finish(ctx)
+		logger.Wf(ctx, "vod upload %v failed, retries=%v, keep it, err %+v", mp4, retries, err)
+		return nil
+	}
+	stream.vodCommit(ctx, stream.artifact, fileID, mediaURL)
+	stream.vodRemux(ctx, stream.artifact, 0, taskID)
+	logger.Tf(ctx, "vod upload %v ok, fileID=%v, mediaURL=%v, taskID=%v", mp4, fileID, mediaURL, taskID)
+
+	// The local files are useless, because the recording is uploaded to VoD.
+	stream.finish(ctx)
+	r0 := os.RemoveAll(tsDir)
+	logger.Tf(ctx, "vod remove %v r0=%v", tsDir, r0)
+
+	return nil
+}
+
+func (v *HttpVodProvider) Upload(ctx context.Context, artifact *M3u8VoDArtifact, file string) (fileID, mediaURL, taskID string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", "", "", errors.Wrapf(err, "open %v", file)
+	}
+	defer f.Close()
+
+	fields := map[string]string{
+		"uuid": artifact.UUID, "app": artifact.App, "stream": artifact.Stream,
+	}
+	name := fmt.Sprintf("%v%v", artifact.UUID, path.Ext(file))
+	req, err := httpNewMultipartRequest(ctx, v.config.UploadURL, fields, name, f)
+	if err != nil {
+		return "", "", "", errors.Wrapf(err, "new request")
+	}
+
+	var res struct {
+		FileID   string `json:"fileId"`
+		MediaURL string `json:"mediaUrl"`
+		TaskID   string `json:"taskId"`
+	}
+	if err := v.do(req, &res); err != nil {
+		return "", "", "", errors.Wrapf(err, "upload %v to %v", file, v.config.UploadURL)
+	}
+
+	if res.FileID == "" {
+		return "", "", "", errors.Errorf("no fileId of %v", v.config.UploadURL)
+	}
+	return res.FileID, res.MediaURL, res.TaskID, nil
+}
+
+func (v *HttpVodProvider) Query(ctx context.Context, artifact *M3u8VoDArtifact) (*VodTaskArtifact, error) {
+	// Never poll the status if disabled.
+	if v.config.StatusURL == "" {
+		return nil, nil
+	}
+
+	statusURL := strings.ReplaceAll(v.config.StatusURL, "{taskId}", artifact.TaskID)
+	statusURL = strings.ReplaceAll(statusURL, "{fileId}", artifact.FileID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request")
+	}
+
+	var res struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		VodTaskArtifact
+	}
+	if err := v.do(req, &res); err != nil {
+		return nil, errors.Wrapf(err, "query %v", statusURL)
+	}
+
+	switch res.Status {
+	case "done":
+		return &res.VodTaskArtifact, nil
+	case "failed":
+		return &VodTaskArtifact{Error: ChooseNotEmpty(res.Error, "failed")}, nil
+	}
+	return nil, nil
+}
+
+func (v *HttpVodProvider) do(req *http.Request, res interface{}) error {
+	if v.config.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.config.Token))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request")
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read body")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("status %v, body %v", resp.StatusCode, string(b))
+	}
+
+	if err := json.Unmarshal(b, res); err != nil {
+		return errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	return nil
+}
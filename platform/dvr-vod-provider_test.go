@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestVodProvider_Http(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/upload" {
+			file, _, err := r.FormFile("file")
+			if err != nil || r.FormValue("uuid") != "uuid-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+
+			b, _ := ioutil.ReadAll(file)
+			w.Write([]byte(fmt.Sprintf(`{"fileId":"file-%v","mediaUrl":"https://media/1.mp4","taskId":"task-1"}`, len(b))))
+			return
+		}
+
+		if r.URL.Path == "/status/task-1" {
+			w.Write([]byte(`{"status":"done","url":"https://media/1-720p.mp4","width":1280,"height":720}`))
+			return
+		}
+		if r.URL.Path == "/status/task-3" {
+			w.Write([]byte(`{"status":"failed","error":"bad format"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"processing"}`))
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "vod-*.mp4")
+	if err != nil {
+		t.Errorf("create temp file err %+v", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("hello"))
+	f.Close()
+
+	provider := NewHttpVodProvider(&VodProviderConfig{
+		Provider: VodProviderHTTP, UploadURL: server.URL + "/upload",
+		StatusURL: server.URL + "/status/{taskId}", Token: "secret",
+	})
+	artifact := &M3u8VoDArtifact{UUID: "uuid-1", App: "live", Stream: "livestream"}
+	if fileID, mediaURL, taskID, err := provider.Upload(context.Background(), artifact, f.Name()); err != nil {
+		t.Errorf("upload err %+v", err)
+		return
+	} else if fileID != "file-5" || mediaURL != "https://media/1.mp4" || taskID != "task-1" {
+		t.Errorf("upload failed, fileID=%v, mediaURL=%v, taskID=%v", fileID, mediaURL, taskID)
+		return
+	} else {
+		artifact.FileID, artifact.TaskID = fileID, taskID
+	}
+
+	if task, err := provider.Query(context.Background(), artifact); err != nil {
+		t.Errorf("query err %+v", err)
+	} else if task == nil || task.URL != "https://media/1-720p.mp4" || task.Width != 1280 {
+		t.Errorf("query failed, task=%v", task)
+	}
+
+	artifact.TaskID = "task-2"
+	if task, err := provider.Query(context.Background(), artifact); err != nil || task != nil {
+		t.Errorf("query processing failed, task=%v, err=%v", task, err)
+	}
+
+	// The failed task is returned with error, so it's never queried again.
+	artifact.TaskID = "task-3"
+	if task, err := provider.Query(context.Background(), artifact); err != nil || task == nil || task.Error != "bad format" {
+		t.Errorf("query failed task failed, task=%v, err=%v", task, err)
+	}
+
+	// Never poll if no status URL.
+	provider.config.StatusURL = ""
+	if task, err := provider.Query(context.Background(), artifact); err != nil || task != nil {
+		t.Errorf("query without status url failed, task=%v, err=%v", task, err)
+	}
+}
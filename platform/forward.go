@@ -364,11 +364,14 @@ type ForwardConfigure struct {
 	Customed bool `json:"custom"`
 	// The label for this configure.
 	Label string `json:"label"`
+	// Whether forward with the captions of transcript embedded, note that the stream is delayed by the
+	// overlay of transcript.
+	Captions bool `json:"captions"`
 }
 
 func (v *ForwardConfigure) String() string {
-	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v",
-		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label,
+	return fmt.Sprintf("platform=%v, stream=%v, server=%v, secret=%v, enabled=%v, customed=%v, label=%v, captions=%v",
+		v.Platform, v.Stream, v.Server, v.Secret, v.Enabled, v.Customed, v.Label, v.Captions,
 	)
 }
 
@@ -380,6 +383,7 @@ func (v *ForwardConfigure) Update(u *ForwardConfigure) error {
 	v.Label = u.Label
 	v.Enabled = u.Enabled
 	v.Customed = u.Customed
+	v.Captions = u.Captions
 	return nil
 }
 
@@ -626,6 +630,12 @@ func (v *ForwardTask) doForward(ctx context.Context, input *SrsStream) error {
 	// Build input URL.
 	host := "localhost"
 	inputURL := fmt.Sprintf("rtmp://%v/%v/%v", host, input.App, input.Stream)
+	// Forward the stream with embedded captions, if enabled by forward and transcript is enabled for it.
+	if v.config.Captions {
+		if captionURL := transcriptWorker.captionInputURL(input); captionURL != "" {
+			inputURL = captionURL
+		}
+	}
 
 	// Build output URL.
 	outputServer := strings.ReplaceAll(v.config.Server, "localhost", host)
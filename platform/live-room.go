@@ -12,6 +12,7 @@ import (
 	"github.com/ossrs/go-oryx-lib/errors"
 	ohttp "github.com/ossrs/go-oryx-lib/http"
 	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
 	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
 	"github.com/go-redis/redis/v8"
 )
@@ -117,6 +118,26 @@ func handleLiveRoomService(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if room.AITTSEnabled {
+				if err := room.TTSProviderConfig().Validate(); err != nil {
+					return errors.Wrapf(err, "validate tts")
+				}
+			}
+			if err := room.VADConfig().Validate(); err != nil {
+				return errors.Wrapf(err, "validate vad")
+			}
+			if err := room.SrsAssistantMix.Validate(); err != nil {
+				return errors.Wrapf(err, "validate mix")
+			}
+			if err := room.SrsAssistantTools.Validate(); err != nil {
+				return errors.Wrapf(err, "validate tools")
+			}
+			for _, memory := range []string{room.AIChatMemory, room.AIPostMemory} {
+				if memory != "" && memory != StageMemoryWindow && memory != StageMemorySummary {
+					return errors.Errorf("invalid memory %v", memory)
+				}
+			}
+
 			// As room is a template config, to create active stage. So if we update the template, we
 			// need to update the active stage object.
 			if err := room.UpdateStage(ctx); err != nil {
@@ -319,11 +340,25 @@ type SrsAssistantASR struct {
 	AIASRLanguage string `json:"aiAsrLanguage"`
 	// The AI asr prompt type. user or user-ai.
 	AIASRPrompt string `json:"aiAsrPrompt"`
+	// The ASR provider, openai, whisper or http. Default to openai.
+	AIASRProvider string `json:"aiAsrProvider"`
+	// The ASR model, default to whisper-1 for OpenAI.
+	AIASRModel string `json:"aiAsrModel"`
+	// The ASR sampling temperature, zero for provider default.
+	AIASRTemperature float32 `json:"aiAsrTemperature"`
+	// The ASR prompt hints, for example, the vocabulary or context.
+	AIASRHints string `json:"aiAsrHints"`
+	// The URL of whisper or http ASR provider.
+	AIASRURL string `json:"aiAsrURL"`
+	// The optional bearer token of whisper or http ASR provider. Note that the AI secret key is never sent to
+	// these providers, which might be third-party servers.
+	AIASRToken string `json:"aiAsrToken"`
 }
 
 func (v *SrsAssistantASR) String() string {
-	return fmt.Sprintf("enabled=%v,language=%v,prompt=%v",
-		v.AIASREnabled, v.AIASRLanguage, v.AIASRPrompt)
+	return fmt.Sprintf("enabled=%v,language=%v,prompt=%v,provider=%v,model=%v,temperature=%v,hints=%vB,url=%v,token=%vB",
+		v.AIASREnabled, v.AIASRLanguage, v.AIASRPrompt, v.AIASRProvider, v.AIASRModel, v.AIASRTemperature,
+		len(v.AIASRHints), v.AIASRURL, len(v.AIASRToken))
 }
 
 type SrsAssistantChat struct {
@@ -337,11 +372,18 @@ type SrsAssistantChat struct {
 	AIChatMaxWindow int `json:"aiChatMaxWindow"`
 	// The AI chat max words.
 	AIChatMaxWords int `json:"aiChatMaxWords"`
+	// The memory strategy for the turns out of window, window to drop or summary to summarize. Default to window.
+	AIChatMemory string `json:"aiChatMemory"`
+	// The model to summarize, default to the chat model.
+	AIChatSummaryModel string `json:"aiChatSummaryModel"`
+	// The max words of summary, zero for default 200.
+	AIChatSummaryWords int `json:"aiChatSummaryWords"`
 }
 
 func (v *SrsAssistantChat) String() string {
-	return fmt.Sprintf("enabled=%v,model=%v,prompt=%v,window=%v,words=%v",
-		v.AIChatEnabled, v.AIChatModel, v.AIChatPrompt, v.AIChatMaxWindow, v.AIChatMaxWords)
+	return fmt.Sprintf("enabled=%v,model=%v,prompt=%v,window=%v,words=%v,memory=%v,summaryModel=%v,summaryWords=%v",
+		v.AIChatEnabled, v.AIChatModel, v.AIChatPrompt, v.AIChatMaxWindow, v.AIChatMaxWords, v.AIChatMemory,
+		v.AIChatSummaryModel, v.AIChatSummaryWords)
 }
 
 type SrsAssistantPost struct {
@@ -355,20 +397,143 @@ type SrsAssistantPost struct {
 	AIPostMaxWindow int `json:"aiPostMaxWindow"`
 	// The AI chat max words.
 	AIPostMaxWords int `json:"aiPostMaxWords"`
+	// The memory strategy for the turns out of window, window to drop or summary to summarize. Default to window.
+	AIPostMemory string `json:"aiPostMemory"`
+	// The model to summarize, default to the post model.
+	AIPostSummaryModel string `json:"aiPostSummaryModel"`
+	// The max words of summary, zero for default 200.
+	AIPostSummaryWords int `json:"aiPostSummaryWords"`
 }
 
 func (v *SrsAssistantPost) String() string {
-	return fmt.Sprintf("enabled=%v,model=%v,prompt=%v,window=%v,words=%v",
-		v.AIPostEnabled, v.AIPostModel, v.AIPostPrompt, v.AIPostMaxWindow, v.AIPostMaxWords)
+	return fmt.Sprintf("enabled=%v,model=%v,prompt=%v,window=%v,words=%v,memory=%v,summaryModel=%v,summaryWords=%v",
+		v.AIPostEnabled, v.AIPostModel, v.AIPostPrompt, v.AIPostMaxWindow, v.AIPostMaxWords, v.AIPostMemory,
+		v.AIPostSummaryModel, v.AIPostSummaryWords)
 }
 
 type SrsAssistantTTS struct {
 	// Whether enable the AI TTS.
 	AITTSEnabled bool `json:"aiTtsEnabled"`
+	// The TTS provider, openai, http, piper or espeak. Default to openai.
+	AITTSProvider string `json:"aiTtsProvider"`
+	// The TTS model, default to tts-1 for OpenAI, or the voice model file for piper.
+	AITTSModel string `json:"aiTtsModel"`
+	// The TTS voice, default to nova for OpenAI.
+	AITTSVoice string `json:"aiTtsVoice"`
+	// The TTS speed, between 0.25 and 4.0, zero for provider default.
+	AITTSSpeed float64 `json:"aiTtsSpeed"`
+	// The TTS audio format, default to aac for OpenAI.
+	AITTSFormat string `json:"aiTtsFormat"`
+	// The TTS language, default to the ASR language.
+	AITTSLanguage string `json:"aiTtsLanguage"`
+	// The URL of http TTS provider.
+	AITTSURL string `json:"aiTtsURL"`
+	// The optional bearer token of http TTS provider. Note that the AI secret key is never sent to the
+	// provider, which might be a third-party server.
+	AITTSToken string `json:"aiTtsToken"`
 }
 
 func (v *SrsAssistantTTS) String() string {
-	return fmt.Sprintf("enabled=%v", v.AITTSEnabled)
+	return fmt.Sprintf("enabled=%v,provider=%v,model=%v,voice=%v,speed=%v,format=%v,language=%v,url=%v,token=%vB",
+		v.AITTSEnabled, v.AITTSProvider, v.AITTSModel, v.AITTSVoice, v.AITTSSpeed, v.AITTSFormat,
+		v.AITTSLanguage, v.AITTSURL, len(v.AITTSToken))
+}
+
+type SrsAssistantVAD struct {
+	// Whether listen to the audio of room stream, to segment to utterances by VAD and ask the assistant.
+	AIVADListen bool `json:"aiVadListen"`
+	// The threshold of speech in dBFS, default to -40.
+	AIVADThreshold float64 `json:"aiVadThreshold"`
+	// The silence in ms to end an utterance, default to 800.
+	AIVADSilence int `json:"aiVadSilence"`
+	// The speech in ms to start an utterance, default to 200.
+	AIVADMinSpeech int `json:"aiVadMinSpeech"`
+	// The max duration in ms of an utterance, default to 30000.
+	AIVADMaxSpeech int `json:"aiVadMaxSpeech"`
+}
+
+func (v *SrsAssistantVAD) String() string {
+	return fmt.Sprintf("listen=%v,threshold=%v,silence=%v,minSpeech=%v,maxSpeech=%v",
+		v.AIVADListen, v.AIVADThreshold, v.AIVADSilence, v.AIVADMinSpeech, v.AIVADMaxSpeech)
+}
+
+type SrsAssistantMix struct {
+	// Whether mix the TTS of assistant into the room stream, to publish a new stream for viewers.
+	AIMixEnabled bool `json:"aiMixEnabled"`
+	// The output stream name, default to the room stream with suffix _ai.
+	AIMixStream string `json:"aiMixStream"`
+	// The volume of host when assistant speaks, in [0, 1], zero for default 0.3.
+	AIMixDucking float64 `json:"aiMixDucking"`
+	// Whether draw the subtitle of answer on video, which transcodes the video.
+	AIMixSubtitle bool `json:"aiMixSubtitle"`
+}
+
+func (v *SrsAssistantMix) String() string {
+	return fmt.Sprintf("enabled=%v,stream=%v,ducking=%v,subtitle=%v",
+		v.AIMixEnabled, v.AIMixStream, v.AIMixDucking, v.AIMixSubtitle)
+}
+
+func (v *SrsAssistantMix) Validate() error {
+	if v.AIMixDucking < 0 || v.AIMixDucking > 1 {
+		return errors.Errorf("invalid ducking %v, should in [0, 1]", v.AIMixDucking)
+	}
+	if v.AIMixStream != "" && strings.ContainsAny(v.AIMixStream, "/?&# ") {
+		return errors.Errorf("invalid stream %v", v.AIMixStream)
+	}
+	return nil
+}
+
+// SrsAssistantHTTPTool is a user defined tool, the arguments in JSON is POST to the URL, and the response
+// body is the result for AI.
+type SrsAssistantHTTPTool struct {
+	// The tool name, letters, digits, underscore or dash.
+	Name string `json:"name"`
+	// The description for AI to know when to use the tool.
+	Description string `json:"description"`
+	// The URL to POST the arguments.
+	URL string `json:"url"`
+	// The optional bearer token for authorization.
+	Token string `json:"token,omitempty"`
+	// The JSON schema of arguments, default to an object without properties.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+type SrsAssistantTools struct {
+	// The builtin tools permitted for this room, viewers, record, ocr or transcript.
+	AITools []string `json:"aiTools,omitempty"`
+	// The user defined HTTP tools.
+	AIHTTPTools []*SrsAssistantHTTPTool `json:"aiHttpTools,omitempty"`
+}
+
+func (v *SrsAssistantTools) String() string {
+	return fmt.Sprintf("tools=%v,http=%v", strings.Join(v.AITools, ","), len(v.AIHTTPTools))
+}
+
+func (v *SrsAssistantTools) Validate() error {
+	for _, tool := range v.AITools {
+		if !slicesContains(stageBuiltinTools, tool) {
+			return errors.Errorf("invalid tool %v, should be %v", tool, strings.Join(stageBuiltinTools, ","))
+		}
+	}
+
+	names := map[string]bool{}
+	for _, tool := range v.AIHTTPTools {
+		if !stageToolNamePattern.MatchString(tool.Name) {
+			return errors.Errorf("invalid http tool name %v", tool.Name)
+		}
+		if names[tool.Name] || stageBuiltinToolName(tool.Name) {
+			return errors.Errorf("duplicated http tool name %v", tool.Name)
+		}
+		names[tool.Name] = true
+
+		if !strings.HasPrefix(tool.URL, "http://") && !strings.HasPrefix(tool.URL, "https://") {
+			return errors.Errorf("invalid url %v of http tool %v", tool.URL, tool.Name)
+		}
+		if len(tool.Parameters) > 0 && !json.Valid(tool.Parameters) {
+			return errors.Errorf("invalid parameters of http tool %v", tool.Name)
+		}
+	}
+	return nil
 }
 
 type SrsAssistant struct {
@@ -386,6 +551,12 @@ type SrsAssistant struct {
 	SrsAssistantPost
 	// The AI assistant TTS.
 	SrsAssistantTTS
+	// The AI assistant VAD.
+	SrsAssistantVAD
+	// The AI assistant mixing into stream.
+	SrsAssistantMix
+	// The AI assistant tools.
+	SrsAssistantTools
 }
 
 func NewAssistant(opts ...func(*SrsAssistant)) *SrsAssistant {
@@ -403,9 +574,43 @@ func NewAssistant(opts ...func(*SrsAssistant)) *SrsAssistant {
 	return v
 }
 
+// ASRProviderConfig build the config of ASR provider, the secret key is only used for OpenAI provider.
+func (v *SrsAssistant) ASRProviderConfig() *ASRProviderConfig {
+	aiConfig := openai.DefaultConfig(v.AISecretKey)
+	aiConfig.OrgID = v.AIOrganization
+	aiConfig.BaseURL = v.AIBaseURL
+
+	return &ASRProviderConfig{
+		Provider: v.AIASRProvider, Model: v.AIASRModel, Temperature: v.AIASRTemperature,
+		Hints: v.AIASRHints, URL: v.AIASRURL, Token: v.AIASRToken, AI: aiConfig,
+	}
+}
+
+// TTSProviderConfig build the config of TTS provider, the secret key is only used for OpenAI provider.
+func (v *SrsAssistant) TTSProviderConfig() *TTSProviderConfig {
+	aiConfig := openai.DefaultConfig(v.AISecretKey)
+	aiConfig.OrgID = v.AIOrganization
+	aiConfig.BaseURL = v.AIBaseURL
+
+	return &TTSProviderConfig{
+		Provider: v.AITTSProvider, Model: v.AITTSModel, Voice: v.AITTSVoice, Speed: v.AITTSSpeed,
+		Format: v.AITTSFormat, Language: ChooseNotEmpty(v.AITTSLanguage, v.AIASRLanguage),
+		URL: v.AITTSURL, Token: v.AITTSToken, AI: aiConfig,
+	}
+}
+
+// VADConfig build the config of VAD, to listen to the room stream.
+func (v *SrsAssistant) VADConfig() *VADConfig {
+	return &VADConfig{
+		Threshold: v.AIVADThreshold, Silence: v.AIVADSilence, MinSpeech: v.AIVADMinSpeech,
+		MaxSpeech: v.AIVADMaxSpeech,
+	}
+}
+
 func (v *SrsAssistant) String() string {
-	return fmt.Sprintf("assistant=%v, name=%v, provider=<%v>, asr=<%v>, chat=<%v>, post=<%v>, tts=<%v>",
+	return fmt.Sprintf("assistant=%v, name=%v, provider=<%v>, asr=<%v>, chat=<%v>, post=<%v>, tts=<%v>, vad=<%v>, mix=<%v>, tools=<%v>",
 		v.Assistant, v.AIName, v.SrsAssistantProvider.String(), v.SrsAssistantASR.String(), v.SrsAssistantChat.String(),
-		v.SrsAssistantPost.String(), v.SrsAssistantTTS.String(),
+		v.SrsAssistantPost.String(), v.SrsAssistantTTS.String(), v.SrsAssistantVAD.String(), v.SrsAssistantMix.String(),
+		v.SrsAssistantTools.String(),
 	)
 }
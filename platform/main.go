@@ -232,9 +232,19 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start OCR worker")
 	}
 
+	// Create detect worker for scene change, black, frozen frames and silence.
+	detectWorker = NewDetectWorker()
+	defer detectWorker.Close()
+	if err := detectWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start detect worker")
+	}
+
 	// Create AI Talk worker for live room.
 	talkServer = NewTalkServer()
 	defer talkServer.Close()
+	if err := talkServer.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start talk server")
+	}
 
 	// Create AI Dubbing server for VoD translation.
 	dubbingServer = NewDubbingServer()
@@ -254,6 +264,27 @@ func doMain(ctx context.Context) error {
 		return errors.Wrapf(err, "start record worker")
 	}
 
+	// Create worker for ARCHIVE, re-encode the record files in off-peak hours.
+	archiveWorker = NewArchiveWorker()
+	defer archiveWorker.Close()
+	if err := archiveWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start archive worker")
+	}
+
+	// Create worker for record TRANSCRIPT, re-transcribe the record files by a high accuracy model.
+	recordTranscriptWorker = NewRecordTranscriptWorker()
+	defer recordTranscriptWorker.Close()
+	if err := recordTranscriptWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start record transcript worker")
+	}
+
+	// Create worker for MERGE, merge multiple record files to one.
+	mergeWorker = NewMergeWorker()
+	defer mergeWorker.Close()
+	if err := mergeWorker.Start(ctx); err != nil {
+		return errors.Wrapf(err, "start merge worker")
+	}
+
 	// Create worker for DVR, covert live stream to local file.
 	dvrWorker = NewDvrWorker()
 	defer dvrWorker.Close()
@@ -493,7 +524,7 @@ func initPlatform(ctx context.Context) error {
 		"containers/data/upload", "containers/data/vlive", "containers/data/signals",
 		"containers/data/lego", "containers/data/.well-known", "containers/data/config",
 		"containers/data/transcript", "containers/data/srs-s3-bucket", "containers/data/ai-talk",
-		"containers/data/dubbing", "containers/data/ocr",
+		"containers/data/dubbing", "containers/data/ocr", "containers/data/detect",
 	} {
 		if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
 			if err = os.MkdirAll(dir, os.ModeDir|os.FileMode(0755)); err != nil {
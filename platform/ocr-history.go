@@ -0,0 +1,586 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The directory to save the frame images of OCR history.
+const ocrHistoryDir = "ocr/history"
+
+// OCRHistoryConfig is the retention policy of OCR history, saved in SRS_OCR_HISTORY_CONFIG.
+type OCRHistoryConfig struct {
+	// Whether persist the OCR results.
+	Enabled bool `json:"enabled"`
+	// The days to keep the history, zero to keep forever.
+	RetentionDays int `json:"retentionDays"`
+	// The max number of history, the oldest is removed, zero for no limit.
+	MaxRecords int `json:"maxRecords"`
+	// Whether save the frame image of history.
+	SaveImage bool `json:"saveImage"`
+}
+
+func NewOCRHistoryConfig() *OCRHistoryConfig {
+	return &OCRHistoryConfig{
+		Enabled: true, RetentionDays: 7, MaxRecords: 10000, SaveImage: true,
+	}
+}
+
+func (v OCRHistoryConfig) String() string {
+	return fmt.Sprintf("enabled=%v, retention=%v, max=%v, image=%v",
+		v.Enabled, v.RetentionDays, v.MaxRecords, v.SaveImage)
+}
+
+func (v *OCRHistoryConfig) Load(ctx context.Context) error {
+	if b, err := rdb.HGet(ctx, SRS_OCR_HISTORY_CONFIG, "global").Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v global", SRS_OCR_HISTORY_CONFIG)
+	} else if len(b) > 0 {
+		if err := json.Unmarshal([]byte(b), v); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+	}
+	return nil
+}
+
+func (v *OCRHistoryConfig) Save(ctx context.Context) error {
+	if b, err := json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "marshal conf %v", v)
+	} else if err := rdb.HSet(ctx, SRS_OCR_HISTORY_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v global %v", SRS_OCR_HISTORY_CONFIG, string(b))
+	}
+	return nil
+}
+
+// OCRHistory is the OCR result of a frame, saved in SRS_OCR_HISTORY, because the segments in queues are
+// disposed when done.
+type OCRHistory struct {
+	// The history UUID.
+	UUID string `json:"uuid"`
+	// The OCR task UUID.
+	TaskUUID string `json:"task"`
+	// The stream of frame.
+	Vhost  string `json:"vhost,omitempty"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The name of region, empty for the whole frame.
+	Region string `json:"region,omitempty"`
+	// The wallclock time of the frame, in ISO 8601 format.
+	Time string `json:"time"`
+	// The OCR text, and the structured object if schema is set.
+	Text   string          `json:"text"`
+	Object json.RawMessage `json:"object,omitempty"`
+	// The error if the text doesn't match the schema, the object is empty for this case.
+	Error string `json:"error,omitempty"`
+	// The frame reference, the TS url of SRS and the offset in seconds of frame in TS.
+	TsURL  string  `json:"ts"`
+	Offset float64 `json:"offset"`
+	// The URL of frame image, empty if not saved.
+	Image string `json:"image,omitempty"`
+	// The UUID of record artifact, if recording is on.
+	RecordUUID string `json:"record,omitempty"`
+	// The create time, in RFC3339.
+	CreatedAt string `json:"created"`
+}
+
+func (v OCRHistory) String() string {
+	return fmt.Sprintf("uuid=%v, task=%v, app=%v, stream=%v, region=%v, time=%v, text=%vB, object=%vB, error=%v, ts=%v, offset=%v, image=%v, record=%v",
+		v.UUID, v.TaskUUID, v.App, v.Stream, v.Region, v.Time, len(v.Text), len(v.Object), v.Error, v.TsURL, v.Offset,
+		v.Image, v.RecordUUID)
+}
+
+// imageFile is the local file of frame image.
+func (v *OCRHistory) imageFile() string {
+	return path.Join(ocrHistoryDir, fmt.Sprintf("%v.jpg", v.UUID))
+}
+
+// dispose remove the frame image of history.
+func (v *OCRHistory) dispose() {
+	if _, err := os.Stat(v.imageFile()); err == nil {
+		os.Remove(v.imageFile())
+	}
+}
+
+// OCRHistoryFilter is the filter to query the histories, the empty field matches any.
+type OCRHistoryFilter struct {
+	TaskUUID string `json:"task"`
+	App      string `json:"app"`
+	Stream   string `json:"stream"`
+	Region   string `json:"region"`
+	// The time range, in RFC3339 or ISO 8601.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// The keyword to search in text and object, case insensitive.
+	Keyword string `json:"keyword"`
+}
+
+func (v OCRHistoryFilter) String() string {
+	return fmt.Sprintf("task=%v, app=%v, stream=%v, region=%v, start=%v, end=%v, keyword=%v",
+		v.TaskUUID, v.App, v.Stream, v.Region, v.Start, v.End, v.Keyword)
+}
+
+// match whether the history matches the filter.
+func (v *OCRHistoryFilter) match(h *OCRHistory) bool {
+	if (v.TaskUUID != "" && h.TaskUUID != v.TaskUUID) || (v.App != "" && h.App != v.App) ||
+		(v.Stream != "" && h.Stream != v.Stream) || (v.Region != "" && h.Region != v.Region) {
+		return false
+	}
+
+	if v.Start != "" || v.End != "" {
+		t, err := time.Parse(time.RFC3339, h.Time)
+		if err != nil {
+			return false
+		}
+		if start, err := time.Parse(time.RFC3339, v.Start); err == nil && t.Before(start) {
+			return false
+		}
+		if end, err := time.Parse(time.RFC3339, v.End); err == nil && t.After(end) {
+			return false
+		}
+	}
+
+	if keyword := strings.ToLower(strings.TrimSpace(v.Keyword)); keyword != "" {
+		if !strings.Contains(strings.ToLower(h.Text), keyword) && !strings.Contains(strings.ToLower(string(h.Object)), keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *OCRHistoryFilter) validate() error {
+	for _, t := range []string{v.Start, v.End} {
+		if _, err := time.Parse(time.RFC3339, t); t != "" && err != nil {
+			return errors.Wrapf(err, "invalid time %v", t)
+		}
+	}
+	return nil
+}
+
+// queryOCRHistories load the histories which match the filter, sorted by time, latest first.
+func queryOCRHistories(ctx context.Context, filter *OCRHistoryFilter) ([]*OCRHistory, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_OCR_HISTORY).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_OCR_HISTORY)
+	}
+
+	histories := []*OCRHistory{}
+	for historyUUID, b := range objs {
+		var h OCRHistory
+		if err := json.Unmarshal([]byte(b), &h); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", historyUUID, b)
+		}
+
+		if filter == nil || filter.match(&h) {
+			histories = append(histories, &h)
+		}
+	}
+
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Time > histories[j].Time
+	})
+	return histories, nil
+}
+
+// exportOCRHistories build the histories in format csv or json.
+func exportOCRHistories(histories []*OCRHistory, format string) (contentType, body string, err error) {
+	switch format {
+	case "csv":
+		var sb strings.Builder
+		w := csv.NewWriter(&sb)
+		w.Write([]string{"time", "app", "stream", "region", "text", "object", "error", "ts", "offset", "image", "record", "uuid"})
+		for _, h := range histories {
+			w.Write([]string{
+				h.Time, h.App, h.Stream, h.Region, h.Text, string(h.Object), h.Error, h.TsURL,
+				fmt.Sprintf("%.3f", h.Offset), h.Image, h.RecordUUID, h.UUID,
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", "", errors.Wrapf(err, "write csv")
+		}
+		return "text/csv; charset=utf-8", sb.String(), nil
+	case "json":
+		b, err := json.Marshal(histories)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "marshal %v histories", len(histories))
+		}
+		return "application/json", string(b), nil
+	}
+	return "", "", errors.Errorf("invalid format %v", format)
+}
+
+// saveHistory persist the OCR result of segment, with the frame image if required.
+func (v *OCRTask) saveHistory(ctx context.Context, segment *OCRSegment) error {
+	if segment.Msg == nil || segment.ImageFile == nil {
+		return nil
+	}
+
+	config := NewOCRHistoryConfig()
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
+	if !config.Enabled {
+		return nil
+	}
+
+	msg := segment.Msg
+	starttime := time.Now()
+	if segment.TsFile != nil {
+		if pdt, err := time.Parse(programDateTimeLayout, segment.TsFile.ProgramDateTime); err == nil {
+			starttime = pdt
+		}
+	}
+
+	h := &OCRHistory{
+		UUID: uuid.NewString(), TaskUUID: v.taskUUID(),
+		Vhost: msg.Vhost, App: msg.App, Stream: msg.Stream, Region: segment.Region,
+		Time: starttime.Add(time.Duration(segment.Offset * float64(time.Second))).Format(programDateTimeLayout),
+		Text: segment.OCRText, Object: segment.OCRObject, Error: segment.OCRError, TsURL: msg.URL, Offset: segment.Offset,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	// Link to the record artifact, to review the frame after stream is done.
+	if recordUUID, err := queryRecordUUIDOfStream(ctx, msg.M3u8URL); err != nil {
+		logger.Wf(ctx, "ocr: ignore query record err %+v", err)
+	} else {
+		h.RecordUUID = recordUUID
+	}
+
+	// Keep the frame image, because the image of segment is disposed when done.
+	if config.SaveImage {
+		if err := os.MkdirAll(ocrHistoryDir, 0755); err != nil {
+			return errors.Wrapf(err, "create dir %v", ocrHistoryDir)
+		}
+		if err := exec.CommandContext(ctx, "cp", "-f", segment.ImageFile.File, h.imageFile()).Run(); err != nil {
+			return errors.Wrapf(err, "copy file %v to %v", segment.ImageFile.File, h.imageFile())
+		}
+		h.Image = fmt.Sprintf("/terraform/v1/ai/ocr/history/image/%v.jpg", h.UUID)
+	}
+
+	if b, err := json.Marshal(h); err != nil {
+		return errors.Wrapf(err, "marshal %v", h.String())
+	} else if err := rdb.HSet(ctx, SRS_OCR_HISTORY, h.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_OCR_HISTORY, h.UUID)
+	}
+
+	logger.Tf(ctx, "ocr: save history %v", h.String())
+	return nil
+}
+
+// expiredOCRHistories get the histories to remove by the retention policy, the histories should be sorted
+// latest first.
+func expiredOCRHistories(histories []*OCRHistory, config *OCRHistoryConfig, now time.Time) []*OCRHistory {
+	var expired []*OCRHistory
+	for i, h := range histories {
+		if config.MaxRecords > 0 && i >= config.MaxRecords {
+			expired = append(expired, h)
+			continue
+		}
+
+		if config.RetentionDays > 0 {
+			t, err := time.Parse(time.RFC3339, h.Time)
+			if err != nil || now.Sub(t) > time.Duration(config.RetentionDays)*24*time.Hour {
+				expired = append(expired, h)
+			}
+		}
+	}
+	return expired
+}
+
+// cleanupHistories remove the histories and images by the retention policy.
+func (v *OCRWorker) cleanupHistories(ctx context.Context) error {
+	config := NewOCRHistoryConfig()
+	if err := config.Load(ctx); err != nil {
+		return errors.Wrapf(err, "load config")
+	}
+
+	histories, err := queryOCRHistories(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "query histories")
+	}
+
+	expired := expiredOCRHistories(histories, config, time.Now())
+	for _, h := range expired {
+		if err := rdb.HDel(ctx, SRS_OCR_HISTORY, h.UUID).Err(); err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "hdel %v %v", SRS_OCR_HISTORY, h.UUID)
+		}
+		h.dispose()
+	}
+
+	if len(expired) > 0 {
+		logger.Tf(ctx, "ocr: cleanup %v of %v histories, config=<%v>", len(expired), len(histories), config)
+	}
+	return nil
+}
+
+func (v *OCRWorker) handleHistoryService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/ocr/history/config"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			config := NewOCRHistoryConfig()
+			if err := config.Load(ctx); err != nil {
+				return errors.Wrapf(err, "load config")
+			}
+
+			ohttp.WriteData(ctx, w, r, config)
+			logger.Tf(ctx, "ocr history config ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/history/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			config := NewOCRHistoryConfig()
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*OCRHistoryConfig
+			}{
+				Token: &token, OCRHistoryConfig: config,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if config.RetentionDays < 0 || config.MaxRecords < 0 {
+				return errors.Errorf("invalid retention %v, max %v", config.RetentionDays, config.MaxRecords)
+			}
+
+			if err := config.Save(ctx); err != nil {
+				return errors.Wrapf(err, "save config")
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ocr history apply ok, config=<%v>, token=%vB", config, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/history/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var limit int
+			var filter OCRHistoryFilter
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*OCRHistoryFilter
+				// The max number of histories, default to 100.
+				Limit *int `json:"limit"`
+			}{
+				Token: &token, OCRHistoryFilter: &filter, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := filter.validate(); err != nil {
+				return errors.Wrapf(err, "invalid filter")
+			}
+			if limit <= 0 {
+				limit = 100
+			}
+
+			histories, err := queryOCRHistories(ctx, &filter)
+			if err != nil {
+				return errors.Wrapf(err, "query histories")
+			}
+
+			type QueryResponse struct {
+				Histories []*OCRHistory `json:"histories"`
+				// The total number of matched histories.
+				Count int `json:"count"`
+			}
+			res := &QueryResponse{Histories: histories, Count: len(histories)}
+			if len(res.Histories) > limit {
+				res.Histories = res.Histories[:limit]
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "ocr history query ok, filter=<%v>, histories=%v/%v, token=%vB",
+				filter, len(res.Histories), res.Count, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/history/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, historyUUID string
+			var filter OCRHistoryFilter
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// Remove the history by uuid, or the histories match the filter.
+				UUID *string `json:"uuid"`
+				*OCRHistoryFilter
+			}{
+				Token: &token, UUID: &historyUUID, OCRHistoryFilter: &filter,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := filter.validate(); err != nil {
+				return errors.Wrapf(err, "invalid filter")
+			}
+			if historyUUID == "" && filter == (OCRHistoryFilter{}) {
+				return errors.New("no uuid or filter")
+			}
+
+			histories, err := queryOCRHistories(ctx, &filter)
+			if err != nil {
+				return errors.Wrapf(err, "query histories")
+			}
+
+			var removed int
+			for _, h := range histories {
+				if historyUUID != "" && h.UUID != historyUUID {
+					continue
+				}
+
+				if err := rdb.HDel(ctx, SRS_OCR_HISTORY, h.UUID).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_OCR_HISTORY, h.UUID)
+				}
+				h.dispose()
+				removed++
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ocr history remove ok, uuid=%v, filter=<%v>, removed=%v, token=%vB",
+				historyUUID, filter, removed, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/history/export/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is /export/:name.csv?token=xxx&app=xxx&stream=xxx&start=xxx&end=xxx&keyword=xxx, or json.
+			q := r.URL.Query()
+			token := q.Get("token")
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			filename := path.Base(r.URL.Path)
+			format := strings.TrimPrefix(path.Ext(filename), ".")
+
+			filter := OCRHistoryFilter{
+				TaskUUID: q.Get("task"), App: q.Get("app"), Stream: q.Get("stream"), Region: q.Get("region"),
+				Start: q.Get("start"), End: q.Get("end"), Keyword: q.Get("keyword"),
+			}
+			if err := filter.validate(); err != nil {
+				return errors.Wrapf(err, "invalid filter")
+			}
+
+			histories, err := queryOCRHistories(ctx, &filter)
+			if err != nil {
+				return errors.Wrapf(err, "query histories")
+			}
+
+			contentType, body, err := exportOCRHistories(histories, format)
+			if err != nil {
+				return errors.Wrapf(err, "export %v histories", len(histories))
+			}
+
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", filename))
+			w.Write([]byte(body))
+			logger.Tf(ctx, "ocr history export ok, filter=<%v>, format=%v, histories=%v",
+				filter, format, len(histories))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/history/image/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is /image/:uuid.jpg
+			filename := path.Base(r.URL.Path)
+			historyUUID := strings.TrimSuffix(filename, path.Ext(filename))
+			if _, err := uuid.Parse(historyUUID); err != nil {
+				return errors.Wrapf(err, "invalid uuid %v of %v", historyUUID, r.URL.Path)
+			}
+
+			h := &OCRHistory{UUID: historyUUID}
+			if f, err := os.Open(h.imageFile()); err != nil {
+				return errors.Wrapf(err, "open file %v", h.imageFile())
+			} else {
+				defer f.Close()
+				w.Header().Set("Content-Type", "image/jpeg")
+				io.Copy(w, f)
+			}
+
+			logger.Tf(ctx, "ocr history image ok, uuid=%v", historyUUID)
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
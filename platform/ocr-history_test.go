@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOCRHistory_FilterAndRetention(t *testing.T) {
+	histories := []*OCRHistory{
+		{UUID: "h2", App: "live", Stream: "sports", Time: "2024-04-23T10:00:05.000+08:00", Text: "Home 2 : 1 Away",
+			Object: json.RawMessage(`{"home":2,"away":1}`)},
+		{UUID: "h1", App: "live", Stream: "news", Time: "2024-04-23T09:00:00.000+08:00", Text: "Breaking, news"},
+	}
+
+	filter := &OCRHistoryFilter{Start: "2024-04-23T01:30:00Z", Keyword: "HOME"}
+	if err := filter.validate(); err != nil {
+		t.Fatalf("invalid filter, %v", err)
+	}
+	if !filter.match(histories[0]) || filter.match(histories[1]) {
+		t.Errorf("invalid match of %v", filter.String())
+	}
+	if filter := (&OCRHistoryFilter{Keyword: `"away":1`}); !filter.match(histories[0]) {
+		t.Errorf("should match object of %v", filter.String())
+	}
+	if err := (&OCRHistoryFilter{End: "yesterday"}).validate(); err == nil {
+		t.Errorf("should fail for invalid time")
+	}
+
+	_, body, err := exportOCRHistories(histories, "csv")
+	if err != nil {
+		t.Fatalf("export failed, %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 3 ||
+		!strings.HasPrefix(lines[2], `2024-04-23T09:00:00.000+08:00,live,news,,"Breaking, news",`) {
+		t.Errorf("invalid csv %v", body)
+	}
+
+	now, _ := time.Parse(time.RFC3339, "2024-04-24T09:30:00+08:00")
+	if expired := expiredOCRHistories(histories, &OCRHistoryConfig{RetentionDays: 1}, now); len(expired) != 1 || expired[0].UUID != "h1" {
+		t.Errorf("invalid expired %v", expired)
+	}
+	if expired := expiredOCRHistories(histories, &OCRHistoryConfig{MaxRecords: 1}, now); len(expired) != 1 || expired[0].UUID != "h1" {
+		t.Errorf("invalid expired %v", expired)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// The OpenAI vision, with the AI service provider of task.
+	OCRProviderOpenAI = "openai"
+	// The OpenAI-compatible vision server, for example, the self-hosted vLLM or Ollama.
+	OCRProviderCompatible = "compatible"
+	// The local Tesseract engine, by the tesseract CLI.
+	OCRProviderTesseract = "tesseract"
+)
+
+// OCRProviderConfig is the config to create an OCR provider, built from the config of OCR task.
+type OCRProviderConfig struct {
+	// The OCR provider, openai, compatible or tesseract. Default to openai.
+	Provider string
+	// The model name, default to gpt-4o for OpenAI.
+	Model string
+	// For compatible provider, the base URL of server, for example, http://127.0.0.1:11434/v1
+	URL string
+	// For compatible provider, the optional bearer token for authorization.
+	Token string
+	// For tesseract provider, the languages, for example, eng+chi_sim. Default to eng.
+	Language string
+	// The price in USD per 1M input and output tokens, to estimate the cost of each frame.
+	InputPrice  float64
+	OutputPrice float64
+	// For OpenAI provider, the AI service config.
+	AI openai.ClientConfig
+}
+
+func (v OCRProviderConfig) String() string {
+	return fmt.Sprintf("provider=%v, model=%v, url=%v, token=%vB, lang=%v, price=%v/%v",
+		v.Provider, v.Model, v.URL, len(v.Token), v.Language, v.InputPrice, v.OutputPrice)
+}
+
+// OCRRequest is the request to recognize an image.
+type OCRRequest struct {
+	// The jpeg image file to recognize.
+	ImageFile string
+	// The system prompt and user prompt, ignored by tesseract.
+	System string
+	Prompt string
+	// The chat histories of the same region, ignored by tesseract.
+	Histories []openai.ChatCompletionMessage
+	// The response format, for example, JSON object for structured OCR, ignored by tesseract.
+	ResponseFormat *openai.ChatCompletionResponseFormat
+}
+
+// OCRResponse is the text recognized from image, and the usage of provider.
+type OCRResponse struct {
+	// The recognized text.
+	Text string
+	// The tokens of request and response, zero for local provider.
+	PromptTokens     int
+	CompletionTokens int
+	// The estimated cost in USD, zero for local provider.
+	Price float64
+}
+
+// OCRProvider is the service to convert image to text.
+type OCRProvider interface {
+	// Recognize the image, with the prompt and histories.
+	Recognize(ctx context.Context, req *OCRRequest) (*OCRResponse, error)
+}
+
+// NewOCRProvider create the OCR provider by config, fallback to OpenAI.
+func NewOCRProvider(config *OCRProviderConfig) OCRProvider {
+	switch config.Provider {
+	case OCRProviderTesseract:
+		return &tesseractOCRProvider{config: *config}
+	case OCRProviderCompatible:
+		aiConfig := openai.DefaultConfig(config.Token)
+		aiConfig.BaseURL = config.URL
+		return &openaiOCRProvider{config: *config, ai: aiConfig}
+	}
+	return &openaiOCRProvider{config: *config, ai: config.AI}
+}
+
+// openaiOCRProvider use the chat completion with image, of OpenAI or OpenAI-compatible server.
+type openaiOCRProvider struct {
+	config OCRProviderConfig
+	ai     openai.ClientConfig
+}
+
+func (v *openaiOCRProvider) Recognize(ctx context.Context, req *OCRRequest) (*OCRResponse, error) {
+	if v.config.Provider == OCRProviderCompatible && v.config.URL == "" {
+		return nil, errors.Errorf("no url for %v ocr", v.config.Provider)
+	}
+
+	// Read the image file and convert to base64.
+	data, err := os.ReadFile(req.ImageFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read image from %v", req.ImageFile)
+	}
+	imageData := base64.StdEncoding.EncodeToString(data)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: req.System},
+	}
+
+	messages = append(messages, req.Histories...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, Content: req.Prompt,
+	})
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{
+				Detail: openai.ImageURLDetailLow, URL: fmt.Sprintf("data:image/jpeg;base64,%v", imageData),
+			}},
+		},
+	})
+
+	model := ChooseNotEmpty(v.config.Model, openai.GPT4o)
+	client := openai.NewClientWithConfig(v.ai)
+	resp, err := client.CreateChatCompletion(
+		ctx, openai.ChatCompletionRequest{
+			Model: model, Messages: messages, ResponseFormat: req.ResponseFormat,
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "chat by %v, messages=%v", model, len(messages))
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.Errorf("no choice by %v", model)
+	}
+
+	return &OCRResponse{
+		Text:         resp.Choices[0].Message.Content,
+		PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens,
+		Price: (float64(resp.Usage.PromptTokens)*v.config.InputPrice +
+			float64(resp.Usage.CompletionTokens)*v.config.OutputPrice) / 1000000,
+	}, nil
+}
+
+// tesseractOCRProvider recognize the image by the local tesseract CLI, see https://github.com/tesseract-ocr/tesseract
+type tesseractOCRProvider struct {
+	config OCRProviderConfig
+}
+
+func (v *tesseractOCRProvider) Recognize(ctx context.Context, req *OCRRequest) (*OCRResponse, error) {
+	// Always use execFile when params contains user inputs, see https://auth0.com/blog/preventing-command-injection-attacks-in-node-js-apps/
+	lang := ChooseNotEmpty(v.config.Language, "eng")
+	stdout, err := exec.CommandContext(ctx, "tesseract", req.ImageFile, "stdout", "-l", lang).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "tesseract %v, lang=%v", req.ImageFile, lang)
+	}
+
+	// Join the lines, as the text on screen is generally short.
+	var lines []string
+	for _, line := range strings.Split(string(stdout), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return &OCRResponse{Text: strings.Join(lines, " ")}, nil
+}
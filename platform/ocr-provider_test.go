@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestOCRProvider_Compatible(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer xxx" {
+			t.Errorf("invalid request %v %v", r.URL.Path, r.Header)
+		}
+
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "llava" || len(req.Messages) != 3 {
+			t.Errorf("invalid request %v, err %v", req, err)
+		}
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"Score 2:1"}}],`+
+			`"usage":{"prompt_tokens":1000,"completion_tokens":10}}`)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "ocr-*.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	provider := NewOCRProvider(&OCRProviderConfig{
+		Provider: OCRProviderCompatible, Model: "llava", URL: server.URL + "/v1", Token: "xxx",
+		InputPrice: 2, OutputPrice: 10,
+	})
+	resp, err := provider.Recognize(context.Background(), &OCRRequest{
+		ImageFile: f.Name(), System: "Be neat.", Prompt: "What is the score?",
+	})
+	if err != nil {
+		t.Fatalf("recognize failed, %v", err)
+	}
+	if resp.Text != "Score 2:1" || resp.PromptTokens != 1000 || resp.CompletionTokens != 10 || resp.Price != 0.0021 {
+		t.Errorf("invalid response %v", resp)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+
+	// From ossrs.
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// OCRSchema is the JSON schema of the structured OCR result, which is a subset of JSON Schema,
+// supports keywords type, properties, required, items, enum, pattern, minimum and maximum. For
+// example, the schema of scoreboard:
+//
+//	{"type":"object","required":["home","away"],"properties":{
+//	  "home":{"type":"integer","minimum":0},"away":{"type":"integer","minimum":0}
+//	}}
+type OCRSchema struct {
+	// The type of value, object, array, string, number, integer, boolean or null. Any type if empty.
+	Type string `json:"type,omitempty"`
+	// The description of value, as hint for AI.
+	Description string `json:"description,omitempty"`
+	// The properties of object.
+	Properties map[string]*OCRSchema `json:"properties,omitempty"`
+	// The required properties of object.
+	Required []string `json:"required,omitempty"`
+	// The schema of items of array.
+	Items *OCRSchema `json:"items,omitempty"`
+	// The allowed values.
+	Enum []interface{} `json:"enum,omitempty"`
+	// The regular expression of string.
+	Pattern string `json:"pattern,omitempty"`
+	// The range of number or integer.
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	// The compiled pattern.
+	pattern *regexp.Regexp
+}
+
+// parseOCRSchema parse and compile the schema, return error if invalid.
+func parseOCRSchema(b []byte) (*OCRSchema, error) {
+	var v OCRSchema
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+
+	if err := v.compile(""); err != nil {
+		return nil, errors.Wrapf(err, "compile %v", string(b))
+	}
+	return &v, nil
+}
+
+func (v *OCRSchema) compile(path string) error {
+	switch v.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return errors.Errorf("%v: invalid type %v", ocrSchemaPath(path), v.Type)
+	}
+
+	if v.Pattern != "" {
+		if r, err := regexp.Compile(v.Pattern); err != nil {
+			return errors.Wrapf(err, "%v: invalid pattern %v", ocrSchemaPath(path), v.Pattern)
+		} else {
+			v.pattern = r
+		}
+	}
+
+	for name, property := range v.Properties {
+		if property == nil {
+			return errors.Errorf("%v: no schema", ocrSchemaPath(path+"."+name))
+		}
+		if err := property.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+
+	if v.Items != nil {
+		if err := v.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate the value, which is unmarshalled from JSON, against the schema.
+func (v *OCRSchema) validate(value interface{}, path string) error {
+	switch v.Type {
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return errors.Errorf("%v: not object", ocrSchemaPath(path))
+		}
+	case "array":
+		if _, ok := value.([]interface{}); !ok {
+			return errors.Errorf("%v: not array", ocrSchemaPath(path))
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return errors.Errorf("%v: not string", ocrSchemaPath(path))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return errors.Errorf("%v: not number", ocrSchemaPath(path))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return errors.Errorf("%v: not integer", ocrSchemaPath(path))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return errors.Errorf("%v: not boolean", ocrSchemaPath(path))
+		}
+	case "null":
+		if value != nil {
+			return errors.Errorf("%v: not null", ocrSchemaPath(path))
+		}
+	}
+
+	if len(v.Enum) > 0 {
+		var matched bool
+		for _, e := range v.Enum {
+			if reflect.DeepEqual(e, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.Errorf("%v: %v not in enum %v", ocrSchemaPath(path), value, v.Enum)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range v.Required {
+			if _, ok := value[name]; !ok {
+				return errors.Errorf("%v: no required property", ocrSchemaPath(path+"."+name))
+			}
+		}
+		for name, property := range v.Properties {
+			if pv, ok := value[name]; ok {
+				if err := property.validate(pv, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if v.Items != nil {
+			for i, item := range value {
+				if err := v.Items.validate(item, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if v.pattern != nil && !v.pattern.MatchString(value) {
+			return errors.Errorf("%v: %v not match %v", ocrSchemaPath(path), value, v.Pattern)
+		}
+	case float64:
+		if v.Minimum != nil && value < *v.Minimum {
+			return errors.Errorf("%v: %v less than %v", ocrSchemaPath(path), value, *v.Minimum)
+		}
+		if v.Maximum != nil && value > *v.Maximum {
+			return errors.Errorf("%v: %v greater than %v", ocrSchemaPath(path), value, *v.Maximum)
+		}
+	}
+	return nil
+}
+
+// parseObject parse the reply of AI, which may be wrapped in markdown code block, and validate it
+// against the schema. Return the compact JSON object.
+func (v *OCRSchema) parseObject(reply string) (json.RawMessage, error) {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```")
+		text = strings.TrimSpace(strings.TrimSuffix(text, "```"))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", text)
+	}
+
+	if err := v.validate(value, ""); err != nil {
+		return nil, errors.Wrapf(err, "validate %v", text)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal %v", value)
+	}
+	return json.RawMessage(b), nil
+}
+
+// ocrSchemaPath is the path of value in the JSON object, for example, $.home or $.players[0].
+func ocrSchemaPath(path string) string {
+	return "$" + path
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOCRSchema_Validate(t *testing.T) {
+	schema, err := parseOCRSchema([]byte(`{"type":"object","required":["home","away"],"properties":{
+		"home":{"type":"integer","minimum":0},"away":{"type":"integer","minimum":0},
+		"period":{"type":"string","enum":["1st","2nd"]},
+		"plates":{"type":"array","items":{"type":"string","pattern":"^[A-Z0-9]+$"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("parse schema err %v", err)
+	}
+
+	if object, err := schema.parseObject("```json\n{\"home\": 2, \"away\": 1, \"plates\": [\"AB12\"]}\n```"); err != nil {
+		t.Errorf("parse object err %v", err)
+	} else if string(object) != `{"away":1,"home":2,"plates":["AB12"]}` {
+		t.Errorf("invalid object %v", string(object))
+	}
+
+	for _, reply := range []string{
+		`{"home": 2}`, `{"home": 2.5, "away": 1}`, `{"home": -1, "away": 1}`,
+		`{"home": 2, "away": 1, "period": "3rd"}`, `{"home": 2, "away": 1, "plates": ["ab-12"]}`,
+		`Home 2, away 1`,
+	} {
+		if _, err := schema.parseObject(reply); err == nil {
+			t.Errorf("should fail for %v", reply)
+		}
+	}
+
+	if _, err := parseOCRSchema([]byte(`{"type":"date"}`)); err == nil {
+		t.Errorf("should fail for invalid type")
+	}
+}
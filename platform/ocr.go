@@ -5,7 +5,6 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -33,17 +32,18 @@ const maxCallbackSegments = 9
 var ocrWorker *OCRWorker
 
 type OCRWorker struct {
+	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global OCR task, only support one OCR task.
+	// The global OCR task, which OCR the latest active stream, if no other task for it.
 	task *OCRTask
+	// All OCR tasks, including the global task and the tasks for specified streams. The key is the key of
+	// task, see ocrTaskKey, and the value is *OCRTask.
+	tasks sync.Map
 
 	// Use async goroutine to process on_hls messages.
 	msgs chan *SrsOnHlsMessage
-
-	// Got message from SRS, a new TS segment file is generated.
-	tsfiles chan *SrsOnHlsObject
 }
 
 // NewOCRWorker 创建并返回一个新的 OCRWorker 实例。
@@ -54,8 +54,6 @@ func NewOCRWorker() *OCRWorker {
 	v := &OCRWorker{
 		// Message on_hls.
 		msgs: make(chan *SrsOnHlsMessage, 1024),
-		// TS files.
-		tsfiles: make(chan *SrsOnHlsObject, 1024),
 	}
 	// 创建一个新的 OCRTask，并将其与当前 OCRWorker 关联。
 	v.task = NewOCRTask()
@@ -63,16 +61,103 @@ func NewOCRWorker() *OCRWorker {
 	return v
 }
 
+// queryTask find the task by uuid, or by stream, or the global task if both empty.
+func (v *OCRWorker) queryTask(uuid, app, stream string) (*OCRTask, error) {
+	if uuid != "" {
+		var target *OCRTask
+		v.tasks.Range(func(key, value interface{}) bool {
+			if task := value.(*OCRTask); task.taskUUID() == uuid {
+				target = task
+				return false
+			}
+			return true
+		})
+		if target == nil {
+			return nil, errors.Errorf("no task of uuid %v", uuid)
+		}
+		return target, nil
+	}
+
+	if app != "" || stream != "" {
+		if value, ok := v.tasks.Load(ocrTaskKey(app, stream)); ok {
+			return value.(*OCRTask), nil
+		}
+		return nil, errors.Errorf("no task of app=%v, stream=%v", app, stream)
+	}
+
+	return v.task, nil
+}
+
+// queryOrCreateTask find the task of stream, or create and start a new task for it.
+func (v *OCRWorker) queryOrCreateTask(ctx context.Context, app, stream string) *OCRTask {
+	if app == "" && stream == "" {
+		return v.task
+	}
+
+	task := NewOCRTask()
+	task.App, task.Stream, task.ocrWorker = app, stream, v
+	if value, loaded := v.tasks.LoadOrStore(task.key(), task); loaded {
+		return value.(*OCRTask)
+	}
+
+	v.startTask(v.ctx, task)
+	logger.Tf(ctx, "ocr: create task %v for app=%v, stream=%v", task.UUID, app, stream)
+	return task
+}
+
+// removeTask stop and remove the task of stream, note that the global task can't be removed.
+func (v *OCRWorker) removeTask(ctx context.Context, task *OCRTask) error {
+	if task == v.task {
+		return errors.New("can not remove global task")
+	}
+
+	v.tasks.Delete(task.key())
+	task.stop()
+
+	if err := task.remove(ctx); err != nil {
+		return errors.Wrapf(err, "remove task %v", task.String())
+	}
+
+	logger.Tf(ctx, "ocr: remove task %v", task.String())
+	return nil
+}
+
+// boundStreams get the streams which are bound to the tasks of specified streams.
+func (v *OCRWorker) boundStreams() map[string]bool {
+	streams := make(map[string]bool)
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*OCRTask); task != v.task {
+			streams[task.key()] = true
+		}
+		return true
+	})
+	return streams
+}
+
+// ocrTaskKey is the key of task and config, global for the global task, or app/stream for the task of
+// the specified stream.
+func ocrTaskKey(app, stream string) string {
+	if app == "" && stream == "" {
+		return "global"
+	}
+	return fmt.Sprintf("%v/%v", app, stream)
+}
+
 func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ai/ocr/query"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -82,26 +167,47 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			config := NewOCRConfig()
+			config.App, config.Stream = task.App, task.Stream
 			if err := config.Load(ctx); err != nil {
 				return errors.Wrapf(err, "load config")
 			}
 
+			type TaskObject struct {
+				UUID   string `json:"uuid"`
+				App    string `json:"app,omitempty"`
+				Stream string `json:"stream,omitempty"`
+				// Whether the task is enabled.
+				Enabled bool `json:"enabled"`
+			}
 			type QueryResponse struct {
 				Config *OCRConfig `json:"config"`
-				Task   struct {
-					UUID string `json:"uuid"`
-				} `json:"task"`
+				Task   TaskObject `json:"task"`
+				// All the tasks, including the global task.
+				Tasks []TaskObject `json:"tasks"`
 			}
 
 			resp := &QueryResponse{
 				Config: config,
-			}
-			resp.Task.UUID = v.task.UUID
+				Task:   TaskObject{UUID: task.taskUUID(), App: task.App, Stream: task.Stream, Enabled: task.enabled()},
+				Tasks:  []TaskObject{},
+			}
+			v.tasks.Range(func(key, value interface{}) bool {
+				t := value.(*OCRTask)
+				resp.Tasks = append(resp.Tasks, TaskObject{
+					UUID: t.taskUUID(), App: t.App, Stream: t.Stream, Enabled: t.enabled(),
+				})
+				return true
+			})
 
 			ohttp.WriteData(ctx, w, r, resp)
-			logger.Tf(ctx, "ocr query ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			logger.Tf(ctx, "ocr query ok, config=<%v>, uuid=%v, tasks=%v, token=%vB",
+				config, resp.Task.UUID, len(resp.Tasks), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -131,16 +237,40 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			if config.Interval < 0 {
+				return errors.Errorf("invalid interval %v", config.Interval)
+			}
+			for _, region := range config.Regions {
+				if err := region.validate(); err != nil {
+					return errors.Wrapf(err, "invalid region %v", region.String())
+				}
+			}
+			if len(config.Schema) > 0 {
+				if _, err := parseOCRSchema(config.Schema); err != nil {
+					return errors.Wrapf(err, "invalid schema")
+				}
+			}
+			if !slicesContains([]string{"", OCRProviderOpenAI, OCRProviderCompatible, OCRProviderTesseract}, config.OCRProvider) {
+				return errors.Errorf("invalid ocr provider %v", config.OCRProvider)
+			}
+			// The tesseract only replies plain text, never a JSON object of schema.
+			if config.OCRProvider == OCRProviderTesseract && len(config.Schema) > 0 {
+				return errors.Errorf("schema is not supported by ocr provider %v", config.OCRProvider)
+			}
+
+			// The task is addressed by the stream of config, create a task if not exists.
+			task := v.queryOrCreateTask(ctx, config.App, config.Stream)
+
 			// Not required yet.
-			if uuid != v.task.UUID {
-				logger.Wf(ctx, "ocr ignore uuid mismatch, query=%v, task=%v", uuid, v.task.UUID)
+			if taskUUID := task.taskUUID(); uuid != taskUUID {
+				logger.Wf(ctx, "ocr ignore uuid mismatch, query=%v, task=%v", uuid, taskUUID)
 			}
 
 			if err := config.Save(ctx); err != nil {
 				return errors.Wrapf(err, "save config")
 			}
 
-			if err := v.task.restart(ctx); err != nil {
+			if err := task.restart(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", config.String())
 			}
 
@@ -148,10 +278,10 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ApplyResponse{
-				UUID: v.task.UUID,
+				UUID: task.taskUUID(),
 			})
 			logger.Tf(ctx, "ocr apply ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+				config, task.taskUUID(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -179,6 +309,48 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			// Check the local tesseract engine, or the OpenAI-compatible vision server.
+			switch ocrConfig.OCRProvider {
+			case OCRProviderTesseract:
+				stdout, err := exec.CommandContext(ctx, "tesseract", "--version").Output()
+				if err != nil {
+					return errors.Wrapf(err, "tesseract version")
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "ocr check ok, config=<%v>, tesseract=<%v>, token=%vB",
+					ocrConfig, strings.Split(strings.TrimSpace(string(stdout)), "\n")[0], len(token))
+				return nil
+			case OCRProviderCompatible:
+				if ocrConfig.OCRURL == "" {
+					return errors.New("no ocr url")
+				}
+
+				ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+				defer cancel()
+
+				config := openai.DefaultConfig(ocrConfig.OCRToken)
+				config.BaseURL = ocrConfig.OCRURL
+				client := openai.NewClientWithConfig(config)
+				resp, err := client.CreateChatCompletion(
+					ctx, openai.ChatCompletionRequest{
+						Model: ChooseNotEmpty(ocrConfig.AIChatModel, openai.GPT4o),
+						Messages: []openai.ChatCompletionMessage{
+							{Role: openai.ChatMessageRoleUser, Content: "Hello!"},
+						},
+						MaxTokens: 50,
+					},
+				)
+				if err != nil {
+					return errors.Wrapf(err, "create chat")
+				}
+
+				ohttp.WriteData(ctx, w, r, nil)
+				logger.Tf(ctx, "ocr check ok, config=<%v>, msg=<%v>, token=%vB",
+					ocrConfig, resp.Choices[0].Message.Content, len(token))
+				return nil
+			}
+
 			// Query whisper-1 model detail.
 			var config openai.ClientConfig
 			config = openai.DefaultConfig(ocrConfig.AISecretKey)
@@ -196,7 +368,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			// Start a chat, to check whether the billing is expired.
 			resp, err := client.CreateChatCompletion(
 				ctx, openai.ChatCompletionRequest{
-					Model: openai.GPT4o,
+					Model: ChooseNotEmpty(ocrConfig.AIChatModel, openai.GPT4o),
 					Messages: []openai.ChatCompletionMessage{
 						{
 							Role:    openai.ChatMessageRoleUser,
@@ -240,11 +412,12 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if uuid != v.task.UUID {
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil || uuid == "" {
 				return errors.Errorf("invalid uuid %v", uuid)
 			}
 
-			if err := v.task.reset(ctx); err != nil {
+			if err := task.reset(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", uuid)
 			}
 
@@ -252,24 +425,67 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ResetResponse{
-				UUID: v.task.UUID,
+				UUID: task.taskUUID(),
 			})
-			logger.Tf(ctx, "ocr reset ok, uuid=%v, new=%v, token=%vB", uuid, v.task.UUID, len(token))
+			logger.Tf(ctx, "ocr reset ok, uuid=%v, new=%v, token=%vB", uuid, task.taskUUID(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
 		}
 	})
 
-	ep = "/terraform/v1/ai/ocr/live-queue"
+	ep = "/terraform/v1/ai/ocr/remove"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
 			}{
 				Token: &token,
+				UUID:  &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil || uuid == "" {
+				return errors.Errorf("invalid uuid %v", uuid)
+			}
+
+			if err := v.removeTask(ctx, task); err != nil {
+				return errors.Wrapf(err, "remove task %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "ocr remove ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/ocr/live-queue"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var uuid, app, stream string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+			}{
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -279,6 +495,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -292,7 +513,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &LiveQueueResponse{}
 
-			segments := v.task.liveSegments()
+			segments := task.liveSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.TsFile.TsID,
@@ -318,10 +539,15 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -331,6 +557,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -339,6 +570,9 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				Size     uint64  `json:"size"`
 				// The source ts file.
 				SourceTsID string `json:"stsid"`
+				// The region and offset in seconds of image in source ts file.
+				Region string  `json:"region,omitempty"`
+				Offset float64 `json:"offset"`
 				// The cost in ms to extract image.
 				ExtractImageCost int32 `json:"eic"`
 			}
@@ -348,7 +582,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.ocrSegments()
+			segments := task.ocrSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -358,6 +592,8 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 					Size:     segment.ImageFile.Size,
 					// The source ts file.
 					SourceTsID: segment.TsFile.TsID,
+					// The region and offset of image.
+					Region: segment.Region, Offset: segment.Offset,
 					// The cost in ms to extract image.
 					ExtractImageCost: int32(segment.CostExtractImage.Milliseconds()),
 				}}...)
@@ -378,10 +614,15 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -391,6 +632,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -399,12 +645,21 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				Size     uint64  `json:"size"`
 				// The source ts file.
 				SourceTsID string `json:"stsid"`
+				// The region and offset in seconds of image in source ts file.
+				Region string  `json:"region,omitempty"`
+				Offset float64 `json:"offset"`
 				// The cost in ms to extract image.
 				ExtractImageCost int32 `json:"eic"`
 				// The OCR text result.
 				OCRText string `json:"ocr"`
+				// The structured OCR result, and the error if not match the schema.
+				OCRObject json.RawMessage `json:"object,omitempty"`
+				OCRError  string          `json:"oce,omitempty"`
 				// The cost in ms to do OCR.
 				OCRCost int32 `json:"ocrc"`
+				// The tokens and estimated price in USD of OCR.
+				OCRTokens int     `json:"ocrt"`
+				OCRPrice  float64 `json:"ocrp"`
 			}
 			type OCRQueueResponse struct {
 				Segments []*Segment `json:"segments"`
@@ -412,7 +667,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.callbackSegments()
+			segments := task.callbackSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -422,12 +677,18 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 					Size:     segment.ImageFile.Size,
 					// The source ts file.
 					SourceTsID: segment.TsFile.TsID,
+					// The region and offset of image.
+					Region: segment.Region, Offset: segment.Offset,
 					// The cost in ms to extract image.
 					ExtractImageCost: int32(segment.CostExtractImage.Milliseconds()),
 					// The OCR text result.
 					OCRText: segment.OCRText,
+					// The structured OCR result.
+					OCRObject: segment.OCRObject, OCRError: segment.OCRError,
 					// The cost in ms to do OCR.
 					OCRCost: int32(segment.CostOCR.Milliseconds()),
+					// The tokens and price of OCR.
+					OCRTokens: segment.OCRTokens, OCRPrice: segment.OCRPrice,
 				}}...)
 			}
 
@@ -446,10 +707,15 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -459,6 +725,11 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			type Segment struct {
 				TsID     string  `json:"tsid"`
 				SeqNo    uint64  `json:"seqno"`
@@ -467,12 +738,21 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 				Size     uint64  `json:"size"`
 				// The source ts file.
 				SourceTsID string `json:"stsid"`
+				// The region and offset in seconds of image in source ts file.
+				Region string  `json:"region,omitempty"`
+				Offset float64 `json:"offset"`
 				// The cost in ms to extract image.
 				ExtractImageCost int32 `json:"eic"`
 				// The OCR text result.
 				OCRText string `json:"ocr"`
+				// The structured OCR result, and the error if not match the schema.
+				OCRObject json.RawMessage `json:"object,omitempty"`
+				OCRError  string          `json:"oce,omitempty"`
 				// The cost in ms to do OCR.
 				OCRCost int32 `json:"ocrc"`
+				// The tokens and estimated price in USD of OCR.
+				OCRTokens int     `json:"ocrt"`
+				OCRPrice  float64 `json:"ocrp"`
 				// The cost in ms to do callback.
 				CallbackCost int32 `json:"cbc"`
 			}
@@ -482,7 +762,7 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 			}
 			res := &OCRQueueResponse{}
 
-			segments := v.task.cleanupSegments()
+			segments := task.cleanupSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.ImageFile.TsID,
@@ -492,12 +772,18 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 					Size:     segment.ImageFile.Size,
 					// The source ts file.
 					SourceTsID: segment.TsFile.TsID,
+					// The region and offset of image.
+					Region: segment.Region, Offset: segment.Offset,
 					// The cost in ms to extract image.
 					ExtractImageCost: int32(segment.CostExtractImage.Milliseconds()),
 					// The OCR text result.
 					OCRText: segment.OCRText,
+					// The structured OCR result.
+					OCRObject: segment.OCRObject, OCRError: segment.OCRError,
 					// The cost in ms to do OCR.
 					OCRCost: int32(segment.CostOCR.Milliseconds()),
+					// The tokens and price of OCR.
+					OCRTokens: segment.OCRTokens, OCRPrice: segment.OCRPrice,
 					// The cost in msg to do callback.
 					CallbackCost: int32(segment.CostCallback.Milliseconds()),
 				}}...)
@@ -545,11 +831,20 @@ func (v *OCRWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 		}
 	})
 
+	if err := v.handleHistoryService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle history")
+	}
+
 	return nil
 }
 
 func (v *OCRWorker) Enabled() bool {
-	return v.task.enabled()
+	var enabled bool
+	v.tasks.Range(func(key, value interface{}) bool {
+		enabled = value.(*OCRTask).enabled()
+		return !enabled
+	})
+	return enabled
 }
 
 func (v *OCRWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
@@ -562,11 +857,24 @@ func (v *OCRWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) er
 }
 
 func (v *OCRWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage) error {
-	// Ignore if not natch the task config.
-	if !v.task.match(msg) {
-		return nil
+	var tasks []*OCRTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*OCRTask); task.match(msg) {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+
+	// Each task has its own copy of ts file, because the task removes the file when done.
+	for _, task := range tasks {
+		if err := v.copyTsFile(ctx, task, msg); err != nil {
+			return errors.Wrapf(err, "copy ts for task %v", task.UUID)
+		}
 	}
+	return nil
+}
 
+func (v *OCRWorker) copyTsFile(ctx context.Context, task *OCRTask, msg *SrsOnHlsMessage) error {
 	// Copy the ts file to temporary cache dir.
 	tsid := fmt.Sprintf("%v-org-%v", msg.SeqNo, uuid.NewString())
 	tsfile := path.Join("ocr", fmt.Sprintf("%v.ts", tsid))
@@ -583,7 +891,8 @@ func (v *OCRWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage
 		return errors.Wrapf(err, "stat file %v", msg.File)
 	}
 
-	// Create a local ts file object.
+	// Create a local ts file object, estimate the start time of ts by its duration.
+	starttime := time.Now().Add(-1 * time.Duration(msg.Duration*float64(time.Second)))
 	tsFile := &TsFile{
 		TsID:     tsid,
 		URL:      msg.URL,
@@ -591,14 +900,19 @@ func (v *OCRWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage
 		Duration: msg.Duration,
 		Size:     uint64(stats.Size()),
 		File:     tsfile,
+		// The wallclock time of ts, for the timestamps of sampled images.
+		ProgramDateTime: starttime.Format(programDateTimeLayout),
 	}
 
-	// Notify worker asynchronously.
+	// Notify task asynchronously, remove the file if task is removed or worker quit.
 	// TODO: FIXME: Should cleanup the temporary file when restart.
 	go func() {
 		select {
 		case <-ctx.Done():
-		case v.tsfiles <- &SrsOnHlsObject{Msg: msg, TsFile: tsFile}:
+			os.Remove(tsfile)
+		case <-task.taskCtx.Done():
+			os.Remove(tsfile)
+		case task.tsfiles <- &SrsOnHlsObject{Msg: msg, TsFile: tsFile}:
 		}
 	}()
 	return nil
@@ -625,54 +939,66 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 
 	// 创建一个可取消的上下文，以便在需要时终止所有 goroutine。
 	ctx, cancel := context.WithCancel(ctx)
-	v.cancel = cancel
+	v.ctx, v.cancel = ctx, cancel
 
 	// 将上下文与日志系统绑定，并记录启动信息。
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "ocr start a worker")
 
-	// Load task from redis and continue to run the task.
-	// 从 Redis 加载任务并继续运行任务。
+	// Load tasks from redis and continue to run the tasks.
+	// 从 Redis 加载所有任务并继续运行任务。
 	if objs, err := rdb.HGetAll(ctx, SRS_OCR_TASK).Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hgetall %v", SRS_OCR_TASK)
-	} else if len(objs) != 1 {
-		// Only support one task right now.
-		// 当前仅支持单个任务，如果任务数量不为 1，则删除 Redis 中的任务。
-		if err = rdb.Del(ctx, SRS_OCR_TASK).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "del %v", SRS_OCR_TASK)
-		}
 	} else {
 		for uuid, obj := range objs {
 			logger.Tf(ctx, "Load task %v object %v", uuid, obj)
 
-			// 将 Redis 中存储的任务反序列化到 v.task。
-			if err = json.Unmarshal([]byte(obj), v.task); err != nil {
+			task := NewOCRTask()
+			if err = json.Unmarshal([]byte(obj), task); err != nil {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			break
+			// Note that the previous task has no stream, which is the global task.
+			task.ocrWorker = v
+			if task.key() == v.task.key() {
+				v.task = task
+			}
+
+			// Remove the duplicated task, only one task for each stream.
+			if _, loaded := v.tasks.LoadOrStore(task.key(), task); loaded {
+				logger.Wf(ctx, "ocr: remove duplicated task %v", task.String())
+				if err = rdb.HDel(ctx, SRS_OCR_TASK, uuid).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_OCR_TASK, uuid)
+				}
+			}
 		}
 	}
 
-	// Start global ocr task.
-	// 启动全局 OCR 任务处理 goroutine。
+	// The global task always exists.
+	// 全局任务总是存在。
+	v.tasks.Store(v.task.key(), v.task)
+
+	// Start all OCR tasks.
+	// 启动所有 OCR 任务。
+	v.tasks.Range(func(key, value interface{}) bool {
+		v.startTask(ctx, value.(*OCRTask))
+		return true
+	})
+
+	// Cleanup the histories by retention policy.
+	// 按保留策略清理 OCR 历史记录。
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.Run(ctx); err != nil {
-				logger.Wf(ctx, "ocr: run task %v err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 3 * time.Second
+			if err := v.cleanupHistories(ctx); err != nil {
+				logger.Wf(ctx, "ocr: cleanup histories err %+v", err)
 			}
 
 			select {
 			case <-ctx.Done():
-			case <-time.After(duration):
+			case <-time.After(10 * time.Minute):
 			}
 		}
 	}()
@@ -694,84 +1020,39 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Consume all ts files by task.
-	// 启动消费 TS 文件的 goroutine。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			select {
-			case <-ctx.Done():
-			case msg := <-v.tsfiles:
-				if err := task.OnTsSegment(ctx, msg); err != nil {
-					logger.Wf(ctx, "ocr: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
-				}
-			}
-		}
-	}()
-
-	// Watch for new stream.
-	// 启动监听新流的 goroutine。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	return nil
+}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.WatchNewStream(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v watch new stream err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+// startTask start the goroutines for task, which quit when worker closed or task removed.
+func (v *OCRWorker) startTask(ctx context.Context, task *OCRTask) {
+	wg := &task.wg
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	ctx, task.cancelTask = context.WithCancel(ctx)
+	task.taskCtx = ctx
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "ocr: start task %v", task.String())
 
-	// Drive the live queue to OCR.
-	// 启动驱动直播队列到 OCR 队列的 goroutine。
-	wg.Add(1)
+	// The worker waits for all goroutines of task to quit.
+	v.wg.Add(1)
 	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveLiveQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive live queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
-
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
+		defer v.wg.Done()
+		<-ctx.Done()
+		task.wg.Wait()
 	}()
 
-	// Drive the OCR queue to correct queue.
-	// 启动驱动 OCR 队列到回调队列的 goroutine。
+	// Run the OCR task.
+	// 启动 OCR 任务处理 goroutine。
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			var duration time.Duration
-			if err := task.DriveOCRQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive ocr queue err %+v", task.String(), err)
+			if err := task.Run(ctx); err != nil {
+				logger.Wf(ctx, "ocr: run task %v err %+v", task.String(), err)
 				duration = 10 * time.Second
 			} else {
-				duration = 200 * time.Millisecond
+				duration = 3 * time.Second
 			}
 
 			select {
@@ -781,58 +1062,85 @@ func (v *OCRWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Drive the callback queue, notify user's service.
-	// 启动驱动回调队列，通知用户服务的 goroutine。
+	// Consume all ts files by task.
+	// 启动消费 TS 文件的 goroutine。
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveCallbackQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive callback queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
-
 			select {
 			case <-ctx.Done():
-			case <-time.After(duration):
+			case msg := <-task.tsfiles:
+				if err := task.OnTsSegment(ctx, msg); err != nil {
+					logger.Wf(ctx, "ocr: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
+				}
 			}
 		}
 	}()
 
-	// Drive the cleanup queue, remove old files.
-	// 启动驱动清理队列，移除旧文件的 goroutine。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveCleanupQueue(ctx); err != nil {
-				logger.Wf(ctx, "ocr: task %v drive cleanup queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	// Watch for new stream, and drive the queues of task.
+	// 监听新流，并驱动任务的各个队列。
+	for _, e := range []struct {
+		name string
+		pfn  func(ctx context.Context) error
+	}{
+		{name: "watch new stream", pfn: task.WatchNewStream},
+		{name: "drive live queue", pfn: task.DriveLiveQueue},
+		{name: "drive ocr queue", pfn: task.DriveOCRQueue},
+		{name: "drive callback queue", pfn: task.DriveCallbackQueue},
+		{name: "drive cleanup queue", pfn: task.DriveCleanupQueue},
+	} {
+		name, pfn := e.name, e.pfn
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				var duration time.Duration
+				if err := pfn(ctx); err != nil {
+					logger.Wf(ctx, "ocr: task %v %v err %+v", task.String(), name, err)
+					duration = 10 * time.Second
+				} else {
+					duration = 200 * time.Millisecond
+				}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
+				select {
+				case <-ctx.Done():
+				case <-time.After(duration):
+				}
 			}
-		}
-	}()
-
-	return nil
+		}()
+	}
 }
 
 type OCRConfig struct {
+	// The app and stream of task, empty for the global task which OCR the latest active stream.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
 	// Whether ocr all streams.
 	All bool `json:"all"`
+	// The interval in seconds to sample a frame, zero to sample one frame for each segment.
+	Interval float64 `json:"interval"`
+	// The regions to crop from the frame, each region is recognized separately. Use the whole
+	// frame if empty.
+	Regions []*OCRRegion `json:"regions,omitempty"`
+	// The JSON schema of the structured ocr result, see OCRSchema. Reply free text if empty.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// The parsed schema, built by Load.
+	schema *OCRSchema
+	// The OCR provider, openai, compatible or tesseract. Default to openai.
+	OCRProvider string `json:"ocrProvider,omitempty"`
+	// For compatible provider, the base URL of the OpenAI-compatible vision server.
+	OCRURL string `json:"ocrURL,omitempty"`
+	// For compatible provider, the optional token of server.
+	OCRToken string `json:"ocrToken,omitempty"`
+	// For tesseract provider, the languages, for example, eng+chi_sim.
+	OCRLanguage string `json:"ocrLanguage,omitempty"`
+	// The price in USD per 1M input and output tokens, to estimate the cost of each frame.
+	OCRInputPrice  float64 `json:"ocrInputPrice,omitempty"`
+	OCROutputPrice float64 `json:"ocrOutputPrice,omitempty"`
 	// The AI service provider.
 	SrsAssistantProvider
 	// The AI chat configuration.
@@ -847,31 +1155,119 @@ func NewOCRConfig() *OCRConfig {
 }
 
 func (v OCRConfig) String() string {
-	return fmt.Sprintf("all=%v, provider=<%v>, chat=<%v>",
-		v.All, v.SrsAssistantProvider.String(), v.SrsAssistantChat.String(),
+	return fmt.Sprintf("app=%v, stream=%v, all=%v, interval=%v, regions=%v, schema=%vB, ocr=<%v>, provider=<%v>, chat=<%v>",
+		v.App, v.Stream, v.All, v.Interval, len(v.Regions), len(v.Schema), v.ocrProviderConfig(),
+		v.SrsAssistantProvider.String(), v.SrsAssistantChat.String(),
 	)
 }
 
+// Build the config of OCR provider, the model of chat is used by openai and compatible provider.
+func (v *OCRConfig) ocrProviderConfig() *OCRProviderConfig {
+	aiConfig := openai.DefaultConfig(v.AISecretKey)
+	aiConfig.BaseURL = v.AIBaseURL
+	aiConfig.OrgID = v.AIOrganization
+
+	return &OCRProviderConfig{
+		Provider: v.OCRProvider, Model: v.AIChatModel, URL: v.OCRURL, Token: v.OCRToken,
+		Language: v.OCRLanguage, InputPrice: v.OCRInputPrice, OutputPrice: v.OCROutputPrice, AI: aiConfig,
+	}
+}
+
+// Load the config of the task, by the app and stream of config.
 func (v *OCRConfig) Load(ctx context.Context) error {
-	if b, err := rdb.HGet(ctx, SRS_OCR_CONFIG, "global").Result(); err != nil && err != redis.Nil {
-		return errors.Wrapf(err, "hget %v global", SRS_OCR_CONFIG)
+	key := ocrTaskKey(v.App, v.Stream)
+	if b, err := rdb.HGet(ctx, SRS_OCR_CONFIG, key).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_OCR_CONFIG, key)
 	} else if len(b) > 0 {
 		if err := json.Unmarshal([]byte(b), v); err != nil {
 			return errors.Wrapf(err, "unmarshal %v", b)
 		}
 	}
+
+	// Parse the schema once, which is used for each frame.
+	v.schema = nil
+	if len(v.Schema) > 0 {
+		schema, err := parseOCRSchema(v.Schema)
+		if err != nil {
+			return errors.Wrapf(err, "parse schema")
+		}
+		v.schema = schema
+	}
 	return nil
 }
 
 func (v *OCRConfig) Save(ctx context.Context) error {
+	key := ocrTaskKey(v.App, v.Stream)
 	if b, err := json.Marshal(v); err != nil {
 		return errors.Wrapf(err, "marshal conf %v", v)
-	} else if err := rdb.HSet(ctx, SRS_OCR_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
-		return errors.Wrapf(err, "hset %v global %v", SRS_OCR_CONFIG, string(b))
+	} else if err := rdb.HSet(ctx, SRS_OCR_CONFIG, key, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_OCR_CONFIG, key, string(b))
 	}
 	return nil
 }
 
+// OCRRegion is a crop region of the frame, in fractions of the frame size, for example, the
+// region {x:0, y:0.8, width:1, height:0.2} is the bottom 20% of frame, where the ticker is.
+type OCRRegion struct {
+	// The name of region, for example, scoreboard or ticker.
+	Name string `json:"name"`
+	// The left and top of region, in [0, 1).
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// The width and height of region, in (0, 1].
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (v OCRRegion) String() string {
+	return fmt.Sprintf("name=%v, x=%v, y=%v, width=%v, height=%v", v.Name, v.X, v.Y, v.Width, v.Height)
+}
+
+func (v *OCRRegion) validate() error {
+	if v.Name == "" {
+		return errors.New("no name")
+	}
+	if v.X < 0 || v.Y < 0 || v.Width <= 0 || v.Height <= 0 {
+		return errors.Errorf("invalid x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+	}
+	// Allow the rounding error of float, for example, 0.1+0.9.
+	if v.X+v.Width > 1+1e-9 || v.Y+v.Height > 1+1e-9 {
+		return errors.Errorf("out of frame x=%v, y=%v, width=%v, height=%v", v.X, v.Y, v.Width, v.Height)
+	}
+	return nil
+}
+
+// filter is the FFmpeg crop filter of region.
+func (v *OCRRegion) filter() string {
+	return fmt.Sprintf("crop=w=iw*%v:h=ih*%v:x=iw*%v:y=ih*%v", v.Width, v.Height, v.X, v.Y)
+}
+
+// ocrSampleOffsets get the offsets in the segment to sample frames, for a segment of duration, where
+// elapsed is the seconds since the last sampled frame, negative to sample at the start of segment. It
+// returns the offsets, and the elapsed seconds since the last sampled frame at the end of segment.
+func ocrSampleOffsets(elapsed, duration, interval float64) ([]float64, float64) {
+	// Sample one frame for each segment.
+	if interval <= 0 {
+		return []float64{0}, 0
+	}
+
+	// The offset of the next frame in the segment.
+	next := interval - elapsed
+	if elapsed < 0 || next < 0 {
+		next = 0
+	}
+
+	var offsets []float64
+	for ; next < duration; next += interval {
+		offsets = append(offsets, next)
+	}
+
+	if len(offsets) == 0 {
+		return nil, elapsed + duration
+	}
+	return offsets, duration - offsets[len(offsets)-1]
+}
+
 type OCRSegment struct {
 	// The SRS callback message msg.
 	Msg *SrsOnHlsMessage `json:"msg,omitempty"`
@@ -879,15 +1275,27 @@ type OCRSegment struct {
 	TsFile *TsFile `json:"tsfile,omitempty"`
 	// The extracted image file.
 	ImageFile *TsFile `json:"image,omitempty"`
+	// The name of region cropped from the frame, empty for the whole frame.
+	Region string `json:"region,omitempty"`
+	// The offset in seconds of the sampled frame in the TS file.
+	Offset float64 `json:"offset,omitempty"`
 	// The ocr result, by AI service.
 	OCRText string `json:"ocr,omitempty"`
+	// The structured ocr result, which matches the schema of config.
+	OCRObject json.RawMessage `json:"object,omitempty"`
+	// The error when the ocr result not match the schema.
+	OCRError string `json:"oce,omitempty"`
 	// The callback video file.
 	CallbackFile *TsFile `json:"callback,omitempty"`
 
 	// The cost to transcode the TS file to image file.
 	CostExtractImage time.Duration `json:"eic,omitempty"`
-	// The cost to do OCR, converting speech to text.
+	// The cost to do OCR, converting image to text, which is the latency of OCR provider.
 	CostOCR time.Duration `json:"ocrc,omitempty"`
+	// The tokens used by OCR provider, zero for local provider.
+	OCRTokens int `json:"ocrt,omitempty"`
+	// The estimated price in USD of OCR, zero for local provider.
+	OCRPrice float64 `json:"ocrp,omitempty"`
 	// The cost to callback the OCR result.
 	CostCallback time.Duration `json:"olc,omitempty"`
 }
@@ -902,11 +1310,18 @@ func (v OCRSegment) String() string {
 	}
 	if v.ImageFile != nil {
 		sb.WriteString(fmt.Sprintf("image=%v, ", v.ImageFile.String()))
+		sb.WriteString(fmt.Sprintf("region=%v, offset=%v, ", v.Region, v.Offset))
 		sb.WriteString(fmt.Sprintf("eac=%v, ", v.CostExtractImage))
 	}
 	if v.OCRText != "" {
 		sb.WriteString(fmt.Sprintf("ocr=%v, ", v.OCRText))
-		sb.WriteString(fmt.Sprintf("ocrc=%v, ", v.CostOCR))
+		if v.OCRObject != nil {
+			sb.WriteString(fmt.Sprintf("object=%v, ", string(v.OCRObject)))
+		}
+		if v.OCRError != "" {
+			sb.WriteString(fmt.Sprintf("oce=%v, ", v.OCRError))
+		}
+		sb.WriteString(fmt.Sprintf("ocrc=%v, ocrt=%v, ocrp=%v, ", v.CostOCR, v.OCRTokens, v.OCRPrice))
 	}
 	if v.CallbackFile != nil {
 		sb.WriteString(fmt.Sprintf("callback=%v, ", v.CallbackFile.String()))
@@ -1014,14 +1429,19 @@ func (v *OCRQueue) reset(ctx context.Context) error {
 type OCRTask struct {
 	// The ID for task.
 	UUID string `json:"uuid,omitempty"`
+	// The stream of task, empty for the global task.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
 
 	// The input url.
 	Input string `json:"input,omitempty"`
 	// The input stream object, select the active stream.
 	inputStream *SrsStream
 
-	// The chat history, to use as prompt for next chat.
-	histories []openai.ChatCompletionMessage
+	// The chat history of each region, to use as prompt for next chat.
+	histories map[string][]openai.ChatCompletionMessage
+	// The seconds since the last sampled frame, negative if no frame sampled.
+	sampleElapsed float64
 
 	// The live queue for the current task. HLS TS segments are copied to the ocr
 	// directory, then a segment is created and added to the live queue for the ocr
@@ -1041,6 +1461,8 @@ type OCRTask struct {
 	signalPersistence chan bool
 	// The signal to change the active stream for task.
 	signalNewStream chan *SrsStream
+	// Got message from SRS, a new TS segment file is generated.
+	tsfiles chan *SrsOnHlsObject
 
 	// The configure for ocr task.
 	config OCRConfig
@@ -1049,6 +1471,11 @@ type OCRTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// The context for all goroutines of task, cancel it to stop the task.
+	taskCtx    context.Context
+	cancelTask context.CancelFunc
+	// To wait for all goroutines of task to quit.
+	wg sync.WaitGroup
 
 	// To protect the common fields.
 	lock sync.Mutex
@@ -1070,13 +1497,19 @@ func NewOCRTask() *OCRTask {
 		signalPersistence: make(chan bool, 1),
 		// Create new stream signal.
 		signalNewStream: make(chan *SrsStream, 1),
+		// The TS files of task.
+		tsfiles: make(chan *SrsOnHlsObject, 1024),
+		// The chat histories of regions.
+		histories: make(map[string][]openai.ChatCompletionMessage),
+		// Sample the first frame immediately.
+		sampleElapsed: -1,
 	}
 }
 
 func (v *OCRTask) String() string {
-	return fmt.Sprintf("uuid=%v, live=%v, ocr=%v, callback=%v, cleanup=%v, config is %v",
-		v.UUID, v.LiveQueue.String(), v.OCRQueue.String(), v.CallbackQueue.String(), v.CleanupQueue.String(),
-		v.config.String(),
+	return fmt.Sprintf("uuid=%v, key=%v, live=%v, ocr=%v, callback=%v, cleanup=%v, config is %v",
+		v.UUID, v.key(), v.LiveQueue.String(), v.OCRQueue.String(), v.CallbackQueue.String(),
+		v.CleanupQueue.String(), v.config.String(),
 	)
 }
 
@@ -1084,6 +1517,9 @@ func (v *OCRTask) Run(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "ocr run task %v", v.String())
 
+	// The config is bound to the stream of task.
+	v.config.App, v.config.Stream = v.App, v.Stream
+
 	pfn := func(ctx context.Context) error {
 		// Load config from redis.
 		if err := v.config.Load(ctx); err != nil {
@@ -1182,6 +1618,9 @@ func (v *OCRTask) WatchNewStream(ctx context.Context) error {
 			return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
 		}
 
+		// The global task ignores the streams of other tasks, while the task of stream only use its stream.
+		boundStreams := v.ocrWorker.boundStreams()
+
 		var best *SrsStream
 		for _, value := range streams {
 			var stream SrsStream
@@ -1189,6 +1628,14 @@ func (v *OCRTask) WatchNewStream(ctx context.Context) error {
 				return nil, errors.Wrapf(err, "unmarshal %v", value)
 			}
 
+			if v.App != "" || v.Stream != "" {
+				if stream.App != v.App || stream.Stream != v.Stream {
+					continue
+				}
+			} else if boundStreams[ocrTaskKey(stream.App, stream.Stream)] {
+				continue
+			}
+
 			if best == nil {
 				best = &stream
 				continue
@@ -1284,45 +1731,94 @@ func (v *OCRTask) DriveLiveQueue(ctx context.Context) error {
 		return nil
 	}
 
-	// Transcode to image file, such as jpg.
-	imageFile := &TsFile{
-		TsID:     fmt.Sprintf("%v-image-%v", segment.TsFile.SeqNo, uuid.NewString()),
-		URL:      segment.TsFile.URL,
-		SeqNo:    segment.TsFile.SeqNo,
-		Duration: segment.TsFile.Duration,
-	}
-	imageFile.File = path.Join("ocr", fmt.Sprintf("%v.jpg", imageFile.TsID))
+	// Select the frames to sample by interval, drop the TS file if no frame in it.
+	offsets, elapsed := ocrSampleOffsets(v.sampleElapsed, segment.TsFile.Duration, v.config.Interval)
+	if len(offsets) == 0 {
+		v.sampleElapsed = elapsed
+		func() {
+			v.lock.Lock()
+			defer v.lock.Unlock()
+			v.LiveQueue.dequeue(segment)
+		}()
 
-	// TODO: FIXME: We should generate a set of images and use the best one.
-	args := []string{
-		"-i", segment.TsFile.File,
-		"-frames:v", "1", "-q:v", "10",
-		"-y", imageFile.File,
+		segment.Dispose()
+		logger.Tf(ctx, "ocr: skip ts segment %v, elapsed=%v, interval=%v", segment.String(), elapsed, v.config.Interval)
+		return nil
 	}
-	if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
-		return errors.Wrapf(err, "transcode %v", args)
+
+	// Use the whole frame if no region.
+	regions := v.config.Regions
+	if len(regions) == 0 {
+		regions = []*OCRRegion{nil}
 	}
 
-	// Update the size of image file.
-	stats, err := os.Stat(imageFile.File)
-	if err != nil {
-		// TODO: FIXME: Cleanup the failed file.
-		return errors.Wrapf(err, "stat file %v", imageFile.File)
+	// Transcode each sampled frame and region to image file, such as jpg.
+	var segments []*OCRSegment
+	if err := func() error {
+		for _, offset := range offsets {
+			for _, region := range regions {
+				imageFile := &TsFile{
+					TsID:     fmt.Sprintf("%v-image-%v", segment.TsFile.SeqNo, uuid.NewString()),
+					URL:      segment.TsFile.URL,
+					SeqNo:    segment.TsFile.SeqNo,
+					Duration: segment.TsFile.Duration,
+				}
+				imageFile.File = path.Join("ocr", fmt.Sprintf("%v.jpg", imageFile.TsID))
+
+				// TODO: FIXME: We should generate a set of images and use the best one.
+				args := []string{
+					"-ss", fmt.Sprintf("%.3f", offset), "-i", segment.TsFile.File,
+					"-frames:v", "1",
+				}
+				if region != nil {
+					args = append(args, "-vf", region.filter())
+				}
+				args = append(args, "-q:v", "10", "-y", imageFile.File)
+				if err := exec.CommandContext(ctx, "ffmpeg", args...).Run(); err != nil {
+					return errors.Wrapf(err, "transcode %v", args)
+				}
+
+				// Update the size of image file.
+				stats, err := os.Stat(imageFile.File)
+				if err != nil {
+					return errors.Wrapf(err, "stat file %v", imageFile.File)
+				}
+				imageFile.Size = uint64(stats.Size())
+
+				s := &OCRSegment{Msg: segment.Msg, TsFile: segment.TsFile, ImageFile: imageFile, Offset: offset}
+				if region != nil {
+					s.Region = region.Name
+				}
+				segments = append(segments, s)
+			}
+		}
+		return nil
+	}(); err != nil {
+		for _, s := range segments {
+			s.Dispose()
+		}
+		return err
 	}
-	imageFile.Size = uint64(stats.Size())
 
-	// Dequeue the segment from live queue and attach to OCR queue.
+	// Update the sampled position only when images extracted, so the failed segment is sampled again.
+	v.sampleElapsed = elapsed
+
+	// Dequeue the segment from live queue and attach the images to OCR queue.
 	func() {
 		v.lock.Lock()
 		defer v.lock.Unlock()
 
 		v.LiveQueue.dequeue(segment)
-		segment.ImageFile = imageFile
-		segment.CostExtractImage = time.Since(starttime)
-		v.OCRQueue.enqueue(segment)
+		for _, s := range segments {
+			s.CostExtractImage = time.Since(starttime)
+			v.OCRQueue.enqueue(s)
+		}
 	}()
-	logger.Tf(ctx, "ocr: extract image %v to %v, size=%v, cost=%v",
-		segment.TsFile.File, imageFile.File, imageFile.Size, segment.CostExtractImage)
+	logger.Tf(ctx, "ocr: extract %v images from %v, offsets=%v, regions=%v, cost=%v",
+		len(segments), segment.TsFile.File, offsets, len(v.config.Regions), time.Since(starttime))
+
+	// The TS file is not used after images extracted.
+	segment.Dispose()
 
 	// Notify the main loop to persistent current task.
 	v.notifyPersistence(ctx)
@@ -1360,57 +1856,50 @@ func (v *OCRTask) DriveOCRQueue(ctx context.Context) error {
 		return nil
 	}
 
-	// Read the image file and convert to base64.
-	var imageData string
-	if data, err := os.ReadFile(segment.ImageFile.File); err != nil {
-		return errors.Wrapf(err, "read image from %v", segment.ImageFile.File)
-	} else {
-		imageData = base64.StdEncoding.EncodeToString(data)
-	}
-
-	// Convert the image file to text by AI.
-	var config openai.ClientConfig
-	config = openai.DefaultConfig(v.config.AISecretKey)
-	config.BaseURL = v.config.AIBaseURL
-	config.OrgID = v.config.AIOrganization
-
 	prompt := v.config.AIChatPrompt
 	system := fmt.Sprintf("Keep your reply neat, limiting the reply to %v words.", v.config.AIChatMaxWords)
 
-	messages := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleSystem, Content: system},
+	// For structured OCR, reply a JSON object which matches the schema.
+	var err error
+	schema := v.config.schema
+	var responseFormat *openai.ChatCompletionResponseFormat
+	if schema != nil {
+		system = fmt.Sprintf("Reply a JSON object only, without any other text, which must match the JSON schema: %v",
+			string(v.config.Schema))
+		responseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
 	}
 
-	messages = append(messages, v.histories...)
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser, Content: prompt,
-	})
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
-			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{
-				Detail: openai.ImageURLDetailLow, URL: fmt.Sprintf("data:image/jpeg;base64,%v", imageData),
-			}},
-		},
+	// Convert the image file to text by OCR provider.
+	providerConfig := v.config.ocrProviderConfig()
+	resp, err := NewOCRProvider(providerConfig).Recognize(ctx, &OCRRequest{
+		ImageFile: segment.ImageFile.File, System: system, Prompt: prompt,
+		Histories: v.histories[segment.Region], ResponseFormat: responseFormat,
 	})
-
-	client := openai.NewClientWithConfig(config)
-	resp, err := client.CreateChatCompletion(
-		ctx, openai.ChatCompletionRequest{
-			Model: v.config.AIChatModel, Messages: messages,
-		},
-	)
 	if err != nil {
-		return errors.Wrapf(err, "AI process, model=%v, image=%v, messages=%v, system=<%v>, prompt=<%v>",
-			v.config.AIChatModel, segment.ImageFile.File, len(messages), system, prompt,
+		return errors.Wrapf(err, "OCR process, provider=<%v>, image=%v, system=<%v>, prompt=<%v>",
+			providerConfig, segment.ImageFile.File, system, prompt,
 		)
 	}
 
-	segment.OCRText = resp.Choices[0].Message.Content
+	segment.OCRText = resp.Text
+	segment.OCRTokens = resp.PromptTokens + resp.CompletionTokens
+	segment.OCRPrice = resp.Price
 	segment.CostOCR = time.Since(starttime)
 
-	// Build the historical messages.
-	if segment.OCRText != "" {
-		v.histories = append(v.histories, openai.ChatCompletionMessage{
+	// Validate the structured result, keep the text for user to check, if not match the schema.
+	if schema != nil {
+		if segment.OCRObject, err = schema.parseObject(segment.OCRText); err != nil {
+			segment.OCRError = err.Error()
+			logger.Wf(ctx, "ocr: invalid object of image=%v, region=%v, err %+v",
+				segment.ImageFile.File, segment.Region, err)
+		}
+	}
+
+	// Build the historical messages, ignore the invalid object, to avoid the model follows the wrong reply.
+	if segment.OCRText != "" && segment.OCRError == "" {
+		histories := append(v.histories[segment.Region], openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleUser,
 			Content: prompt,
 		}, openai.ChatCompletionMessage{
@@ -1418,9 +1907,10 @@ func (v *OCRTask) DriveOCRQueue(ctx context.Context) error {
 			Content: segment.OCRText,
 		})
 
-		for len(v.histories) > v.config.AIChatMaxWindow*2 {
-			v.histories = v.histories[1:]
+		for len(histories) > v.config.AIChatMaxWindow*2 {
+			histories = histories[1:]
 		}
+		v.histories[segment.Region] = histories
 	}
 
 	// Dequeue the segment from OCR queue and attach to correct queue.
@@ -1434,8 +1924,9 @@ func (v *OCRTask) DriveOCRQueue(ctx context.Context) error {
 		defer v.lock.Unlock()
 		v.CallbackQueue.enqueue(segment)
 	}()
-	logger.Tf(ctx, "ocr: recognize image=%v, model=%v, prompt=%v, text=%v, cost=%v",
-		segment.ImageFile.File, v.config.AIChatModel, prompt, segment.OCRText, segment.CostOCR)
+	logger.Tf(ctx, "ocr: recognize image=%v, region=%v, provider=<%v>, prompt=%v, text=%v, object=%v, tokens=%v, price=%v, cost=%v",
+		segment.ImageFile.File, segment.Region, providerConfig, prompt, segment.OCRText, string(segment.OCRObject),
+		segment.OCRTokens, segment.OCRPrice, segment.CostOCR)
 
 	// Notify the main loop to persistent current task.
 	v.notifyPersistence(ctx)
@@ -1461,8 +1952,13 @@ func (v *OCRTask) DriveCallbackQueue(ctx context.Context) error {
 	segment := v.CallbackQueue.first()
 	starttime := time.Now()
 
+	// Persist the OCR result, before the segment is disposed.
+	if err := v.saveHistory(ctx, segment); err != nil {
+		logger.Wf(ctx, "ocr: ignore save history %v err %+v", segment.String(), err)
+	}
+
 	// Do callback to notify user's service.
-	if err := callbackWorker.OnOCR(ctx, SrsActionOnOcr, v.UUID, segment.Msg, v.config.AIChatPrompt, segment.OCRText); err != nil {
+	if err := callbackWorker.OnOCR(ctx, SrsActionOnOcr, v.UUID, segment.Msg, segment.Region, v.config.AIChatPrompt, segment.OCRText, segment.OCRObject); err != nil {
 		logger.Wf(ctx, "ocr: ignore callback %v err %+v", segment.String(), err)
 	}
 
@@ -1554,6 +2050,8 @@ func (v *OCRTask) reset(ctx context.Context) error {
 
 		// Reset all states.
 		v.Input = ""
+		v.histories = make(map[string][]openai.ChatCompletionMessage)
+		v.sampleElapsed = -1
 
 		// Remove previous task from redis.
 		if err := rdb.HDel(ctx, SRS_OCR_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
@@ -1579,6 +2077,52 @@ func (v *OCRTask) reset(ctx context.Context) error {
 	return nil
 }
 
+// key is the key of task, see ocrTaskKey.
+func (v *OCRTask) key() string {
+	return ocrTaskKey(v.App, v.Stream)
+}
+
+func (v *OCRTask) taskUUID() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.UUID
+}
+
+// stop all goroutines of task, and wait for them to quit.
+func (v *OCRTask) stop() {
+	if v.cancelTask != nil {
+		v.cancelTask()
+	}
+	v.wg.Wait()
+}
+
+// remove the files, task and config of the task, after stopped.
+func (v *OCRTask) remove(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.LiveQueue.reset(ctx)
+	v.OCRQueue.reset(ctx)
+	v.CallbackQueue.reset(ctx)
+	v.CleanupQueue.reset(ctx)
+
+	// Remove the ts files which are not consumed by task.
+	for len(v.tsfiles) > 0 {
+		if msg := <-v.tsfiles; msg.TsFile != nil {
+			os.Remove(msg.TsFile.File)
+		}
+	}
+
+	if err := rdb.HDel(ctx, SRS_OCR_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_OCR_TASK, v.UUID)
+	}
+	if err := rdb.HDel(ctx, SRS_OCR_CONFIG, v.key()).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_OCR_CONFIG, v.key())
+	}
+	return nil
+}
+
 func (v *OCRTask) enabled() bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
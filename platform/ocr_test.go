@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOCR_SampleOffsetsAndRegion(t *testing.T) {
+	// Sample one frame for each segment, if no interval.
+	if offsets, elapsed := ocrSampleOffsets(-1, 10, 0); len(offsets) != 1 || offsets[0] != 0 || elapsed != 0 {
+		t.Errorf("invalid offsets %v, elapsed %v", offsets, elapsed)
+	}
+
+	// Sample the first frame immediately, then every interval across segments.
+	offsets, elapsed := ocrSampleOffsets(-1, 10, 4)
+	if len(offsets) != 3 || offsets[0] != 0 || offsets[1] != 4 || offsets[2] != 8 || elapsed != 2 {
+		t.Errorf("invalid offsets %v, elapsed %v", offsets, elapsed)
+	}
+	offsets, elapsed = ocrSampleOffsets(elapsed, 10, 4)
+	if len(offsets) != 2 || offsets[0] != 2 || offsets[1] != 6 || elapsed != 4 {
+		t.Errorf("invalid offsets %v, elapsed %v", offsets, elapsed)
+	}
+	if offsets, elapsed = ocrSampleOffsets(1, 2, 10); len(offsets) != 0 || elapsed != 3 {
+		t.Errorf("invalid offsets %v, elapsed %v", offsets, elapsed)
+	}
+
+	region := &OCRRegion{Name: "ticker", X: 0.1, Y: 0.8, Width: 0.9, Height: 0.2}
+	if err := region.validate(); err != nil {
+		t.Errorf("invalid region %v err %v", region.String(), err)
+	}
+	if filter := region.filter(); filter != "crop=w=iw*0.9:h=ih*0.2:x=iw*0.1:y=ih*0.8" {
+		t.Errorf("invalid filter %v", filter)
+	}
+	if err := (&OCRRegion{Name: "bad", X: 0.5, Y: 0, Width: 0.6, Height: 1}).validate(); err == nil {
+		t.Errorf("should fail for region out of frame")
+	}
+}
@@ -245,6 +245,10 @@ func handleHTTPService(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle ocr")
 	}
 
+	if err := detectWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle detect")
+	}
+
 	if err := transcodeWorker.Handle(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle transcode")
 	}
@@ -49,6 +49,10 @@ const (
 
 	// The on_ocr action.
 	SrsActionOnOcr = "on_ocr"
+	// The on_transcript_alert action.
+	SrsActionOnTranscriptAlert = "on_transcript_alert"
+	// The on_detect action, for scene change, black, frozen frames or silence.
+	SrsActionOnDetect = "on_detect"
 )
 
 func handleHooksService(ctx context.Context, handler *http.ServeMux) error {
@@ -734,9 +738,9 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 			logger.Tf(ctx, "on_hls ok, %v", string(b))
 
 			// Handle TS file by Record task if enabled.
-			if recordAll, err := rdb.HGet(ctx, SRS_RECORD_PATTERNS, "all").Result(); err != nil && err != redis.Nil {
-				return errors.Wrapf(err, "hget %v all", SRS_RECORD_PATTERNS)
-			} else if recordAll == "true" {
+			if _, recordEnabled, err := queryRecordEnabled(ctx, msg.App, msg.Stream); err != nil {
+				return errors.Wrapf(err, "query record")
+			} else if recordEnabled {
 				if err = recordWorker.OnHlsTsMessage(ctx, &msg); err != nil {
 					return errors.Wrapf(err, "feed %v", msg.String())
 				}
@@ -779,6 +783,14 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 				logger.Tf(ctx, "ocr %v", msg.String())
 			}
 
+			// Handle TS file by detect worker if enabled.
+			if detectWorker.Enabled() {
+				if err = detectWorker.OnHlsTsMessage(ctx, &msg); err != nil {
+					return errors.Wrapf(err, "feed %v", msg.String())
+				}
+				logger.Tf(ctx, "detect %v", msg.String())
+			}
+
 			ohttp.WriteData(ctx, w, r, nil)
 			return nil
 		}(); err != nil {
@@ -790,6 +802,18 @@ func handleOnHls(ctx context.Context, handler *http.ServeMux) error {
 		return errors.Wrapf(err, "handle record")
 	}
 
+	if err := archiveWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle archive")
+	}
+
+	if err := recordTranscriptWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle record transcript")
+	}
+
+	if err := mergeWorker.Handle(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle merge")
+	}
+
 	if err := dvrWorker.Handle(ctx, handler); err != nil {
 		return errors.Wrapf(err, "handle dvr")
 	}
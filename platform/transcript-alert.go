@@ -0,0 +1,465 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The max number of alerts to keep in SRS_TRANSCRIPT_ALERT, the oldest alerts are trimmed.
+const maxTranscriptAlerts = 10000
+
+// The number of alerts to load in a batch, when querying the alerts.
+const transcriptAlertsPageSize = 1000
+
+// TranscriptAlertRule is a rule to match the live transcript, saved in SRS_TRANSCRIPT_ALERT_RULE.
+type TranscriptAlertRule struct {
+	// The rule UUID.
+	UUID string `json:"uuid"`
+	// The name of rule, for example, compliance or product.
+	Name string `json:"name"`
+	// The stream to match, empty to match all streams.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	// The keywords or phrases to match, case insensitive.
+	Keywords []string `json:"keywords,omitempty"`
+	// The regular expressions to match.
+	Patterns []string `json:"patterns,omitempty"`
+	// Whether the rule is enabled.
+	Enabled bool `json:"enabled"`
+
+	// The compiled regular expressions of patterns, built by validate.
+	regexps []*regexp.Regexp
+}
+
+func (v TranscriptAlertRule) String() string {
+	return fmt.Sprintf("uuid=%v, name=%v, app=%v, stream=%v, keywords=%v, patterns=%v, enabled=%v",
+		v.UUID, v.Name, v.App, v.Stream, len(v.Keywords), len(v.Patterns), v.Enabled)
+}
+
+// validate the rule, and compile the regular expressions for match.
+func (v *TranscriptAlertRule) validate() error {
+	if len(v.Keywords) == 0 && len(v.Patterns) == 0 {
+		return errors.Errorf("no keywords or patterns")
+	}
+
+	regexps := make([]*regexp.Regexp, 0, len(v.Patterns))
+	for _, pattern := range v.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %v", pattern)
+		}
+		regexps = append(regexps, re)
+	}
+	v.regexps = regexps
+	return nil
+}
+
+// match the text, return the matched keywords or texts of patterns, nil if not matched.
+func (v *TranscriptAlertRule) match(app, stream, text string) []string {
+	if !v.Enabled || (v.App != "" && v.App != app) || (v.Stream != "" && v.Stream != stream) {
+		return nil
+	}
+
+	var matches []string
+	lowerText := strings.ToLower(text)
+	for _, keyword := range v.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" && strings.Contains(lowerText, strings.ToLower(keyword)) {
+			matches = append(matches, keyword)
+		}
+	}
+	for _, re := range v.regexps {
+		matches = append(matches, re.FindAllString(text, -1)...)
+	}
+	return matches
+}
+
+// TranscriptAlert is a matched alert of transcript, saved in SRS_TRANSCRIPT_ALERT.
+type TranscriptAlert struct {
+	// The alert UUID.
+	UUID string `json:"uuid"`
+	// The rule which is matched.
+	RuleUUID string `json:"rule"`
+	RuleName string `json:"name"`
+	// The transcript task UUID.
+	TaskUUID string `json:"task"`
+	// The stream of alert.
+	Vhost  string `json:"vhost,omitempty"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	// The wallclock time of the matched text, in ISO 8601 format.
+	Time string `json:"time"`
+	// The matched keywords or texts, and the full text of ASR segment.
+	Matches []string `json:"matches"`
+	Text    string   `json:"text"`
+	// The clip reference, the TS url of SRS, and the offset and duration in seconds of text in TS.
+	TsURL    string  `json:"ts"`
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+	// The UUID of record artifact, if recording is on.
+	RecordUUID string `json:"record,omitempty"`
+	// The create time, in RFC3339.
+	CreatedAt string `json:"created"`
+}
+
+func (v TranscriptAlert) String() string {
+	return fmt.Sprintf("uuid=%v, rule=%v, name=%v, task=%v, app=%v, stream=%v, time=%v, matches=%v, ts=%v, offset=%v, record=%v",
+		v.UUID, v.RuleUUID, v.RuleName, v.TaskUUID, v.App, v.Stream, v.Time, v.Matches, v.TsURL, v.Offset, v.RecordUUID)
+}
+
+// queryTranscriptAlertRules load all the alert rules.
+func queryTranscriptAlertRules(ctx context.Context) ([]*TranscriptAlertRule, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_TRANSCRIPT_ALERT_RULE).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_TRANSCRIPT_ALERT_RULE)
+	}
+
+	var rules []*TranscriptAlertRule
+	for uuid, b := range objs {
+		var rule TranscriptAlertRule
+		if err := json.Unmarshal([]byte(b), &rule); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", uuid, b)
+		}
+		if err := rule.validate(); err != nil {
+			return nil, errors.Wrapf(err, "validate %v", rule.String())
+		}
+		rules = append(rules, &rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules, nil
+}
+
+// TranscriptAlertRules is the cache of alert rules, to avoid loading the rules for each segment. It's reset
+// when rules are applied or removed, and reloaded when used.
+type TranscriptAlertRules struct {
+	// The cached rules, valid only when loaded.
+	rules  []*TranscriptAlertRule
+	loaded bool
+	// To protect the fields.
+	lock sync.Mutex
+}
+
+var transcriptAlertRules = &TranscriptAlertRules{}
+
+// Load the cached rules, or load from redis if not loaded.
+func (v *TranscriptAlertRules) Load(ctx context.Context) ([]*TranscriptAlertRule, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.loaded {
+		return v.rules, nil
+	}
+
+	rules, err := queryTranscriptAlertRules(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query rules")
+	}
+
+	v.rules, v.loaded = rules, true
+	return rules, nil
+}
+
+// Reset the cached rules, to reload when used.
+func (v *TranscriptAlertRules) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.rules, v.loaded = nil, false
+}
+
+// queryTranscriptAlerts load the alerts which matches the filter, the latest first, at most limit alerts.
+func queryTranscriptAlerts(ctx context.Context, limit int, filter func(alert *TranscriptAlert) bool) ([]*TranscriptAlert, error) {
+	alerts := []*TranscriptAlert{}
+	for start := int64(0); len(alerts) < limit; start += transcriptAlertsPageSize {
+		objs, err := rdb.LRange(ctx, SRS_TRANSCRIPT_ALERT, start, start+transcriptAlertsPageSize-1).Result()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrapf(err, "lrange %v %v", SRS_TRANSCRIPT_ALERT, start)
+		}
+
+		for _, b := range objs {
+			var alert TranscriptAlert
+			if err := json.Unmarshal([]byte(b), &alert); err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %v", b)
+			}
+			if filter(&alert) && len(alerts) < limit {
+				alerts = append(alerts, &alert)
+			}
+		}
+
+		if len(objs) < transcriptAlertsPageSize {
+			break
+		}
+	}
+	return alerts, nil
+}
+
+// saveTranscriptAlerts save the alerts, the latest first, and trim the oldest ones in one transaction.
+func saveTranscriptAlerts(ctx context.Context, alerts []*TranscriptAlert) error {
+	var values []interface{}
+	for _, alert := range alerts {
+		if b, err := json.Marshal(alert); err != nil {
+			return errors.Wrapf(err, "marshal %v", alert.String())
+		} else {
+			values = append(values, string(b))
+		}
+	}
+
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, SRS_TRANSCRIPT_ALERT, values...)
+		pipe.LTrim(ctx, SRS_TRANSCRIPT_ALERT, 0, maxTranscriptAlerts-1)
+		return nil
+	}); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "lpush %v %v", SRS_TRANSCRIPT_ALERT, len(values))
+	}
+	return nil
+}
+
+// matchAlerts match the ASR text of segment with the alert rules, save the alerts and notify by callback.
+func (v *TranscriptTask) matchAlerts(ctx context.Context, segment *TranscriptSegment) error {
+	if segment.Msg == nil || segment.TsFile == nil || segment.AsrText == nil {
+		return nil
+	}
+
+	rules, err := transcriptAlertRules.Load(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "load rules")
+	}
+
+	msg := segment.Msg
+	starttime, err := time.Parse(programDateTimeLayout, segment.TsFile.ProgramDateTime)
+	if err != nil {
+		starttime = time.Now()
+	}
+
+	var alerts []*TranscriptAlert
+	for _, as := range segment.AsrText.Segments {
+		// Match the corrected text, because the keywords are generally the vocabulary which is misheard.
+		text := v.config.correctText(as.Text)
+		for _, rule := range rules {
+			matches := rule.match(msg.App, msg.Stream, text)
+			if len(matches) == 0 {
+				continue
+			}
+
+			alerts = append(alerts, &TranscriptAlert{
+				UUID: uuid.NewString(), RuleUUID: rule.UUID, RuleName: rule.Name, TaskUUID: v.UUID,
+				Vhost: msg.Vhost, App: msg.App, Stream: msg.Stream,
+				Time:    starttime.Add(time.Duration(as.Start * float64(time.Second))).Format(programDateTimeLayout),
+				Matches: matches, Text: strings.TrimSpace(text),
+				TsURL: msg.URL, Offset: as.Start, Duration: as.End - as.Start,
+				CreatedAt: time.Now().Format(time.RFC3339),
+			})
+		}
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	// Link to the record artifact, to review the clip after stream is done.
+	recordUUID, err := queryRecordUUIDOfStream(ctx, msg.M3u8URL)
+	if err != nil {
+		logger.Wf(ctx, "transcript: ignore query record err %+v", err)
+	}
+
+	for _, alert := range alerts {
+		alert.RecordUUID = recordUUID
+		logger.Tf(ctx, "transcript: alert %v", alert.String())
+	}
+
+	if err := saveTranscriptAlerts(ctx, alerts); err != nil {
+		return errors.Wrapf(err, "save alerts")
+	}
+
+	// Notify by callback asynchronously, because the callback is slow and should not block the transcription.
+	go func() {
+		for _, alert := range alerts {
+			if err := callbackWorker.OnTranscriptAlert(ctx, SrsActionOnTranscriptAlert, alert); err != nil {
+				logger.Wf(ctx, "transcript: ignore alert callback %v err %+v", alert.String(), err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (v *TranscriptWorker) handleAlertService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/transcript/alert/rules"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+			}{
+				Token: &token,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			rules, err := queryTranscriptAlertRules(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query rules")
+			}
+			if rules == nil {
+				rules = []*TranscriptAlertRule{}
+			}
+
+			ohttp.WriteData(ctx, w, r, rules)
+			logger.Tf(ctx, "transcript alert rules ok, rules=%v, token=%vB", len(rules), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/alert/apply"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			rule := &TranscriptAlertRule{Enabled: true}
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				*TranscriptAlertRule
+			}{
+				Token: &token, TranscriptAlertRule: rule,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if err := rule.validate(); err != nil {
+				return errors.Wrapf(err, "validate %v", rule.String())
+			}
+
+			// Create a new rule if no uuid, or update the rule.
+			if rule.UUID == "" {
+				rule.UUID = uuid.NewString()
+			}
+
+			if b, err := json.Marshal(rule); err != nil {
+				return errors.Wrapf(err, "marshal %v", rule.String())
+			} else if err := rdb.HSet(ctx, SRS_TRANSCRIPT_ALERT_RULE, rule.UUID, string(b)).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hset %v %v", SRS_TRANSCRIPT_ALERT_RULE, rule.UUID)
+			}
+			transcriptAlertRules.Reset()
+
+			ohttp.WriteData(ctx, w, r, rule)
+			logger.Tf(ctx, "transcript alert apply ok, rule=<%v>, token=%vB", rule.String(), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/alert/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, ruleUUID string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &ruleUUID,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if ruleUUID == "" {
+				return errors.Errorf("empty uuid")
+			}
+
+			if err := rdb.HDel(ctx, SRS_TRANSCRIPT_ALERT_RULE, ruleUUID).Err(); err != nil && err != redis.Nil {
+				return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_ALERT_RULE, ruleUUID)
+			}
+			transcriptAlertRules.Reset()
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "transcript alert remove ok, uuid=%v, token=%vB", ruleUUID, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/alert/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream, ruleUUID string
+			var limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+				Rule   *string `json:"rule"`
+				// The max number of alerts, default to 100.
+				Limit *int `json:"limit"`
+			}{
+				Token: &token, App: &app, Stream: &stream, Rule: &ruleUUID, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if limit <= 0 {
+				limit = 100
+			}
+
+			// The latest alerts first.
+			alerts, err := queryTranscriptAlerts(ctx, limit, func(alert *TranscriptAlert) bool {
+				return (app == "" || alert.App == app) && (stream == "" || alert.Stream == stream) &&
+					(ruleUUID == "" || alert.RuleUUID == ruleUUID)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "query alerts")
+			}
+
+			ohttp.WriteData(ctx, w, r, alerts)
+			logger.Tf(ctx, "transcript alert query ok, app=%v, stream=%v, rule=%v, alerts=%v, token=%vB",
+				app, stream, ruleUUID, len(alerts), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscriptAlert_RuleMatch(t *testing.T) {
+	rule := &TranscriptAlertRule{
+		Stream: "livestream", Keywords: []string{"Refund"}, Patterns: []string{`\d{3}-\d{4}`}, Enabled: true,
+	}
+	if err := rule.validate(); err != nil {
+		t.Errorf("validate failed, %v", err)
+	}
+
+	if matches := rule.match("live", "livestream", "Ask for a refund at 555-1234"); strings.Join(matches, ",") != "Refund,555-1234" {
+		t.Errorf("invalid matches %v", matches)
+	}
+	if matches := rule.match("live", "other", "Ask for a refund"); len(matches) != 0 {
+		t.Errorf("should not match other stream, %v", matches)
+	}
+
+	rule.Enabled = false
+	if matches := rule.match("live", "livestream", "refund"); len(matches) != 0 {
+		t.Errorf("should not match disabled rule, %v", matches)
+	}
+
+	if err := (&TranscriptAlertRule{Patterns: []string{"("}}).validate(); err == nil {
+		t.Errorf("should fail for invalid pattern")
+	}
+}
@@ -0,0 +1,677 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	ohttp "github.com/ossrs/go-oryx-lib/http"
+	"github.com/ossrs/go-oryx-lib/logger"
+	// Use v8 because we use Go 1.16+, while v9 requires Go 1.18+
+	"github.com/go-redis/redis/v8"
+)
+
+// The session is finished if no new segment for this duration, then a new session is created for the
+// next segment, for example, the stream is republished.
+const transcriptSessionTimeout = 120 * time.Second
+
+// The max number of segments to load from redis at a time, to search the long session by pages.
+const transcriptSessionPageSize = 1000
+
+// TranscriptSession is the full transcript of a live session, because the segments in queues are disposed
+// when done. The session is persisted in SRS_TRANSCRIPT_SESSION without segments, while the segments are
+// appended to a redis list per session, see transcriptSessionSegmentsKey.
+type TranscriptSession struct {
+	// The session UUID.
+	UUID string `json:"uuid"`
+	// The transcript task UUID.
+	TaskUUID string `json:"task"`
+	// The stream of session.
+	App     string `json:"app"`
+	Stream  string `json:"stream"`
+	M3u8URL string `json:"m3u8_url"`
+	// The language of transcript.
+	Language string `json:"lang"`
+	// The wallclock time of the start of session, in ISO 8601 format.
+	StartedAt string `json:"start"`
+	// The last update time, in RFC3339.
+	Update string `json:"update"`
+	// The duration of all segments, in seconds.
+	Duration float64 `json:"duration"`
+	// The UUID of record artifact, if recording is on.
+	RecordUUID string `json:"record,omitempty"`
+	// The number of segments.
+	Count int `json:"count"`
+	// The transcript segments of session, only loaded to export or search, see loadSegments.
+	Segments []*TranscriptSessionSegment `json:"segments,omitempty"`
+}
+
+// TranscriptSessionSegment is a transcript text with timestamps.
+type TranscriptSessionSegment struct {
+	// The start and end offset in seconds, relative to the start of session.
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	// The wallclock time of the start of segment, in ISO 8601 format.
+	Time string `json:"time"`
+	// The transcript text.
+	Text string `json:"text"`
+}
+
+func (v TranscriptSession) String() string {
+	return fmt.Sprintf("uuid=%v, task=%v, app=%v, stream=%v, lang=%v, start=%v, update=%v, duration=%v, record=%v, count=%v, segments=%v",
+		v.UUID, v.TaskUUID, v.App, v.Stream, v.Language, v.StartedAt, v.Update, v.Duration, v.RecordUUID, v.Count, len(v.Segments))
+}
+
+// transcriptSessionSegmentsKey is the redis list of segments of session, each element is a segment.
+func transcriptSessionSegmentsKey(uuid string) string {
+	return fmt.Sprintf("%v:%v", SRS_TRANSCRIPT_SESSION, uuid)
+}
+
+// Save the session without segments, see appendSegments and replaceSegments.
+func (v *TranscriptSession) Save(ctx context.Context) error {
+	return v.saveWith(ctx, nil)
+}
+
+// saveWith save the session without segments, and update the segments by pipe, in one transaction.
+func (v *TranscriptSession) saveWith(ctx context.Context, updateSegments func(pipe redis.Pipeliner)) error {
+	summary := *v
+	summary.Segments = nil
+
+	b, err := json.Marshal(&summary)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", v.String())
+	}
+
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if updateSegments != nil {
+			updateSegments(pipe)
+		}
+		pipe.HSet(ctx, SRS_TRANSCRIPT_SESSION, v.UUID, string(b))
+		return nil
+	}); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v", SRS_TRANSCRIPT_SESSION, v.UUID)
+	}
+	return nil
+}
+
+// appendSegments append the segments to the list of session, and save the session.
+func (v *TranscriptSession) appendSegments(ctx context.Context, segments []*TranscriptSessionSegment) error {
+	values, err := marshalTranscriptSessionSegments(segments)
+	if err != nil {
+		return errors.Wrapf(err, "marshal segments")
+	}
+
+	return v.saveWith(ctx, func(pipe redis.Pipeliner) {
+		if len(values) > 0 {
+			pipe.RPush(ctx, transcriptSessionSegmentsKey(v.UUID), values...)
+		}
+	})
+}
+
+// replaceSegments replace all segments of session, and save the session.
+func (v *TranscriptSession) replaceSegments(ctx context.Context, segments []*TranscriptSessionSegment) error {
+	values, err := marshalTranscriptSessionSegments(segments)
+	if err != nil {
+		return errors.Wrapf(err, "marshal segments")
+	}
+
+	v.Count = len(segments)
+	return v.saveWith(ctx, func(pipe redis.Pipeliner) {
+		pipe.Del(ctx, transcriptSessionSegmentsKey(v.UUID))
+		if len(values) > 0 {
+			pipe.RPush(ctx, transcriptSessionSegmentsKey(v.UUID), values...)
+		}
+	})
+}
+
+// loadSegments load the segments of session from start to stop, both are inclusive, and stop -1 is the last
+// one, see LRANGE of redis.
+func (v *TranscriptSession) loadSegments(ctx context.Context, start, stop int64) error {
+	key := transcriptSessionSegmentsKey(v.UUID)
+	values, err := rdb.LRange(ctx, key, start, stop).Result()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "lrange %v %v %v", key, start, stop)
+	}
+
+	v.Segments = make([]*TranscriptSessionSegment, 0, len(values))
+	for _, b := range values {
+		var segment TranscriptSessionSegment
+		if err := json.Unmarshal([]byte(b), &segment); err != nil {
+			return errors.Wrapf(err, "unmarshal %v", b)
+		}
+		v.Segments = append(v.Segments, &segment)
+	}
+	return nil
+}
+
+// remove the session and its segments.
+func (v *TranscriptSession) remove(ctx context.Context) error {
+	if _, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, SRS_TRANSCRIPT_SESSION, v.UUID)
+		pipe.Del(ctx, transcriptSessionSegmentsKey(v.UUID))
+		return nil
+	}); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_SESSION, v.UUID)
+	}
+	return nil
+}
+
+func marshalTranscriptSessionSegments(segments []*TranscriptSessionSegment) ([]interface{}, error) {
+	var values []interface{}
+	for _, segment := range segments {
+		b, err := json.Marshal(segment)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal %v", segment)
+		}
+		values = append(values, string(b))
+	}
+	return values, nil
+}
+
+// expired whether the session is finished, so the next segment should be in a new session.
+func (v *TranscriptSession) expired(msg *SrsOnHlsMessage) bool {
+	if msg != nil && msg.M3u8URL != v.M3u8URL {
+		return true
+	}
+	if update, err := time.Parse(time.RFC3339, v.Update); err != nil || time.Since(update) > transcriptSessionTimeout {
+		return true
+	}
+	return false
+}
+
+// append the ASR text of segment, the duration of ts is used to build the timestamps. Return the new
+// segments to persist, which are not kept in session.
+func (v *TranscriptSession) append(asr *TranscriptAsrResult, duration float64) []*TranscriptSessionSegment {
+	starttime, _ := time.Parse(programDateTimeLayout, v.StartedAt)
+
+	var segments []*TranscriptSessionSegment
+	if asr != nil {
+		for _, as := range asr.Segments {
+			if text := strings.TrimSpace(as.Text); text != "" {
+				start := v.Duration + as.Start
+				segments = append(segments, &TranscriptSessionSegment{
+					Start: start, End: v.Duration + as.End, Text: text,
+					Time: starttime.Add(time.Duration(start * float64(time.Second))).Format(programDateTimeLayout),
+				})
+			}
+		}
+	}
+
+	v.Count += len(segments)
+	v.Duration += duration
+	v.Update = time.Now().Format(time.RFC3339)
+	return segments
+}
+
+// transcriptSessionExportType get the content type of export format srt, vtt, txt or json.
+func transcriptSessionExportType(format string) (string, error) {
+	switch format {
+	case "srt":
+		return "application/x-subrip", nil
+	case "vtt":
+		return "text/vtt", nil
+	case "txt":
+		return "text/plain; charset=utf-8", nil
+	case "json":
+		return "application/json", nil
+	}
+	return "", errors.Errorf("invalid format %v", format)
+}
+
+// Write the transcript of session in format srt, vtt, txt or json to w. The segments are written page by page,
+// each page is loaded to v.Segments by load, to avoid loading the whole session in memory.
+func (v *TranscriptSession) export(w io.Writer, format string, load func(start, stop int64) error) error {
+	if _, err := transcriptSessionExportType(format); err != nil {
+		return err
+	}
+
+	// The header of format, for json, it's the session without segments.
+	switch format {
+	case "vtt":
+		if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+			return errors.Wrapf(err, "write header")
+		}
+	case "json":
+		session := *v
+		session.Segments = nil
+		b, err := json.Marshal(&session)
+		if err != nil {
+			return errors.Wrapf(err, "marshal %v", v.String())
+		}
+		if _, err := fmt.Fprintf(w, `%v,"segments":[`, string(b[:len(b)-1])); err != nil {
+			return errors.Wrapf(err, "write header")
+		}
+	}
+
+	var index int
+	for start := int64(0); start < int64(v.Count); start += transcriptSessionPageSize {
+		if err := load(start, start+transcriptSessionPageSize-1); err != nil {
+			return errors.Wrapf(err, "load segments from %v", start)
+		}
+
+		var sb strings.Builder
+		for _, s := range v.Segments {
+			switch format {
+			case "srt":
+				sb.WriteString(fmt.Sprintf("%v\n%v --> %v\n%v\n\n", index+1,
+					strings.Replace(formatWebVTTTime(s.Start), ".", ",", 1),
+					strings.Replace(formatWebVTTTime(s.End), ".", ",", 1), s.Text))
+			case "vtt":
+				sb.WriteString(fmt.Sprintf("%v --> %v\n%v\n\n", formatWebVTTTime(s.Start), formatWebVTTTime(s.End), s.Text))
+			case "txt":
+				sb.WriteString(fmt.Sprintf("[%v] %v\n", formatWebVTTTime(s.Start), s.Text))
+			case "json":
+				b, err := json.Marshal(s)
+				if err != nil {
+					return errors.Wrapf(err, "marshal %v", s)
+				}
+				if index > 0 {
+					sb.WriteString(",")
+				}
+				sb.Write(b)
+			}
+			index++
+		}
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return errors.Wrapf(err, "write segments")
+		}
+
+		// Stop if no more segments, for example, the session is removed.
+		if len(v.Segments) < transcriptSessionPageSize {
+			break
+		}
+	}
+
+	if format == "json" {
+		if _, err := io.WriteString(w, "]}"); err != nil {
+			return errors.Wrapf(err, "write footer")
+		}
+	}
+	return nil
+}
+
+// Build the transcript of session in format srt, vtt, txt or json, all segments are already in memory.
+func (v *TranscriptSession) exportAll(format string) (contentType, body string, err error) {
+	if contentType, err = transcriptSessionExportType(format); err != nil {
+		return
+	}
+
+	segments, session := v.Segments, *v
+	session.Count = len(segments)
+
+	var sb strings.Builder
+	if err = session.export(&sb, format, func(start, stop int64) error {
+		session.Segments = segments[start:]
+		if int(stop) < len(segments)-1 {
+			session.Segments = segments[start : stop+1]
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	return contentType, sb.String(), nil
+}
+
+// search the segments which contains the keyword, case insensitive.
+func (v *TranscriptSession) search(keyword string) []*TranscriptSessionSegment {
+	var matches []*TranscriptSessionSegment
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	for _, s := range v.Segments {
+		if keyword != "" && strings.Contains(strings.ToLower(s.Text), keyword) {
+			matches = append(matches, s)
+		}
+	}
+	return matches
+}
+
+// queryTranscriptSession load the session by uuid without segments, return nil if not exists.
+func queryTranscriptSession(ctx context.Context, uuid string) (*TranscriptSession, error) {
+	b, err := rdb.HGet(ctx, SRS_TRANSCRIPT_SESSION, uuid).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hget %v %v", SRS_TRANSCRIPT_SESSION, uuid)
+	} else if b == "" {
+		return nil, nil
+	}
+
+	var session TranscriptSession
+	if err := json.Unmarshal([]byte(b), &session); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return &session, nil
+}
+
+// queryTranscriptSessions load all sessions without segments, sorted by start time, newest first.
+func queryTranscriptSessions(ctx context.Context) ([]*TranscriptSession, error) {
+	objs, err := rdb.HGetAll(ctx, SRS_TRANSCRIPT_SESSION).Result()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrapf(err, "hgetall %v", SRS_TRANSCRIPT_SESSION)
+	}
+
+	var sessions []*TranscriptSession
+	for uuid, b := range objs {
+		var session TranscriptSession
+		if err := json.Unmarshal([]byte(b), &session); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v %v", uuid, b)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt > sessions[j].StartedAt
+	})
+	return sessions, nil
+}
+
+// queryRecordUUIDOfStream get the uuid of record artifact which is recording the stream, empty if not.
+func queryRecordUUIDOfStream(ctx context.Context, m3u8URL string) (string, error) {
+	b, err := rdb.HGet(ctx, SRS_RECORD_M3U8_WORKING, m3u8URL).Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "hget %v %v", SRS_RECORD_M3U8_WORKING, m3u8URL)
+	} else if b == "" {
+		return "", nil
+	}
+
+	var stream RecordM3u8Stream
+	if err := json.Unmarshal([]byte(b), &stream); err != nil {
+		return "", errors.Wrapf(err, "unmarshal %v", b)
+	}
+	return stream.UUID, nil
+}
+
+// appendSession persist the ASR text of ts file to the session of task, create a new session if the
+// stream changed or the session is finished. The asr is nil if user clear the text.
+func (v *TranscriptTask) appendSession(ctx context.Context, msg *SrsOnHlsMessage, tsFile *TsFile, asr *TranscriptAsrResult) error {
+	if msg == nil || tsFile == nil {
+		return nil
+	}
+
+	if v.session == nil && v.SessionUUID != "" {
+		if session, err := queryTranscriptSession(ctx, v.SessionUUID); err != nil {
+			return errors.Wrapf(err, "query session %v", v.SessionUUID)
+		} else {
+			v.session = session
+		}
+	}
+
+	if v.session == nil || v.session.expired(msg) {
+		starttime := time.Now().Add(-1 * time.Duration(tsFile.Duration*float64(time.Second)))
+		if pdt, err := time.Parse(programDateTimeLayout, tsFile.ProgramDateTime); err == nil {
+			starttime = pdt
+		}
+
+		v.session = &TranscriptSession{
+			UUID: uuid.NewString(), TaskUUID: v.UUID, Language: v.config.Language,
+			App: msg.App, Stream: msg.Stream, M3u8URL: msg.M3u8URL,
+			StartedAt: starttime.Format(programDateTimeLayout), Segments: []*TranscriptSessionSegment{},
+		}
+		v.SessionUUID = v.session.UUID
+		logger.Tf(ctx, "transcript: create session %v", v.session.String())
+	}
+
+	// Link to the record artifact, which is created when recording starts.
+	if v.session.RecordUUID == "" {
+		if recordUUID, err := queryRecordUUIDOfStream(ctx, v.session.M3u8URL); err != nil {
+			logger.Wf(ctx, "transcript: ignore query record err %+v", err)
+		} else {
+			v.session.RecordUUID = recordUUID
+		}
+	}
+
+	segments := v.session.append(asr, tsFile.Duration)
+	if err := v.session.appendSegments(ctx, segments); err != nil {
+		return errors.Wrapf(err, "save session %v", v.session.String())
+	}
+	return nil
+}
+
+func (v *TranscriptWorker) handleSessionService(ctx context.Context, handler *http.ServeMux) error {
+	ep := "/terraform/v1/ai/transcript/session/query"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, app, stream string
+			var offset, limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token  *string `json:"token"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+				// The offset and max number of sessions, default to 0 and 100.
+				Offset *int `json:"offset"`
+				Limit  *int `json:"limit"`
+			}{
+				Token: &token, App: &app, Stream: &stream, Offset: &offset, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if offset < 0 {
+				offset = 0
+			}
+			if limit <= 0 {
+				limit = 100
+			}
+
+			// Only return the summary of sessions, without segments.
+			sessions, err := queryTranscriptSessions(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query sessions")
+			}
+
+			res := []*TranscriptSession{}
+			for _, session := range sessions {
+				if (app != "" && session.App != app) || (stream != "" && session.Stream != stream) {
+					continue
+				}
+				res = append(res, session)
+			}
+			if offset < len(res) {
+				res = res[offset:]
+			} else {
+				res = []*TranscriptSession{}
+			}
+			if len(res) > limit {
+				res = res[:limit]
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "transcript session query ok, app=%v, stream=%v, offset=%v, limit=%v, sessions=%v, token=%vB",
+				app, stream, offset, limit, len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/session/search"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid, keyword string
+			var limit int
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// Search in all sessions if empty.
+				UUID    *string `json:"uuid"`
+				Keyword *string `json:"keyword"`
+				// The max number of matches, default to 100.
+				Limit *int `json:"limit"`
+			}{
+				Token: &token, UUID: &uuid, Keyword: &keyword, Limit: &limit,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if strings.TrimSpace(keyword) == "" {
+				return errors.Errorf("empty keyword")
+			}
+			if limit <= 0 {
+				limit = 100
+			}
+
+			sessions, err := queryTranscriptSessions(ctx)
+			if err != nil {
+				return errors.Wrapf(err, "query sessions")
+			}
+
+			// The match with the offset in record artifact, to jump to the time when playing.
+			type MatchObject struct {
+				Session string `json:"session"`
+				Record  string `json:"record,omitempty"`
+				TranscriptSessionSegment
+				// The offset in seconds of record artifact, if linked.
+				RecordOffset float64 `json:"recordOffset,omitempty"`
+			}
+			res := []*MatchObject{}
+			for _, session := range sessions {
+				if uuid != "" && session.UUID != uuid {
+					continue
+				}
+				if len(res) >= limit {
+					break
+				}
+
+				// The start time of record artifact, to convert the time of segment to offset.
+				var recordStart time.Time
+				if session.RecordUUID != "" {
+					if artifact, err := queryRecordArtifact(ctx, session.RecordUUID); err == nil && artifact != nil {
+						recordStart, _, _ = artifactTimeRange(artifact)
+					}
+				}
+
+				// Load the segments by pages, until got enough matches.
+				for start := int64(0); start < int64(session.Count) && len(res) < limit; start += transcriptSessionPageSize {
+					if err := session.loadSegments(ctx, start, start+transcriptSessionPageSize-1); err != nil {
+						return errors.Wrapf(err, "load segments of %v", session.UUID)
+					}
+
+					for _, s := range session.search(keyword) {
+						obj := &MatchObject{Session: session.UUID, Record: session.RecordUUID, TranscriptSessionSegment: *s}
+						if t, err := time.Parse(programDateTimeLayout, s.Time); err == nil && !recordStart.IsZero() {
+							if offset := t.Sub(recordStart).Seconds(); offset > 0 {
+								obj.RecordOffset = offset
+							}
+						}
+						if res = append(res, obj); len(res) >= limit {
+							break
+						}
+					}
+				}
+			}
+
+			ohttp.WriteData(ctx, w, r, res)
+			logger.Tf(ctx, "transcript session search ok, uuid=%v, keyword=%v, matches=%v, token=%vB",
+				uuid, keyword, len(res), len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/session/remove"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token, uuid string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
+			}{
+				Token: &token, UUID: &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			if uuid == "" {
+				return errors.Errorf("empty uuid")
+			}
+
+			session := &TranscriptSession{UUID: uuid}
+			if err := session.remove(ctx); err != nil {
+				return errors.Wrapf(err, "remove session %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "transcript session remove ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/session/export/"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			// Format is /export/:uuid.srt?token=xxx, or vtt, txt and json.
+			token := r.URL.Query().Get("token")
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			filename := path.Base(r.URL.Path)
+			format := strings.TrimPrefix(path.Ext(filename), ".")
+			uuid := strings.TrimSuffix(filename, path.Ext(filename))
+			if uuid == "" || format == "" {
+				return errors.Errorf("invalid uuid %v format %v of %v", uuid, format, r.URL.Path)
+			}
+
+			contentType, err := transcriptSessionExportType(format)
+			if err != nil {
+				return errors.Wrapf(err, "export %v", filename)
+			}
+
+			session, err := queryTranscriptSession(ctx, uuid)
+			if err != nil {
+				return errors.Wrapf(err, "query session %v", uuid)
+			} else if session == nil {
+				return errors.Errorf("no session %v", uuid)
+			}
+
+			// The response is written in pages, so the error is only logged once the body is started.
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", filename))
+			if err := session.export(w, format, func(start, stop int64) error {
+				return session.loadSegments(ctx, start, stop)
+			}); err != nil {
+				logger.Wf(ctx, "transcript session export %v err %+v", session.String(), err)
+				return nil
+			}
+
+			logger.Tf(ctx, "transcript session export ok, uuid=%v, format=%v, segments=%v, token=%vB",
+				uuid, format, session.Count, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	return nil
+}
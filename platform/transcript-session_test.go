@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscriptSession_Export(t *testing.T) {
+	session := &TranscriptSession{StartedAt: "2024-04-23T01:00:00.000+08:00"}
+	for _, asr := range []*TranscriptAsrResult{
+		{Segments: []TranscriptAsrSegment{{Start: 0, End: 2.5, Text: " Hello Oryx"}, {Start: 2.5, End: 4, Text: " "}}},
+		nil,
+		{Segments: []TranscriptAsrSegment{{Start: 1, End: 3, Text: "Welcome to oryx"}}},
+	} {
+		session.Segments = append(session.Segments, session.append(asr, 10)...)
+	}
+
+	if len(session.Segments) != 2 || session.Count != 2 || session.Duration != 30 {
+		t.Fatalf("invalid session %v", session.String())
+	}
+	if s := session.Segments[1]; s.Start != 21 || s.Time != "2024-04-23T01:00:21.000+08:00" {
+		t.Errorf("invalid segment %v", s)
+	}
+
+	export := func(format string) (string, error) {
+		_, body, err := session.exportAll(format)
+		return body, err
+	}
+
+	if body, err := export("srt"); err != nil || !strings.Contains(body, "2\n00:00:21,000 --> 00:00:23,000\nWelcome to oryx") {
+		t.Errorf("export srt failed, err=%v, body=%v", err, body)
+	}
+	if body, err := export("vtt"); err != nil || !strings.HasPrefix(body, "WEBVTT\n\n00:00:00.000 --> 00:00:02.500\n") {
+		t.Errorf("export vtt failed, err=%v, body=%v", err, body)
+	}
+	if body, err := export("json"); err != nil || !strings.HasSuffix(body, `"count":2,"segments":[{"start":0,"end":2.5,"time":"2024-04-23T01:00:00.000+08:00","text":"Hello Oryx"},{"start":21,"end":23,"time":"2024-04-23T01:00:21.000+08:00","text":"Welcome to oryx"}]}`) {
+		t.Errorf("export json failed, err=%v, body=%v", err, body)
+	}
+	if _, err := export("doc"); err == nil {
+		t.Errorf("export should fail for doc")
+	}
+
+	if matches := session.search("ORYX"); len(matches) != 2 {
+		t.Errorf("search failed, matches=%v", len(matches))
+	}
+}
@@ -12,10 +12,13 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	// From ossrs.
 	"github.com/ossrs/go-oryx-lib/errors"
@@ -33,17 +36,18 @@ const maxOverlaySegments = 9
 var transcriptWorker *TranscriptWorker
 
 type TranscriptWorker struct {
+	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// The global transcript task, only support one transcript task.
+	// The global transcript task, which transcript the latest active stream, if no other task for it.
 	task *TranscriptTask
+	// All transcript tasks, including the global task and the tasks for specified streams. The key is the
+	// key of task, see transcriptTaskKey, and the value is *TranscriptTask.
+	tasks sync.Map
 
 	// Use async goroutine to process on_hls messages.
 	msgs chan *SrsOnHlsMessage
-
-	// Got message from SRS, a new TS segment file is generated.
-	tsfiles chan *SrsOnHlsObject
 }
 
 // NewTranscriptWorker 创建并返回一个新的 TranscriptWorker 实例。
@@ -51,14 +55,11 @@ type TranscriptWorker struct {
 func NewTranscriptWorker() *TranscriptWorker {
 	// 初始化 TranscriptWorker 实例。
 	// msgs: 用于接收 SrsOnHlsMessage 类型的消息，缓冲区大小为 1024。
-	// tsfiles: 用于接收 SrsOnHlsObject 类型的对象，缓冲区大小为 1024。
 	v := &TranscriptWorker{
 		// Message on_hls.
 		msgs: make(chan *SrsOnHlsMessage, 1024),
-		// TS files.
-		tsfiles: make(chan *SrsOnHlsObject, 1024),
 	}
-	// 初始化与该 worker 相关的任务。
+	// 初始化与该 worker 相关的全局任务。
 	v.task = NewTranscriptTask()
 	// 将当前 worker 设置到任务中，形成双向引用关系。
 	v.task.transcriptWorker = v
@@ -66,16 +67,103 @@ func NewTranscriptWorker() *TranscriptWorker {
 	return v
 }
 
+// queryTask find the task by uuid, or by stream, or the global task if both empty.
+func (v *TranscriptWorker) queryTask(uuid, app, stream string) (*TranscriptTask, error) {
+	if uuid != "" {
+		var target *TranscriptTask
+		v.tasks.Range(func(key, value interface{}) bool {
+			if task := value.(*TranscriptTask); task.taskUUID() == uuid {
+				target = task
+				return false
+			}
+			return true
+		})
+		if target == nil {
+			return nil, errors.Errorf("no task of uuid %v", uuid)
+		}
+		return target, nil
+	}
+
+	if app != "" || stream != "" {
+		if value, ok := v.tasks.Load(transcriptTaskKey(app, stream)); ok {
+			return value.(*TranscriptTask), nil
+		}
+		return nil, errors.Errorf("no task of app=%v, stream=%v", app, stream)
+	}
+
+	return v.task, nil
+}
+
+// queryOrCreateTask find the task of stream, or create and start a new task for it.
+func (v *TranscriptWorker) queryOrCreateTask(ctx context.Context, app, stream string) *TranscriptTask {
+	if app == "" && stream == "" {
+		return v.task
+	}
+
+	task := NewTranscriptTask()
+	task.App, task.Stream, task.transcriptWorker = app, stream, v
+	if value, loaded := v.tasks.LoadOrStore(task.key(), task); loaded {
+		return value.(*TranscriptTask)
+	}
+
+	v.startTask(v.ctx, task)
+	logger.Tf(ctx, "transcript: create task %v for app=%v, stream=%v", task.UUID, app, stream)
+	return task
+}
+
+// removeTask stop and remove the task of stream, note that the global task can't be removed.
+func (v *TranscriptWorker) removeTask(ctx context.Context, task *TranscriptTask) error {
+	if task == v.task {
+		return errors.New("can not remove global task")
+	}
+
+	v.tasks.Delete(task.key())
+	task.stop()
+
+	if err := task.remove(ctx); err != nil {
+		return errors.Wrapf(err, "remove task %v", task.String())
+	}
+
+	logger.Tf(ctx, "transcript: remove task %v", task.String())
+	return nil
+}
+
+// boundStreams get the streams which are bound to the tasks of specified streams.
+func (v *TranscriptWorker) boundStreams() map[string]bool {
+	streams := make(map[string]bool)
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*TranscriptTask); task != v.task {
+			streams[task.key()] = true
+		}
+		return true
+	})
+	return streams
+}
+
+// transcriptTaskKey is the key of task and config, global for the global task, or app/stream for the
+// task of the specified stream.
+func transcriptTaskKey(app, stream string) string {
+	if app == "" && stream == "" {
+		return "global"
+	}
+	return fmt.Sprintf("%v/%v", app, stream)
+}
+
 func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) error {
 	ep := "/terraform/v1/ai/transcript/query"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -85,26 +173,47 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
 			config := NewTranscriptConfig()
+			config.App, config.Stream = task.App, task.Stream
 			if err := config.Load(ctx); err != nil {
 				return errors.Wrapf(err, "load config")
 			}
 
+			type TaskObject struct {
+				UUID   string `json:"uuid"`
+				App    string `json:"app,omitempty"`
+				Stream string `json:"stream,omitempty"`
+				// Whether the task is enabled.
+				Enabled bool `json:"enabled"`
+			}
 			type QueryResponse struct {
 				Config *TranscriptConfig `json:"config"`
-				Task   struct {
-					UUID string `json:"uuid"`
-				} `json:"task"`
+				Task   TaskObject        `json:"task"`
+				// All the tasks, including the global task.
+				Tasks []TaskObject `json:"tasks"`
 			}
 
 			resp := &QueryResponse{
 				Config: config,
-			}
-			resp.Task.UUID = v.task.UUID
+				Task:   TaskObject{UUID: task.taskUUID(), App: task.App, Stream: task.Stream, Enabled: task.enabled()},
+				Tasks:  []TaskObject{},
+			}
+			v.tasks.Range(func(key, value interface{}) bool {
+				t := value.(*TranscriptTask)
+				resp.Tasks = append(resp.Tasks, TaskObject{
+					UUID: t.taskUUID(), App: t.App, Stream: t.Stream, Enabled: t.enabled(),
+				})
+				return true
+			})
 
 			ohttp.WriteData(ctx, w, r, resp)
-			logger.Tf(ctx, "transcript query ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+			logger.Tf(ctx, "transcript query ok, config=<%v>, uuid=%v, tasks=%v, token=%vB",
+				config, resp.Task.UUID, len(resp.Tasks), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -134,16 +243,25 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
+			for _, replacement := range config.Replacements {
+				if strings.TrimSpace(replacement.From) == "" {
+					return errors.Errorf("empty replacement from, to=%v", replacement.To)
+				}
+			}
+
+			// The task is addressed by the stream of config, create a task if not exists.
+			task := v.queryOrCreateTask(ctx, config.App, config.Stream)
+
 			// Not required yet.
-			if uuid != v.task.UUID {
-				logger.Wf(ctx, "transcript ignore uuid mismatch, query=%v, task=%v", uuid, v.task.UUID)
+			if taskUUID := task.taskUUID(); uuid != taskUUID {
+				logger.Wf(ctx, "transcript ignore uuid mismatch, query=%v, task=%v", uuid, taskUUID)
 			}
 
 			if err := config.Save(ctx); err != nil {
 				return errors.Wrapf(err, "save config")
 			}
 
-			if err := v.task.restart(ctx); err != nil {
+			if err := task.restart(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", config.String())
 			}
 
@@ -151,10 +269,10 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ApplyResponse{
-				UUID: v.task.UUID,
+				UUID: task.taskUUID(),
 			})
 			logger.Tf(ctx, "transcript apply ok, config=<%v>, uuid=%v, token=%vB",
-				config, v.task.UUID, len(token))
+				config, task.taskUUID(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
@@ -244,11 +362,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if uuid != v.task.UUID {
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil || uuid == "" {
 				return errors.Errorf("invalid uuid %v", uuid)
 			}
 
-			if err := v.task.clearSubtitle(ctx, tsid); err != nil {
+			if err := task.clearSubtitle(ctx, tsid); err != nil {
 				return errors.Wrapf(err, "clear subtitle task %v and tsid=%v", uuid, tsid)
 			}
 
@@ -285,11 +404,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Wrapf(err, "authenticate")
 			}
 
-			if uuid != v.task.UUID {
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil || uuid == "" {
 				return errors.Errorf("invalid uuid %v", uuid)
 			}
 
-			if err := v.task.reset(ctx); err != nil {
+			if err := task.reset(ctx); err != nil {
 				return errors.Wrapf(err, "restart task %v", uuid)
 			}
 
@@ -297,24 +417,67 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				UUID string `json:"uuid"`
 			}
 			ohttp.WriteData(ctx, w, r, &ResetResponse{
-				UUID: v.task.UUID,
+				UUID: task.taskUUID(),
 			})
-			logger.Tf(ctx, "transcript reset ok, uuid=%v, new=%v, token=%vB", uuid, v.task.UUID, len(token))
+			logger.Tf(ctx, "transcript reset ok, uuid=%v, new=%v, token=%vB", uuid, task.taskUUID(), len(token))
 			return nil
 		}(); err != nil {
 			ohttp.WriteError(ctx, w, r, err)
 		}
 	})
 
-	ep = "/terraform/v1/ai/transcript/live-queue"
+	ep = "/terraform/v1/ai/transcript/remove"
 	logger.Tf(ctx, "Handle %v", ep)
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				UUID  *string `json:"uuid"`
 			}{
 				Token: &token,
+				UUID:  &uuid,
+			}); err != nil {
+				return errors.Wrapf(err, "parse body")
+			}
+
+			apiSecret := envApiSecret()
+			if err := Authenticate(ctx, apiSecret, token, r.Header); err != nil {
+				return errors.Wrapf(err, "authenticate")
+			}
+
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil || uuid == "" {
+				return errors.Errorf("invalid uuid %v", uuid)
+			}
+
+			if err := v.removeTask(ctx, task); err != nil {
+				return errors.Wrapf(err, "remove task %v", uuid)
+			}
+
+			ohttp.WriteData(ctx, w, r, nil)
+			logger.Tf(ctx, "transcript remove ok, uuid=%v, token=%vB", uuid, len(token))
+			return nil
+		}(); err != nil {
+			ohttp.WriteError(ctx, w, r, err)
+		}
+	})
+
+	ep = "/terraform/v1/ai/transcript/live-queue"
+	logger.Tf(ctx, "Handle %v", ep)
+	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
+		if err := func() error {
+			var token string
+			var uuid, app, stream string
+			if err := ParseBody(ctx, r.Body, &struct {
+				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
+			}{
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -337,7 +500,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &LiveQueueResponse{}
 
-			segments := v.task.liveSegments()
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
+			segments := task.liveSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.TsFile.TsID,
@@ -363,10 +531,15 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -393,7 +566,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &AsrQueueResponse{}
 
-			segments := v.task.asrSegments()
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
+			segments := task.asrSegments()
 			for _, segment := range segments {
 				res.Segments = append(res.Segments, []*Segment{&Segment{
 					TsID:     segment.AudioFile.TsID,
@@ -423,10 +601,15 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -467,7 +650,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &FixQueueResponse{}
 
-			segments := v.task.fixSegments()
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
+			segments := task.fixSegments()
 			for _, segment := range segments {
 				asrSegments := []AsrSegment{}
 				for _, asrSegment := range segment.AsrText.Segments {
@@ -514,10 +702,15 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 	handler.HandleFunc(ep, func(w http.ResponseWriter, r *http.Request) {
 		if err := func() error {
 			var token string
+			var uuid, app, stream string
 			if err := ParseBody(ctx, r.Body, &struct {
 				Token *string `json:"token"`
+				// Address the task by uuid or stream, use the global task if empty.
+				UUID   *string `json:"uuid"`
+				App    *string `json:"app"`
+				Stream *string `json:"stream"`
 			}{
-				Token: &token,
+				Token: &token, UUID: &uuid, App: &app, Stream: &stream,
 			}); err != nil {
 				return errors.Wrapf(err, "parse body")
 			}
@@ -562,7 +755,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 			res := &OverlayQueueResonse{}
 
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, app, stream)
+			if err != nil {
+				return errors.Wrapf(err, "query task")
+			}
+
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				asrSegments := []AsrSegment{}
 				for _, asrSegment := range segment.AsrText.Segments {
@@ -621,7 +819,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
 
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			if len(segments) == 0 {
 				return errors.Errorf("no segments for %v", uuid)
 			}
@@ -638,10 +841,16 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 				return errors.Errorf("invalid bitrate %v of %v %v", bitrate, uuid, firstSegment.OverlayFile.TsID)
 			}
 
+			// The subtitle in source language, and the translated subtitles.
+			subtitles := []*SubtitleRendition{{Language: task.config.Language, URI: "subtitles.m3u8"}}
+			for _, lang := range task.config.translations() {
+				subtitles = append(subtitles, &SubtitleRendition{
+					Language: lang, URI: fmt.Sprintf("subtitles-%v.m3u8", lang),
+				})
+			}
+
 			contentType, m3u8Body, err := buildLiveM3u8ForVariantCC(
-				ctx, bitrate, v.task.config.Language,
-				fmt.Sprintf("%v%v.m3u8", webvttPrefix, uuid),
-				"subtitles.m3u8",
+				ctx, bitrate, fmt.Sprintf("%v%v.m3u8", webvttPrefix, uuid), subtitles,
 			)
 			if err != nil {
 				return errors.Wrapf(err, "build transcript webvtt m3u8 of %v", uuid)
@@ -663,7 +872,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.TsFile)
 			}
@@ -708,20 +922,31 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 		}
 
 		hlsM3u8SubtitleHandler := func(w http.ResponseWriter, r *http.Request) error {
-			// Format is webvtt/:uuid/subtitles.m3u8
+			// Format is webvtt/:uuid/subtitles.m3u8, or webvtt/:uuid/subtitles-:lang.m3u8 for translation.
 			webvttPrefix := "/terraform/v1/ai/transcript/hls/webvtt/"
 			filename := r.URL.Path[len(webvttPrefix):]
-			// Format is :uuid/index.m3u8
-			uuid := filename[:len(filename)-len("/subtitles.m3u8")]
-			if len(uuid) == 0 {
+			// Format is :uuid/subtitles.m3u8
+			uuid := path.Dir(filename)
+			if len(uuid) == 0 || uuid == "." {
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, filename, r.URL.Path)
 			}
+			// The language of translation, empty for the source language.
+			lang := strings.TrimPrefix(strings.TrimSuffix(path.Base(filename), ".m3u8"), "subtitles")
+			lang = strings.TrimPrefix(lang, "-")
 
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				vttFile := *segment.OverlayFile
 				vttFile.Key = fmt.Sprintf("%v.vtt", vttFile.TsID)
+				if lang != "" {
+					vttFile.Key = fmt.Sprintf("%v.%v.vtt", vttFile.TsID, lang)
+				}
 				tsFiles = append(tsFiles, &vttFile)
 			}
 
@@ -734,41 +959,52 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 
 			w.Header().Set("Content-Type", contentType)
 			w.Write([]byte(m3u8Body))
-			logger.Tf(ctx, "transcript generate m3u8 ok, uuid=%v", uuid)
+			logger.Tf(ctx, "transcript generate m3u8 ok, uuid=%v, lang=%v", uuid, lang)
 			return nil
 		}
 
 		hlsVttHandler := func(w http.ResponseWriter, r *http.Request) error {
-			// Format is :uuid.ts
+			// Format is :uuid.vtt, or :uuid.:lang.vtt for translation.
 			filename := r.URL.Path[len("/terraform/v1/ai/transcript/hls/webvtt/"):]
 			fileBase := path.Base(filename)
 			uuid := fileBase[:len(fileBase)-len(path.Ext(fileBase))]
+			var lang string
+			if ext := path.Ext(uuid); ext != "" {
+				uuid, lang = uuid[:len(uuid)-len(ext)], ext[1:]
+			}
 			if len(uuid) == 0 {
 				return errors.Errorf("invalid uuid %v from %v of %v", uuid, fileBase, r.URL.Path)
 			}
 
-			// Find out the segment by overlay vtt ID.
+			// Find out the segment by overlay vtt ID, from all tasks.
 			var segment *TranscriptSegment
-			for _, s := range v.task.overlaySegments() {
-				if s.OverlayFile != nil && s.OverlayFile.TsID == uuid {
-					segment = s
-					break
+			v.tasks.Range(func(key, value interface{}) bool {
+				for _, s := range value.(*TranscriptTask).overlaySegments() {
+					if s.OverlayFile != nil && s.OverlayFile.TsID == uuid {
+						segment = s
+						return false
+					}
 				}
-			}
+				return true
+			})
 			if segment == nil {
 				return errors.Errorf("no segment for %v", uuid)
 			}
 
-			if segment.AsrText == nil {
-				return errors.Errorf("no asr text for %v", uuid)
+			asrText := segment.AsrText
+			if lang != "" {
+				asrText = segment.Translations[lang]
 			}
-			if len(segment.AsrText.Segments) == 0 {
-				return errors.Errorf("no asr text segments for %v", uuid)
+			if asrText == nil {
+				return errors.Errorf("no asr text for %v, lang=%v", uuid, lang)
+			}
+			if len(asrText.Segments) == 0 {
+				return errors.Errorf("no asr text segments for %v, lang=%v", uuid, lang)
 			}
 
 			var vttBody strings.Builder
 			vttBody.WriteString(fmt.Sprintf("WEBVTT\n\n"))
-			for _, as := range segment.AsrText.Segments {
+			for _, as := range asrText.Segments {
 				s := segment.StreamStarttime + time.Duration(as.Start*float64(time.Second))
 				e := segment.StreamStarttime + time.Duration(as.End*float64(time.Second))
 				vttBody.WriteString(fmt.Sprintf("%02d:%02d:%02d.%03d --> ",
@@ -780,14 +1016,14 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 
 			w.Header().Set("Content-Type", "text/vtt")
 			w.Write([]byte(vttBody.String()))
-			logger.Tf(ctx, "transcript server vtt file ok, uuid=%v", uuid)
+			logger.Tf(ctx, "transcript server vtt file ok, uuid=%v, lang=%v", uuid, lang)
 			return nil
 		}
 
 		if err := func() error {
 			if strings.HasSuffix(r.URL.Path, "/index.m3u8") {
 				return hlsM3u8VariantHandler(w, r)
-			} else if strings.HasSuffix(r.URL.Path, "/subtitles.m3u8") {
+			} else if strings.HasPrefix(path.Base(r.URL.Path), "subtitles") && strings.HasSuffix(r.URL.Path, ".m3u8") {
 				return hlsM3u8SubtitleHandler(w, r)
 			} else if strings.HasSuffix(r.URL.Path, ".m3u8") {
 				return hlsM3u8Handler(w, r)
@@ -816,7 +1052,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.OverlayFile)
 			}
@@ -886,7 +1127,12 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 			}
 
 			var tsFiles []*TsFile
-			segments := v.task.overlaySegments()
+			task, err := v.queryTask(uuid, "", "")
+			if err != nil {
+				return errors.Wrapf(err, "query task %v", uuid)
+			}
+
+			segments := task.overlaySegments()
 			for _, segment := range segments {
 				tsFiles = append(tsFiles, segment.TsFile)
 			}
@@ -943,11 +1189,39 @@ func (v *TranscriptWorker) Handle(ctx context.Context, handler *http.ServeMux) e
 		}
 	})
 
+	if err := v.handleSessionService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle session")
+	}
+
+	if err := v.handleAlertService(ctx, handler); err != nil {
+		return errors.Wrapf(err, "handle alert")
+	}
+
 	return nil
 }
 
+// captionInputURL get the HLS URL with embedded captions of stream, to forward the captions to other
+// platforms. Return empty if no task embeds captions for the stream.
+func (v *TranscriptWorker) captionInputURL(stream *SrsStream) string {
+	var captionURL string
+	v.tasks.Range(func(key, value interface{}) bool {
+		task := value.(*TranscriptTask)
+		if input := task.captionStream(); input != nil && input.StreamURL() == stream.StreamURL() {
+			captionURL = fmt.Sprintf("http://localhost:%v/terraform/v1/ai/transcript/hls/overlay/%v.m3u8",
+				strings.TrimPrefix(envMgmtListen(), ":"), task.taskUUID())
+		}
+		return captionURL == ""
+	})
+	return captionURL
+}
+
 func (v *TranscriptWorker) Enabled() bool {
-	return v.task.enabled()
+	var enabled bool
+	v.tasks.Range(func(key, value interface{}) bool {
+		enabled = value.(*TranscriptTask).enabled()
+		return !enabled
+	})
+	return enabled
 }
 
 func (v *TranscriptWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMessage) error {
@@ -960,11 +1234,24 @@ func (v *TranscriptWorker) OnHlsTsMessage(ctx context.Context, msg *SrsOnHlsMess
 }
 
 func (v *TranscriptWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHlsMessage) error {
-	// Ignore if not natch the task config.
-	if !v.task.match(msg) {
-		return nil
+	var tasks []*TranscriptTask
+	v.tasks.Range(func(key, value interface{}) bool {
+		if task := value.(*TranscriptTask); task.match(msg) {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+
+	// Each task has its own copy of ts file, because the task removes the file when done.
+	for _, task := range tasks {
+		if err := v.copyTsFile(ctx, task, msg); err != nil {
+			return errors.Wrapf(err, "copy ts for task %v", task.UUID)
+		}
 	}
+	return nil
+}
 
+func (v *TranscriptWorker) copyTsFile(ctx context.Context, task *TranscriptTask, msg *SrsOnHlsMessage) error {
 	// Copy the ts file to temporary cache dir.
 	tsid := fmt.Sprintf("%v-org-%v", msg.SeqNo, uuid.NewString())
 	tsfile := path.Join("transcript", fmt.Sprintf("%v.ts", tsid))
@@ -981,7 +1268,8 @@ func (v *TranscriptWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHls
 		return errors.Wrapf(err, "stat file %v", msg.File)
 	}
 
-	// Create a local ts file object.
+	// Create a local ts file object, estimate the start time of ts by its duration.
+	starttime := time.Now().Add(-1 * time.Duration(msg.Duration*float64(time.Second)))
 	tsFile := &TsFile{
 		TsID:     tsid,
 		URL:      msg.URL,
@@ -989,14 +1277,19 @@ func (v *TranscriptWorker) OnHlsTsMessageImpl(ctx context.Context, msg *SrsOnHls
 		Duration: msg.Duration,
 		Size:     uint64(stats.Size()),
 		File:     tsfile,
+		// The wallclock time of ts, for the timestamps of transcript session.
+		ProgramDateTime: starttime.Format(programDateTimeLayout),
 	}
 
-	// Notify worker asynchronously.
+	// Notify task asynchronously, remove the file if task is removed or worker quit.
 	// TODO: FIXME: Should cleanup the temporary file when restart.
 	go func() {
 		select {
 		case <-ctx.Done():
-		case v.tsfiles <- &SrsOnHlsObject{Msg: msg, TsFile: tsFile}:
+			os.Remove(tsfile)
+		case <-task.taskCtx.Done():
+			os.Remove(tsfile)
+		case task.tsfiles <- &SrsOnHlsObject{Msg: msg, TsFile: tsFile}:
 		}
 	}()
 	return nil
@@ -1023,55 +1316,50 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 
 	// 创建可取消的上下文，以便在需要时停止所有 Goroutine。
 	ctx, cancel := context.WithCancel(ctx)
-	v.cancel = cancel
+	v.ctx, v.cancel = ctx, cancel
 
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "transcript start a worker")
 
-	// Load task from redis and continue to run the task.
-	// 从 Redis 加载任务并继续运行任务。
+	// Load tasks from redis and continue to run the tasks.
+	// 从 Redis 加载所有任务并继续运行任务。
 	if objs, err := rdb.HGetAll(ctx, SRS_TRANSCRIPT_TASK).Result(); err != nil && err != redis.Nil {
 		return errors.Wrapf(err, "hgetall %v", SRS_TRANSCRIPT_TASK)
-	} else if len(objs) != 1 {
-		// Only support one task right now.
-		// 当前仅支持一个任务。
-		if err = rdb.Del(ctx, SRS_TRANSCRIPT_TASK).Err(); err != nil && err != redis.Nil {
-			return errors.Wrapf(err, "del %v", SRS_TRANSCRIPT_TASK)
-		}
 	} else {
 		for uuid, obj := range objs {
 			logger.Tf(ctx, "Load task %v object %v", uuid, obj)
 
-			if err = json.Unmarshal([]byte(obj), v.task); err != nil {
+			task := NewTranscriptTask()
+			if err = json.Unmarshal([]byte(obj), task); err != nil {
 				return errors.Wrapf(err, "unmarshal %v %v", uuid, obj)
 			}
 
-			break
-		}
-	}
-
-	// Start global transcript task.
-	// 启动全局转录任务。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.Run(ctx); err != nil {
-				logger.Wf(ctx, "transcript: run task %v err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 3 * time.Second
+			// Note that the previous task has no stream, which is the global task.
+			task.transcriptWorker = v
+			if task.key() == v.task.key() {
+				v.task = task
 			}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
+			// Remove the duplicated task, only one task for each stream.
+			if _, loaded := v.tasks.LoadOrStore(task.key(), task); loaded {
+				logger.Wf(ctx, "transcript: remove duplicated task %v", task.String())
+				if err = rdb.HDel(ctx, SRS_TRANSCRIPT_TASK, uuid).Err(); err != nil && err != redis.Nil {
+					return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_TASK, uuid)
+				}
 			}
 		}
-	}()
+	}
+
+	// The global task always exists.
+	// 全局任务总是存在。
+	v.tasks.Store(v.task.key(), v.task)
+
+	// Start all transcript tasks.
+	// 启动所有转录任务。
+	v.tasks.Range(func(key, value interface{}) bool {
+		v.startTask(ctx, value.(*TranscriptTask))
+		return true
+	})
 
 	// Consume all on_hls messages.
 	// 消费所有的 on_hls 消息。
@@ -1090,84 +1378,39 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Consume all ts files by task.
-	// 消费任务的所有 TS 文件。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			select {
-			case <-ctx.Done():
-			case msg := <-v.tsfiles:
-				if err := task.OnTsSegment(ctx, msg); err != nil {
-					logger.Wf(ctx, "transcript: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
-				}
-			}
-		}
-	}()
-
-	// Watch for new stream.
-	// 监控新流的出现。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	return nil
+}
 
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.WatchNewStream(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v watch new stream err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+// startTask start the goroutines for task, which quit when worker closed or task removed.
+func (v *TranscriptWorker) startTask(ctx context.Context, task *TranscriptTask) {
+	wg := &task.wg
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
-	}()
+	ctx, task.cancelTask = context.WithCancel(ctx)
+	task.taskCtx = ctx
+	ctx = logger.WithContext(ctx)
+	logger.Tf(ctx, "transcript: start task %v", task.String())
 
-	// Drive the live queue to ASR.
-	// 驱动实时队列到 ASR 队列。
-	wg.Add(1)
+	// The worker waits for all goroutines of task to quit.
+	v.wg.Add(1)
 	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveLiveQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive live queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
-
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
-			}
-		}
+		defer v.wg.Done()
+		<-ctx.Done()
+		task.wg.Wait()
 	}()
 
-	// Drive the asr queue to correct queue.
-	// 驱动 ASR 队列到修正队列。
+	// Run the transcript task.
+	// 运行转录任务。
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
 			var duration time.Duration
-			if err := task.DriveAsrQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive asr queue err %+v", task.String(), err)
+			if err := task.Run(ctx); err != nil {
+				logger.Wf(ctx, "transcript: run task %v err %+v", task.String(), err)
 				duration = 10 * time.Second
 			} else {
-				duration = 200 * time.Millisecond
+				duration = 3 * time.Second
 			}
 
 			select {
@@ -1177,57 +1420,64 @@ func (v *TranscriptWorker) Start(ctx context.Context) error {
 		}
 	}()
 
-	// Drive the fix queue to overlay queue.
-	// 驱动修正队列到叠加队列。
+	// Consume all ts files by task.
+	// 消费任务的所有 TS 文件。
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		task := v.task
 		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveFixQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive fix queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
-
 			select {
 			case <-ctx.Done():
-			case <-time.After(duration):
+			case msg := <-task.tsfiles:
+				if err := task.OnTsSegment(ctx, msg); err != nil {
+					logger.Wf(ctx, "transcript: task %v on hls ts message %v err %+v", task.String(), msg.String(), err)
+				}
 			}
 		}
 	}()
 
-	// Drive the overlay queue, remove old files.
-	// 驱动叠加队列，移除旧文件。
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		task := v.task
-		for ctx.Err() == nil {
-			var duration time.Duration
-			if err := task.DriveOverlayQueue(ctx); err != nil {
-				logger.Wf(ctx, "transcript: task %v drive overlay queue err %+v", task.String(), err)
-				duration = 10 * time.Second
-			} else {
-				duration = 200 * time.Millisecond
-			}
+	// Watch for new stream, and drive the queues of task.
+	// 监控新流的出现，并驱动任务的各个队列。
+	for _, e := range []struct {
+		name string
+		pfn  func(ctx context.Context) error
+	}{
+		{name: "watch new stream", pfn: task.WatchNewStream},
+		{name: "drive live queue", pfn: task.DriveLiveQueue},
+		{name: "drive asr queue", pfn: task.DriveAsrQueue},
+		{name: "drive fix queue", pfn: task.DriveFixQueue},
+		{name: "drive overlay queue", pfn: task.DriveOverlayQueue},
+	} {
+		name, pfn := e.name, e.pfn
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				var duration time.Duration
+				if err := pfn(ctx); err != nil {
+					logger.Wf(ctx, "transcript: task %v %v err %+v", task.String(), name, err)
+					duration = 10 * time.Second
+				} else {
+					duration = 200 * time.Millisecond
+				}
 
-			select {
-			case <-ctx.Done():
-			case <-time.After(duration):
+				select {
+				case <-ctx.Done():
+				case <-time.After(duration):
+				}
 			}
-		}
-	}()
-
-	return nil
+		}()
+	}
 }
 
 // TODO: FIXME: Use SrsAssistantProvider and SrsAssistantASR instead.
 type TranscriptConfig struct {
+	// The stream to transcript, empty for the global task, which transcript the latest active stream.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
 	// Whether transcript all streams.
 	All bool `json:"all"`
 	// The secret key for AI service.
@@ -1236,6 +1486,24 @@ type TranscriptConfig struct {
 	BaseURL string `json:"baseURL"`
 	// The AI organization.
 	Organization string `json:"organization"`
+	// The ASR provider, openai, whisper or http. Default to openai.
+	ASRProvider string `json:"asrProvider"`
+	// The ASR model, default to whisper-1 for OpenAI.
+	ASRModel string `json:"asrModel"`
+	// The ASR sampling temperature, zero for provider default.
+	ASRTemperature float32 `json:"asrTemperature"`
+	// The ASR prompt hints, for example, the vocabulary or context.
+	ASRHints string `json:"asrHints"`
+	// The custom vocabulary, for example, the product names and jargon, passed to ASR as hints.
+	Vocabulary []string `json:"vocabulary,omitempty"`
+	// The context prompt, for example, the topic or speakers of the stream, passed to ASR as hints.
+	ContextPrompt string `json:"contextPrompt,omitempty"`
+	// The replacement dictionary to correct the ASR text, before generating the subtitles.
+	Replacements []*TranscriptReplacement `json:"replacements,omitempty"`
+	// The URL of whisper or http ASR provider.
+	ASRURL string `json:"asrURL"`
+	// The optional bearer token of whisper or http ASR provider, never use the secret key of AI service.
+	ASRToken string `json:"asrToken"`
 	// The language of the stream.
 	Language string `json:"lang"`
 	// The force_style for overlay subtitle.
@@ -1246,6 +1514,13 @@ type TranscriptConfig struct {
 	EnableOverlay bool `json:"overlayEnabled"`
 	// Whether enable WebVTT subtitle.
 	EnableWebVTT bool `json:"webvttEnabled"`
+	// Whether embed CEA-608 captions in H.264 SEI, without transcoding. Ignored if overlay enabled. Note that no
+	// CEA-708 service is built, see Cea608Caption.
+	EnableCaption bool `json:"captionEnabled"`
+	// The target languages to translate the WebVTT subtitle to, each is a subtitle track.
+	Translations []string `json:"translations,omitempty"`
+	// The chat model to translate the subtitle.
+	TranslationModel string `json:"translationModel,omitempty"`
 }
 
 func NewTranscriptConfig() *TranscriptConfig {
@@ -1255,27 +1530,128 @@ func NewTranscriptConfig() *TranscriptConfig {
 }
 
 func (v TranscriptConfig) String() string {
-	return fmt.Sprintf("all=%v, key=%vB, organization=%v, base=%v, lang=%v, overlay=%v, forceStyle=%v, videoCodecParams=%v, webvtt=%v",
-		v.All, len(v.SecretKey), v.Organization, v.BaseURL, v.Language, v.EnableOverlay, v.ForceStyle,
-		v.VideoCodecParams, v.EnableWebVTT)
+	return fmt.Sprintf("app=%v, stream=%v, all=%v, key=%vB, organization=%v, base=%v, lang=%v, overlay=%v, forceStyle=%v, videoCodecParams=%v, webvtt=%v, caption=%v, asr=<%v>, vocabulary=%v, context=%vB, replacements=%v, translations=%v, translationModel=%v",
+		v.App, v.Stream, v.All, len(v.SecretKey), v.Organization, v.BaseURL, v.Language, v.EnableOverlay, v.ForceStyle,
+		v.VideoCodecParams, v.EnableWebVTT, v.EnableCaption, v.asrProviderConfig(), len(v.Vocabulary),
+		len(v.ContextPrompt), len(v.Replacements), v.Translations, v.TranslationModel)
+}
+
+// The prompt hints of ASR, by the hints, context prompt and vocabulary.
+func (v *TranscriptConfig) asrHints() string {
+	var words []string
+	for _, word := range v.Vocabulary {
+		if word = strings.TrimSpace(word); word != "" && !slicesContains(words, word) {
+			words = append(words, word)
+		}
+	}
+
+	var hints []string
+	for _, hint := range []string{v.ASRHints, v.ContextPrompt} {
+		if hint = strings.TrimSpace(hint); hint != "" {
+			hints = append(hints, hint)
+		}
+	}
+	if len(words) > 0 {
+		hints = append(hints, fmt.Sprintf("Vocabulary: %v.", strings.Join(words, ", ")))
+	}
+	return strings.Join(hints, " ")
+}
+
+// Compile the replacement dictionary, should be called when the config is loaded.
+func (v *TranscriptConfig) compileReplacements() {
+	for _, replacement := range v.Replacements {
+		replacement.compile()
+	}
+}
+
+// Correct the text by the replacement dictionary, in order.
+func (v *TranscriptConfig) correctText(text string) string {
+	for _, replacement := range v.Replacements {
+		if re := replacement.re; re != nil {
+			text = re.ReplaceAllLiteralString(text, replacement.To)
+		}
+	}
+	return text
+}
+
+// TranscriptReplacement is a rule to correct the ASR text, for example, the misheard product names.
+type TranscriptReplacement struct {
+	// The text to replace, case insensitive.
+	From string `json:"from"`
+	// The text to replace with.
+	To string `json:"to"`
+
+	// The compiled regexp of from, nil if empty.
+	re *regexp.Regexp
 }
 
+// Compile the regexp to match the text to replace, match whole word for latin words, but not for CJK,
+// because there is no word boundary for CJK. Set to nil if empty.
+func (v *TranscriptReplacement) compile() {
+	from := strings.TrimSpace(v.From)
+	if from == "" {
+		v.re = nil
+		return
+	}
+
+	isWord := func(r rune) bool {
+		return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+	}
+
+	pattern := regexp.QuoteMeta(from)
+	if first, _ := utf8.DecodeRuneInString(from); isWord(first) {
+		pattern = `\b` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(from); isWord(last) {
+		pattern = pattern + `\b`
+	}
+	v.re = regexp.MustCompile("(?i)" + pattern)
+}
+
+// The target languages to translate to, ignore the source language and duplicated ones.
+func (v *TranscriptConfig) translations() []string {
+	var langs []string
+	for _, lang := range v.Translations {
+		if lang = strings.TrimSpace(lang); lang != "" && lang != v.Language && !slicesContains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// Build the config of ASR provider, the secret key is only used for OpenAI provider.
+func (v *TranscriptConfig) asrProviderConfig() *ASRProviderConfig {
+	aiConfig := openai.DefaultConfig(v.SecretKey)
+	aiConfig.BaseURL = v.BaseURL
+	aiConfig.OrgID = v.Organization
+
+	return &ASRProviderConfig{
+		Provider: v.ASRProvider, Model: v.ASRModel, Temperature: v.ASRTemperature,
+		Hints: v.asrHints(), URL: v.ASRURL, Token: v.ASRToken, AI: aiConfig,
+	}
+}
+
+// Load the config of the task, by the app and stream of config.
 func (v *TranscriptConfig) Load(ctx context.Context) error {
-	if b, err := rdb.HGet(ctx, SRS_TRANSCRIPT_CONFIG, "global").Result(); err != nil && err != redis.Nil {
-		return errors.Wrapf(err, "hget %v global", SRS_TRANSCRIPT_CONFIG)
+	key := transcriptTaskKey(v.App, v.Stream)
+	if b, err := rdb.HGet(ctx, SRS_TRANSCRIPT_CONFIG, key).Result(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hget %v %v", SRS_TRANSCRIPT_CONFIG, key)
 	} else if len(b) > 0 {
 		if err := json.Unmarshal([]byte(b), v); err != nil {
 			return errors.Wrapf(err, "unmarshal %v", b)
 		}
 	}
+
+	v.compileReplacements()
 	return nil
 }
 
 func (v *TranscriptConfig) Save(ctx context.Context) error {
+	key := transcriptTaskKey(v.App, v.Stream)
 	if b, err := json.Marshal(v); err != nil {
 		return errors.Wrapf(err, "marshal conf %v", v)
-	} else if err := rdb.HSet(ctx, SRS_TRANSCRIPT_CONFIG, "global", string(b)).Err(); err != nil && err != redis.Nil {
-		return errors.Wrapf(err, "hset %v global %v", SRS_TRANSCRIPT_CONFIG, string(b))
+	} else if err := rdb.HSet(ctx, SRS_TRANSCRIPT_CONFIG, key, string(b)).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hset %v %v %v", SRS_TRANSCRIPT_CONFIG, key, string(b))
 	}
 	return nil
 }
@@ -1320,6 +1696,12 @@ type TranscriptSegment struct {
 	SrtFile string `json:"srt,omitempty"`
 	// Whether user clear the ASR text of this segment.
 	UserClearASR bool `json:"uca,omitempty"`
+	// Whether the ASR text is appended to the transcript session.
+	SessionAppended bool `json:"sa,omitempty"`
+	// Whether the ASR text is corrected by the replacement dictionary.
+	Corrected bool `json:"cor,omitempty"`
+	// The translated ASR text, the key is the target language.
+	Translations map[string]*TranscriptAsrResult `json:"trans,omitempty"`
 
 	// The cost to transcode the TS file to audio file.
 	CostExtractAudio time.Duration `json:"eac,omitempty"`
@@ -1327,6 +1709,25 @@ type TranscriptSegment struct {
 	CostASR time.Duration `json:"asrc,omitempty"`
 	// The cost to overlay the ASR text onto the video.
 	CostOverlay time.Duration `json:"olc,omitempty"`
+	// The cost to translate the ASR text.
+	CostTranslate time.Duration `json:"trc,omitempty"`
+}
+
+// The captions of ASR text, with the time of PTS in seconds. Empty if user clear the ASR text.
+func (v *TranscriptSegment) captions() []Cea608Caption {
+	if v.UserClearASR || v.AsrText == nil {
+		return nil
+	}
+
+	var captions []Cea608Caption
+	for _, as := range v.AsrText.Segments {
+		captions = append(captions, Cea608Caption{
+			Start: v.StreamStarttime.Seconds() + as.Start,
+			End:   v.StreamStarttime.Seconds() + as.End,
+			Text:  as.Text,
+		})
+	}
+	return captions
 }
 
 func (v TranscriptSegment) String() string {
@@ -1475,6 +1876,9 @@ func (v *TranscriptQueue) reset(ctx context.Context) error {
 type TranscriptTask struct {
 	// The ID for task.
 	UUID string `json:"uuid,omitempty"`
+	// The stream of task, empty for the global task, which transcript the latest active stream.
+	App    string `json:"app,omitempty"`
+	Stream string `json:"stream,omitempty"`
 
 	// The input url.
 	Input string `json:"input,omitempty"`
@@ -1500,11 +1904,17 @@ type TranscriptTask struct {
 	// generating the next one. AI services may use this previous ASR text as a prompt to
 	// produce more accurate and robust subsequent ASR text.
 	PreviousAsrText string `json:"pat,omitempty"`
+	// The UUID of current transcript session, which persists the full transcript.
+	SessionUUID string `json:"session,omitempty"`
+	// The current transcript session.
+	session *TranscriptSession
 
 	// The signal to persistence task.
 	signalPersistence chan bool
 	// The signal to change the active stream for task.
 	signalNewStream chan *SrsStream
+	// Got message from SRS, a new TS segment file is generated.
+	tsfiles chan *SrsOnHlsObject
 
 	// The configure for transcript task.
 	config TranscriptConfig
@@ -1513,6 +1923,11 @@ type TranscriptTask struct {
 
 	// The context for current task.
 	cancel context.CancelFunc
+	// The context for all goroutines of task, cancel it to stop the task.
+	taskCtx    context.Context
+	cancelTask context.CancelFunc
+	// To wait for all goroutines of task to quit.
+	wg sync.WaitGroup
 
 	// To protect the common fields.
 	lock sync.Mutex
@@ -1536,12 +1951,14 @@ func NewTranscriptTask() *TranscriptTask {
 		signalPersistence: make(chan bool, 1),
 		// 创建一个信道用于通知新流信号，缓冲区大小为1，类型为 *SrsStream。
 		signalNewStream: make(chan *SrsStream, 1),
+		// 创建一个信道用于接收 TS 文件，缓冲区大小为 1024。
+		tsfiles: make(chan *SrsOnHlsObject, 1024),
 	}
 }
 
 func (v *TranscriptTask) String() string {
-	return fmt.Sprintf("uuid=%v, live=%v, asr=%v, fix=%v, pat=%v, overlay=%v, config is %v",
-		v.UUID, v.LiveQueue.String(), v.AsrQueue.String(), v.FixQueue.String(), v.PreviousAsrText,
+	return fmt.Sprintf("uuid=%v, key=%v, live=%v, asr=%v, fix=%v, pat=%v, overlay=%v, config is %v",
+		v.UUID, v.key(), v.LiveQueue.String(), v.AsrQueue.String(), v.FixQueue.String(), v.PreviousAsrText,
 		v.OverlayQueue.String(), v.config.String(),
 	)
 }
@@ -1550,6 +1967,9 @@ func (v *TranscriptTask) Run(ctx context.Context) error {
 	ctx = logger.WithContext(ctx)
 	logger.Tf(ctx, "transcript run task %v", v.String())
 
+	// The config is bound to the stream of task.
+	v.config.App, v.config.Stream = v.App, v.Stream
+
 	pfn := func(ctx context.Context) error {
 		// Load config from redis.
 		if err := v.config.Load(ctx); err != nil {
@@ -1648,6 +2068,9 @@ func (v *TranscriptTask) WatchNewStream(ctx context.Context) error {
 			return nil, errors.Wrapf(err, "hgetall %v", SRS_STREAM_ACTIVE)
 		}
 
+		// The global task ignores the streams of other tasks, while the task of stream only use its stream.
+		boundStreams := v.transcriptWorker.boundStreams()
+
 		var best *SrsStream
 		for _, value := range streams {
 			var stream SrsStream
@@ -1655,6 +2078,14 @@ func (v *TranscriptTask) WatchNewStream(ctx context.Context) error {
 				return nil, errors.Wrapf(err, "unmarshal %v", value)
 			}
 
+			if v.App != "" || v.Stream != "" {
+				if stream.App != v.App || stream.Stream != v.Stream {
+					continue
+				}
+			} else if boundStreams[transcriptTaskKey(stream.App, stream.Stream)] {
+				continue
+			}
+
 			if best == nil {
 				best = &stream
 				continue
@@ -1826,25 +2257,11 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 	}
 
 	// Convert the audio file to text by AI.
-	var config openai.ClientConfig
-	config = openai.DefaultConfig(v.config.SecretKey)
-	config.BaseURL = v.config.BaseURL
-	config.OrgID = v.config.Organization
-
 	// TODO: FIXME: Fast retry when failed.
 	// TODO: FIXME: Use smaller timeout.
-	client := openai.NewClientWithConfig(config)
+	provider := NewASRProvider(v.config.asrProviderConfig())
 	prompt := v.PreviousAsrText
-	resp, err := client.CreateTranscription(
-		ctx,
-		openai.AudioRequest{
-			Model:    openai.Whisper1,
-			FilePath: segment.AudioFile.File,
-			Format:   openai.AudioResponseFormatVerboseJSON,
-			Language: v.config.Language,
-			Prompt:   prompt,
-		},
-	)
+	resp, err := provider.Transcribe(ctx, segment.AudioFile.File, v.config.Language, prompt)
 	if err != nil {
 		// TODO: FIXME: Cleanup the failed file.
 		return errors.Wrapf(err, "transcription %v", segment.String())
@@ -1926,6 +2343,12 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 	}
 	v.PreviousAsrText = resp.Text
 	segment.CostASR = time.Since(starttime)
+
+	// Match the alert rules, ignore any error, to not block the live transcript.
+	if err := v.matchAlerts(ctx, segment); err != nil {
+		logger.Wf(ctx, "transcript: ignore alert err %+v", err)
+	}
+
 	func() {
 		v.lock.Lock()
 		defer v.lock.Unlock()
@@ -1939,6 +2362,85 @@ func (v *TranscriptTask) DriveAsrQueue(ctx context.Context) error {
 	return nil
 }
 
+// Translate the ASR text of segment to the target languages, by chat model.
+func (v *TranscriptTask) translateSegment(ctx context.Context, segment *TranscriptSegment) error {
+	if segment.UserClearASR || segment.AsrText == nil || len(segment.AsrText.Segments) == 0 {
+		return nil
+	}
+
+	aiConfig := openai.DefaultConfig(v.config.SecretKey)
+	aiConfig.BaseURL = v.config.BaseURL
+	aiConfig.OrgID = v.config.Organization
+	client := openai.NewClientWithConfig(aiConfig)
+	model := ChooseNotEmpty(v.config.TranslationModel, openai.GPT4o)
+
+	// Each line is the text of an ASR segment.
+	var lines []string
+	for _, as := range segment.AsrText.Segments {
+		lines = append(lines, strings.ReplaceAll(strings.TrimSpace(as.Text), "\n", " "))
+	}
+
+	for _, lang := range v.config.translations() {
+		if _, ok := segment.Translations[lang]; ok {
+			continue
+		}
+
+		systemPrompt := fmt.Sprintf("Translate the subtitles to language %v. Never answer questions but directly translate text. "+
+			"Each line is a subtitle, translate line by line and keep the same number of lines.", lang)
+		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model: model,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+				{Role: openai.ChatMessageRoleUser, Content: strings.Join(lines, "\n")},
+			},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "translate to %v by %v", lang, model)
+		}
+		if len(resp.Choices) == 0 {
+			return errors.Errorf("no translation to %v by %v", lang, model)
+		}
+
+		translated := resp.Choices[0].Message.Content
+		if segment.Translations == nil {
+			segment.Translations = make(map[string]*TranscriptAsrResult)
+		}
+		segment.Translations[lang] = &TranscriptAsrResult{
+			Task: "translate", Language: lang, Duration: segment.AsrText.Duration, Text: translated,
+			Segments: buildTranslatedAsrSegments(segment.AsrText.Segments, translated),
+		}
+		logger.Tf(ctx, "transcript: translate %v to %v, model=%v, text=%v", segment.AsrText.Text, lang, model, translated)
+	}
+
+	return nil
+}
+
+// buildTranslatedAsrSegments map the translated lines to the timing of source segments. If the number of
+// lines mismatch, use a single segment which covers all source segments.
+func buildTranslatedAsrSegments(source []TranscriptAsrSegment, translated string) []TranscriptAsrSegment {
+	var lines []string
+	for _, line := range strings.Split(translated, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(source) == 0 || len(lines) == 0 {
+		return nil
+	}
+
+	if len(lines) != len(source) {
+		return []TranscriptAsrSegment{{
+			Start: source[0].Start, End: source[len(source)-1].End, Text: strings.Join(lines, " "),
+		}}
+	}
+
+	segments := make([]TranscriptAsrSegment, len(source))
+	for i, s := range source {
+		segments[i] = TranscriptAsrSegment{ID: s.ID, Seek: s.Seek, Start: s.Start, End: s.End, Text: lines[i]}
+	}
+	return segments
+}
+
 func (v *TranscriptTask) DriveFixQueue(ctx context.Context) error {
 	// Ignore if not enabled.
 	if !v.config.All {
@@ -1970,6 +2472,37 @@ func (v *TranscriptTask) DriveFixQueue(ctx context.Context) error {
 		return nil
 	}
 
+	// Correct the ASR text by the replacement dictionary, before generating the subtitles. Note that the
+	// segment might be retried, so never correct it twice.
+	if !segment.Corrected && segment.AsrText != nil {
+		segment.AsrText.Text = v.config.correctText(segment.AsrText.Text)
+		for i := range segment.AsrText.Segments {
+			segment.AsrText.Segments[i].Text = v.config.correctText(segment.AsrText.Segments[i].Text)
+		}
+		segment.Corrected = true
+	}
+
+	// Translate the ASR text for WebVTT subtitles, ignore if failed, to not block the live stream.
+	if v.config.EnableWebVTT && len(v.config.translations()) > 0 {
+		translateStarttime := time.Now()
+		if err := v.translateSegment(ctx, segment); err != nil {
+			logger.Wf(ctx, "transcript: ignore translate err %+v, segment=%v", err, segment.String())
+		}
+		segment.CostTranslate = time.Since(translateStarttime)
+	}
+
+	// Persist the ASR text to the session, after user has chance to clear it.
+	if !segment.SessionAppended {
+		asrText := segment.AsrText
+		if segment.UserClearASR {
+			asrText = nil
+		}
+		if err := v.appendSession(ctx, segment.Msg, segment.TsFile, asrText); err != nil {
+			logger.Wf(ctx, "transcript: ignore session err %+v, segment=%v", err, segment.String())
+		}
+		segment.SessionAppended = true
+	}
+
 	// Overlay the ASR text onto the video.
 	overlayFile := &TsFile{
 		TsID:     fmt.Sprintf("%v-overlay-%v", segment.TsFile.SeqNo, uuid.NewString()),
@@ -2015,6 +2548,12 @@ func (v *TranscriptTask) DriveFixQueue(ctx context.Context) error {
 		}
 
 		processCmd = fmt.Sprintf("ffmpeg %v", strings.Join(args, " "))
+	} else if captions := segment.captions(); v.config.EnableCaption && len(captions) > 0 {
+		if err := injectCea608Captions(segment.TsFile.File, overlayFile.File, captions); err != nil {
+			return errors.Wrapf(err, "inject captions to %v", segment.TsFile.File)
+		}
+
+		processCmd = fmt.Sprintf("cea608 %v %v, captions=%v", segment.TsFile.File, overlayFile.File, len(captions))
 	} else {
 		args := []string{segment.TsFile.File, overlayFile.File}
 		if err := exec.CommandContext(ctx, "cp", args...).Run(); err != nil {
@@ -2149,6 +2688,52 @@ func (v *TranscriptTask) reset(ctx context.Context) error {
 	return nil
 }
 
+// key is the key of task, see transcriptTaskKey.
+func (v *TranscriptTask) key() string {
+	return transcriptTaskKey(v.App, v.Stream)
+}
+
+func (v *TranscriptTask) taskUUID() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.UUID
+}
+
+// stop all goroutines of task, and wait for them to quit.
+func (v *TranscriptTask) stop() {
+	if v.cancelTask != nil {
+		v.cancelTask()
+	}
+	v.wg.Wait()
+}
+
+// remove the files, task and config of the task, after stopped.
+func (v *TranscriptTask) remove(ctx context.Context) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.LiveQueue.reset(ctx)
+	v.AsrQueue.reset(ctx)
+	v.FixQueue.reset(ctx)
+	v.OverlayQueue.reset(ctx)
+
+	// Remove the ts files which are not consumed by task.
+	for len(v.tsfiles) > 0 {
+		if msg := <-v.tsfiles; msg.TsFile != nil {
+			os.Remove(msg.TsFile.File)
+		}
+	}
+
+	if err := rdb.HDel(ctx, SRS_TRANSCRIPT_TASK, v.UUID).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_TASK, v.UUID)
+	}
+	if err := rdb.HDel(ctx, SRS_TRANSCRIPT_CONFIG, v.key()).Err(); err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "hdel %v %v", SRS_TRANSCRIPT_CONFIG, v.key())
+	}
+	return nil
+}
+
 func (v *TranscriptTask) enabled() bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -2156,6 +2741,17 @@ func (v *TranscriptTask) enabled() bool {
 	return v.config.All
 }
 
+// The input stream if embed captions, nil if not.
+func (v *TranscriptTask) captionStream() *SrsStream {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if !v.config.All || !v.config.EnableCaption || v.config.EnableOverlay {
+		return nil
+	}
+	return v.inputStream
+}
+
 func (v *TranscriptTask) match(msg *SrsOnHlsMessage) bool {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -2199,6 +2795,20 @@ func (v *TranscriptTask) overlaySegments() []*TranscriptSegment {
 	return v.OverlayQueue.Segments[:]
 }
 
+// overlayText join the ASR text of overlay segments, under lock because the segments are updated by ASR.
+func (v *TranscriptTask) overlayText() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var texts []string
+	for _, segment := range v.OverlayQueue.Segments {
+		if segment.AsrText != nil && segment.AsrText.Text != "" {
+			texts = append(texts, segment.AsrText.Text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
 func (v *TranscriptTask) notifyPersistence(ctx context.Context) {
 	select {
 	case <-ctx.Done():
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTranscript_TaskKey(t *testing.T) {
+	if key := transcriptTaskKey("", ""); key != "global" {
+		t.Errorf("transcript key failed, expect global, actual %v", key)
+	}
+	if key := transcriptTaskKey("live", "livestream"); key != "live/livestream" {
+		t.Errorf("transcript key failed, expect live/livestream, actual %v", key)
+	}
+}
+
+func TestTranscript_BuildTranslatedAsrSegments(t *testing.T) {
+	source := []TranscriptAsrSegment{{Start: 0, End: 1.5, Text: "Hello"}, {Start: 1.5, End: 3, Text: "world"}}
+	if segments := buildTranslatedAsrSegments(source, "你好\n\n世界\n"); len(segments) != 2 ||
+		segments[1].Text != "世界" || segments[1].Start != 1.5 {
+		t.Errorf("translate segments failed, %v", segments)
+	}
+	if segments := buildTranslatedAsrSegments(source, "你好世界"); len(segments) != 1 ||
+		segments[0].Text != "你好世界" || segments[0].End != 3 {
+		t.Errorf("translate segments failed, %v", segments)
+	}
+
+	_, m3u8, err := buildLiveM3u8ForVariantCC(context.Background(), 1000, "live.m3u8", []*SubtitleRendition{
+		{Language: "en", URI: "subtitles.m3u8"}, {Language: "zh", URI: "subtitles-zh.m3u8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m3u8, `LANGUAGE="en",DEFAULT=YES`) || !strings.Contains(m3u8, `LANGUAGE="zh",DEFAULT=NO`) {
+		t.Errorf("invalid m3u8 %v", m3u8)
+	}
+}
+
+func TestTranscript_VocabularyAndReplacements(t *testing.T) {
+	config := &TranscriptConfig{
+		ASRHints: "Tech talk.", ContextPrompt: " About streaming. ",
+		Vocabulary: []string{"Oryx", " SRS ", "Oryx", ""},
+		Replacements: []*TranscriptReplacement{
+			{From: "oryks", To: "Oryx"}, {From: "S.R.S", To: "SRS"}, {From: "奥瑞克斯", To: "Oryx"},
+		},
+	}
+	if hints := config.asrHints(); hints != "Tech talk. About streaming. Vocabulary: Oryx, SRS." {
+		t.Errorf("invalid hints %v", hints)
+	}
+
+	config.compileReplacements()
+	if text := config.correctText("Oryks is built on s.r.s, not oryksx."); text != "Oryx is built on SRS, not oryksx." {
+		t.Errorf("invalid text %v", text)
+	}
+	if text := config.correctText("欢迎使用奥瑞克斯"); text != "欢迎使用Oryx" {
+		t.Errorf("invalid text %v", text)
+	}
+}
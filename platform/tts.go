@@ -0,0 +1,321 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// The OpenAI or OpenAI-compatible speech endpoint.
+	TTSProviderOpenAI = "openai"
+	// The generic HTTP TTS, POST text in JSON and get the audio in body.
+	TTSProviderHTTP = "http"
+	// The local Piper engine, by the piper CLI, see https://github.com/rhasspy/piper
+	TTSProviderPiper = "piper"
+	// The local eSpeak engine, by the espeak-ng CLI, see https://github.com/espeak-ng/espeak-ng
+	TTSProviderESpeak = "espeak"
+)
+
+// TTSProviderConfig is the config to create a TTS provider, built from the config of live room assistant or
+// dubbing project.
+type TTSProviderConfig struct {
+	// The TTS provider, openai, http, piper or espeak. Default to openai.
+	Provider string
+	// The model name, default to tts-1 for OpenAI. For piper, the path of voice model, for example,
+	// /data/piper/en_US-lessac-medium.onnx
+	Model string
+	// The voice name, default to nova for OpenAI. For espeak, the voice such as en-us or cmn.
+	Voice string
+	// The speed of speech, between 0.25 and 4.0. Zero for provider default, which is 1.0.
+	Speed float64
+	// The audio format, for OpenAI, aac, mp3, opus, flac or wav, default to aac. For http, the format
+	// of response, detect by content type if empty. The local engines always generate wav.
+	Format string
+	// The language of text, for http provider, and the default voice for espeak.
+	Language string
+	// For http provider, the URL to POST the text.
+	URL string
+	// For http provider, the optional bearer token for authorization.
+	Token string
+	// For OpenAI provider, the AI service config.
+	AI openai.ClientConfig
+}
+
+func (v TTSProviderConfig) String() string {
+	return fmt.Sprintf("provider=%v, model=%v, voice=%v, speed=%v, format=%v, lang=%v, url=%v, token=%vB",
+		v.Provider, v.Model, v.Voice, v.Speed, v.Format, v.Language, v.URL, len(v.Token))
+}
+
+// Customized whether the voice is different from the default OpenAI nova, so the hello voice should be
+// generated, rather than the example files.
+func (v *TTSProviderConfig) Customized() bool {
+	if v.Provider != "" && v.Provider != TTSProviderOpenAI {
+		return true
+	}
+	return v.Voice != "" && v.Voice != string(openai.VoiceNova)
+}
+
+// Validate the config, for example, the voice and format of OpenAI.
+func (v *TTSProviderConfig) Validate() error {
+	if v.Speed != 0 && (v.Speed < 0.25 || v.Speed > 4.0) {
+		return errors.Errorf("invalid speed %v, should in [0.25, 4.0]", v.Speed)
+	}
+
+	switch v.Provider {
+	case "", TTSProviderOpenAI:
+		voices := []string{
+			string(openai.VoiceAlloy), string(openai.VoiceEcho), string(openai.VoiceFable),
+			string(openai.VoiceOnyx), string(openai.VoiceNova), string(openai.VoiceShimmer),
+		}
+		if v.Voice != "" && !slicesContains(voices, v.Voice) {
+			return errors.Errorf("invalid voice %v, should be %v", v.Voice, strings.Join(voices, ","))
+		}
+
+		formats := []string{
+			string(openai.SpeechResponseFormatAac), string(openai.SpeechResponseFormatMp3),
+			string(openai.SpeechResponseFormatOpus), string(openai.SpeechResponseFormatFlac),
+			string(openai.SpeechResponseFormatWav),
+		}
+		if v.Format != "" && !slicesContains(formats, v.Format) {
+			return errors.Errorf("invalid format %v, should be %v", v.Format, strings.Join(formats, ","))
+		}
+	case TTSProviderHTTP:
+		if v.URL == "" {
+			return errors.Errorf("no url for %v tts", v.Provider)
+		}
+	case TTSProviderPiper:
+		if v.Model == "" {
+			return errors.Errorf("no model for %v tts", v.Provider)
+		}
+	case TTSProviderESpeak:
+	default:
+		return errors.Errorf("invalid provider %v", v.Provider)
+	}
+	return nil
+}
+
+// TTSProvider is the service to convert text to audio.
+type TTSProvider interface {
+	// RequestTTS convert the text to audio file, which is built by the format of audio, for example, aac.
+	RequestTTS(ctx context.Context, buildFilepath func(ext string) string, text string) error
+}
+
+// NewTTSProvider create the TTS provider by config, fallback to OpenAI.
+func NewTTSProvider(config *TTSProviderConfig) TTSProvider {
+	switch config.Provider {
+	case TTSProviderHTTP:
+		return &httpTTSProvider{config: *config}
+	case TTSProviderPiper, TTSProviderESpeak:
+		return &localTTSProvider{config: *config}
+	}
+	return &openaiTTSProvider{config: *config}
+}
+
+type openaiTTSProvider struct {
+	config TTSProviderConfig
+}
+
+func (v *openaiTTSProvider) RequestTTS(ctx context.Context, buildFilepath func(ext string) string, text string) error {
+	model := ChooseNotEmpty(v.config.Model, string(openai.TTSModel1))
+	voice := ChooseNotEmpty(v.config.Voice, string(openai.VoiceNova))
+	format := ChooseNotEmpty(v.config.Format, string(openai.SpeechResponseFormatAac))
+
+	client := openai.NewClientWithConfig(v.config.AI)
+	resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.SpeechModel(model),
+		Input:          text,
+		Voice:          openai.SpeechVoice(voice),
+		ResponseFormat: openai.SpeechResponseFormat(format),
+		Speed:          v.config.Speed,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create speech by %v, voice=%v", model, voice)
+	}
+	defer resp.Close()
+
+	return writeTTSFile(buildFilepath(format), resp)
+}
+
+// httpTTSProvider POST the text in JSON, with fields text, voice, speed, language, format and model, and
+// the response body is the audio file.
+type httpTTSProvider struct {
+	config TTSProviderConfig
+}
+
+func (v *httpTTSProvider) RequestTTS(ctx context.Context, buildFilepath func(ext string) string, text string) error {
+	if v.config.URL == "" {
+		return errors.Errorf("no url for %v tts", v.config.Provider)
+	}
+
+	b, err := json.Marshal(&struct {
+		Text     string  `json:"text"`
+		Voice    string  `json:"voice,omitempty"`
+		Speed    float64 `json:"speed,omitempty"`
+		Language string  `json:"language,omitempty"`
+		Format   string  `json:"format,omitempty"`
+		Model    string  `json:"model,omitempty"`
+	}{
+		Text: text, Voice: v.config.Voice, Speed: v.config.Speed, Language: v.config.Language,
+		Format: v.config.Format, Model: v.config.Model,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "marshal request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.config.URL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.config.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.config.Token))
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request %v", v.config.URL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("status %v, body %v", resp.StatusCode, string(b))
+	}
+
+	format := v.config.Format
+	if format == "" {
+		format = ttsFormatOfContentType(resp.Header.Get("Content-Type"))
+	}
+	return writeTTSFile(buildFilepath(format), resp.Body)
+}
+
+// localTTSProvider generate the wav file by the local piper or espeak-ng CLI.
+type localTTSProvider struct {
+	config TTSProviderConfig
+}
+
+func (v *localTTSProvider) RequestTTS(ctx context.Context, buildFilepath func(ext string) string, text string) error {
+	ttsFile := buildFilepath("wav")
+
+	// Always use execFile when params contains user inputs, see https://auth0.com/blog/preventing-command-injection-attacks-in-node-js-apps/
+	// The text is piped by stdin, to avoid being parsed as options.
+	var cmd *exec.Cmd
+	if v.config.Provider == TTSProviderPiper {
+		args := []string{"--model", v.config.Model, "--output_file", ttsFile}
+		if v.config.Speed > 0 {
+			args = append(args, "--length_scale", fmt.Sprintf("%.2f", 1/v.config.Speed))
+		}
+		cmd = exec.CommandContext(ctx, "piper", args...)
+	} else {
+		voice := ChooseNotEmpty(v.config.Voice, v.config.Language, "en")
+		args := []string{"-v", voice, "-w", ttsFile}
+		if v.config.Speed > 0 {
+			// The default speed of espeak is 175 words per minute.
+			args = append(args, "-s", fmt.Sprintf("%v", int(175*v.config.Speed)))
+		}
+		cmd = exec.CommandContext(ctx, "espeak-ng", args...)
+	}
+	cmd.Stdin = strings.NewReader(text)
+
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "run %v, output is %v", cmd.String(), string(b))
+	}
+	return nil
+}
+
+func writeTTSFile(ttsFile string, r io.Reader) error {
+	out, err := os.Create(ttsFile)
+	if err != nil {
+		return errors.Errorf("Unable to create the file %v for writing", ttsFile)
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, r); err != nil {
+		return errors.Errorf("Error writing the file")
+	}
+	return nil
+}
+
+// ttsFormatOfContentType is the format of audio, for example, audio/mpeg is mp3, default to wav.
+func ttsFormatOfContentType(contentType string) string {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/aac":
+		return "aac"
+	case "audio/ogg", "audio/opus":
+		return "opus"
+	case "audio/flac":
+		return "flac"
+	}
+	return "wav"
+}
+
+// ttsContentType is the content type of audio file, by the extension.
+func ttsContentType(filename string) string {
+	switch ext := strings.Trim(path.Ext(filename), "."); ext {
+	case "mp3":
+		return "audio/mpeg"
+	case "opus":
+		return "audio/ogg"
+	default:
+		return fmt.Sprintf("audio/%v", ext)
+	}
+}
+
+// helloVoiceText is the text of hello voice, to let user know the assistant is ready.
+func helloVoiceText(language string) string {
+	if language == "zh" {
+		return "你好，我是你的AI助手，请开始说话吧。"
+	}
+	return "Hello, I'm your AI assistant, please start talking."
+}
+
+// generateHelloVoice generate the hello voice by the TTS provider in dir, return the filename. The file is
+// reused if exists, because the name is by the config and language.
+func generateHelloVoice(ctx context.Context, dir string, config *TTSProviderConfig, language string) (string, error) {
+	key := fmt.Sprintf("%v/%v/%v/%v/%v/%v/%v",
+		config.Provider, config.Model, config.Voice, config.Speed, config.Format, config.URL, language)
+	prefix := fmt.Sprintf("hello-voice-%x", md5.Sum([]byte(key)))
+
+	if files, err := ioutil.ReadDir(dir); err == nil {
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), prefix+".") {
+				return file.Name(), nil
+			}
+		}
+	}
+
+	var filename string
+	if err := NewTTSProvider(config).RequestTTS(ctx, func(ext string) string {
+		filename = fmt.Sprintf("%v.%v", prefix, ext)
+		return path.Join(dir, filename)
+	}, helloVoiceText(language)); err != nil {
+		if filename != "" {
+			os.Remove(path.Join(dir, filename))
+		}
+		return "", errors.Wrapf(err, "tts %v", config.String())
+	}
+
+	logger.Tf(ctx, "TTS: Generate hello voice %v, lang=%v, %v", filename, language, config.String())
+	return filename, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTTS_HttpProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Text  string  `json:"text"`
+			Voice string  `json:"voice"`
+			Speed float64 `json:"speed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text != "Hello" || req.Voice != "alice" || req.Speed != 1.5 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("mp3 data"))
+	}))
+	defer ts.Close()
+
+	config := &TTSProviderConfig{Provider: TTSProviderHTTP, URL: ts.URL, Token: "secret", Voice: "alice", Speed: 1.5}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("validate err %+v", err)
+	}
+	if !config.Customized() {
+		t.Errorf("should be customized")
+	}
+
+	dir, err := ioutil.TempDir("", "tts")
+	if err != nil {
+		t.Fatalf("temp dir err %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ttsFile string
+	if err := NewTTSProvider(config).RequestTTS(context.Background(), func(ext string) string {
+		ttsFile = path.Join(dir, fmt.Sprintf("tts.%v", ext))
+		return ttsFile
+	}, "Hello"); err != nil {
+		t.Fatalf("tts err %+v", err)
+	}
+	if b, err := ioutil.ReadFile(ttsFile); err != nil || string(b) != "mp3 data" || path.Ext(ttsFile) != ".mp3" {
+		t.Errorf("invalid tts file %v, %v, err %v", ttsFile, string(b), err)
+	}
+	if ct := ttsContentType(ttsFile); ct != "audio/mpeg" {
+		t.Errorf("invalid content type %v", ct)
+	}
+
+	if err := (&TTSProviderConfig{Voice: "unknown"}).Validate(); err == nil {
+		t.Errorf("should fail for invalid voice")
+	}
+	if err := (&TTSProviderConfig{Speed: 5}).Validate(); err == nil {
+		t.Errorf("should fail for invalid speed")
+	}
+	if (&TTSProviderConfig{Voice: "nova"}).Customized() {
+		t.Errorf("should not be customized")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -304,6 +305,8 @@ const (
 	SRS_RECORD_PATTERNS      = "SRS_RECORD_PATTERNS"
 	SRS_RECORD_M3U8_WORKING  = "SRS_RECORD_M3U8_WORKING"
 	SRS_RECORD_M3U8_ARTIFACT = "SRS_RECORD_M3U8_ARTIFACT"
+	SRS_RECORD_ARCHIVE       = "SRS_RECORD_ARCHIVE"
+	SRS_RECORD_TRANSCRIPT    = "SRS_RECORD_TRANSCRIPT"
 	// For cloud storage.
 	SRS_DVR_PATTERNS      = "SRS_DVR_PATTERNS"
 	SRS_DVR_M3U8_WORKING  = "SRS_DVR_M3U8_WORKING"
@@ -327,11 +330,23 @@ const (
 	SRS_TRANSCODE_CONFIG = "SRS_TRANSCODE_CONFIG"
 	SRS_TRANSCODE_TASK   = "SRS_TRANSCODE_TASK"
 	// For transcription.
-	SRS_TRANSCRIPT_CONFIG = "SRS_TRANSCRIPT_CONFIG"
-	SRS_TRANSCRIPT_TASK   = "SRS_TRANSCRIPT_TASK"
+	SRS_TRANSCRIPT_CONFIG  = "SRS_TRANSCRIPT_CONFIG"
+	SRS_TRANSCRIPT_TASK    = "SRS_TRANSCRIPT_TASK"
+	SRS_TRANSCRIPT_SESSION = "SRS_TRANSCRIPT_SESSION"
+	// For transcript alerts.
+	SRS_TRANSCRIPT_ALERT_RULE = "SRS_TRANSCRIPT_ALERT_RULE"
+	SRS_TRANSCRIPT_ALERT      = "SRS_TRANSCRIPT_ALERT"
 	// For OCR.
-	SRS_OCR_CONFIG = "SRS_OCR_CONFIG"
-	SRS_OCR_TASK   = "SRS_OCR_TASK"
+	SRS_OCR_CONFIG         = "SRS_OCR_CONFIG"
+	SRS_OCR_TASK           = "SRS_OCR_TASK"
+	SRS_OCR_HISTORY        = "SRS_OCR_HISTORY"
+	SRS_OCR_HISTORY_CONFIG = "SRS_OCR_HISTORY_CONFIG"
+	// For scene change and visual event detection.
+	SRS_DETECT_CONFIG = "SRS_DETECT_CONFIG"
+	SRS_DETECT_STATUS = "SRS_DETECT_STATUS"
+	// For AI talk stages and conversation log of live room.
+	SRS_AI_TALK_STAGE        = "SRS_AI_TALK_STAGE"
+	SRS_AI_TALK_CONVERSATION = "SRS_AI_TALK_CONVERSATION"
 	// For SRS stream status.
 	SRS_STREAM_ACTIVE     = "SRS_STREAM_ACTIVE"
 	SRS_STREAM_SRT_ACTIVE = "SRS_STREAM_SRT_ACTIVE"
@@ -1072,6 +1087,10 @@ func buildVodM3u8(
 			}
 		}
 
+		if file.Discontinuity && m3u8[len(m3u8)-1] != "#EXT-X-DISCONTINUITY" {
+			m3u8 = append(m3u8, "#EXT-X-DISCONTINUITY")
+		}
+
 		m3u8 = append(m3u8, fmt.Sprintf("#EXTINF:%.2f, no desc", file.Duration))
 
 		var tsURL string
@@ -1129,6 +1148,10 @@ func buildVodM3u8ForLocal(
 			}
 		}
 
+		if file.Discontinuity && m3u8[len(m3u8)-1] != "#EXT-X-DISCONTINUITY" {
+			m3u8 = append(m3u8, "#EXT-X-DISCONTINUITY")
+		}
+
 		m3u8 = append(m3u8, fmt.Sprintf("#EXTINF:%.2f, no desc", file.Duration))
 
 		var tsURL string
@@ -1178,6 +1201,10 @@ func buildLiveM3u8ForLocal(
 			}
 		}
 
+		if file.Discontinuity && m3u8[len(m3u8)-1] != "#EXT-X-DISCONTINUITY" {
+			m3u8 = append(m3u8, "#EXT-X-DISCONTINUITY")
+		}
+
 		m3u8 = append(m3u8, fmt.Sprintf("#EXTINF:%.2f, no desc", file.Duration))
 
 		var tsURL string
@@ -1194,19 +1221,92 @@ func buildLiveM3u8ForLocal(
 	return
 }
 
-// buildLiveM3u8ForVariantCC go generate variant m3u8 with CC(Closed Caption).
+// The time layout of EXT-X-PROGRAM-DATE-TIME, ISO 8601 with milliseconds.
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// buildTimeshiftM3u8ForLocal go generate dynamic m3u8 for timeshift of a live stream, the sequence is the
+// media sequence of the first ts file. If event is true, the playlist is a growing EVENT playlist, otherwise
+// it's a sliding window playlist.
+func buildTimeshiftM3u8ForLocal(
+	ctx context.Context, tsFiles []*TsFile, sequence int, event bool, prefix string,
+) (
+	contentType, m3u8Body string, duration float64, err error,
+) {
+	if len(tsFiles) == 0 {
+		err = errors.Errorf("no files")
+		return
+	}
+
+	var targetDuration float64
+	for _, file := range tsFiles {
+		targetDuration = math.Max(targetDuration, file.Duration)
+		duration += file.Duration
+	}
+
+	m3u8 := []string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%v", sequence),
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%v", math.Ceil(targetDuration)),
+	}
+	if event {
+		m3u8 = append(m3u8, "#EXT-X-PLAYLIST-TYPE:EVENT")
+	}
+	for index, file := range tsFiles {
+		// The stitched file, which is not continuous to the previous one, is also a discontinuity.
+		discontinuity := file.Discontinuity || (index > 0 && tsFiles[index-1].SeqNo+1 != file.SeqNo)
+		if discontinuity {
+			m3u8 = append(m3u8, "#EXT-X-DISCONTINUITY")
+		}
+		if file.ProgramDateTime != "" {
+			m3u8 = append(m3u8, fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%v", file.ProgramDateTime))
+		}
+
+		m3u8 = append(m3u8, fmt.Sprintf("#EXTINF:%.2f, no desc", file.Duration))
+		m3u8 = append(m3u8, fmt.Sprintf("%v%v", prefix, file.Key))
+	}
+
+	contentType = "application/vnd.apple.mpegurl"
+	m3u8Body = strings.Join(m3u8, "\n")
+	return
+}
+
+// SubtitleRendition is a subtitle track in the variant m3u8.
+type SubtitleRendition struct {
+	// The language of subtitle, for example, en.
+	Language string
+	// The URI of subtitle m3u8, relative to the variant m3u8.
+	URI string
+}
+
+// buildLiveM3u8ForVariantCC go generate variant m3u8 with CC(Closed Caption). The first subtitle is the
+// default track, and players are able to switch between the subtitles.
 func buildLiveM3u8ForVariantCC(
-	ctx context.Context, bitrate int64, lang, stream, subtitles string,
+	ctx context.Context, bitrate int64, stream string, subtitles []*SubtitleRendition,
 ) (contentType, m3u8Body string, err error) {
+	if len(subtitles) == 0 {
+		err = errors.Errorf("no subtitles")
+		return
+	}
+
 	m3u8 := []string{
 		"#EXTM3U",
-		fmt.Sprintf(
-			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Subtitle-%v",LANGUAGE="%v",DEFAULT=YES,AUTOSELECT=YES,FORCED=NO,URI="%v"`,
-			strings.ToUpper(lang), lang, subtitles,
-		),
+	}
+	for index, subtitle := range subtitles {
+		isDefault := "NO"
+		if index == 0 {
+			isDefault = "YES"
+		}
+
+		m3u8 = append(m3u8, fmt.Sprintf(
+			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Subtitle-%v",LANGUAGE="%v",DEFAULT=%v,AUTOSELECT=YES,FORCED=NO,URI="%v"`,
+			strings.ToUpper(subtitle.Language), subtitle.Language, isDefault, subtitle.URI,
+		))
+	}
+	m3u8 = append(m3u8, []string{
 		fmt.Sprintf(`#EXT-X-STREAM-INF:BANDWIDTH=%v,SUBTITLES="subs"`, bitrate),
 		stream,
-	}
+	}...)
 
 	contentType = "application/vnd.apple.mpegurl"
 	m3u8Body = strings.Join(m3u8, "\n")
@@ -1244,6 +1344,12 @@ type TsFile struct {
 	Duration float64 `json:"duration,omitempty"`
 	// The size of TS file in bytes, such as 1934897
 	Size uint64 `json:"size,omitempty"`
+	// The wallclock time of the start of TS, in ISO 8601 format, such as 2024-04-23T01:02:03.456+08:00
+	// Note that for RECORD only, it's estimated by the time SRS generates the TS and the duration of TS.
+	ProgramDateTime string `json:"pdt,omitempty"`
+	// Whether the TS is discontinuous with the previous one, for example, the first TS of a reconnected
+	// session, or the filler between sessions.
+	Discontinuity bool `json:"discontinuity,omitempty"`
 }
 
 func (v *TsFile) String() string {
@@ -1288,6 +1394,8 @@ type M3u8VoDArtifact struct {
 	Region string `json:"region"`
 
 	// For VoD only.
+	// The VoD provider, empty for Tencent Cloud VoD, or http for generic HTTP provider.
+	Provider string `json:"provider,omitempty"`
 	// The file ID generated by VoD commit.
 	FileID   string `json:"fileId"`
 	MediaURL string `json:"mediaUrl"`
@@ -1296,6 +1404,16 @@ type M3u8VoDArtifact struct {
 	TaskID     string `json:"taskId"`
 	// The remux task result.
 	Task *VodTaskArtifact `json:"taskObj"`
+
+	// For RECORD only.
+	// The poster and thumbnails for previewing, generated when record is done.
+	Preview *M3u8VoDPreview `json:"preview,omitempty"`
+	// The archive job to re-encode the file to a smaller profile.
+	Archive *M3u8VoDArchive `json:"archive,omitempty"`
+	// The transcript job to re-transcribe the file by a high accuracy model.
+	Transcript *M3u8VoDTranscript `json:"transcript,omitempty"`
+	// The merge job, if the artifact is merged from other artifacts.
+	Merge *M3u8VoDMerge `json:"merge,omitempty"`
 }
 
 func (v *M3u8VoDArtifact) String() string {
@@ -1324,6 +1442,9 @@ func (v *M3u8VoDArtifact) String() string {
 	if v.Task != nil {
 		sb.WriteString(fmt.Sprintf(", task=(%v)", v.Task.String()))
 	}
+	if v.Preview != nil {
+		sb.WriteString(fmt.Sprintf(", preview=(%v)", v.Preview.String()))
+	}
 	return sb.String()
 }
 
@@ -1336,12 +1457,133 @@ type VodTaskArtifact struct {
 	Size     int64   `json:"size"`
 	Duration float64 `json:"duration"`
 	MD5      string  `json:"md5"`
+	// The error message if task failed.
+	Error string `json:"error,omitempty"`
 }
 
 func (v *VodTaskArtifact) String() string {
+	if v.Error != "" {
+		return fmt.Sprintf("url=%v, error=%v", v.URL, v.Error)
+	}
 	return fmt.Sprintf("url=%v", v.URL)
 }
 
+// The width in pixels of each thumbnail in the sprite sheet.
+const previewThumbnailWidth = 160
+
+// The number of thumbnails in each row of the sprite sheet.
+const previewSpriteColumns = 10
+
+// The max number of thumbnails in the sprite sheet, the interval grows for long files.
+const previewMaxThumbnails = 100
+
+// The min interval in seconds between thumbnails.
+const previewMinInterval = 10.0
+
+// M3u8VoDPreview is the visual preview of a VoD file, a poster image and a sprite sheet with a
+// WebVTT thumbnails track, for players to show hover previews when scrubbing. All files are stored
+// in the same directory of the VoD file, so the track uses relative URL to refer to the sprite.
+type M3u8VoDPreview struct {
+	// The poster image, such as poster.jpg
+	Poster string `json:"poster"`
+	// The sprite sheet image, such as sprite.jpg
+	Sprite string `json:"sprite"`
+	// The WebVTT thumbnails track, such as thumbnails.vtt
+	Thumbnails string `json:"thumbnails"`
+	// The interval in seconds between thumbnails.
+	Interval float64 `json:"interval"`
+	// The number of thumbnails in sprite.
+	Count int `json:"count"`
+	// The number of thumbnails in each row of sprite.
+	Columns int `json:"columns"`
+	// The size of each thumbnail in sprite.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (v *M3u8VoDPreview) String() string {
+	return fmt.Sprintf("poster=%v, sprite=%v, thumbnails=%v, interval=%v, count=%v, columns=%v, size=%vx%v",
+		v.Poster, v.Sprite, v.Thumbnails, v.Interval, v.Count, v.Columns, v.Width, v.Height,
+	)
+}
+
+// generateVoDPreview use FFmpeg to generate the poster, sprite and thumbnails track of the mp4 file,
+// and write all files to the dir.
+func generateVoDPreview(ctx context.Context, mp4File, dir string) (*M3u8VoDPreview, error) {
+	format, video, _, err := FFprobeFileFormat(ctx, mp4File)
+	if err != nil {
+		return nil, errors.Wrapf(err, "probe %v", mp4File)
+	}
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return nil, errors.Errorf("no video of %v", mp4File)
+	}
+	if format.Duration <= 0 {
+		return nil, errors.Errorf("invalid duration %v of %v", format.Duration, mp4File)
+	}
+
+	preview := &M3u8VoDPreview{
+		Poster: "poster.jpg", Sprite: "sprite.jpg", Thumbnails: "thumbnails.vtt",
+		Interval: math.Max(previewMinInterval, math.Ceil(format.Duration/previewMaxThumbnails)),
+		Columns:  previewSpriteColumns,
+		Width:    previewThumbnailWidth,
+	}
+	// The height must be even for some encoders.
+	preview.Height = int(math.Round(float64(preview.Width)*float64(video.Height)/float64(video.Width)/2) * 2)
+	preview.Count = int(math.Ceil(format.Duration / preview.Interval))
+	if preview.Count < preview.Columns {
+		preview.Columns = preview.Count
+	}
+	rows := (preview.Count + preview.Columns - 1) / preview.Columns
+
+	// Use the frame at 1s as poster, or the first frame for very short file.
+	posterFile := path.Join(dir, preview.Poster)
+	posterAt := math.Min(1.0, format.Duration/2)
+	if b, err := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", fmt.Sprintf("%.3f", posterAt), "-i", mp4File, "-frames:v", "1", "-q:v", "2", "-y", posterFile,
+	).CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "generate poster %v err %v", posterFile, string(b))
+	}
+
+	spriteFile := path.Join(dir, preview.Sprite)
+	filter := fmt.Sprintf("fps=1/%v,scale=%v:%v,tile=%vx%v",
+		preview.Interval, preview.Width, preview.Height, preview.Columns, rows)
+	if b, err := exec.CommandContext(ctx, "ffmpeg",
+		"-i", mp4File, "-vf", filter, "-frames:v", "1", "-q:v", "5", "-y", spriteFile,
+	).CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "generate sprite %v err %v", spriteFile, string(b))
+	}
+
+	thumbnailsFile := path.Join(dir, preview.Thumbnails)
+	vtt := buildThumbnailsWebVTT(preview, format.Duration)
+	if err := ioutil.WriteFile(thumbnailsFile, []byte(vtt), 0644); err != nil {
+		return nil, errors.Wrapf(err, "write %v", thumbnailsFile)
+	}
+
+	return preview, nil
+}
+
+// buildThumbnailsWebVTT build the WebVTT thumbnails track, each cue refers to a region of the sprite
+// by media fragment, such as sprite.jpg#xywh=160,0,160,90
+func buildThumbnailsWebVTT(preview *M3u8VoDPreview, duration float64) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for i := 0; i < preview.Count; i++ {
+		start := float64(i) * preview.Interval
+		end := math.Min(start+preview.Interval, duration)
+		x, y := (i%preview.Columns)*preview.Width, (i/preview.Columns)*preview.Height
+		sb.WriteString(fmt.Sprintf("%v --> %v\n", formatWebVTTTime(start), formatWebVTTTime(end)))
+		sb.WriteString(fmt.Sprintf("%v#xywh=%v,%v,%v,%v\n\n", preview.Sprite, x, y, preview.Width, preview.Height))
+	}
+	return sb.String()
+}
+
+// formatWebVTTTime format the seconds to WebVTT timestamp, such as 01:02:03.456
+func formatWebVTTTime(seconds float64) string {
+	t := time.Duration(seconds * float64(time.Second))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(t.Hours()), int(t.Minutes())%60, int(t.Seconds())%60, int(t.Milliseconds())%1000)
+}
+
 // SrsOnHlsMessage is the SRS on_hls callback message.
 type SrsOnHlsMessage struct {
 	// Must be on_hls
@@ -1607,6 +1849,43 @@ func httpCreateProxy(targetURL string) (*httputil.ReverseProxy, error) {
 	return proxy, nil
 }
 
+// httpNewMultipartRequest create a POST request of multipart form, with the fields and the file named by
+// name. The file is streamed by pipe, to avoid loading the whole file in memory. Empty fields are ignored.
+func httpNewMultipartRequest(ctx context.Context, targetURL string, fields map[string]string, name string, file io.Reader) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(func() error {
+			for k, v := range fields {
+				if v == "" {
+					continue
+				}
+				if err := writer.WriteField(k, v); err != nil {
+					return errors.Wrapf(err, "write field %v", k)
+				}
+			}
+
+			part, err := writer.CreateFormFile("file", name)
+			if err != nil {
+				return errors.Wrapf(err, "create form file")
+			}
+			if _, err := io.Copy(part, file); err != nil {
+				return errors.Wrapf(err, "copy %v", name)
+			}
+			return writer.Close()
+		}())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, pr)
+	if err != nil {
+		// Unblock the writer goroutine.
+		pr.Close()
+		return nil, errors.Wrapf(err, "new request")
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
 // whxpResponseModifier is the response modifier for WHIP or WHEP proxy.
 type whxpResponseModifier struct {
 	w http.ResponseWriter
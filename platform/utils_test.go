@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"strings"
 	"testing"
 )
 
@@ -58,3 +60,38 @@ func TestUtils_ParseFFmpegLogs(t *testing.T) {
 		}
 	}
 }
+
+func TestUtils_BuildThumbnailsWebVTT(t *testing.T) {
+	preview := &M3u8VoDPreview{
+		Sprite: "sprite.jpg", Interval: 10, Count: 3, Columns: 2, Width: 160, Height: 90,
+	}
+	expect := "WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:10.000\nsprite.jpg#xywh=0,0,160,90\n\n" +
+		"00:00:10.000 --> 00:00:20.000\nsprite.jpg#xywh=160,0,160,90\n\n" +
+		"00:00:20.000 --> 00:00:25.500\nsprite.jpg#xywh=0,90,160,90\n\n"
+	if vtt := buildThumbnailsWebVTT(preview, 25.5); vtt != expect {
+		t.Errorf("build thumbnails failed, expect %v, actual %v", expect, vtt)
+	}
+}
+
+func TestUtils_BuildTimeshiftM3u8ForLocal(t *testing.T) {
+	tsFiles := []*TsFile{
+		{Key: "1.ts", SeqNo: 1, Duration: 10, ProgramDateTime: "2024-04-23T01:00:00.000+08:00"},
+		{Key: "3.ts", SeqNo: 3, Duration: 10, ProgramDateTime: "2024-04-23T01:00:20.000+08:00"},
+		{Key: "4.ts", SeqNo: 4, Duration: 10, Discontinuity: true},
+	}
+	_, m3u8, duration, err := buildTimeshiftM3u8ForLocal(context.Background(), tsFiles, 1, false, "")
+	if err != nil {
+		t.Fatalf("build m3u8 failed, %v", err)
+	}
+	if duration != 30 {
+		t.Errorf("invalid duration %v", duration)
+	}
+	if n := strings.Count(m3u8, "#EXT-X-DISCONTINUITY"); n != 2 {
+		t.Errorf("expect 2 discontinuity, actual %v, %v", n, m3u8)
+	}
+	expect := "#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:2024-04-23T01:00:20.000+08:00\n#EXTINF:10.00, no desc\n3.ts"
+	if !strings.Contains(m3u8, expect) {
+		t.Errorf("expect %v, actual %v", expect, m3u8)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// VADConfig is the config of voice activity detection, to segment the continuous audio to utterances.
+type VADConfig struct {
+	// The threshold of speech, the volume of frame in dBFS, for example, -40.
+	Threshold float64 `json:"threshold,omitempty"`
+	// The silence in ms to end an utterance.
+	Silence int `json:"silence,omitempty"`
+	// The speech in ms to start an utterance, shorter speech is ignored as noise.
+	MinSpeech int `json:"minSpeech,omitempty"`
+	// The max duration in ms of an utterance, to force ending a long utterance.
+	MaxSpeech int `json:"maxSpeech,omitempty"`
+	// The audio in ms before speech, to keep the start of utterance.
+	Padding int `json:"padding,omitempty"`
+}
+
+func (v VADConfig) String() string {
+	return fmt.Sprintf("threshold=%v, silence=%v, minSpeech=%v, maxSpeech=%v, padding=%v",
+		v.Threshold, v.Silence, v.MinSpeech, v.MaxSpeech, v.Padding)
+}
+
+// withDefaults set the default value of fields not set.
+func (v VADConfig) withDefaults() VADConfig {
+	if v.Threshold == 0 {
+		v.Threshold = -40
+	}
+	if v.Silence <= 0 {
+		v.Silence = 800
+	}
+	if v.MinSpeech <= 0 {
+		v.MinSpeech = 200
+	}
+	if v.MaxSpeech <= 0 {
+		v.MaxSpeech = 30000
+	}
+	if v.Padding <= 0 {
+		v.Padding = 300
+	}
+	return v
+}
+
+func (v *VADConfig) Validate() error {
+	if v.Threshold > 0 || v.Threshold < -96 {
+		return errors.Errorf("invalid threshold %v, should in [-96, 0] dBFS", v.Threshold)
+	}
+	if v.Silence < 0 || v.MinSpeech < 0 || v.MaxSpeech < 0 || v.Padding < 0 {
+		return errors.Errorf("invalid duration %v", v.String())
+	}
+	if v.MaxSpeech > 0 && v.MaxSpeech < v.MinSpeech {
+		return errors.Errorf("maxSpeech %v less than minSpeech %v", v.MaxSpeech, v.MinSpeech)
+	}
+	return nil
+}
+
+type VADEventType string
+
+const (
+	// The user starts speaking, for barge-in.
+	VADSpeechStart VADEventType = "start"
+	// The user stops speaking, the audio is the utterance.
+	VADSpeechEnd VADEventType = "end"
+)
+
+// VADEvent is the event of speech, the audio is the PCM of utterance for end event.
+type VADEvent struct {
+	Type  VADEventType
+	Audio []byte
+}
+
+// The duration in ms of a frame to detect speech.
+const vadFrameDuration = 20
+
+// VAD is an energy based voice activity detector, for the signed 16 bits little-endian PCM.
+type VAD struct {
+	config VADConfig
+	// The bytes of a frame.
+	frameSize int
+	// The frames not enough, to process with next audio.
+	pending []byte
+	// The frames before speech, for padding.
+	preroll []byte
+
+	// Whether in utterance, and whether the start event is emitted.
+	speaking, started bool
+	// The audio of utterance.
+	audio []byte
+	// The duration in ms of speech, and the trailing silence.
+	speechDuration, silenceDuration int
+}
+
+func NewVAD(config VADConfig, sampleRate, channels int) (*VAD, error) {
+	frameSize := sampleRate * vadFrameDuration / 1000 * channels * 2
+	if frameSize <= 0 {
+		return nil, errors.Errorf("invalid frame size %v, rate=%v, channels=%v", frameSize, sampleRate, channels)
+	}
+
+	return &VAD{
+		config: config.withDefaults(), frameSize: frameSize,
+	}, nil
+}
+
+// Write the PCM audio, return the events of speech.
+func (v *VAD) Write(pcm []byte) (events []*VADEvent) {
+	v.pending = append(v.pending, pcm...)
+	for len(v.pending) >= v.frameSize {
+		frame := v.pending[:v.frameSize]
+		if event := v.process(frame, vadFrameVolume(frame) >= v.config.Threshold); event != nil {
+			events = append(events, event)
+		}
+		v.pending = v.pending[v.frameSize:]
+	}
+	return
+}
+
+// Flush end the utterance, for example, the stream is closed.
+func (v *VAD) Flush() *VADEvent {
+	defer v.reset()
+	if v.started {
+		return &VADEvent{Type: VADSpeechEnd, Audio: v.audio}
+	}
+	return nil
+}
+
+func (v *VAD) process(frame []byte, voiced bool) *VADEvent {
+	if !v.speaking {
+		if !voiced {
+			v.preroll = append(v.preroll, frame...)
+			if max := v.config.Padding / vadFrameDuration * v.frameSize; len(v.preroll) > max {
+				v.preroll = v.preroll[len(v.preroll)-max:]
+			}
+			return nil
+		}
+
+		v.speaking, v.speechDuration, v.silenceDuration = true, 0, 0
+		v.audio = append(append([]byte{}, v.preroll...), frame...)
+		v.preroll = nil
+	} else {
+		v.audio = append(v.audio, frame...)
+	}
+
+	if voiced {
+		v.speechDuration, v.silenceDuration = v.speechDuration+vadFrameDuration, 0
+	} else {
+		v.silenceDuration += vadFrameDuration
+	}
+
+	// Ignore the noise, which is too short.
+	if !v.started && v.silenceDuration >= v.config.Silence {
+		v.reset()
+		return nil
+	}
+
+	// Start utterance when speech is long enough.
+	if !v.started && v.speechDuration >= v.config.MinSpeech {
+		v.started = true
+		return &VADEvent{Type: VADSpeechStart}
+	}
+
+	// End utterance if silence or too long.
+	if v.started && (v.silenceDuration >= v.config.Silence ||
+		len(v.audio)/v.frameSize*vadFrameDuration >= v.config.MaxSpeech) {
+		return v.Flush()
+	}
+	return nil
+}
+
+func (v *VAD) reset() {
+	v.speaking, v.started, v.audio = false, false, nil
+	v.speechDuration, v.silenceDuration = 0, 0
+}
+
+// vadFrameVolume is the RMS volume of frame in dBFS, -96 for silence.
+func vadFrameVolume(frame []byte) float64 {
+	var sum float64
+	samples := len(frame) / 2
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(frame[i*2:])))
+		sum += sample * sample
+	}
+	if samples == 0 || sum == 0 {
+		return -96
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(samples))/32768)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestVAD_Segments(t *testing.T) {
+	// Build the PCM of 16kHz mono, in ms, silence or tone of 440Hz.
+	pcm := func(ms int, tone bool) []byte {
+		b := make([]byte, 16*ms*2)
+		for i := 0; tone && i < len(b)/2; i++ {
+			sample := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/16000))
+			binary.LittleEndian.PutUint16(b[i*2:], uint16(sample))
+		}
+		return b
+	}
+
+	vad, err := NewVAD(VADConfig{Silence: 500, MinSpeech: 200, Padding: 100}, 16000, 1)
+	if err != nil {
+		t.Fatalf("create vad failed, %v", err)
+	}
+	if _, err := NewVAD(VADConfig{}, 40, 1); err == nil {
+		t.Errorf("should fail for invalid sample rate")
+	}
+
+	// The short noise is ignored.
+	if events := vad.Write(append(append(pcm(400, false), pcm(100, true)...), pcm(600, false)...)); len(events) != 0 {
+		t.Errorf("noise should be ignored, events %v", len(events))
+	}
+
+	// The speech is segmented, by 100ms padding, 800ms speech and 500ms silence.
+	var events []*VADEvent
+	for _, b := range [][]byte{pcm(300, false), pcm(800, true), pcm(1000, false)} {
+		events = append(events, vad.Write(b)...)
+	}
+	if len(events) != 2 || events[0].Type != VADSpeechStart || events[1].Type != VADSpeechEnd {
+		t.Fatalf("invalid events %v", events)
+	}
+	if duration := len(events[1].Audio) / 32; duration != 1400 {
+		t.Errorf("invalid utterance %vms", duration)
+	}
+
+	// Force to end the long speech.
+	if vad, err = NewVAD(VADConfig{MaxSpeech: 1000}, 16000, 1); err != nil {
+		t.Fatalf("create vad failed, %v", err)
+	}
+	if events := vad.Write(pcm(2500, true)); len(events) != 5 || events[1].Type != VADSpeechEnd {
+		t.Errorf("invalid events %v", len(events))
+	}
+	if event := vad.Flush(); event == nil || event.Type != VADSpeechEnd {
+		t.Errorf("should flush the speech")
+	}
+}
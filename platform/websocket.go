@@ -0,0 +1,283 @@
+// Copyright (c) 2022-2024 Winlin
+//
+// SPDX-License-Identifier: MIT
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ossrs/go-oryx-lib/errors"
+)
+
+// The opcodes of WebSocket frame, see https://www.rfc-editor.org/rfc/rfc6455#section-5.2
+const (
+	WebSocketContinuation = 0x0
+	WebSocketText         = 0x1
+	WebSocketBinary       = 0x2
+	WebSocketClose        = 0x8
+	WebSocketPing         = 0x9
+	WebSocketPong         = 0xa
+)
+
+// The max size of a message, to limit the memory of a connection.
+const webSocketMaxMessageSize = 16 * 1024 * 1024
+
+// The max payload of a control frame, see https://www.rfc-editor.org/rfc/rfc6455#section-5.5
+const webSocketMaxControlSize = 125
+
+// The timeout to write messages, to not block the writers forever when client doesn't read.
+const webSocketWriteTimeout = 10 * time.Second
+
+// WebSocketMessage is a text or binary message to write.
+type WebSocketMessage struct {
+	Opcode int
+	Data   []byte
+}
+
+// WebSocketConn is a minimal server side WebSocket connection, which reads the masked frames from client,
+// and writes the unmasked frames to client, see https://www.rfc-editor.org/rfc/rfc6455
+type WebSocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	// The lock to write frames, to keep messages in order.
+	lock sync.Mutex
+}
+
+func newWebSocketConn(conn net.Conn, rw *bufio.ReadWriter) *WebSocketConn {
+	return &WebSocketConn{conn: conn, rw: rw}
+}
+
+// webSocketAcceptKey is the Sec-WebSocket-Accept of key, see https://www.rfc-editor.org/rfc/rfc6455#section-4.2.2
+func webSocketAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkWebSocketOrigin allow the request without Origin, which is not from browser, or the Origin is the same
+// host, to reject the cross-site WebSocket hijacking, see https://www.rfc-editor.org/rfc/rfc6455#section-10.2
+func checkWebSocketOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errors.Wrapf(err, "parse origin %v", origin)
+	}
+	if !strings.EqualFold(u.Host, r.Host) {
+		return errors.Errorf("origin %v not match host %v", origin, r.Host)
+	}
+	return nil
+}
+
+// UpgradeWebSocket upgrade the HTTP request to WebSocket, by hijacking the connection.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, errors.Errorf("invalid method %v", r.Method)
+	}
+	if !strings.Contains(strings.ToLower(r.Header.Get("Upgrade")), "websocket") {
+		return nil, errors.Errorf("invalid upgrade %v", r.Header.Get("Upgrade"))
+	}
+	if !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return nil, errors.Errorf("invalid connection %v", r.Header.Get("Connection"))
+	}
+	if version := r.Header.Get("Sec-WebSocket-Version"); version != "13" {
+		return nil, errors.Errorf("invalid version %v", version)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.Errorf("empty key")
+	}
+	if err := checkWebSocketOrigin(r); err != nil {
+		return nil, errors.Wrapf(err, "check origin")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.Errorf("not hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Wrapf(err, "hijack")
+	}
+
+	// Clear the deadline set by HTTP server, as WebSocket is a long connection.
+	_ = conn.SetDeadline(time.Time{})
+
+	if _, err := rw.WriteString(fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n",
+		webSocketAcceptKey(key))); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "write handshake")
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "flush handshake")
+	}
+
+	return newWebSocketConn(conn, rw), nil
+}
+
+// ReadMessage read a text or binary message, the control frames are handled, and return io.EOF when
+// client closes the connection.
+func (v *WebSocketConn) ReadMessage() (opcode int, data []byte, err error) {
+	for {
+		fin, op, payload, err := v.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case WebSocketPing:
+			if err := v.WriteMessage(WebSocketPong, payload); err != nil {
+				return 0, nil, errors.Wrapf(err, "write pong")
+			}
+			continue
+		case WebSocketPong:
+			continue
+		case WebSocketClose:
+			_ = v.WriteMessage(WebSocketClose, payload)
+			return 0, nil, io.EOF
+		case WebSocketContinuation:
+			if opcode == 0 {
+				return 0, nil, errors.Errorf("unexpected continuation")
+			}
+		case WebSocketText, WebSocketBinary:
+			if opcode != 0 {
+				return 0, nil, errors.Errorf("unexpected opcode %v in fragments", op)
+			}
+			opcode = op
+		default:
+			return 0, nil, errors.Errorf("invalid opcode %v", op)
+		}
+
+		if len(data)+len(payload) > webSocketMaxMessageSize {
+			return 0, nil, errors.Errorf("message exceed %v bytes", webSocketMaxMessageSize)
+		}
+		data = append(data, payload...)
+
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+func (v *WebSocketConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(v.rw, header[:]); err != nil {
+		return false, 0, nil, errors.Wrapf(err, "read header")
+	}
+
+	fin, opcode = header[0]&0x80 != 0, int(header[0]&0x0f)
+	masked, size := header[1]&0x80 != 0, uint64(header[1]&0x7f)
+	if !masked {
+		return false, 0, nil, errors.Errorf("client frame not masked")
+	}
+	// No extension is negotiated, so the RSV bits must be zero.
+	if rsv := header[0] & 0x70; rsv != 0 {
+		return false, 0, nil, errors.Errorf("invalid rsv 0x%x", rsv)
+	}
+	// The control frames must not be fragmented, and the payload is at most 125 bytes.
+	if isControl := opcode&0x08 != 0; isControl && (!fin || size > webSocketMaxControlSize) {
+		return false, 0, nil, errors.Errorf("invalid control frame opcode=%v, fin=%v, size=%v", opcode, fin, size)
+	}
+
+	switch size {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(v.rw, b[:]); err != nil {
+			return false, 0, nil, errors.Wrapf(err, "read size")
+		}
+		size = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(v.rw, b[:]); err != nil {
+			return false, 0, nil, errors.Wrapf(err, "read size")
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	}
+	if size > webSocketMaxMessageSize {
+		return false, 0, nil, errors.Errorf("frame exceed %v bytes", webSocketMaxMessageSize)
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(v.rw, mask[:]); err != nil {
+		return false, 0, nil, errors.Wrapf(err, "read mask")
+	}
+
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(v.rw, payload); err != nil {
+		return false, 0, nil, errors.Wrapf(err, "read payload")
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage write a message in a frame.
+func (v *WebSocketConn) WriteMessage(opcode int, data []byte) error {
+	return v.WriteMessages(WebSocketMessage{Opcode: opcode, Data: data})
+}
+
+// WriteJSON write the object as a text message.
+func (v *WebSocketConn) WriteJSON(obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %v", obj)
+	}
+	return v.WriteMessage(WebSocketText, b)
+}
+
+// WriteMessages write messages in order, no other message is inserted between them, for example, the
+// metadata in text and the audio data in binary.
+func (v *WebSocketConn) WriteMessages(messages ...WebSocketMessage) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if err := v.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout)); err != nil {
+		return errors.Wrapf(err, "set write deadline")
+	}
+
+	for _, m := range messages {
+		header := []byte{0x80 | byte(m.Opcode)}
+		switch size := len(m.Data); {
+		case size < 126:
+			header = append(header, byte(size))
+		case size <= 0xffff:
+			header = append(header, 126, byte(size>>8), byte(size))
+		default:
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], uint64(size))
+			header = append(append(header, 127), b[:]...)
+		}
+
+		if _, err := v.rw.Write(header); err != nil {
+			return errors.Wrapf(err, "write header")
+		}
+		if _, err := v.rw.Write(m.Data); err != nil {
+			return errors.Wrapf(err, "write payload")
+		}
+	}
+	return v.rw.Flush()
+}
+
+// Close send the close frame and close the connection.
+func (v *WebSocketConn) Close() error {
+	_ = v.WriteMessage(WebSocketClose, nil)
+	return v.conn.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestWebSocket_Frames(t *testing.T) {
+	if key := webSocketAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("invalid accept key %v", key)
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	conn := newWebSocketConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)))
+
+	// The client frames are masked, the text message is fragmented with a ping in the middle.
+	writeFrame := func(b0 byte, payload string) {
+		mask := []byte{1, 2, 3, 4}
+		frame := append([]byte{b0, 0x80 | byte(len(payload))}, mask...)
+		for i := 0; i < len(payload); i++ {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+		client.Write(frame)
+	}
+	go func() {
+		writeFrame(WebSocketText, "Hello, ")
+		writeFrame(0x80|WebSocketPing, "hi")
+		writeFrame(0x80|WebSocketContinuation, "World")
+		writeFrame(0x80|WebSocketClose, "")
+	}()
+
+	// Read the pong for ping, and the close for close.
+	frames := make(chan []byte, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(client, header); err != nil {
+				return
+			}
+			payload := make([]byte, header[1])
+			io.ReadFull(client, payload)
+			frames <- append(header, payload...)
+		}
+	}()
+
+	opcode, data, err := conn.ReadMessage()
+	if err != nil || opcode != WebSocketText || string(data) != "Hello, World" {
+		t.Fatalf("invalid message opcode=%v, data=%v, err %v", opcode, string(data), err)
+	}
+	if pong := <-frames; pong[0] != 0x80|WebSocketPong || string(pong[2:]) != "hi" {
+		t.Errorf("invalid pong %v", pong)
+	}
+
+	if _, _, err := conn.ReadMessage(); err != io.EOF {
+		t.Errorf("should be eof, err %v", err)
+	}
+	if closed := <-frames; closed[0] != 0x80|WebSocketClose {
+		t.Errorf("invalid close %v", closed)
+	}
+}
+
+func TestWebSocket_InvalidFrames(t *testing.T) {
+	for _, frame := range [][]byte{
+		// The RSV1 is set, without extension.
+		{0x80 | 0x40 | WebSocketText, 0x80, 1, 2, 3, 4},
+		// The ping is fragmented.
+		{WebSocketPing, 0x80, 1, 2, 3, 4},
+		// The ping is larger than 125 bytes.
+		{0x80 | WebSocketPing, 0x80 | 126, 0, 126, 1, 2, 3, 4},
+	} {
+		server, client := net.Pipe()
+		conn := newWebSocketConn(server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)))
+		go client.Write(frame)
+
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Errorf("should fail for frame %v", frame)
+		}
+		server.Close()
+		client.Close()
+	}
+}
+
+func TestWebSocket_Origin(t *testing.T) {
+	for _, c := range []struct {
+		host, origin string
+		ok           bool
+	}{
+		{"oryx.example.com", "", true},
+		{"oryx.example.com", "https://oryx.example.com", true},
+		{"localhost:2022", "http://localhost:2022", true},
+		{"oryx.example.com", "https://evil.example.com", false},
+		{"localhost:2022", "http://localhost:3000", false},
+	} {
+		r := &http.Request{Host: c.host, Header: http.Header{}}
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if err := checkWebSocketOrigin(r); (err == nil) != c.ok {
+			t.Errorf("host=%v, origin=%v, expect ok=%v, err %v", c.host, c.origin, c.ok, err)
+		}
+	}
+}